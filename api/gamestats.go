@@ -0,0 +1,15 @@
+package api
+
+import (
+	"net/http"
+	"wwfc/gamestats"
+)
+
+func HandleGameStatsStats(w http.ResponseWriter, r *http.Request) {
+	_, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	replyOK(w, gamestats.GetWebStats())
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type ResolveGhostReviewRequestSpec struct {
+	AuthInfo
+	ID        int    `json:"id"`
+	Approve   bool   `json:"approve"`
+	Moderator string `json:"moderator"`
+}
+
+type GhostReviewSpec struct {
+	database.MarioKartWiiGhostReview
+	// Moderator only, the request has to be authenticated
+	GhostURL string `json:"ghost_url,omitempty"`
+}
+
+func HandleGhostReviews(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	status := query.Get("status")
+	if status == "" {
+		status = "pending"
+	} else if status == "all" {
+		status = ""
+	} else if status != "pending" && status != "approved" && status != "rejected" {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	limit, offset, ok := parsePageQuery(w, query)
+	if !ok {
+		return
+	}
+
+	reviews, err := db.GetMarioKartWiiGhostReviews(status, limit, offset)
+	if err != nil {
+		logging.Error("API", "Failed to get ghost reviews:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	specs := make([]GhostReviewSpec, 0, len(reviews))
+	for _, review := range reviews {
+		spec := GhostReviewSpec{MarioKartWiiGhostReview: review}
+		if review.HasGhost {
+			spec.GhostURL = "/api/ghost_review_file?id=" + strconv.Itoa(review.ID)
+		}
+		specs = append(specs, spec)
+	}
+
+	replyOK(w, specs)
+}
+
+// HandleGhostReviewFile serves the ghost of a review as an RKG file
+func HandleGhostReviewFile(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	id, err := strconv.Atoi(query.Get("id"))
+	if err != nil || id <= 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	ghost, err := db.GetMarioKartWiiGhostReviewFile(id)
+	if err == pgx.ErrNoRows || (err == nil && len(ghost) == 0) {
+		replyError(w, http.StatusNotFound, APIErrorGhostNotFound)
+		return
+	} else if err != nil {
+		logging.Error("API", "Failed to get ghost review file:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="review_`+strconv.Itoa(id)+`.rkg"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(ghost)))
+	_, _ = w.Write(ghost)
+}
+
+func HandleResolveGhostReview(w http.ResponseWriter, r *http.Request) {
+	req := ResolveGhostReviewRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	if req.ID <= 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	err = db.ResolveMarioKartWiiGhostReview(req.ID, req.Approve, moderator)
+	if err == pgx.ErrNoRows {
+		replyError(w, http.StatusOK, APIErrorGhostReviewNotFound)
+		return
+	} else if err != nil {
+		logging.Error("API:"+moderator, "Failed to resolve ghost review:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	replyOK(w, nil)
+
+	logging.Event("mkw_ghost_review_resolved", map[string]any{
+		"review_id": req.ID,
+		"approved":  req.Approve,
+		"moderator": moderator,
+	})
+
+	if req.Approve {
+		logging.Notice("API:"+moderator, "Approved ghost review", aurora.Cyan(req.ID))
+	} else {
+		logging.Notice("API:"+moderator, "Rejected ghost review", aurora.Cyan(req.ID))
+	}
+}
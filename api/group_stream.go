@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"wwfc/qr2"
+)
+
+// Minimum time between updates, so bursts of heartbeats are sent as one update
+const groupStreamInterval = 250 * time.Millisecond
+
+// HandleGroupStream streams a room's changes as server-sent events. The first event is
+// a snapshot of the room, followed by an event for each change until the room closes.
+func HandleGroupStream(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleNone)
+	if err != nil {
+		return
+	}
+
+	groupName := query.Get("id")
+	if groupName == "" {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		replyError(w, http.StatusInternalServerError, APIErrorStreamingUnsupported)
+		return
+	}
+
+	updates, unwatch, err := qr2.WatchGroup(groupName)
+	if err == qr2.ErrGroupNotFound {
+		replyError(w, http.StatusNotFound, APIErrorGroupNotFound)
+		return
+	} else if err != nil {
+		replyError(w, http.StatusServiceUnavailable, APIErrorTooManyWatchers)
+		return
+	}
+	defer unwatch()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	last, ok := getGroupInfo(groupName)
+	if !ok {
+		writeStreamEvent(w, flusher, "closed", nil)
+		return
+	}
+	writeStreamEvent(w, flusher, "snapshot", last)
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			_, _ = fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+
+		case _, open := <-updates:
+			if !open {
+				writeStreamEvent(w, flusher, "closed", nil)
+				return
+			}
+
+			current, ok := getGroupInfo(groupName)
+			if !ok {
+				writeStreamEvent(w, flusher, "closed", nil)
+				return
+			}
+
+			for _, change := range qr2.DiffGroups(last, current) {
+				writeStreamEvent(w, flusher, change.Event, change)
+			}
+			last = current
+
+			time.Sleep(groupStreamInterval)
+		}
+	}
+}
+
+func getGroupInfo(groupName string) (qr2.GroupInfo, bool) {
+	groups := qr2.GetGroups(nil, []string{groupName}, false)
+	if len(groups) == 0 {
+		return qr2.GroupInfo{}, false
+	}
+
+	return groups[0], true
+}
+
+func writeStreamEvent(w http.ResponseWriter, flusher http.Flusher, event string, data any) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, jsonData)
+	flusher.Flush()
+}
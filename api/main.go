@@ -25,6 +25,18 @@ func StartServer(reload bool) {
 		"profile_kicked",
 		"profile_banned",
 		"profile_unbanned",
+		"profile_privacy_updated",
+		"profile_updated",
+		"profile_device_reset",
+		"profile_transferred",
+		"profile_merged",
+		"tournament_created",
+		"tournament_deleted",
+		"mkw_ghost_review_resolved",
+		"mkw_rating_flags_cleared",
+		"sake_record_deleted",
+		"sake_record_blanked",
+		"sake_tables_reloaded",
 	})
 }
 
@@ -34,9 +46,39 @@ func Shutdown() {
 
 func RegisterHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/api/groups", HandleGroups)
+	mux.HandleFunc("/api/group_stream", HandleGroupStream)
 	mux.HandleFunc("/api/stats", HandleStats)
+	mux.HandleFunc("/api/payload_versions", HandlePayloadVersions)
+	mux.HandleFunc("/api/relay_stats", HandleRelayStats)
+	mux.HandleFunc("/api/gamestats_stats", HandleGameStatsStats)
+	mux.HandleFunc("/api/matchmaking_stats", HandleMatchmakingStats)
+	mux.HandleFunc("/api/rating_history", HandleRatingHistory)
+	mux.HandleFunc("/api/rating_leaderboard", HandleRatingLeaderboard)
+	mux.HandleFunc("/api/clear_rating_flags", HandleClearRatingFlags)
+	mux.HandleFunc("/api/mkw_leaderboard", HandleGhostLeaderboard)
+	mux.HandleFunc("/api/mkw_personal_bests", HandlePersonalBests)
+	mux.HandleFunc("/api/mkw_ghost", HandleGhost)
+	mux.HandleFunc("/api/ghost_reviews", HandleGhostReviews)
+	mux.HandleFunc("/api/ghost_review_file", HandleGhostReviewFile)
+	mux.HandleFunc("/api/resolve_ghost_review", HandleResolveGhostReview)
+	mux.HandleFunc("/api/room_history", HandleRoomHistory)
+	mux.HandleFunc("/api/tournament", HandleTournament)
+	mux.HandleFunc("/api/create_tournament", HandleCreateTournament)
+	mux.HandleFunc("/api/delete_tournament", HandleDeleteTournament)
+	mux.HandleFunc("/api/sake_tables", HandleSakeTables)
+	mux.HandleFunc("/api/reload_sake_tables", HandleReloadSakeTables)
+	mux.HandleFunc("/api/sake_records", HandleSakeRecords)
+	mux.HandleFunc("/api/delete_sake_record", HandleDeleteSakeRecord)
+	mux.HandleFunc("/api/blank_sake_record", HandleBlankSakeRecord)
 	mux.HandleFunc("/api/ban", HandleBan)
 	mux.HandleFunc("/api/unban", HandleUnban)
 	mux.HandleFunc("/api/kick", HandleKick)
 	mux.HandleFunc("/api/baninfo", HandleBanInfo)
+	mux.HandleFunc("/api/privacy", HandlePrivacy)
+	mux.HandleFunc("/api/update_privacy", HandleUpdatePrivacy)
+	mux.HandleFunc("/api/reputation", HandleReputation)
+	mux.HandleFunc("/api/profile", HandleProfile)
+	mux.HandleFunc("/api/update_profile", HandleUpdateProfile)
+	mux.HandleFunc("/api/reset_device", HandleResetDevice)
+	mux.HandleFunc("/api/transfer_profile", HandleTransferProfile)
 }
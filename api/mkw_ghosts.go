@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type GhostRankingSpec struct {
+	database.MarioKartWiiRanking
+	// Set if the time has a ghost that can be downloaded
+	GhostURL string `json:"ghost_url,omitempty"`
+}
+
+type GhostLeaderboardResponseSpec struct {
+	RegionID    common.MarioKartWiiLeaderboardRegionId `json:"region_id"`
+	CourseID    common.MarioKartWiiCourseId            `json:"course_id"`
+	Leaderboard []GhostRankingSpec                     `json:"leaderboard"`
+}
+
+type PersonalBestsResponseSpec struct {
+	ProfileID     uint32                                 `json:"pid"`
+	RegionID      common.MarioKartWiiLeaderboardRegionId `json:"region_id"`
+	PersonalBests []GhostRankingSpec                     `json:"personal_bests"`
+}
+
+// parseGhostRegion reads the leaderboard region, which defaults to worldwide
+func parseGhostRegion(w http.ResponseWriter, query url.Values) (common.MarioKartWiiLeaderboardRegionId, bool) {
+	if query.Get("region") == "" {
+		return common.Worldwide, true
+	}
+
+	regionIdInt, err := strconv.Atoi(query.Get("region"))
+	regionId := common.MarioKartWiiLeaderboardRegionId(regionIdInt)
+	if err != nil || !regionId.IsValid() {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return 0, false
+	}
+
+	return regionId, true
+}
+
+func makeGhostRankings(rankings []database.MarioKartWiiRanking) []GhostRankingSpec {
+	specs := make([]GhostRankingSpec, 0, len(rankings))
+	for _, ranking := range rankings {
+		spec := GhostRankingSpec{MarioKartWiiRanking: ranking}
+		if ranking.HasGhost {
+			spec.GhostURL = "/api/mkw_ghost?id=" + strconv.Itoa(ranking.ID)
+		}
+		specs = append(specs, spec)
+	}
+
+	return specs
+}
+
+func HandleGhostLeaderboard(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleNone)
+	if err != nil {
+		return
+	}
+
+	courseIdInt, err := strconv.Atoi(query.Get("course"))
+	courseId := common.MarioKartWiiCourseId(courseIdInt)
+	if err != nil || courseId < common.MarioCircuit || courseId > 32767 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	regionId, ok := parseGhostRegion(w, query)
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := parsePageQuery(w, query)
+	if !ok {
+		return
+	}
+
+	leaderboard, err := db.GetMarioKartWiiLeaderboard(regionId, courseId, limit, offset)
+	if err != nil {
+		logging.Error("API", "Failed to get ghost leaderboard:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	replyOK(w, GhostLeaderboardResponseSpec{
+		RegionID:    regionId,
+		CourseID:    courseId,
+		Leaderboard: makeGhostRankings(leaderboard),
+	})
+}
+
+func HandlePersonalBests(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleNone)
+	if err != nil {
+		return
+	}
+
+	profileId, err := strconv.ParseUint(query.Get("pid"), 10, 32)
+	if err != nil || profileId == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	regionId, ok := parseGhostRegion(w, query)
+	if !ok {
+		return
+	}
+
+	personalBests, err := db.GetMarioKartWiiPersonalBests(regionId, int(profileId))
+	if err != nil {
+		logging.Error("API", "Failed to get personal bests:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	replyOK(w, PersonalBestsResponseSpec{
+		ProfileID:     uint32(profileId),
+		RegionID:      regionId,
+		PersonalBests: makeGhostRankings(personalBests),
+	})
+}
+
+// HandleGhost serves a stored ghost as an RKG file
+func HandleGhost(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleNone)
+	if err != nil {
+		return
+	}
+
+	id, err := strconv.Atoi(query.Get("id"))
+	if err != nil || id <= 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	ghost, err := db.GetMarioKartWiiFile(id)
+	if err == pgx.ErrNoRows || (err == nil && len(ghost) == 0) {
+		replyError(w, http.StatusNotFound, APIErrorGhostNotFound)
+		return
+	} else if err != nil {
+		logging.Error("API", "Failed to get ghost:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strconv.Itoa(id)+`.rkg"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(ghost)))
+	_, _ = w.Write(ghost)
+}
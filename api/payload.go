@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"wwfc/common"
+	"wwfc/gpcm"
+)
+
+func HandlePayloadVersions(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleNone)
+	if err != nil {
+		return
+	}
+
+	games := query["game"]
+
+	counts := gpcm.GetPayloadVersionCounts()
+	if len(games) > 0 {
+		for gameName := range counts {
+			if !common.StringInSlice(gameName, games) {
+				delete(counts, gameName)
+			}
+		}
+	}
+
+	replyOK(w, counts)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"wwfc/database"
+	"wwfc/gpcm"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type PrivacyResponseSpec struct {
+	ProfileID    uint32 `json:"pid"`
+	JoinPolicy   string `json:"join_policy"`
+	OpenHost     bool   `json:"open_host"`
+	HidePresence bool   `json:"hide_presence"`
+	HideSearch   bool   `json:"hide_search"`
+}
+
+type UpdatePrivacyRequestSpec struct {
+	AuthInfo
+	ProfileID    uint32 `json:"pid"`
+	JoinPolicy   string `json:"join_policy"`
+	HidePresence bool   `json:"hide_presence"`
+	HideSearch   bool   `json:"hide_search"`
+	Moderator    string `json:"moderator"`
+}
+
+func HandlePrivacy(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	profileId, err := strconv.ParseUint(query.Get("pid"), 10, 32)
+	if err != nil || profileId == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	user, ok := db.GetProfile(uint32(profileId))
+	if !ok {
+		replyError(w, http.StatusOK, APIErrorProfileNotFound)
+		return
+	}
+
+	replyOK(w, PrivacyResponseSpec{
+		ProfileID:    user.ProfileId,
+		JoinPolicy:   user.JoinPolicy,
+		OpenHost:     user.OpenHost,
+		HidePresence: user.HidePresence,
+		HideSearch:   user.HideSearch,
+	})
+}
+
+func HandleUpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+	req := UpdatePrivacyRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	if req.ProfileID == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	if !database.IsValidJoinPolicy(req.JoinPolicy) {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidJoinPolicy)
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	user, ok := db.GetProfile(req.ProfileID)
+	if !ok {
+		replyError(w, http.StatusOK, APIErrorProfileNotFound)
+		return
+	}
+
+	if err := db.UpdatePrivacySettings(&user, req.JoinPolicy, req.HidePresence, req.HideSearch); err != nil {
+		logging.Error("API:"+moderator, "Failed to update privacy settings:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorUpdateFailed)
+		return
+	}
+
+	gpcm.UpdatePrivacySettings(req.ProfileID, req.JoinPolicy, req.HidePresence, req.HideSearch)
+
+	replyOK(w, nil)
+
+	logging.Event("profile_privacy_updated", map[string]any{
+		"profile_id":    req.ProfileID,
+		"join_policy":   req.JoinPolicy,
+		"hide_presence": req.HidePresence,
+		"hide_search":   req.HideSearch,
+		"moderator":     moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Privacy:", aurora.Cyan(req.ProfileID), "Join policy:", aurora.Cyan(req.JoinPolicy), "Hide presence:", aurora.Cyan(req.HidePresence), "Hide search:", aurora.Cyan(req.HideSearch))
+}
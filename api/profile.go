@@ -0,0 +1,338 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"wwfc/database"
+	"wwfc/gpcm"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type ProfileResponseSpec struct {
+	ProfileID     uint32   `json:"pid"`
+	UserID        uint64   `json:"user_id"`
+	GsbrCode      string   `json:"gsbrcd"`
+	FirstName     string   `json:"firstname"`
+	LastName      string   `json:"lastname"`
+	LastInGameSn  string   `json:"last_ingamesn"`
+	OpenHost      bool     `json:"open_host"`
+	DeviceIDs     []uint32 `json:"ng_device_ids"`
+	LastIPAddress string   `json:"last_ip_address"`
+}
+
+type UpdateProfileRequestSpec struct {
+	AuthInfo
+	ProfileID    uint32    `json:"pid"`
+	FirstName    *string   `json:"firstname"`
+	LastName     *string   `json:"lastname"`
+	LastInGameSn *string   `json:"last_ingamesn"`
+	OpenHost     *bool     `json:"open_host"`
+	DeviceIDs    *[]uint32 `json:"ng_device_ids"`
+	Moderator    string    `json:"moderator"`
+}
+
+type ResetDeviceRequestSpec struct {
+	AuthInfo
+	ProfileID uint32 `json:"pid"`
+	Moderator string `json:"moderator"`
+}
+
+type TransferProfileRequestSpec struct {
+	AuthInfo
+	ProfileID uint32 `json:"pid"`
+	UserID    uint64 `json:"user_id"`
+	GsbrCode  string `json:"gsbrcd"`
+	// Optionally assign a new profile ID once transferred
+	NewProfileID uint32 `json:"new_pid"`
+	Moderator    string `json:"moderator"`
+}
+
+type TransferProfileResponseSpec struct {
+	ProfileID       uint32 `json:"pid"`
+	MergedProfileID uint32 `json:"merged_pid"`
+}
+
+func HandleProfile(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	profileId, err := strconv.ParseUint(query.Get("pid"), 10, 32)
+	if err != nil || profileId == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	user, ok := db.GetProfile(uint32(profileId))
+	if !ok {
+		replyError(w, http.StatusOK, APIErrorProfileNotFound)
+		return
+	}
+
+	deviceIds, err := db.GetProfileDeviceIDs(uint32(profileId))
+	if err != nil {
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	replyOK(w, ProfileResponseSpec{
+		ProfileID:     user.ProfileId,
+		UserID:        user.UserId,
+		GsbrCode:      user.GsbrCode,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		LastInGameSn:  user.LastInGameSn,
+		OpenHost:      user.OpenHost,
+		DeviceIDs:     deviceIds,
+		LastIPAddress: user.LastIPAddress,
+	})
+}
+
+func HandleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	req := UpdateProfileRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	if req.ProfileID == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	user, ok := db.GetProfile(req.ProfileID)
+	if !ok {
+		replyError(w, http.StatusOK, APIErrorProfileNotFound)
+		return
+	}
+
+	oldDeviceIds, err := db.GetProfileDeviceIDs(req.ProfileID)
+	if err != nil {
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	edit := database.ProfileEdit{
+		FirstName:    req.FirstName,
+		LastName:     req.LastName,
+		LastInGameSn: req.LastInGameSn,
+		OpenHost:     req.OpenHost,
+		NgDeviceId:   req.DeviceIDs,
+	}
+
+	if err := db.EditProfile(req.ProfileID, edit); err != nil {
+		logging.Error("API:"+moderator, "Failed to update profile:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorUpdateFailed)
+		return
+	}
+
+	gpcm.ApplyProfileEdit(req.ProfileID, edit)
+
+	replyOK(w, nil)
+
+	// Record the old and new value of each changed field
+	changes := map[string]any{}
+	if req.FirstName != nil {
+		changes["firstname"] = []string{user.FirstName, *req.FirstName}
+	}
+	if req.LastName != nil {
+		changes["lastname"] = []string{user.LastName, *req.LastName}
+	}
+	if req.LastInGameSn != nil {
+		changes["last_ingamesn"] = []string{user.LastInGameSn, *req.LastInGameSn}
+	}
+	if req.OpenHost != nil {
+		changes["open_host"] = []bool{user.OpenHost, *req.OpenHost}
+	}
+	if req.DeviceIDs != nil {
+		changes["ng_device_ids"] = [][]uint32{oldDeviceIds, *req.DeviceIDs}
+	}
+
+	logging.Event("profile_updated", map[string]any{
+		"profile_id": req.ProfileID,
+		"changes":    changes,
+		"moderator":  moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Updated profile:", aurora.Cyan(req.ProfileID), "Fields changed:", aurora.Cyan(len(changes)))
+}
+
+func HandleResetDevice(w http.ResponseWriter, r *http.Request) {
+	req := ResetDeviceRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	if req.ProfileID == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	oldDeviceIds, err := db.GetProfileDeviceIDs(req.ProfileID)
+	if errors.Is(err, database.ErrProfileNotFound) {
+		replyError(w, http.StatusOK, APIErrorProfileNotFound)
+		return
+	} else if err != nil {
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	if err := db.ResetDeviceIDs(req.ProfileID); err != nil {
+		logging.Error("API:"+moderator, "Failed to reset device IDs:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorUpdateFailed)
+		return
+	}
+
+	// The player has to log in again from the new console to be bound to it
+	gpcm.KickPlayer(req.ProfileID, "device_reset")
+
+	replyOK(w, nil)
+
+	logging.Event("profile_device_reset", map[string]any{
+		"profile_id":        req.ProfileID,
+		"old_ng_device_ids": oldDeviceIds,
+		"moderator":         moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Reset device IDs for profile:", aurora.Cyan(req.ProfileID))
+}
+
+func HandleTransferProfile(w http.ResponseWriter, r *http.Request) {
+	req := TransferProfileRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	if req.ProfileID == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	if req.UserID == 0 || len(req.GsbrCode) < 4 || strings.ContainsRune(req.GsbrCode, 0) {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidUserPair)
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	if req.NewProfileID >= 1000000000 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	user, ok := db.GetProfile(req.ProfileID)
+	if !ok {
+		replyError(w, http.StatusOK, APIErrorProfileNotFound)
+		return
+	}
+
+	mergedId, mergedBanned, err := db.GetProfileIDByPair(req.UserID, req.GsbrCode)
+	if err != nil && err != pgx.ErrNoRows {
+		logging.Error("API:"+moderator, "Failed to look up the profile bound to the new pair:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+	if mergedBanned {
+		replyError(w, http.StatusBadRequest, APIErrorMergeBannedProfile)
+		return
+	}
+
+	// Both the transferred profile and the profile bound to the new pair must be offline
+	gpcm.KickPlayer(req.ProfileID, "profile_transferred")
+	if mergedId != 0 {
+		gpcm.KickPlayer(mergedId, "profile_transferred")
+	}
+
+	merged, err := db.TransferProfile(database.ProfileTransfer{
+		ProfileID:       req.ProfileID,
+		UserID:          req.UserID,
+		GsbrCode:        req.GsbrCode,
+		NewProfileID:    req.NewProfileID,
+		MergedProfileID: mergedId,
+		Moderator:       moderator,
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, database.ErrProfileNotFound):
+		replyError(w, http.StatusOK, APIErrorProfileNotFound)
+		return
+	case errors.Is(err, database.ErrTransferSameProfile):
+		replyError(w, http.StatusBadRequest, APIErrorInvalidUserPair)
+		return
+	case errors.Is(err, database.ErrProfileIDInUse), errors.Is(err, database.ErrReservedProfileIDRange):
+		replyError(w, http.StatusBadRequest, APIErrorProfileIDInUse)
+		return
+	case errors.Is(err, database.ErrTransferBannedProfile):
+		replyError(w, http.StatusBadRequest, APIErrorMergeBannedProfile)
+		return
+	case errors.Is(err, database.ErrTransferConflict):
+		replyError(w, http.StatusConflict, APIErrorTransferConflict)
+		return
+	default:
+		logging.Error("API:"+moderator, "Failed to transfer profile:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorUpdateFailed)
+		return
+	}
+
+	profileId := req.ProfileID
+	if req.NewProfileID != 0 {
+		profileId = req.NewProfileID
+	}
+
+	replyOK(w, TransferProfileResponseSpec{
+		ProfileID:       profileId,
+		MergedProfileID: mergedId,
+	})
+
+	if merged != nil {
+		logging.Event("profile_merged", map[string]any{
+			"profile_id":    merged.ProfileID,
+			"merged_into":   req.ProfileID,
+			"archive_id":    merged.ArchiveID,
+			"user_id":       req.UserID,
+			"gsbrcd":        req.GsbrCode,
+			"email":         merged.Email,
+			"unique_nick":   merged.UniqueNick,
+			"firstname":     merged.FirstName,
+			"lastname":      merged.LastName,
+			"last_ingamesn": merged.LastInGameSn,
+			"moderator":     moderator,
+		})
+
+		logging.Notice("API:"+moderator, "Merged profile", aurora.Cyan(merged.ProfileID), "into", aurora.Cyan(req.ProfileID), "archive ID:", aurora.Cyan(merged.ArchiveID))
+	}
+
+	logging.Event("profile_transferred", map[string]any{
+		"profile_id":        req.ProfileID,
+		"new_profile_id":    profileId,
+		"merged_profile_id": mergedId,
+		"old_user_id":       user.UserId,
+		"old_gsbrcd":        user.GsbrCode,
+		"user_id":           req.UserID,
+		"gsbrcd":            req.GsbrCode,
+		"moderator":         moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Transferred profile:", aurora.Cyan(req.ProfileID), "to", aurora.Cyan(req.UserID), aurora.Cyan(req.GsbrCode), "Profile ID:", aurora.Cyan(profileId), "Merged:", aurora.Cyan(mergedId))
+}
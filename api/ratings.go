@@ -0,0 +1,198 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type RatingHistoryResponseSpec struct {
+	ProfileID  uint32                              `json:"pid"`
+	RatingType string                              `json:"type"`
+	History    []database.MarioKartWiiRatingChange `json:"history"`
+}
+
+type ClearRatingFlagsRequestSpec struct {
+	AuthInfo
+	ProfileID  uint32 `json:"pid"`
+	RatingType string `json:"type"`
+	Moderator  string `json:"moderator"`
+}
+
+type RatingLeaderboardResponseSpec struct {
+	RatingType  string                             `json:"type"`
+	Leaderboard []database.MarioKartWiiRatingEntry `json:"leaderboard"`
+}
+
+// parseRatingQuery reads the rating type and result limits shared by the rating endpoints
+func parseRatingQuery(w http.ResponseWriter, query url.Values) (ratingType string, limit int, offset int, ok bool) {
+	ratingType = "vr"
+	if typeStr := query.Get("type"); typeStr != "" {
+		ratingType = typeStr
+	}
+
+	if ratingType != "vr" && ratingType != "br" {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return "", 0, 0, false
+	}
+
+	limit, offset, ok = parsePageQuery(w, query)
+	if !ok {
+		return "", 0, 0, false
+	}
+
+	return ratingType, limit, offset, true
+}
+
+// parsePageQuery reads the result limit and offset of a paginated endpoint
+func parsePageQuery(w http.ResponseWriter, query url.Values) (limit int, offset int, ok bool) {
+	var err error
+	limit = 100
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 1000 {
+			replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+			return 0, 0, false
+		}
+	}
+
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+			return 0, 0, false
+		}
+	}
+
+	return limit, offset, true
+}
+
+func HandleRatingHistory(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleNone)
+	if err != nil {
+		return
+	}
+
+	profileId, err := strconv.ParseUint(query.Get("pid"), 10, 32)
+	if err != nil || profileId == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	ratingType, limit, _, ok := parseRatingQuery(w, query)
+	if !ok {
+		return
+	}
+
+	history, err := db.GetMarioKartWiiRatingHistory(uint32(profileId), ratingType, limit)
+	if err != nil {
+		logging.Error("API", "Failed to get rating history:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	// Only moderators can see why a change was flagged
+	if !authenticate(makeAuthInfo(query), RoleModerator) {
+		for i := range history {
+			history[i].Flagged = false
+			history[i].Reason = ""
+		}
+	}
+
+	replyOK(w, RatingHistoryResponseSpec{
+		ProfileID:  uint32(profileId),
+		RatingType: ratingType,
+		History:    history,
+	})
+}
+
+func HandleRatingLeaderboard(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleNone)
+	if err != nil {
+		return
+	}
+
+	ratingType, limit, offset, ok := parseRatingQuery(w, query)
+	if !ok {
+		return
+	}
+
+	// Profiles with flagged changes are only listed for moderators who ask for them
+	includeFlagged := query.Get("include_flagged") == "true"
+	if includeFlagged && !authenticate(makeAuthInfo(query), RoleModerator) {
+		replyError(w, http.StatusUnauthorized, APIErrorFailedAuthentication)
+		return
+	}
+
+	leaderboard, err := db.GetMarioKartWiiRatingLeaderboard(ratingType, limit, offset, includeFlagged)
+	if err != nil {
+		logging.Error("API", "Failed to get rating leaderboard:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	if !includeFlagged {
+		for i := range leaderboard {
+			leaderboard[i].Flagged = 0
+		}
+	}
+
+	replyOK(w, RatingLeaderboardResponseSpec{
+		RatingType:  ratingType,
+		Leaderboard: leaderboard,
+	})
+}
+
+// HandleClearRatingFlags lists a profile on the rating leaderboard again after its flagged
+// changes have been reviewed
+func HandleClearRatingFlags(w http.ResponseWriter, r *http.Request) {
+	req := ClearRatingFlagsRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	if req.ProfileID == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	if req.RatingType == "" {
+		req.RatingType = "vr"
+	}
+	if req.RatingType != "vr" && req.RatingType != "br" {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	cleared, err := db.ClearMarioKartWiiRatingFlags(req.ProfileID, req.RatingType)
+	if err == pgx.ErrNoRows {
+		replyError(w, http.StatusOK, APIErrorRatingNotFound)
+		return
+	} else if err != nil {
+		logging.Error("API:"+moderator, "Failed to clear rating flags:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	replyOK(w, nil)
+
+	logging.Event("mkw_rating_flags_cleared", map[string]any{
+		"profile_id":  strconv.FormatUint(uint64(req.ProfileID), 10),
+		"rating_type": req.RatingType,
+		"cleared":     cleared,
+		"moderator":   moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Cleared", aurora.Cyan(cleared), "flagged", aurora.Cyan(req.RatingType), "changes of profile", aurora.Cyan(req.ProfileID))
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"net/http"
+	"wwfc/natneg"
+)
+
+func HandleRelayStats(w http.ResponseWriter, r *http.Request) {
+	_, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	replyOK(w, natneg.GetRelayStats())
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type ReputationResponseSpec struct {
+	ProfileID uint32                      `json:"pid"`
+	Score     float64                     `json:"score"`
+	History   []database.ReputationReport `json:"history"`
+}
+
+func HandleReputation(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	profileId, err := strconv.ParseUint(query.Get("pid"), 10, 32)
+	if err != nil || profileId == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	limit := 100
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 1000 {
+			replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+			return
+		}
+	}
+
+	score, updated, err := db.GetReputationScore(uint32(profileId))
+	if err != nil && err != pgx.ErrNoRows {
+		logging.Error("API", "Failed to get reputation score:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	history, err := db.GetReputationHistory(uint32(profileId), limit)
+	if err != nil {
+		logging.Error("API", "Failed to get reputation history:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	halfLife := time.Duration(common.GetConfig().Reputation.HalfLifeHours * float64(time.Hour))
+	replyOK(w, ReputationResponseSpec{
+		ProfileID: uint32(profileId),
+		Score:     database.DecayReputationScore(score, updated, halfLife),
+		History:   history,
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"wwfc/database"
+	"wwfc/logging"
+)
+
+type RoomHistoryResponseSpec struct {
+	ProfileID uint32                 `json:"pid"`
+	Rooms     []database.RoomHistory `json:"rooms"`
+}
+
+func HandleRoomHistory(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	profileId, err := strconv.ParseUint(query.Get("pid"), 10, 32)
+	if err != nil || profileId == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+		return
+	}
+
+	limit := 20
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+			return
+		}
+	}
+
+	rooms, err := db.GetProfileRoomHistory(uint32(profileId), limit)
+	if err != nil {
+		logging.Error("API", "Failed to get room history:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	replyOK(w, RoomHistoryResponseSpec{
+		ProfileID: uint32(profileId),
+		Rooms:     rooms,
+	})
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+	"wwfc/sake"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type SakeRecordRequestSpec struct {
+	AuthInfo
+	Game     string `json:"game"`
+	Table    string `json:"table"`
+	RecordID int32  `json:"record_id"`
+	// Fields to blank, every field if empty. Not used when deleting.
+	Fields    []string `json:"fields"`
+	Moderator string   `json:"moderator"`
+}
+
+type SakeFieldSpec struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// Decoded binary data as hex
+	Hex string `json:"hex,omitempty"`
+}
+
+type SakeRecordSpec struct {
+	Game       string                   `json:"game"`
+	Table      string                   `json:"table"`
+	RecordID   int32                    `json:"record_id"`
+	OwnerID    int32                    `json:"owner_id"`
+	Fields     map[string]SakeFieldSpec `json:"fields"`
+	CreateTime *time.Time               `json:"create_time"`
+	UpdateTime *time.Time               `json:"update_time"`
+}
+
+// HandleSakeRecords searches stored SAKE records by game, table, owner and field value
+func HandleSakeRecords(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	search := database.SakeRecordSearch{
+		TableID:    query.Get("table"),
+		FieldName:  query.Get("field"),
+		FieldValue: query.Get("value"),
+	}
+
+	if gameName := query.Get("game"); gameName != "" {
+		gameInfo := common.GetGameInfoByName(gameName)
+		if gameInfo == nil {
+			replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+			return
+		}
+		search.GameID = gameInfo.GameID
+	}
+
+	if pidStr := query.Get("pid"); pidStr != "" {
+		profileId, err := strconv.ParseUint(pidStr, 10, 31)
+		if err != nil || profileId == 0 {
+			replyError(w, http.StatusBadRequest, APIErrorInvalidProfileID)
+			return
+		}
+		search.OwnerID = int32(profileId)
+	}
+
+	limit, offset, ok := parsePageQuery(w, query)
+	if !ok {
+		return
+	}
+
+	records, err := db.SearchSakeRecords(search, limit, offset)
+	if err != nil {
+		logging.Error("API", "Failed to search SAKE records:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	specs := make([]SakeRecordSpec, 0, len(records))
+	for _, record := range records {
+		specs = append(specs, sakeRecordToSpec(record))
+	}
+
+	replyOK(w, specs)
+}
+
+func HandleDeleteSakeRecord(w http.ResponseWriter, r *http.Request) {
+	req := SakeRecordRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	gameInfo, _, ok := parseSakeRecordRequest(w, req)
+	if !ok {
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	record, err := db.ForceDeleteSakeRecord(gameInfo.GameID, req.Table, req.RecordID)
+	if err == pgx.ErrNoRows {
+		replyError(w, http.StatusOK, APIErrorSakeRecordNotFound)
+		return
+	} else if err != nil {
+		logging.Error("API:"+moderator, "Failed to delete SAKE record:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	replyOK(w, nil)
+
+	spec := sakeRecordToSpec(record)
+	logging.Event("sake_record_deleted", map[string]any{
+		"game":      gameInfo.Name,
+		"table":     req.Table,
+		"record_id": req.RecordID,
+		"profile":   strconv.FormatUint(uint64(record.OwnerId), 10),
+		"fields":    spec.Fields,
+		"moderator": moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Deleted SAKE record", aurora.Cyan(req.RecordID), "in table", aurora.Cyan(req.Table), "for game", aurora.Cyan(gameInfo.Name), "owned by", aurora.Cyan(record.OwnerId))
+}
+
+func HandleBlankSakeRecord(w http.ResponseWriter, r *http.Request) {
+	req := SakeRecordRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	gameInfo, table, ok := parseSakeRecordRequest(w, req)
+	if !ok {
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	record, err := db.GetSakeRecordDetails(gameInfo.GameID, req.Table, req.RecordID)
+	if err == pgx.ErrNoRows {
+		replyError(w, http.StatusOK, APIErrorSakeRecordNotFound)
+		return
+	} else if err != nil {
+		logging.Error("API:"+moderator, "Failed to get SAKE record:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	// Hardened tables only accept the fields and values their definition allows
+	blankFields, fieldName, result := table.GetBlankFields(record.Fields, req.Fields)
+	if result != sake.ResultSuccess {
+		logging.Error("API:"+moderator, "Cannot blank SAKE field", aurora.Cyan(fieldName).String()+":", aurora.Cyan(result))
+		replyError(w, http.StatusBadRequest, APIErrorInvalidSakeField)
+		return
+	}
+
+	if len(blankFields) != 0 {
+		err = db.OverwriteSakeRecordFields(gameInfo.GameID, req.Table, req.RecordID, blankFields)
+		if err == pgx.ErrNoRows {
+			replyError(w, http.StatusOK, APIErrorSakeRecordNotFound)
+			return
+		} else if err != nil {
+			logging.Error("API:"+moderator, "Failed to blank SAKE record:", err)
+			replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+			return
+		}
+	}
+
+	// Keep only the values that were removed for the audit trail
+	previous := sakeRecordToSpec(record).Fields
+	for name := range previous {
+		if _, blanked := blankFields[name]; !blanked {
+			delete(previous, name)
+		}
+	}
+
+	for name, field := range blankFields {
+		record.Fields[name] = field
+	}
+	replyOK(w, sakeRecordToSpec(record))
+
+	logging.Event("sake_record_blanked", map[string]any{
+		"game":      gameInfo.Name,
+		"table":     req.Table,
+		"record_id": req.RecordID,
+		"profile":   strconv.FormatUint(uint64(record.OwnerId), 10),
+		"fields":    previous,
+		"moderator": moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Blanked", aurora.Cyan(len(blankFields)), "fields of SAKE record", aurora.Cyan(req.RecordID), "in table", aurora.Cyan(req.Table), "for game", aurora.Cyan(gameInfo.Name))
+}
+
+// parseSakeRecordRequest looks up the game and table of a request for a single record. Reserved
+// tables don't hold their records in the SAKE database, so they are refused.
+func parseSakeRecordRequest(w http.ResponseWriter, req SakeRecordRequestSpec) (*common.GameInfo, *sake.SakeTable, bool) {
+	gameInfo := common.GetGameInfoByName(req.Game)
+	if gameInfo == nil || req.Table == "" || req.RecordID == 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return nil, nil, false
+	}
+
+	table := sake.GetTable(gameInfo.Name, req.Table)
+	if table != nil && table.Reserved {
+		replyError(w, http.StatusBadRequest, APIErrorSakeTableReserved)
+		return nil, nil, false
+	}
+
+	return gameInfo, table, true
+}
+
+func sakeRecordToSpec(record database.SakeRecordDetails) SakeRecordSpec {
+	spec := SakeRecordSpec{
+		Table:      record.TableId,
+		RecordID:   record.RecordId,
+		OwnerID:    record.OwnerId,
+		Fields:     map[string]SakeFieldSpec{},
+		CreateTime: record.CreateTime,
+		UpdateTime: record.UpdateTime,
+	}
+
+	if gameInfo := common.GetGameInfoByID(record.GameId); gameInfo != nil {
+		spec.Game = gameInfo.Name
+	} else {
+		spec.Game = strconv.Itoa(record.GameId)
+	}
+
+	for name, field := range record.Fields {
+		fieldSpec := SakeFieldSpec{
+			Type:  sake.FieldTypeName(field.Type),
+			Value: field.Value,
+		}
+		if field.Type == database.SakeFieldTypeBinaryData {
+			if data, err := base64.StdEncoding.DecodeString(field.Value); err == nil {
+				fieldSpec.Hex = hex.EncodeToString(data)
+			}
+		}
+		spec.Fields[name] = fieldSpec
+	}
+
+	return spec
+}
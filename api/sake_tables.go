@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"wwfc/logging"
+	"wwfc/sake"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type ReloadSakeTablesRequestSpec struct {
+	AuthInfo
+	Moderator string `json:"moderator"`
+}
+
+type ReloadSakeTablesResponseSpec struct {
+	// Number of tables loaded from files
+	Count int `json:"count"`
+}
+
+func HandleSakeTables(w http.ResponseWriter, r *http.Request) {
+	_, err := parseGet(r, w, RoleNone)
+	if err != nil {
+		return
+	}
+
+	replyOK(w, sake.GetTableSpecs())
+}
+
+func HandleReloadSakeTables(w http.ResponseWriter, r *http.Request) {
+	req := ReloadSakeTablesRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	count, err := sake.LoadTableDefinitions()
+	if err != nil {
+		logging.Error("API:"+moderator, "Failed to reload SAKE tables:", err)
+		replyError(w, http.StatusBadRequest, APIErrorInvalidSakeTables)
+		return
+	}
+
+	replyOK(w, ReloadSakeTablesResponseSpec{Count: count})
+
+	logging.Event("sake_tables_reloaded", map[string]any{
+		"count":     count,
+		"moderator": moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Reloaded", aurora.Cyan(count), "SAKE tables")
+}
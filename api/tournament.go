@@ -0,0 +1,211 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+	"wwfc/qr2"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Longest a tournament can run for
+const maxTournamentDuration = 7 * 24 * time.Hour
+
+type CreateTournamentRequestSpec struct {
+	AuthInfo
+	// Room code, a random one is chosen if empty
+	Code            string    `json:"code"`
+	Name            string    `json:"name"`
+	HostProfileID   uint32    `json:"host_pid"`
+	ProfileIDs      []uint32  `json:"profile_ids"`
+	CourseIDs       []int     `json:"course_ids"`
+	EngineClassIDs  []int     `json:"engine_class_ids"`
+	StartTime       time.Time `json:"start_time"`
+	DurationMinutes int       `json:"duration_minutes"`
+	Moderator       string    `json:"moderator"`
+}
+
+type DeleteTournamentRequestSpec struct {
+	AuthInfo
+	ID        int    `json:"id"`
+	Moderator string `json:"moderator"`
+}
+
+type TournamentResponseSpec struct {
+	Tournament database.Tournament    `json:"tournament"`
+	Rooms      []database.RoomHistory `json:"rooms"`
+}
+
+func HandleTournament(w http.ResponseWriter, r *http.Request) {
+	query, err := parseGet(r, w, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	// List the tournaments that haven't finished if no ID is given
+	if query.Get("id") == "" {
+		tournaments, err := db.GetUnfinishedTournaments()
+		if err != nil {
+			logging.Error("API", "Failed to get tournaments:", err)
+			replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+			return
+		}
+
+		replyOK(w, tournaments)
+		return
+	}
+
+	id, err := strconv.Atoi(query.Get("id"))
+	if err != nil || id <= 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	tournament, err := db.GetTournament(id)
+	if err == pgx.ErrNoRows {
+		replyError(w, http.StatusOK, APIErrorTournamentNotFound)
+		return
+	} else if err != nil {
+		logging.Error("API", "Failed to get tournament:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	rooms, err := db.GetTournamentRooms(id)
+	if err != nil {
+		logging.Error("API", "Failed to get tournament rooms:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	replyOK(w, TournamentResponseSpec{
+		Tournament: tournament,
+		Rooms:      rooms,
+	})
+}
+
+func HandleCreateTournament(w http.ResponseWriter, r *http.Request) {
+	req := CreateTournamentRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	if req.Code == "" {
+		req.Code = common.RandomString(6)
+	}
+
+	if req.DurationMinutes == 0 {
+		req.DurationMinutes = 240
+	}
+	duration := time.Duration(req.DurationMinutes) * time.Minute
+
+	if req.Name == "" || req.HostProfileID == 0 || len(req.ProfileIDs) == 0 || len(req.ProfileIDs) > 100 ||
+		len(req.Code) < 4 || len(req.Code) > 16 || !common.IsUppercaseAlphanumeric(req.Code) ||
+		req.StartTime.IsZero() || duration <= 0 || duration > maxTournamentDuration {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidTournament)
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	if !slices.Contains(req.ProfileIDs, req.HostProfileID) {
+		req.ProfileIDs = append(req.ProfileIDs, req.HostProfileID)
+	}
+
+	if req.CourseIDs == nil {
+		req.CourseIDs = []int{}
+	}
+	if req.EngineClassIDs == nil {
+		req.EngineClassIDs = []int{}
+	}
+
+	tournament := database.Tournament{
+		Code:           req.Code,
+		Name:           req.Name,
+		HostProfileID:  req.HostProfileID,
+		ProfileIDs:     req.ProfileIDs,
+		CourseIDs:      req.CourseIDs,
+		EngineClassIDs: req.EngineClassIDs,
+		StartTime:      req.StartTime.UTC(),
+		EndTime:        req.StartTime.UTC().Add(duration),
+		Moderator:      moderator,
+	}
+
+	if err := db.CreateTournament(&tournament); err == database.ErrTournamentCodeInUse {
+		replyError(w, http.StatusConflict, APIErrorTournamentCodeInUse)
+		return
+	} else if err == database.ErrTournamentOverlap {
+		replyError(w, http.StatusConflict, APIErrorTournamentOverlap)
+		return
+	} else if err != nil {
+		logging.Error("API:"+moderator, "Failed to create tournament:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	}
+
+	qr2.AddTournament(tournament)
+
+	replyOK(w, tournament)
+
+	logging.Event("tournament_created", map[string]any{
+		"tournament_id": tournament.ID,
+		"code":          tournament.Code,
+		"name":          tournament.Name,
+		"host_id":       tournament.HostProfileID,
+		"profile_ids":   tournament.ProfileIDs,
+		"start_time":    tournament.StartTime,
+		"end_time":      tournament.EndTime,
+		"moderator":     moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Created tournament", aurora.Cyan(tournament.Name), "code:", aurora.Cyan(tournament.Code), "players:", aurora.Cyan(len(tournament.ProfileIDs)))
+}
+
+func HandleDeleteTournament(w http.ResponseWriter, r *http.Request) {
+	req := DeleteTournamentRequestSpec{}
+	err := parsePost(r, w, &req, RoleModerator)
+	if err != nil {
+		return
+	}
+
+	if req.ID <= 0 {
+		replyError(w, http.StatusBadRequest, APIErrorInvalidQuery)
+		return
+	}
+
+	moderator := req.Moderator
+	if moderator == "" {
+		moderator = "admin"
+	}
+
+	deleted, err := db.DeleteTournament(req.ID)
+	if err != nil {
+		logging.Error("API:"+moderator, "Failed to delete tournament:", err)
+		replyError(w, http.StatusInternalServerError, APIErrorDatabase)
+		return
+	} else if !deleted {
+		replyError(w, http.StatusOK, APIErrorTournamentNotFound)
+		return
+	}
+
+	qr2.RemoveTournament(req.ID)
+
+	replyOK(w, nil)
+
+	logging.Event("tournament_deleted", map[string]any{
+		"tournament_id": req.ID,
+		"moderator":     moderator,
+	})
+
+	logging.Notice("API:"+moderator, "Deleted tournament", aurora.Cyan(req.ID))
+}
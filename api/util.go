@@ -21,6 +21,28 @@ const (
 	APIErrorBanFailed            APIErrorString = "ban_failed"
 	APIErrorUnbanFailed          APIErrorString = "unban_failed"
 	APIErrorBanNotFound          APIErrorString = "ban_not_found"
+	APIErrorProfileNotFound      APIErrorString = "profile_not_found"
+	APIErrorInvalidJoinPolicy    APIErrorString = "invalid_join_policy"
+	APIErrorUpdateFailed         APIErrorString = "update_failed"
+	APIErrorDatabase             APIErrorString = "database_error"
+	APIErrorInvalidUserPair      APIErrorString = "invalid_user_pair"
+	APIErrorProfileIDInUse       APIErrorString = "profile_id_in_use"
+	APIErrorMergeBannedProfile   APIErrorString = "merge_banned_profile"
+	APIErrorTransferConflict     APIErrorString = "transfer_conflict"
+	APIErrorGroupNotFound        APIErrorString = "group_not_found"
+	APIErrorTooManyWatchers      APIErrorString = "too_many_watchers"
+	APIErrorStreamingUnsupported APIErrorString = "streaming_unsupported"
+	APIErrorInvalidTournament    APIErrorString = "invalid_tournament"
+	APIErrorTournamentNotFound   APIErrorString = "tournament_not_found"
+	APIErrorTournamentCodeInUse  APIErrorString = "tournament_code_in_use"
+	APIErrorTournamentOverlap    APIErrorString = "tournament_overlap"
+	APIErrorInvalidSakeTables    APIErrorString = "invalid_sake_tables"
+	APIErrorGhostNotFound        APIErrorString = "ghost_not_found"
+	APIErrorGhostReviewNotFound  APIErrorString = "ghost_review_not_found"
+	APIErrorSakeRecordNotFound   APIErrorString = "sake_record_not_found"
+	APIErrorSakeTableReserved    APIErrorString = "sake_table_reserved"
+	APIErrorInvalidSakeField     APIErrorString = "invalid_sake_field"
+	APIErrorRatingNotFound       APIErrorString = "rating_not_found"
 )
 
 type APIError struct {
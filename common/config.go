@@ -14,13 +14,15 @@ type Config struct {
 	DatabaseAddress string `xml:"databaseAddress"`
 	DatabaseName    string `xml:"databaseName"`
 
-	DefaultAddress       string  `xml:"address"`
-	GameSpyAddress       *string `xml:"gsAddress,omitempty"`
-	NASAddress           *string `xml:"nasAddress,omitempty"`
-	NASPort              string  `xml:"nasPort"`
-	NASAddressHTTPS      *string `xml:"nasAddressHttps,omitempty"`
-	NASPortHTTPS         string  `xml:"nasPortHttps"`
-	PayloadServerAddress string  `xml:"payloadServerAddress"`
+	DefaultAddress string  `xml:"address"`
+	GameSpyAddress *string `xml:"gsAddress,omitempty"`
+	// Second IP address used for NATNEG's unsolicited IP tests
+	NATNEGSecondaryAddress *string `xml:"natnegSecondaryAddress,omitempty"`
+	NASAddress             *string `xml:"nasAddress,omitempty"`
+	NASPort                string  `xml:"nasPort"`
+	NASAddressHTTPS        *string `xml:"nasAddressHttps,omitempty"`
+	NASPortHTTPS           string  `xml:"nasPortHttps"`
+	PayloadServerAddress   string  `xml:"payloadServerAddress"`
 
 	FrontendAddress        string `xml:"frontendAddress"`
 	FrontendBackendAddress string `xml:"frontendBackendAddress"`
@@ -50,7 +52,19 @@ type Config struct {
 
 	ServerName string `xml:"serverName,omitempty"`
 
+	SakeTablesPath string          `xml:"sakeTablesPath"`
+	SakeFiles      SakeFilesConfig `xml:"sakeFiles"`
+
 	EventReporting EventReportingConfig `xml:"eventReporting"`
+
+	PayloadVersionPolicies []PayloadVersionPolicyConfig `xml:"payloadVersions>game"`
+	ReportEscalations      []ReportEscalationConfig     `xml:"reportEscalations>rule"`
+	Reputation             ReputationConfig             `xml:"reputation"`
+	Relay                  RelayConfig                  `xml:"relay"`
+	Matchmaking            MatchmakingConfig            `xml:"matchmaking"`
+	Ratings                RatingsConfig                `xml:"ratings"`
+	GhostReview            GhostReviewConfig            `xml:"ghostReview"`
+	GameStats              GameStatsConfig              `xml:"gameStats"`
 }
 
 type EventReportingConfig struct {
@@ -58,6 +72,131 @@ type EventReportingConfig struct {
 	Webhooks      []logging.WebhookConfig `xml:"discord>webhook"`
 }
 
+type PayloadVersionPolicyConfig struct {
+	GameName        string   `xml:"name,attr"`
+	Minimum         string   `xml:"minimum"`
+	Recommended     string   `xml:"recommended"`
+	Blocked         []string `xml:"blocked"`
+	GracePeriodEnd  string   `xml:"gracePeriodEnd"`
+	OutdatedMessage string   `xml:"outdatedMessage"`
+	BlockedMessage  string   `xml:"blockedMessage"`
+	WarningMessage  string   `xml:"warningMessage"`
+}
+
+type ReportEscalationConfig struct {
+	Record        string `xml:"record,attr"`
+	Threshold     int    `xml:"threshold"`
+	WindowMinutes int    `xml:"windowMinutes"`
+	Action        string `xml:"action"`
+}
+
+type ReputationConfig struct {
+	// Score at which a player is kept out of public rooms
+	Threshold       float64 `xml:"threshold"`
+	HalfLifeHours   float64 `xml:"halfLifeHours"`
+	StallWeight     float64 `xml:"stallWeight"`
+	BadPacketWeight float64 `xml:"badPacketWeight"`
+	// A reporter's reports against the same player only count once within this window
+	ReportWindowHours float64 `xml:"reportWindowHours"`
+}
+
+type RelayConfig struct {
+	Enable bool `xml:"enable"`
+	// Address the relay binds to, and the address advertised to clients if it differs
+	Address       string `xml:"address"`
+	PublicAddress string `xml:"publicAddress"`
+	PortMin       int    `xml:"portMin"`
+	PortMax       int    `xml:"portMax"`
+	// Bandwidth limit for each relayed pair, in kilobytes per second
+	BandwidthLimitKB   int  `xml:"bandwidthLimitKB"`
+	IdleTimeoutSeconds int  `xml:"idleTimeoutSeconds"`
+	RelaySymmetricNAT  bool `xml:"relaySymmetricNat"`
+	// Relayed pairs and failed negotiations tracked for a single IP, 0 for no limit
+	MaxPairsPerIP int `xml:"maxPairsPerIp"`
+}
+
+type MatchmakingConfig struct {
+	Enable bool `xml:"enable"`
+	// Rooms with an average rating within this distance of the player's are listed first
+	SoftRatingBand int `xml:"softRatingBand"`
+	// Rooms further than this from the player's rating are not listed at all, 0 to disable
+	HardRatingBand int `xml:"hardRatingBand"`
+	// Largest allowed difference between the lowest and highest rating in a public room, 0 to disable
+	MaxRatingSpread  int  `xml:"maxRatingSpread"`
+	PreferSameRegion bool `xml:"preferSameRegion"`
+}
+
+type RatingsConfig struct {
+	Enable bool `xml:"enable"`
+	// Largest VR or BR change per race before the change is flagged
+	MaxChangePerRace int `xml:"maxChangePerRace"`
+}
+
+type GhostReviewConfig struct {
+	Enable  bool                      `xml:"enable"`
+	Courses []GhostReviewCourseConfig `xml:"course"`
+}
+
+type GhostReviewCourseConfig struct {
+	CourseID int `xml:"id,attr"`
+	// Known world record in milliseconds, faster times are sent for review
+	Record int `xml:"record"`
+	// Fastest time that is physically possible, faster times are rejected
+	Minimum int `xml:"minimum"`
+}
+
+type GameStatsConfig struct {
+	// "strict" to reject web requests with an invalid hash or token, or "lenient" to only log them
+	HashPolicy string `xml:"hashPolicy"`
+	// How long a web token can be used for, each token can only be used once
+	TokenExpirySeconds int `xml:"tokenExpirySeconds"`
+	// Most unused tokens kept in total and for each IP address, further token requests are refused
+	MaxTokens      int `xml:"maxTokens"`
+	MaxTokensPerIP int `xml:"maxTokensPerIp"`
+	// Most bytes of persistent data each profile can store
+	StorageQuota int `xml:"storageQuota"`
+	// Most game snapshots kept for each profile, the oldest finished ones are replaced
+	MaxSnapshots int                   `xml:"maxSnapshots"`
+	Games        []GameStatsGameConfig `xml:"game"`
+}
+
+type GameStatsGameConfig struct {
+	GameName   string `xml:"name,attr"`
+	HashPolicy string `xml:"hashPolicy"`
+	// Store and serve put2/get2 rankings, other games get an empty ranking list
+	Rankings bool `xml:"rankings"`
+}
+
+type SakeFilesConfig struct {
+	// "disk" or "s3"
+	Storage string `xml:"storage"`
+	// Directory files are stored in when using disk storage
+	Path string   `xml:"path"`
+	S3   S3Config `xml:"s3"`
+	// Largest file accepted from games without their own limit, 0 to only accept files from listed games
+	DefaultMaxSize int                  `xml:"defaultMaxSize"`
+	Games          []SakeFileGameConfig `xml:"game"`
+	// Reject uploads without a login ticket instead of checking the pid against the player's GPCM session
+	RequireLoginTicket bool `xml:"requireLoginTicket"`
+	// New files a profile may upload in a day, 0 for no limit
+	MaxUploadsPerDay int `xml:"maxUploadsPerDay"`
+}
+
+type S3Config struct {
+	Endpoint  string `xml:"endpoint"`
+	Region    string `xml:"region"`
+	Bucket    string `xml:"bucket"`
+	AccessKey string `xml:"accessKey"`
+	SecretKey string `xml:"secretKey"`
+}
+
+type SakeFileGameConfig struct {
+	GameName string `xml:"name,attr"`
+	MaxSize  int    `xml:"maxSize"`
+	// Hex encoded prefixes a file has to start with, any content is accepted if there are none
+	Magic []string `xml:"magic"`
+}
+
 var (
 	config       Config
 	configLoaded bool
@@ -81,6 +220,43 @@ func GetConfig() Config {
 	config.AllowMultipleDeviceIDs = "never"
 	config.AllowConnectWithoutDeviceID = false
 	config.ServerName = "WiiLink"
+	config.SakeTablesPath = "./sake_tables"
+	config.SakeFiles = SakeFilesConfig{
+		Storage:          "disk",
+		Path:             "./sake_files",
+		MaxUploadsPerDay: 32,
+	}
+	config.Reputation = ReputationConfig{
+		Threshold:         10,
+		HalfLifeHours:     72,
+		StallWeight:       1,
+		BadPacketWeight:   0.5,
+		ReportWindowHours: 24,
+	}
+	config.Relay = RelayConfig{
+		PortMin:            50000,
+		PortMax:            50999,
+		BandwidthLimitKB:   64,
+		IdleTimeoutSeconds: 60,
+		RelaySymmetricNAT:  true,
+		MaxPairsPerIP:      16,
+	}
+	config.Matchmaking = MatchmakingConfig{
+		SoftRatingBand:   1000,
+		MaxRatingSpread:  5000,
+		PreferSameRegion: true,
+	}
+	config.Ratings = RatingsConfig{
+		MaxChangePerRace: 200,
+	}
+	config.GameStats = GameStatsConfig{
+		HashPolicy:         "strict",
+		TokenExpirySeconds: 300,
+		MaxTokens:          65536,
+		MaxTokensPerIP:     64,
+		StorageQuota:       65536,
+		MaxSnapshots:       32,
+	}
 
 	err = xml.Unmarshal(data, &config)
 	if err != nil {
@@ -4,9 +4,16 @@ import (
 	"time"
 )
 
-func EncryptTypeX(key []byte, challenge []byte, data []byte) []byte {
+// TypeXEncryptor holds the state of a GameSpy EncTypeX stream. Data written to a
+// connection after the first reply continues the same stream.
+type TypeXEncryptor struct {
+	encxkey []byte
+}
+
+// NewTypeXEncryptor starts a new EncTypeX stream, returning the header that has to be sent
+// before any encrypted data
+func NewTypeXEncryptor(key []byte, challenge []byte) (*TypeXEncryptor, []byte) {
 	returnData := make([]byte, 20)
-	returnData = append(returnData, data...)
 
 	rnd := time.Now().Unix()
 
@@ -21,12 +28,22 @@ func EncryptTypeX(key []byte, challenge []byte, data []byte) []byte {
 	returnData[2] = 0x00
 	returnData[headerLen-1] = byte((20 - headerLen) ^ 0xea)
 
-	header := returnData[:20]
-	encxkey := make([]byte, 261)
-	returnData = initEncrypt(encxkey, key, challenge, returnData)
-	func6e(encxkey, returnData)
+	header := append([]byte{}, returnData...)
+	encryptor := &TypeXEncryptor{encxkey: make([]byte, 261)}
+	// Copy the challenge as the key setup modifies it
+	initEncrypt(encryptor.encxkey, key, append([]byte{}, challenge...), returnData)
+
+	return encryptor, header
+}
+
+// Encrypt encrypts the next data in the stream in place
+func (e *TypeXEncryptor) Encrypt(data []byte) []byte {
+	return func6e(e.encxkey, data)
+}
 
-	return append(header, returnData...)
+func EncryptTypeX(key []byte, challenge []byte, data []byte) []byte {
+	encryptor, header := NewTypeXEncryptor(key, challenge)
+	return append(header, encryptor.Encrypt(data)...)
 }
 
 func initEncrypt(encxkey, key, validate, data []byte) []byte {
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 )
@@ -12,3 +13,110 @@ func TestEncryption(t *testing.T) {
 	}
 	fmt.Printf("\n")
 }
+
+func TestEncryptionStream(t *testing.T) {
+	key := []byte("key")
+	challenge := []byte("challeng")
+
+	encryptor, header := NewTypeXEncryptor(key, challenge)
+	streamed := append(encryptor.Encrypt([]byte("first")), encryptor.Encrypt([]byte("second"))...)
+
+	// Rebuild the stream state from the header and encrypt everything at once
+	oneShot := &TypeXEncryptor{encxkey: make([]byte, 261)}
+	initEncrypt(oneShot.encxkey, key, append([]byte{}, challenge...), append([]byte{}, header...))
+	expected := oneShot.Encrypt([]byte("firstsecond"))
+
+	if !bytes.Equal(streamed, expected) {
+		t.Errorf("streamed encryption % x does not match % x", streamed, expected)
+	}
+}
+
+// decryptTypeX decrypts a server list the way the client does
+func decryptTypeX(key []byte, challenge []byte, data []byte) []byte {
+	encxkey := make([]byte, 261)
+	data = initEncrypt(encxkey, key, append([]byte{}, challenge...), append([]byte{}, data...))
+
+	for i := range data {
+		data[i] = func7d(encxkey, data[i])
+	}
+
+	return data
+}
+
+// func7d is func7e with the plaintext and ciphertext swapped in the feedback
+func func7d(encxkey []byte, d byte) byte {
+	a := encxkey[256]
+	b := encxkey[257]
+	c := encxkey[a]
+	encxkey[256] = a + 1
+	encxkey[257] = b + c
+
+	a = encxkey[260]
+	b = encxkey[257]
+	b = encxkey[b]
+	c = encxkey[a]
+	encxkey[a] = b
+
+	a = encxkey[259]
+	b = encxkey[257]
+	a = encxkey[a]
+	encxkey[b] = a
+
+	a = encxkey[256]
+	b = encxkey[259]
+	a = encxkey[a]
+	encxkey[b] = a
+
+	a = encxkey[256]
+	encxkey[a] = c
+
+	b = encxkey[258]
+	a = encxkey[c]
+	c = encxkey[259]
+	b = a + b
+	encxkey[258] = b
+
+	a = b
+	c = encxkey[c]
+	b = encxkey[257]
+	b = encxkey[b]
+	a = encxkey[a]
+	c = b + c
+	b = encxkey[260]
+	b = encxkey[b]
+	c = b + c
+	b = encxkey[c]
+	c = encxkey[256]
+	c = encxkey[c]
+	a = a + c
+	c = encxkey[b]
+	b = encxkey[a]
+	c ^= b ^ d
+	encxkey[260] = d
+	encxkey[259] = c
+
+	return c
+}
+
+// The list below is built by hand, not captured from a console, and is decrypted with the
+// client's algorithm as reimplemented above. No capture is available, so this only checks that
+// the stream stays consistent across messages, not that it matches what retail clients expect.
+func TestEncryptionRoundTrip(t *testing.T) {
+	// Server list header for mariokartwii as it would be sent to a client on 192.168.1.2:51234, with one requested field
+	key := []byte("9r3Rmy")
+	challenge := []byte("AbCdEfGh")
+	list := []byte{0xc0, 0xa8, 0x01, 0x02, 0xc8, 0x22, 0x01, 0x00, 'd', 'w', 'c', '_', 'p', 'i', 'd', 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff}
+
+	encryptor, header := NewTypeXEncryptor(key, challenge)
+	encrypted := append(header, encryptor.Encrypt(append([]byte{}, list...))...)
+
+	// Messages pushed later continue the same stream
+	push := []byte{0x00, 0x09, 0x04, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	encrypted = append(encrypted, encryptor.Encrypt(append([]byte{}, push...))...)
+
+	decrypted := decryptTypeX(key, challenge, encrypted)
+	expected := append(list, push...)
+	if !bytes.Equal(decrypted, expected) {
+		t.Errorf("decrypted % x, expected % x", decrypted, expected)
+	}
+}
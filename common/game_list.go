@@ -6,6 +6,20 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Server browser encryption modes, set in the optional seventh column of game_list.tsv
+const (
+	EncryptionNone    = "none"
+	EncryptionTypeX   = "enctypex"
+	EncryptionDefault = ""
+	// TODO: Implement enctype1 and enctype2 once there is captured traffic from a title using
+	// them to test against. Until then server list requests for games set to these are refused.
+	EncryptionType1 = "enctype1"
+	EncryptionType2 = "enctype2"
 )
 
 type GameInfo struct {
@@ -15,6 +29,7 @@ type GameInfo struct {
 	GameStatsVersion int
 	GameStatsKey     string
 	Description      string
+	Encryption       string
 }
 
 var (
@@ -84,6 +99,8 @@ func ReadGameList() {
 
 	reader := csv.NewReader(file)
 	reader.Comma = '\t'
+	// The encryption column is optional
+	reader.FieldsPerRecord = -1
 	csvList, err := reader.ReadAll()
 	if err != nil {
 		panic(err)
@@ -112,6 +129,11 @@ func ReadGameList() {
 			}
 		}
 
+		encryption := EncryptionDefault
+		if len(entry) > 6 {
+			encryption = entry[6]
+		}
+
 		gameList = append(gameList, GameInfo{
 			GameID:           gameId,
 			Name:             entry[1],
@@ -119,6 +141,7 @@ func ReadGameList() {
 			GameStatsVersion: gameStatsVer,
 			GameStatsKey:     entry[5],
 			Description:      entry[0],
+			Encryption:       getEncryptionMode(entry[1], encryption, entry[3]),
 		})
 
 		// Create lookup tables
@@ -131,6 +154,36 @@ func ReadGameList() {
 	readGameList = true
 }
 
+// getEncryptionMode validates a game's server browser encryption mode. Games default to
+// enctypex, or no encryption if they have no secret key to encrypt with.
+func getEncryptionMode(gameName string, encryption string, secretKey string) string {
+	switch encryption {
+	case EncryptionDefault:
+		if secretKey == "" {
+			return EncryptionNone
+		}
+		return EncryptionTypeX
+
+	case EncryptionNone:
+		return EncryptionNone
+
+	case EncryptionTypeX:
+		if secretKey == "" {
+			logging.Error("COMMON", "Game", aurora.Cyan(gameName), "uses enctypex without a secret key")
+			return EncryptionNone
+		}
+		return EncryptionTypeX
+
+	case EncryptionType1, EncryptionType2:
+		// Sending these games a list they can't decrypt would look like an empty list to players
+		logging.Error("COMMON", "Game", aurora.Cyan(gameName), "uses encryption", aurora.Cyan(encryption), "which is not implemented yet, its server list requests will be refused")
+		return encryption
+
+	default:
+		panic("Invalid encryption mode for " + gameName + ": " + encryption)
+	}
+}
+
 func GetExpectedUnitCode(gameName string) byte {
 	if strings.HasSuffix(gameName, "wii") || strings.HasSuffix(gameName, "wiiam") {
 		return 1
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestGetEncryptionMode(t *testing.T) {
+	tests := []struct {
+		encryption string
+		secretKey  string
+		expected   string
+	}{
+		{EncryptionDefault, "9r3Rmy", EncryptionTypeX},
+		{EncryptionDefault, "", EncryptionNone},
+		{EncryptionNone, "9r3Rmy", EncryptionNone},
+		{EncryptionTypeX, "9r3Rmy", EncryptionTypeX},
+		{EncryptionTypeX, "", EncryptionNone},
+		{EncryptionType1, "9r3Rmy", EncryptionType1},
+		{EncryptionType2, "9r3Rmy", EncryptionType2},
+	}
+
+	for _, test := range tests {
+		if mode := getEncryptionMode("test", test.encryption, test.secretKey); mode != test.expected {
+			t.Errorf("getEncryptionMode(%q, %q) = %q, expected %q", test.encryption, test.secretKey, mode, test.expected)
+		}
+	}
+}
+
+func TestGetEncryptionModeInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("invalid encryption mode did not panic")
+		}
+	}()
+
+	getEncryptionMode("test", "enctype3", "9r3Rmy")
+}
@@ -213,6 +213,48 @@ func (controllerId MarioKartWiiControllerId) IsValid() bool {
 	return controllerId >= WiiWheel && controllerId <= GameCube
 }
 
+// MarioKartWiiPlayerInfo is stored with every leaderboard time
+type MarioKartWiiPlayerInfo struct {
+	MiiData      RawMii // 0x00
+	ControllerId byte   // 0x4C
+	Unknown      byte   // 0x4D
+	StateCode    byte   // 0x4E
+	CountryCode  byte   // 0x4F
+}
+
+const MarioKartWiiPlayerInfoSize = 0x50
+
+// FixMarioKartWiiPlayerInfo checks if the player info is valid, and if so, returns a copy of it with the Mii's personal info cleared
+func FixMarioKartWiiPlayerInfo(data []byte) ([]byte, bool) {
+	if len(data) != MarioKartWiiPlayerInfoSize {
+		return nil, false
+	}
+
+	var playerInfo MarioKartWiiPlayerInfo
+	err := binary.Read(bytes.NewReader(data), binary.BigEndian, &playerInfo)
+	if err != nil {
+		return nil, false
+	}
+
+	if playerInfo.MiiData.CalculateMiiCRC() != 0x0000 {
+		return nil, false
+	}
+
+	if !MarioKartWiiControllerId(playerInfo.ControllerId).IsValid() {
+		return nil, false
+	}
+
+	playerInfo.MiiData = playerInfo.MiiData.ClearMiiInfo()
+
+	fixed := new(bytes.Buffer)
+	err = binary.Write(fixed, binary.BigEndian, playerInfo)
+	if err != nil {
+		return nil, false
+	}
+
+	return fixed.Bytes(), true
+}
+
 type RKGhostData []byte
 
 const (
@@ -470,9 +512,11 @@ func (rkgd RKGhostData) IsRKGDFileValid(moduleName string, expectedCourse MarioK
 		return false
 	}
 
+	// The game sizes its input buffer from the header, so the decompressed data must match it
 	decompSize := binary.BigEndian.Uint32(szsData[0x4:0x8])
 	if uint32(rkgd.GetInputDataLength()) != decompSize {
-		logging.Error(moduleName, "Invalid RKGD input data length:", aurora.Cyan(rkgd.GetInputDataLength()), "actual:", aurora.Cyan(len(szsData)))
+		logging.Error(moduleName, "Invalid RKGD input data length:", aurora.Cyan(rkgd.GetInputDataLength()), "decompressed size:", aurora.Cyan(decompSize))
+		return false
 	}
 
 	if rkgd.GetCompressedSize() != uint32(len(szsData)) {
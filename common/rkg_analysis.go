@@ -0,0 +1,263 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+)
+
+type RKGInput struct {
+	Value  byte
+	Frames int
+}
+
+// RKGInputData is the decompressed input stream of a ghost
+type RKGInputData struct {
+	FaceButtons []RKGInput
+	Directions  []RKGInput
+	Tricks      []RKGInput
+}
+
+// Reasons a ghost is sent for review
+const (
+	GhostFlagFasterThanRecord = "faster_than_record"
+	// The inputs end before the race does, or run far past it
+	GhostFlagInputLength = "input_length"
+	// The face button, direction and trick streams cover a different number of frames
+	GhostFlagInputStreams = "input_streams"
+	// Button bits or stick positions the game never records
+	GhostFlagInvalidInput = "invalid_input"
+	// Consecutive identical entries that the game would have merged into one
+	GhostFlagUnmergedInputs = "unmerged_inputs"
+	// An unusual amount of single frame stick changes
+	GhostFlagInputJitter = "input_jitter"
+)
+
+const (
+	// Accelerate, brake, item and drift
+	rkgFaceButtonMask = 0x0F
+	// Each stick axis ranges from 0 to 14, with 7 as neutral
+	rkgDirectionMax = 14
+	rkgTrickMax     = 4
+
+	rkgMaxFaceFrames  = 0xFF
+	rkgMaxTrickFrames = 0xFFF
+
+	// Inputs start at the countdown, so they may run somewhat longer than the race time
+	rkgInputFrameMargin = 10 * 60
+
+	// More single frame stick changes than this, making up more than a quarter of all stick
+	// inputs, is beyond what a player can do with a real controller
+	rkgJitterMinCount = 200
+	rkgJitterRatio    = 4
+)
+
+var ErrRKGInputData = errors.New("invalid RKG input data")
+
+// DecompressYaz1 decompresses Yaz0/Yaz1 data without its 0x10 byte header
+func DecompressYaz1(szsData []byte, decompSize int) ([]byte, error) {
+	decompressed := make([]byte, 0, decompSize)
+
+	i := 0
+	for len(decompressed) < decompSize {
+		if i >= len(szsData) {
+			return nil, ErrRKGInputData
+		}
+
+		flags := szsData[i]
+		i++
+
+		for j := 0; j < 8 && len(decompressed) < decompSize; j++ {
+			if flags&0x80 != 0 {
+				if i >= len(szsData) {
+					return nil, ErrRKGInputData
+				}
+
+				decompressed = append(decompressed, szsData[i])
+				i++
+			} else {
+				if i+1 >= len(szsData) {
+					return nil, ErrRKGInputData
+				}
+
+				copyLen := int(szsData[i]>>4) + 2
+				copySrc := len(decompressed) - ((int(szsData[i])&0x0F)<<8 | int(szsData[i+1])) - 1
+				i += 2
+
+				if copyLen == 2 {
+					if i >= len(szsData) {
+						return nil, ErrRKGInputData
+					}
+
+					copyLen = int(szsData[i]) + 0x12
+					i++
+				}
+
+				if copySrc < 0 {
+					return nil, ErrRKGInputData
+				}
+
+				// The source can overlap the bytes being written, so copy one at a time
+				for k := 0; k < copyLen && len(decompressed) < decompSize; k++ {
+					decompressed = append(decompressed, decompressed[copySrc+k])
+				}
+			}
+
+			flags <<= 1
+		}
+	}
+
+	return decompressed, nil
+}
+
+// GetInputData decompresses and parses the ghost's inputs. The file should already have passed IsRKGDFileValid.
+func (rkgd RKGhostData) GetInputData() (RKGInputData, error) {
+	if len(rkgd) < RKGDFileMinSize {
+		return RKGInputData{}, ErrRKGInputData
+	}
+
+	szsData := rkgd.GetCompressedData()
+	if len(szsData) < 0x10 || string(szsData[:4]) != "Yaz1" {
+		return RKGInputData{}, ErrRKGInputData
+	}
+
+	decompSize := binary.BigEndian.Uint32(szsData[0x4:0x8])
+	if decompSize != uint32(rkgd.GetInputDataLength()) {
+		return RKGInputData{}, ErrRKGInputData
+	}
+
+	data, err := DecompressYaz1(szsData[0x10:], int(decompSize))
+	if err != nil {
+		return RKGInputData{}, err
+	}
+
+	if len(data) < 0x8 {
+		return RKGInputData{}, ErrRKGInputData
+	}
+
+	faceCount := int(binary.BigEndian.Uint16(data[0x0:0x2]))
+	directionCount := int(binary.BigEndian.Uint16(data[0x2:0x4]))
+	trickCount := int(binary.BigEndian.Uint16(data[0x4:0x6]))
+	if 0x8+(faceCount+directionCount+trickCount)*2 > len(data) {
+		return RKGInputData{}, ErrRKGInputData
+	}
+
+	inputs := RKGInputData{
+		FaceButtons: make([]RKGInput, faceCount),
+		Directions:  make([]RKGInput, directionCount),
+		Tricks:      make([]RKGInput, trickCount),
+	}
+
+	offset := 0x8
+	for i := range inputs.FaceButtons {
+		inputs.FaceButtons[i] = RKGInput{Value: data[offset], Frames: int(data[offset+1])}
+		offset += 2
+	}
+	for i := range inputs.Directions {
+		inputs.Directions[i] = RKGInput{Value: data[offset], Frames: int(data[offset+1])}
+		offset += 2
+	}
+	for i := range inputs.Tricks {
+		inputs.Tricks[i] = RKGInput{
+			Value:  (data[offset] >> 4) & 0x7,
+			Frames: int(data[offset]&0x0F)<<8 | int(data[offset+1]),
+		}
+		offset += 2
+	}
+
+	return inputs, nil
+}
+
+// AnalyzeInputs looks for input patterns that can't come from a player racing on real hardware.
+// Returns the reasons the ghost should be reviewed, if any.
+func (rkgd RKGhostData) AnalyzeInputs() ([]string, error) {
+	inputs, err := rkgd.GetInputData()
+	if err != nil {
+		return nil, err
+	}
+
+	var flags []string
+	addFlag := func(flag string) {
+		if !slices.Contains(flags, flag) {
+			flags = append(flags, flag)
+		}
+	}
+
+	faceFrames := countRKGInputFrames(inputs.FaceButtons, rkgMaxFaceFrames, addFlag)
+	directionFrames := countRKGInputFrames(inputs.Directions, rkgMaxFaceFrames, addFlag)
+	trickFrames := countRKGInputFrames(inputs.Tricks, rkgMaxTrickFrames, addFlag)
+
+	if faceFrames != directionFrames || faceFrames != trickFrames {
+		addFlag(GhostFlagInputStreams)
+	}
+
+	// 59.94 frames per second
+	raceFrames := rkgd.GetTime(0) * 5994 / 100000
+	if faceFrames < raceFrames || faceFrames > raceFrames+rkgInputFrameMargin {
+		addFlag(GhostFlagInputLength)
+	}
+
+	for _, input := range inputs.FaceButtons {
+		if input.Value&^rkgFaceButtonMask != 0 {
+			addFlag(GhostFlagInvalidInput)
+		}
+	}
+
+	singleFrameChanges := 0
+	for _, input := range inputs.Directions {
+		if input.Value>>4 > rkgDirectionMax || input.Value&0x0F > rkgDirectionMax {
+			addFlag(GhostFlagInvalidInput)
+		}
+		if input.Frames == 1 {
+			singleFrameChanges++
+		}
+	}
+	if singleFrameChanges > rkgJitterMinCount && singleFrameChanges*rkgJitterRatio > len(inputs.Directions) {
+		addFlag(GhostFlagInputJitter)
+	}
+
+	for _, input := range inputs.Tricks {
+		if input.Value > rkgTrickMax {
+			addFlag(GhostFlagInvalidInput)
+		}
+	}
+
+	return flags, nil
+}
+
+// countRKGInputFrames returns the number of frames the inputs cover, flagging zero length and unmerged entries
+func countRKGInputFrames(inputs []RKGInput, maxFrames int, addFlag func(string)) int {
+	frames := 0
+	for i, input := range inputs {
+		if input.Frames == 0 {
+			addFlag(GhostFlagInvalidInput)
+		}
+		if i > 0 && input.Value == inputs[i-1].Value && inputs[i-1].Frames < maxFrames {
+			addFlag(GhostFlagUnmergedInputs)
+		}
+		frames += input.Frames
+	}
+
+	return frames
+}
+
+// CheckMarioKartWiiTime compares a time against the course's known world record and the fastest
+// time that is physically possible on it. Returns the reason the time should be reviewed, if any,
+// and whether the time is impossible and should be rejected outright.
+func CheckMarioKartWiiTime(config GhostReviewConfig, courseId MarioKartWiiCourseId, score int) (string, bool) {
+	for _, course := range config.Courses {
+		if MarioKartWiiCourseId(course.CourseID) != courseId {
+			continue
+		}
+
+		if course.Minimum != 0 && score < course.Minimum {
+			return "", true
+		}
+		if course.Record != 0 && score < course.Record {
+			return GhostFlagFasterThanRecord, false
+		}
+		break
+	}
+
+	return "", false
+}
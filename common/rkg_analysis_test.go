@@ -0,0 +1,216 @@
+package common
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"slices"
+	"testing"
+)
+
+// The ghosts in these tests are built by hand from the RKG layout the parser reads, they are not
+// files recorded by the game
+
+func makeTestInputData(face []RKGInput, directions []RKGInput, tricks []RKGInput) []byte {
+	data := make([]byte, 0x8)
+	binary.BigEndian.PutUint16(data[0x0:0x2], uint16(len(face)))
+	binary.BigEndian.PutUint16(data[0x2:0x4], uint16(len(directions)))
+	binary.BigEndian.PutUint16(data[0x4:0x6], uint16(len(tricks)))
+
+	for _, input := range face {
+		data = append(data, input.Value, byte(input.Frames))
+	}
+	for _, input := range directions {
+		data = append(data, input.Value, byte(input.Frames))
+	}
+	for _, input := range tricks {
+		data = append(data, input.Value<<4|byte(input.Frames>>8), byte(input.Frames))
+	}
+
+	// Pad to a whole Yaz1 group so every group is literal bytes
+	for len(data)%8 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// makeTestInputs splits a held input into entries of at most maxFrames frames
+func makeTestInputs(value byte, frames int, maxFrames int) []RKGInput {
+	var inputs []RKGInput
+	for frames > 0 {
+		length := min(frames, maxFrames)
+		inputs = append(inputs, RKGInput{Value: value, Frames: length})
+		frames -= length
+	}
+	return inputs
+}
+
+func putTestTime(rkgd RKGhostData, offset int, milliseconds int) {
+	value := uint32(milliseconds/60000)<<17 | uint32(milliseconds/1000%60)<<10 | uint32(milliseconds%1000)
+	rkgd[offset] = byte(value >> 16)
+	rkgd[offset+1] = byte(value >> 8)
+	rkgd[offset+2] = byte(value)
+}
+
+// makeTestGhost builds a compressed three lap ghost on Mario Circuit. Every lap but the last takes
+// a third of the time.
+func makeTestGhost(milliseconds int, inputData []byte, inputLength int) RKGhostData {
+	var szs []byte
+	szs = append(szs, "Yaz1"...)
+	szs = binary.BigEndian.AppendUint32(szs, uint32(len(inputData)))
+	szs = append(szs, make([]byte, 8)...)
+	for i := 0; i < len(inputData); i += 8 {
+		szs = append(szs, 0xFF)
+		szs = append(szs, inputData[i:i+8]...)
+	}
+
+	rkgd := make(RKGhostData, 0x8C+len(szs)+4)
+	copy(rkgd, "RKGD")
+	putTestTime(rkgd, 0x04, milliseconds)
+	// Compressed flag
+	rkgd[0x0C] = 0x08
+	binary.BigEndian.PutUint16(rkgd[0x0E:0x10], uint16(inputLength))
+	rkgd[0x10] = 3
+	putTestTime(rkgd, 0x11, milliseconds/3)
+	putTestTime(rkgd, 0x14, milliseconds/3)
+	putTestTime(rkgd, 0x17, milliseconds-milliseconds/3*2)
+
+	binary.BigEndian.PutUint32(rkgd[0x88:0x8C], uint32(len(szs)))
+	copy(rkgd[0x8C:], szs)
+	binary.BigEndian.PutUint32(rkgd[len(rkgd)-4:], crc32.ChecksumIEEE(rkgd[:len(rkgd)-4]))
+	return rkgd
+}
+
+// A 68 second race is 4075 frames, the inputs also cover the countdown
+const testGhostTime = 68000
+const testGhostFrames = 4300
+
+func makeTestCleanInputData() []byte {
+	return makeTestInputData(
+		makeTestInputs(0x01, testGhostFrames, rkgMaxFaceFrames),
+		makeTestInputs(0x77, testGhostFrames, rkgMaxFaceFrames),
+		makeTestInputs(0, testGhostFrames, rkgMaxTrickFrames),
+	)
+}
+
+func TestGetInputData(t *testing.T) {
+	inputData := makeTestCleanInputData()
+	inputs, err := makeTestGhost(testGhostTime, inputData, len(inputData)).GetInputData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inputs.FaceButtons) != 17 || len(inputs.Directions) != 17 || len(inputs.Tricks) != 2 {
+		t.Errorf("got %d face, %d direction and %d trick inputs", len(inputs.FaceButtons), len(inputs.Directions), len(inputs.Tricks))
+	}
+	if inputs.Tricks[0] != (RKGInput{Value: 0, Frames: rkgMaxTrickFrames}) || inputs.Directions[16] != (RKGInput{Value: 0x77, Frames: testGhostFrames % rkgMaxFaceFrames}) {
+		t.Errorf("inputs were parsed incorrectly: %+v", inputs)
+	}
+}
+
+func TestGetInputDataInvalid(t *testing.T) {
+	inputData := makeTestCleanInputData()
+
+	tests := []struct {
+		name  string
+		ghost RKGhostData
+	}{
+		{"header length shorter than the data", makeTestGhost(testGhostTime, inputData, len(inputData)-8)},
+		{"header length longer than the data", makeTestGhost(testGhostTime, inputData, len(inputData)+8)},
+		{"input counts past the data", makeTestGhost(testGhostTime, makeTestInputData(make([]RKGInput, 4), nil, nil)[:8], 8)},
+		{"truncated file", makeTestGhost(testGhostTime, inputData, len(inputData))[:0x90]},
+	}
+
+	for _, test := range tests {
+		if _, err := test.ghost.GetInputData(); err != ErrRKGInputData {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+func TestDecompressYaz1(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		decompSize int
+		expected   []byte
+	}{
+		{"literals", []byte{0xFF, 1, 2, 3, 4, 5, 6, 7, 8}, 8, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		// One literal, then a copy of 5 bytes starting 1 byte back
+		{"overlapping copy", []byte{0x80, 9, 0x30, 0x00}, 6, []byte{9, 9, 9, 9, 9, 9}},
+		// Copy length of 0x12 + 2 from the third byte
+		{"long copy", []byte{0x80, 7, 0x00, 0x00, 0x02}, 21, slices.Repeat([]byte{7}, 21)},
+		{"copy before the start", []byte{0x00, 0x30, 0x00}, 5, nil},
+		{"truncated", []byte{0xFF, 1, 2}, 8, nil},
+	}
+
+	for _, test := range tests {
+		result, err := DecompressYaz1(test.data, test.decompSize)
+		if test.expected == nil {
+			if err != ErrRKGInputData {
+				t.Errorf("%s: got %v, expected an error", test.name, err)
+			}
+			continue
+		}
+
+		if err != nil || !slices.Equal(result, test.expected) {
+			t.Errorf("%s: got %v, %v, expected %v", test.name, result, err, test.expected)
+		}
+	}
+}
+
+func TestAnalyzeInputs(t *testing.T) {
+	face := makeTestInputs(0x01, testGhostFrames, rkgMaxFaceFrames)
+	directions := makeTestInputs(0x77, testGhostFrames, rkgMaxFaceFrames)
+	tricks := makeTestInputs(0, testGhostFrames, rkgMaxTrickFrames)
+
+	// Alternating single frame stick changes for the whole race
+	var jitter []RKGInput
+	for i := 0; i < testGhostFrames; i++ {
+		jitter = append(jitter, RKGInput{Value: 0x77 + byte(i%2), Frames: 1})
+	}
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected []string
+	}{
+		{"clean", makeTestInputData(face, directions, tricks), nil},
+		{"inputs end early", makeTestInputData(
+			makeTestInputs(0x01, 3000, rkgMaxFaceFrames),
+			makeTestInputs(0x77, 3000, rkgMaxFaceFrames),
+			makeTestInputs(0, 3000, rkgMaxTrickFrames),
+		), []string{GhostFlagInputLength}},
+		{"streams differ", makeTestInputData(face, makeTestInputs(0x77, testGhostFrames+100, rkgMaxFaceFrames), tricks), []string{GhostFlagInputStreams}},
+		{"invalid button", makeTestInputData(append([]RKGInput{{Value: 0x10, Frames: 1}}, makeTestInputs(0x01, testGhostFrames-1, rkgMaxFaceFrames)...), directions, tricks), []string{GhostFlagInvalidInput}},
+		{"invalid stick position", makeTestInputData(face, append([]RKGInput{{Value: 0xF7, Frames: 1}}, makeTestInputs(0x77, testGhostFrames-1, rkgMaxFaceFrames)...), tricks), []string{GhostFlagInvalidInput}},
+		{"unmerged inputs", makeTestInputData(append([]RKGInput{{Value: 0x01, Frames: 100}}, makeTestInputs(0x01, testGhostFrames-100, rkgMaxFaceFrames)...), directions, tricks), []string{GhostFlagUnmergedInputs}},
+		{"stick jitter", makeTestInputData(face, jitter, tricks), []string{GhostFlagInputJitter}},
+	}
+
+	for _, test := range tests {
+		flags, err := makeTestGhost(testGhostTime, test.input, len(test.input)).AnalyzeInputs()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if !slices.Equal(flags, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, flags, test.expected)
+		}
+	}
+}
+
+func TestIsRKGDFileValidInputLength(t *testing.T) {
+	inputData := makeTestCleanInputData()
+
+	if !makeTestGhost(testGhostTime, inputData, len(inputData)).IsRKGDFileValid("test", MarioCircuit, testGhostTime) {
+		t.Fatal("valid ghost was rejected")
+	}
+
+	if makeTestGhost(testGhostTime, inputData, len(inputData)-8).IsRKGDFileValid("test", MarioCircuit, testGhostTime) {
+		t.Error("ghost with a header input length below the decompressed size was accepted")
+	}
+	if makeTestGhost(testGhostTime, inputData, len(inputData)+8).IsRKGDFileValid("test", MarioCircuit, testGhostTime) {
+		t.Error("ghost with a header input length above the decompressed size was accepted")
+	}
+}
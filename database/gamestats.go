@@ -1,13 +1,42 @@
 package database
 
 import (
+	"errors"
 	"time"
+
+	"github.com/jackc/pgx/v4"
 )
 
 const (
 	queryGsGetPublicData    = `SELECT modified_time, pdata FROM gamestats_public_data WHERE profile_id = $1 AND dindex = $2 AND ptype = $3`
 	queryGsInsertPublicData = `INSERT INTO gamestats_public_data (profile_id, dindex, ptype, pdata, modified_time) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP) RETURNING modified_time`
 	queryGsUpdatePublicData = `UPDATE gamestats_public_data SET pdata = $4, modified_time = CURRENT_TIMESTAMP WHERE profile_id = $1 AND dindex = $2 AND ptype = $3 RETURNING modified_time`
+	queryGsGetOtherDataSize = `SELECT COALESCE(SUM(OCTET_LENGTH(pdata)), 0) FROM gamestats_public_data WHERE profile_id = $1 AND NOT (dindex = $2 AND ptype = $3)`
+	queryGsSnapshotExists   = `SELECT EXISTS (SELECT 1 FROM gamestats_snapshots WHERE profile_id = $1 AND session_key = $2 AND connection_id = $3)`
+	queryGsCountSnapshots   = `SELECT COUNT(*) FROM gamestats_snapshots WHERE profile_id = $1`
+	queryGsDeleteSnapshots  = `
+		DELETE FROM gamestats_snapshots
+		WHERE (profile_id, session_key, connection_id) IN (
+			SELECT profile_id, session_key, connection_id FROM gamestats_snapshots
+			WHERE profile_id = $1 AND done
+			ORDER BY updated_time
+			LIMIT $2)`
+	queryGsUpsertSnapshot = `
+		INSERT INTO gamestats_snapshots (profile_id, game_name, session_key, connection_id, gamedata, done, created_time, updated_time)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (profile_id, session_key, connection_id) DO UPDATE
+		SET gamedata = CASE WHEN gamestats_snapshots.done THEN gamestats_snapshots.gamedata ELSE EXCLUDED.gamedata END,
+			done = gamestats_snapshots.done OR EXCLUDED.done,
+			updated_time = CURRENT_TIMESTAMP`
+
+	// Serialise writes from the same profile so the storage limits can't be raced
+	queryGsLockPublicData = `SELECT pg_advisory_xact_lock(hashtext('gamestats_public_data'), $1::integer)`
+	queryGsLockSnapshots  = `SELECT pg_advisory_xact_lock(hashtext('gamestats_snapshots'), $1::integer)`
+)
+
+var (
+	ErrGameStatsQuotaExceeded = errors.New("profile has exceeded the persistent data storage quota")
+	ErrGameStatsSnapshotLimit = errors.New("profile has reached the game snapshot limit")
 )
 
 func (c *Connection) GetGameStatsPublicData(profileId uint32, dindex string, ptype string) (modifiedTime time.Time, publicData string, err error) {
@@ -24,3 +53,99 @@ func (c *Connection) UpdateGameStatsPublicData(profileId uint32, dindex string,
 	err = c.pool.QueryRow(c.ctx, queryGsUpdatePublicData, profileId, dindex, ptype, publicData).Scan(&modifiedTime)
 	return
 }
+
+// SetGameStatsPublicData creates or replaces the profile's data for the index and type. If the data already
+// exists and merge is set, the stored data is passed through merge first. Fails with ErrGameStatsQuotaExceeded
+// if the profile would store more than quota bytes in total.
+func (c *Connection) SetGameStatsPublicData(profileId uint32, dindex string, ptype string, publicData string, merge func(oldData string) string, quota int) (modifiedTime time.Time, err error) {
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = tx.Rollback(c.ctx)
+	}()
+
+	// The lock key is the profile ID's bits, profile IDs above the integer range wrap
+	if _, err = tx.Exec(c.ctx, queryGsLockPublicData, int32(profileId)); err != nil {
+		return
+	}
+
+	var oldData string
+	err = tx.QueryRow(c.ctx, queryGsGetPublicData, profileId, dindex, ptype).Scan(&modifiedTime, &oldData)
+	exists := err == nil
+	if err != nil && err != pgx.ErrNoRows {
+		return
+	}
+
+	if exists && merge != nil {
+		publicData = merge(oldData)
+	}
+
+	var otherSize int
+	if err = tx.QueryRow(c.ctx, queryGsGetOtherDataSize, profileId, dindex, ptype).Scan(&otherSize); err != nil {
+		return
+	}
+	if otherSize+len(publicData) > quota {
+		err = ErrGameStatsQuotaExceeded
+		return
+	}
+
+	query := queryGsInsertPublicData
+	if exists {
+		query = queryGsUpdatePublicData
+	}
+	if err = tx.QueryRow(c.ctx, query, profileId, dindex, ptype, publicData).Scan(&modifiedTime); err != nil {
+		return
+	}
+
+	err = tx.Commit(c.ctx)
+	return
+}
+
+// SaveGameStatsSnapshot creates or updates the snapshot a game reports with newgame and updgame. A snapshot
+// can't be changed after it's marked done. A profile keeps at most maxSnapshots snapshots, the oldest finished
+// ones are removed to make room and ErrGameStatsSnapshotLimit is returned if none are finished.
+func (c *Connection) SaveGameStatsSnapshot(profileId uint32, gameName string, sessionKey int32, connectionId string, gameData string, done bool, maxSnapshots int) error {
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(c.ctx)
+	}()
+
+	if maxSnapshots > 0 {
+		if _, err := tx.Exec(c.ctx, queryGsLockSnapshots, int32(profileId)); err != nil {
+			return err
+		}
+
+		var exists bool
+		if err := tx.QueryRow(c.ctx, queryGsSnapshotExists, profileId, sessionKey, connectionId).Scan(&exists); err != nil {
+			return err
+		}
+
+		if !exists {
+			var count int
+			if err := tx.QueryRow(c.ctx, queryGsCountSnapshots, profileId).Scan(&count); err != nil {
+				return err
+			}
+
+			if count >= maxSnapshots {
+				tag, err := tx.Exec(c.ctx, queryGsDeleteSnapshots, profileId, count-maxSnapshots+1)
+				if err != nil {
+					return err
+				}
+				if int(tag.RowsAffected()) < count-maxSnapshots+1 {
+					return ErrGameStatsSnapshotLimit
+				}
+			}
+		}
+	}
+
+	if _, err := tx.Exec(c.ctx, queryGsUpsertSnapshot, profileId, gameName, sessionKey, connectionId, gameData, done); err != nil {
+		return err
+	}
+
+	return tx.Commit(c.ctx)
+}
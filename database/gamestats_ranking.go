@@ -0,0 +1,114 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+type GameStatsRanking struct {
+	Rank       int
+	ProfileID  uint32
+	Region     uint32
+	Score      int32
+	Data       []byte
+	UpdateTime time.Time
+}
+
+type GameStatsRankingQuery struct {
+	GameName string
+	Category uint32
+	// Bitmask of the regions to include
+	RegionMask uint32
+	Ascending  bool
+	// Only include scores updated within this duration, zero for all
+	Since time.Duration
+}
+
+const (
+	upsertGameStatsRankingQuery = `
+		INSERT INTO gamestats_rankings (game_name, category, profile_id, region, score, data, update_time)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		ON CONFLICT (game_name, category, profile_id) DO UPDATE
+		SET region = EXCLUDED.region, score = EXCLUDED.score, data = EXCLUDED.data, update_time = CURRENT_TIMESTAMP`
+
+	// Scores are ranked with ties sharing a rank, and positioned by the earlier update for paging around a profile
+	rankedGameStatsQuery = `
+		WITH ranked AS (
+			SELECT profile_id, region, score, data, update_time,
+				RANK() OVER (ORDER BY score {order}) AS rank,
+				ROW_NUMBER() OVER (ORDER BY score {order}, update_time ASC, profile_id ASC) AS pos
+			FROM gamestats_rankings
+			WHERE game_name = $1 AND category = $2 AND region & $3 <> 0
+			AND ($4::bigint = 0 OR update_time > CURRENT_TIMESTAMP - make_interval(secs => $4::bigint))
+		)
+		SELECT rank, profile_id, region, score, data, update_time FROM ranked `
+	getGameStatsTopRankingsQuery = rankedGameStatsQuery + `
+		ORDER BY pos ASC
+		LIMIT $5`
+	getGameStatsProfileRankingQuery = rankedGameStatsQuery + `
+		WHERE profile_id = $5`
+	getGameStatsRankingsAroundQuery = rankedGameStatsQuery + `
+		WHERE pos BETWEEN (SELECT pos FROM ranked WHERE profile_id = $5) - $6
+		AND (SELECT pos FROM ranked WHERE profile_id = $5) + $7
+		ORDER BY pos ASC`
+	getGameStatsFriendRankingsQuery = rankedGameStatsQuery + `
+		WHERE profile_id = ANY($5::bigint[])
+		ORDER BY pos ASC
+		LIMIT $6`
+)
+
+func (c *Connection) PutGameStatsRanking(gameName string, category uint32, profileId uint32, region uint32, score int32, data []byte) error {
+	_, err := c.pool.Exec(c.ctx, upsertGameStatsRankingQuery, gameName, category, profileId, region, score, data)
+	return err
+}
+
+// GetGameStatsTopRankings returns the best scores in the category
+func (c *Connection) GetGameStatsTopRankings(query GameStatsRankingQuery, limit int) ([]GameStatsRanking, error) {
+	return c.queryGameStatsRankings(query, getGameStatsTopRankingsQuery, limit)
+}
+
+// GetGameStatsProfileRanking returns the profile's own ranking, or nothing if it has no score in the category
+func (c *Connection) GetGameStatsProfileRanking(query GameStatsRankingQuery, profileId uint32) ([]GameStatsRanking, error) {
+	return c.queryGameStatsRankings(query, getGameStatsProfileRankingQuery, profileId)
+}
+
+// GetGameStatsRankingsAround returns the profile's ranking with up to the given number of scores above and below it
+func (c *Connection) GetGameStatsRankingsAround(query GameStatsRankingQuery, profileId uint32, above int, below int) ([]GameStatsRanking, error) {
+	return c.queryGameStatsRankings(query, getGameStatsRankingsAroundQuery, profileId, above, below)
+}
+
+// GetGameStatsFriendRankings returns the rankings of the profiles, keeping their rank among all scores
+func (c *Connection) GetGameStatsFriendRankings(query GameStatsRankingQuery, profileIds []uint32, limit int) ([]GameStatsRanking, error) {
+	return c.queryGameStatsRankings(query, getGameStatsFriendRankingsQuery, profileIds, limit)
+}
+
+func (c *Connection) queryGameStatsRankings(query GameStatsRankingQuery, sql string, args ...any) ([]GameStatsRanking, error) {
+	order := "DESC"
+	if query.Ascending {
+		order = "ASC"
+	}
+	sql = strings.ReplaceAll(sql, "{order}", order)
+
+	args = append([]any{query.GameName, query.Category, query.RegionMask, int64(query.Since.Seconds())}, args...)
+	rows, err := c.pool.Query(c.ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rankings := []GameStatsRanking{}
+	for rows.Next() {
+		var ranking GameStatsRanking
+		err = rows.Scan(&ranking.Rank, &ranking.ProfileID, &ranking.Region, &ranking.Score, &ranking.Data, &ranking.UpdateTime)
+		if err != nil {
+			return nil, err
+		}
+
+		rankings = append(rankings, ranking)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rankings, nil
+}
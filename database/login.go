@@ -99,7 +99,7 @@ func (c *Connection) LoginUserToGPCM(userId uint64, gsbrcd string, profileId uin
 		var lastName *string
 		var allowDefaultKeys bool
 
-		err := c.pool.QueryRow(c.ctx, GetUserProfileID, userId, gsbrcd).Scan(&user.ProfileId, &user.NgDeviceId, &user.Email, &user.UniqueNick, &firstName, &lastName, &user.OpenHost, &lastIPAddress, &allowDefaultKeys)
+		err := c.pool.QueryRow(c.ctx, GetUserProfileID, userId, gsbrcd).Scan(&user.ProfileId, &user.NgDeviceId, &user.Email, &user.UniqueNick, &firstName, &lastName, &user.OpenHost, &lastIPAddress, &allowDefaultKeys, &user.JoinPolicy, &user.HidePresence, &user.HideSearch)
 		if err != nil {
 			return User{}, err
 		}
@@ -251,7 +251,7 @@ func (c *Connection) LoginUserToGameStats(userId uint64, gsbrcd string) (User, e
 	var lastIPAddress *string
 	var allowDefaultKeys bool
 
-	err := c.pool.QueryRow(c.ctx, GetUserProfileID, userId, gsbrcd).Scan(&user.ProfileId, &user.NgDeviceId, &user.Email, &user.UniqueNick, &firstName, &lastName, &user.OpenHost, &lastIPAddress, &allowDefaultKeys)
+	err := c.pool.QueryRow(c.ctx, GetUserProfileID, userId, gsbrcd).Scan(&user.ProfileId, &user.NgDeviceId, &user.Email, &user.UniqueNick, &firstName, &lastName, &user.OpenHost, &lastIPAddress, &allowDefaultKeys, &user.JoinPolicy, &user.HidePresence, &user.HideSearch)
 	if err != nil {
 		return User{}, err
 	}
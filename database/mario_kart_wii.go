@@ -1,6 +1,7 @@
 package database
 
 import (
+	"time"
 	"wwfc/common"
 
 	"github.com/jackc/pgx/v4"
@@ -12,6 +13,19 @@ type MarioKartWiiTopTenRanking struct {
 	PlayerInfo string
 }
 
+type MarioKartWiiRanking struct {
+	ID         int                                    `json:"id"`
+	Rank       int                                    `json:"rank"`
+	ProfileID  int                                    `json:"pid"`
+	RegionID   common.MarioKartWiiLeaderboardRegionId `json:"region_id"`
+	CourseID   common.MarioKartWiiCourseId            `json:"course_id"`
+	Score      int                                    `json:"score"`
+	PlayerInfo string                                 `json:"-"`
+	HasGhost   bool                                   `json:"has_ghost"`
+	// Not set for times uploaded before upload times were recorded
+	UploadTime *time.Time `json:"upload_time,omitempty"`
+}
+
 const (
 	getTopTenRankingsQuery = "" +
 		"SELECT score, pid, playerinfo " +
@@ -20,11 +34,13 @@ const (
 		"AND courseid = $2 " +
 		"ORDER BY score ASC " +
 		"LIMIT 10"
+	// Times submitted without a ghost are only on the leaderboards, so ghost lookups skip them
 	getGhostDataQuery = "" +
 		"SELECT id " +
 		"FROM mario_kart_wii_sake " +
 		"WHERE courseid = $1 " +
 		"AND score < $2 " +
+		"AND ghost IS NOT NULL " +
 		"ORDER BY score DESC " +
 		"LIMIT 1"
 	getStoredGhostDataQuery = "" +
@@ -32,12 +48,14 @@ const (
 		"FROM mario_kart_wii_sake " +
 		"WHERE ($1 = 0 OR regionid = $1) " +
 		"AND courseid = $2 " +
+		"AND ghost IS NOT NULL " +
 		"ORDER BY score ASC " +
 		"LIMIT 1"
 	getFileQuery = "" +
 		"SELECT ghost " +
 		"FROM mario_kart_wii_sake " +
 		"WHERE id = $1 " +
+		"AND ghost IS NOT NULL " +
 		"LIMIT 1"
 	getGhostFileQuery = "" +
 		"SELECT ghost " +
@@ -45,6 +63,7 @@ const (
 		"WHERE courseid = $1 " +
 		"AND score < $2 " +
 		"AND pid <> $3 " +
+		"AND ghost IS NOT NULL " +
 		"ORDER BY score DESC " +
 		"LIMIT 1"
 	insertGhostFileStatement = "" +
@@ -52,6 +71,37 @@ const (
 		"VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP) " +
 		"ON CONFLICT (courseid, pid) DO UPDATE " +
 		"SET regionid = EXCLUDED.regionid, score = EXCLUDED.score, playerinfo = EXCLUDED.playerinfo, ghost = EXCLUDED.ghost, upload_time = CURRENT_TIMESTAMP"
+	// A time submitted without a ghost only replaces a slower one, whose ghost no longer matches
+	insertScoreStatement = "" +
+		"INSERT INTO mario_kart_wii_sake (regionid, courseid, score, pid, playerinfo, ghost, upload_time) " +
+		"VALUES ($1, $2, $3, $4, $5, NULL, CURRENT_TIMESTAMP) " +
+		"ON CONFLICT (courseid, pid) DO UPDATE " +
+		"SET regionid = EXCLUDED.regionid, score = EXCLUDED.score, playerinfo = EXCLUDED.playerinfo, ghost = NULL, upload_time = CURRENT_TIMESTAMP " +
+		"WHERE EXCLUDED.score < mario_kart_wii_sake.score"
+
+	// Ranks every time in the region, per course. Ties go to the earlier upload.
+	rankedTimesQuery = "" +
+		"WITH ranked AS (" +
+		"SELECT id, ROW_NUMBER() OVER (PARTITION BY courseid ORDER BY score ASC, upload_time ASC NULLS FIRST, id ASC) AS rank, " +
+		"pid, regionid, courseid, score, playerinfo, ghost IS NOT NULL AS has_ghost, upload_time " +
+		"FROM mario_kart_wii_sake " +
+		"WHERE ($1 = 0 OR regionid = $1) " +
+		"AND ($2::integer IS NULL OR courseid = $2)" +
+		") " +
+		"SELECT id, rank, pid, regionid, courseid, score, playerinfo, has_ghost, upload_time " +
+		"FROM ranked "
+	getLeaderboardQuery = rankedTimesQuery +
+		"ORDER BY rank ASC " +
+		"LIMIT $3 OFFSET $4"
+	getRankingsAboveQuery = rankedTimesQuery +
+		"WHERE rank BETWEEN (SELECT rank FROM ranked WHERE pid = $3) - $4 AND (SELECT rank FROM ranked WHERE pid = $3) " +
+		"ORDER BY rank ASC"
+	getFriendRankingsQuery = rankedTimesQuery +
+		"WHERE pid = ANY($3) " +
+		"ORDER BY rank ASC"
+	getPersonalBestsQuery = rankedTimesQuery +
+		"WHERE pid = $3 " +
+		"ORDER BY courseid ASC"
 )
 
 func (c *Connection) GetMarioKartWiiTopTenRankings(regionId common.MarioKartWiiLeaderboardRegionId,
@@ -132,10 +182,70 @@ func (c *Connection) InsertMarioKartWiiGhostFile(regionId common.MarioKartWiiLea
 	return err
 }
 
+// InsertMarioKartWiiScore records a time without a ghost, returning false if the profile already has an equal or faster time on the course
+func (c *Connection) InsertMarioKartWiiScore(regionId common.MarioKartWiiLeaderboardRegionId,
+	courseId common.MarioKartWiiCourseId, score int, pid int, playerInfo string) (bool, error) {
+	result, err := c.pool.Exec(c.ctx, insertScoreStatement, regionId, courseId, score, pid, playerInfo)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() != 0, nil
+}
+
+// GetMarioKartWiiLeaderboard returns a page of the course's times in the region, or worldwide
+func (c *Connection) GetMarioKartWiiLeaderboard(regionId common.MarioKartWiiLeaderboardRegionId,
+	courseId common.MarioKartWiiCourseId, limit int, offset int) ([]MarioKartWiiRanking, error) {
+	return c.queryMarioKartWiiRankings(getLeaderboardQuery, regionId, courseId, limit, offset)
+}
+
+// GetMarioKartWiiRankingsAbove returns the profile's ranking on the course along with the rankings
+// of up to count times above it. Returns nothing if the profile has no time on the course.
+func (c *Connection) GetMarioKartWiiRankingsAbove(regionId common.MarioKartWiiLeaderboardRegionId,
+	courseId common.MarioKartWiiCourseId, pid int, count int) ([]MarioKartWiiRanking, error) {
+	return c.queryMarioKartWiiRankings(getRankingsAboveQuery, regionId, courseId, pid, count)
+}
+
+// GetMarioKartWiiFriendRankings returns the rankings of the profiles on the course, keeping their rank among all times
+func (c *Connection) GetMarioKartWiiFriendRankings(regionId common.MarioKartWiiLeaderboardRegionId,
+	courseId common.MarioKartWiiCourseId, pids []int) ([]MarioKartWiiRanking, error) {
+	return c.queryMarioKartWiiRankings(getFriendRankingsQuery, regionId, courseId, pids)
+}
+
+// GetMarioKartWiiPersonalBests returns the profile's ranking on every course it has a time on
+func (c *Connection) GetMarioKartWiiPersonalBests(regionId common.MarioKartWiiLeaderboardRegionId, pid int) ([]MarioKartWiiRanking, error) {
+	return c.queryMarioKartWiiRankings(getPersonalBestsQuery, regionId, nil, pid)
+}
+
+func (c *Connection) queryMarioKartWiiRankings(query string, args ...any) ([]MarioKartWiiRanking, error) {
+	rows, err := c.pool.Query(c.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rankings := []MarioKartWiiRanking{}
+	for rows.Next() {
+		var ranking MarioKartWiiRanking
+		err = rows.Scan(&ranking.ID, &ranking.Rank, &ranking.ProfileID, &ranking.RegionID, &ranking.CourseID,
+			&ranking.Score, &ranking.PlayerInfo, &ranking.HasGhost, &ranking.UploadTime)
+		if err != nil {
+			return nil, err
+		}
+
+		rankings = append(rankings, ranking)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rankings, nil
+}
+
 // Mario Kart Wii friend info functions for API compatibility
 
 func (c *Connection) GetMKWFriendInfo(profileId uint32) string {
-	records, err := c.GetSakeRecords(1687, []int32{int32(profileId)}, "FriendInfo", nil, []string{"info"}, "")
+	records, err := c.GetSakeRecords(1687, []int32{int32(profileId)}, "FriendInfo", nil, []string{"info"}, "", nil)
 	if err != nil || len(records) == 0 {
 		return ""
 	}
@@ -149,7 +259,7 @@ func (c *Connection) GetMKWFriendInfo(profileId uint32) string {
 }
 
 func (c *Connection) UpdateMKWFriendInfo(profileId uint32, info string) {
-	records, err := c.GetSakeRecords(1687, []int32{int32(profileId)}, "FriendInfo", nil, []string{"info"}, "")
+	records, err := c.GetSakeRecords(1687, []int32{int32(profileId)}, "FriendInfo", nil, []string{"info"}, "", nil)
 	if err == pgx.ErrNoRows || (err == nil && len(records) == 0) {
 		// No existing record, insert new one
 		record := SakeRecord{
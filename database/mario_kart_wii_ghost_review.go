@@ -0,0 +1,122 @@
+package database
+
+import (
+	"time"
+	"wwfc/common"
+)
+
+type MarioKartWiiGhostReview struct {
+	ID         int                                    `json:"id"`
+	ProfileID  int                                    `json:"pid"`
+	RegionID   common.MarioKartWiiLeaderboardRegionId `json:"region_id"`
+	CourseID   common.MarioKartWiiCourseId            `json:"course_id"`
+	Score      int                                    `json:"score"`
+	HasGhost   bool                                   `json:"has_ghost"`
+	Reasons    []string                               `json:"reasons"`
+	UploadTime time.Time                              `json:"upload_time"`
+	// "pending", "approved" or "rejected"
+	Status     string     `json:"status"`
+	Moderator  string     `json:"moderator,omitempty"`
+	ReviewTime *time.Time `json:"review_time,omitempty"`
+}
+
+const (
+	insertGhostReviewQuery = `
+		INSERT INTO mario_kart_wii_ghost_reviews (regionid, courseid, score, pid, playerinfo, ghost, reasons)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+	getGhostReviewsQuery = `
+		SELECT id, pid, regionid, courseid, score, ghost IS NOT NULL, reasons, upload_time, status, COALESCE(moderator, ''), review_time
+		FROM mario_kart_wii_ghost_reviews
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY id ASC
+		LIMIT $2 OFFSET $3`
+	getGhostReviewFileQuery     = `SELECT ghost FROM mario_kart_wii_ghost_reviews WHERE id = $1`
+	lockPendingGhostReviewQuery = `
+		SELECT regionid, courseid, score, pid, playerinfo, ghost
+		FROM mario_kart_wii_ghost_reviews
+		WHERE id = $1 AND status = 'pending'
+		FOR UPDATE`
+	// An approved time only replaces a slower one, the player may have improved on it while it was being reviewed
+	insertApprovedGhostStatement = insertGhostFileStatement + " WHERE EXCLUDED.score < mario_kart_wii_sake.score"
+	resolveGhostReviewQuery      = `
+		UPDATE mario_kart_wii_ghost_reviews
+		SET status = $2, moderator = $3, review_time = CURRENT_TIMESTAMP
+		WHERE id = $1`
+)
+
+// InsertMarioKartWiiGhostReview holds an uploaded time, with or without a ghost, for moderator review
+func (c *Connection) InsertMarioKartWiiGhostReview(regionId common.MarioKartWiiLeaderboardRegionId,
+	courseId common.MarioKartWiiCourseId, score int, pid int, playerInfo string, ghost []byte, reasons []string) (int, error) {
+	var id int
+	err := c.pool.QueryRow(c.ctx, insertGhostReviewQuery, regionId, courseId, score, pid, playerInfo, ghost, reasons).Scan(&id)
+	return id, err
+}
+
+// GetMarioKartWiiGhostReviews lists the reviews with the status, or every review if it's empty, oldest first
+func (c *Connection) GetMarioKartWiiGhostReviews(status string, limit int, offset int) ([]MarioKartWiiGhostReview, error) {
+	rows, err := c.pool.Query(c.ctx, getGhostReviewsQuery, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []MarioKartWiiGhostReview{}
+	for rows.Next() {
+		var review MarioKartWiiGhostReview
+		err = rows.Scan(&review.ID, &review.ProfileID, &review.RegionID, &review.CourseID, &review.Score, &review.HasGhost,
+			&review.Reasons, &review.UploadTime, &review.Status, &review.Moderator, &review.ReviewTime)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews = append(reviews, review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+func (c *Connection) GetMarioKartWiiGhostReviewFile(id int) ([]byte, error) {
+	var ghost []byte
+	err := c.pool.QueryRow(c.ctx, getGhostReviewFileQuery, id).Scan(&ghost)
+	return ghost, err
+}
+
+// ResolveMarioKartWiiGhostReview approves or rejects a pending review. An approved time is added to the
+// leaderboards. Returns pgx.ErrNoRows if there is no pending review with the ID.
+func (c *Connection) ResolveMarioKartWiiGhostReview(id int, approve bool, moderator string) error {
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(c.ctx)
+
+	var regionId common.MarioKartWiiLeaderboardRegionId
+	var courseId common.MarioKartWiiCourseId
+	var score, pid int
+	var playerInfo string
+	var ghost []byte
+	err = tx.QueryRow(c.ctx, lockPendingGhostReviewQuery, id).Scan(&regionId, &courseId, &score, &pid, &playerInfo, &ghost)
+	if err != nil {
+		return err
+	}
+
+	status := "rejected"
+	if approve {
+		status = "approved"
+		_, err = tx.Exec(c.ctx, insertApprovedGhostStatement, regionId, courseId, score, pid, playerInfo, ghost)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(c.ctx, resolveGhostReviewQuery, id, status, moderator)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(c.ctx)
+}
@@ -0,0 +1,158 @@
+package database
+
+import "time"
+
+const (
+	getMarioKartWiiRatingQuery    = `SELECT rating FROM mario_kart_wii_ratings WHERE profile_id = $1 AND rating_type = $2`
+	insertMarioKartWiiRatingQuery = `
+		INSERT INTO mario_kart_wii_ratings (profile_id, rating_type, rating, updated)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (profile_id, rating_type) DO NOTHING`
+	updateMarioKartWiiRatingQuery = `
+		INSERT INTO mario_kart_wii_ratings (profile_id, rating_type, rating, races, flagged, updated)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (profile_id, rating_type) DO UPDATE
+		SET rating = EXCLUDED.rating, races = mario_kart_wii_ratings.races + EXCLUDED.races, flagged = mario_kart_wii_ratings.flagged + EXCLUDED.flagged, updated = EXCLUDED.updated`
+	insertMarioKartWiiRatingHistoryQuery = `
+		INSERT INTO mario_kart_wii_rating_history (profile_id, rating_type, old_rating, new_rating, group_name, race_number, flagged, reason, change_time)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, 0), $7, NULLIF($8, ''), $9)`
+	getMarioKartWiiRatingHistoryQuery = `
+		SELECT old_rating, new_rating, COALESCE(group_name, ''), COALESCE(race_number, 0), flagged, COALESCE(reason, ''), change_time
+		FROM mario_kart_wii_rating_history
+		WHERE profile_id = $1 AND rating_type = $2
+		ORDER BY change_time DESC
+		LIMIT $3`
+	getMarioKartWiiRatingLeaderboardQuery = `
+		SELECT r.profile_id, COALESCE(u.last_ingamesn, ''), r.rating, r.races, r.flagged, r.updated
+		FROM mario_kart_wii_ratings r
+		LEFT JOIN users u ON u.profile_id = r.profile_id
+		WHERE r.rating_type = $1 AND ($4 OR r.flagged = 0)
+		ORDER BY r.rating DESC, r.updated ASC
+		LIMIT $2 OFFSET $3`
+	clearMarioKartWiiRatingFlagsQuery = `
+		UPDATE mario_kart_wii_ratings r
+		SET flagged = 0
+		FROM mario_kart_wii_ratings prev
+		WHERE r.profile_id = $1 AND r.rating_type = $2
+		  AND prev.profile_id = r.profile_id AND prev.rating_type = r.rating_type
+		RETURNING prev.flagged`
+)
+
+type MarioKartWiiRatingChange struct {
+	ProfileID  uint32    `json:"-"`
+	RatingType string    `json:"-"`
+	OldRating  int       `json:"old_rating"`
+	NewRating  int       `json:"new_rating"`
+	GroupName  string    `json:"group,omitempty"`
+	RaceNumber int       `json:"race_number,omitempty"`
+	Flagged    bool      `json:"flagged"`
+	Reason     string    `json:"reason,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+type MarioKartWiiRatingEntry struct {
+	Rank       int       `json:"rank"`
+	ProfileID  uint32    `json:"pid"`
+	InGameName string    `json:"name"`
+	Rating     int       `json:"rating"`
+	Races      int       `json:"races"`
+	Flagged    int       `json:"flagged"`
+	Updated    time.Time `json:"updated"`
+}
+
+// GetMarioKartWiiRating returns the last rating recorded for the profile, or pgx.ErrNoRows if there is none
+func (c *Connection) GetMarioKartWiiRating(profileId uint32, ratingType string) (rating int, err error) {
+	err = c.pool.QueryRow(c.ctx, getMarioKartWiiRatingQuery, profileId, ratingType).Scan(&rating)
+	return
+}
+
+// InitMarioKartWiiRating records the first rating seen for a profile without adding it to the history
+func (c *Connection) InitMarioKartWiiRating(profileId uint32, ratingType string, rating int) error {
+	_, err := c.pool.Exec(c.ctx, insertMarioKartWiiRatingQuery, profileId, ratingType, rating, time.Now().UTC())
+	return err
+}
+
+// RecordMarioKartWiiRatingChange adds the change to the profile's history and updates its current rating
+func (c *Connection) RecordMarioKartWiiRatingChange(change MarioKartWiiRatingChange) error {
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(c.ctx)
+	}()
+
+	_, err = tx.Exec(c.ctx, insertMarioKartWiiRatingHistoryQuery, change.ProfileID, change.RatingType, change.OldRating, change.NewRating, change.GroupName, change.RaceNumber, change.Flagged, change.Reason, change.Time)
+	if err != nil {
+		return err
+	}
+
+	races := 0
+	if change.RaceNumber != 0 {
+		races = 1
+	}
+	flagged := 0
+	if change.Flagged {
+		flagged = 1
+	}
+
+	_, err = tx.Exec(c.ctx, updateMarioKartWiiRatingQuery, change.ProfileID, change.RatingType, change.NewRating, races, flagged, change.Time)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(c.ctx)
+}
+
+// GetMarioKartWiiRatingHistory returns the profile's most recent rating changes, newest first
+func (c *Connection) GetMarioKartWiiRatingHistory(profileId uint32, ratingType string, limit int) ([]MarioKartWiiRatingChange, error) {
+	rows, err := c.pool.Query(c.ctx, getMarioKartWiiRatingHistoryQuery, profileId, ratingType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []MarioKartWiiRatingChange{}
+	for rows.Next() {
+		change := MarioKartWiiRatingChange{ProfileID: profileId, RatingType: ratingType}
+		err = rows.Scan(&change.OldRating, &change.NewRating, &change.GroupName, &change.RaceNumber, &change.Flagged, &change.Reason, &change.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+// GetMarioKartWiiRatingLeaderboard returns the highest rated profiles. Profiles with
+// flagged rating changes are left out unless includeFlagged is set.
+func (c *Connection) GetMarioKartWiiRatingLeaderboard(ratingType string, limit int, offset int, includeFlagged bool) ([]MarioKartWiiRatingEntry, error) {
+	rows, err := c.pool.Query(c.ctx, getMarioKartWiiRatingLeaderboardQuery, ratingType, limit, offset, includeFlagged)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	leaderboard := []MarioKartWiiRatingEntry{}
+	for rows.Next() {
+		entry := MarioKartWiiRatingEntry{Rank: offset + len(leaderboard) + 1}
+		err = rows.Scan(&entry.ProfileID, &entry.InGameName, &entry.Rating, &entry.Races, &entry.Flagged, &entry.Updated)
+		if err != nil {
+			return nil, err
+		}
+
+		leaderboard = append(leaderboard, entry)
+	}
+
+	return leaderboard, rows.Err()
+}
+
+// ClearMarioKartWiiRatingFlags lists the profile on the leaderboard again once its flagged changes
+// have been reviewed. The changes stay flagged in the history. Returns the number of flagged changes
+// that were cleared, or pgx.ErrNoRows if the profile has no recorded rating.
+func (c *Connection) ClearMarioKartWiiRatingFlags(profileId uint32, ratingType string) (cleared int, err error) {
+	err = c.pool.QueryRow(c.ctx, clearMarioKartWiiRatingFlagsQuery, profileId, ratingType).Scan(&cleared)
+	return
+}
@@ -0,0 +1,23 @@
+package database
+
+const (
+	upsertReportAggregateQuery = `
+		INSERT INTO report_aggregates (profile_id, record, report_count, last_reporter_id)
+		VALUES ($1, $2, 1, $3)
+		ON CONFLICT (profile_id, record) DO UPDATE
+		SET report_count = report_aggregates.report_count + 1, last_reporter_id = $3, last_report = CURRENT_TIMESTAMP`
+	updateReportEscalationQuery = `
+		UPDATE report_aggregates
+		SET escalation_count = escalation_count + 1, last_escalation = CURRENT_TIMESTAMP, last_action = $3
+		WHERE profile_id = $1 AND record = $2`
+)
+
+func (c *Connection) RecordReport(profileId uint32, record string, reporterId uint32) error {
+	_, err := c.pool.Exec(c.ctx, upsertReportAggregateQuery, profileId, record, reporterId)
+	return err
+}
+
+func (c *Connection) RecordReportEscalation(profileId uint32, record string, action string) error {
+	_, err := c.pool.Exec(c.ctx, updateReportEscalationQuery, profileId, record, action)
+	return err
+}
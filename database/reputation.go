@@ -0,0 +1,128 @@
+package database
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// Serialises reports against the same profile so a reporter can't be counted twice
+	lockReputationReportsQuery   = `SELECT pg_advisory_xact_lock(hashtext('reputation_reports'), $1::integer)`
+	countRecentReputationReports = `
+		SELECT COUNT(*) FROM reputation_reports
+		WHERE profile_id = $1
+		  AND reporter_id = $2
+		  AND report_time > CURRENT_TIMESTAMP - $3 * INTERVAL '1 second'`
+	insertReputationReportQuery = `
+		INSERT INTO reputation_reports (profile_id, reporter_id, record, group_name, weight)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+	updateReputationReportScoreQuery = `UPDATE reputation_reports SET score = $2 WHERE id = $1`
+	addReputationScoreQuery          = `
+		INSERT INTO reputation (profile_id, score, updated)
+		VALUES ($1, $2, $4)
+		ON CONFLICT (profile_id) DO UPDATE
+		SET score = CASE
+				WHEN $3 > 0 THEN reputation.score * power(0.5, GREATEST(EXTRACT(EPOCH FROM ($4 - reputation.updated)), 0) / $3)
+				ELSE reputation.score
+			END + $2,
+			updated = $4
+		RETURNING score`
+	getReputationScoreQuery   = `SELECT score, updated FROM reputation WHERE profile_id = $1`
+	getReputationHistoryQuery = `
+		SELECT reporter_id, record, group_name, weight, score, report_time
+		FROM reputation_reports
+		WHERE profile_id = $1
+		ORDER BY report_time DESC
+		LIMIT $2`
+)
+
+type ReputationReport struct {
+	ReporterID uint32    `json:"reporter"`
+	Record     string    `json:"record"`
+	GroupName  string    `json:"group,omitempty"`
+	Weight     float64   `json:"weight"`
+	Score      float64   `json:"score"`
+	Time       time.Time `json:"time"`
+}
+
+// DecayReputationScore applies exponential decay to a score last updated at the given time
+func DecayReputationScore(score float64, updated time.Time, halfLife time.Duration) float64 {
+	if score == 0 || halfLife <= 0 {
+		return score
+	}
+
+	elapsed := time.Since(updated)
+	if elapsed <= 0 {
+		return score
+	}
+
+	return score * math.Pow(0.5, elapsed.Seconds()/halfLife.Seconds())
+}
+
+// GetReputationScore returns the profile's score and the time it was last updated, without decay applied
+func (c *Connection) GetReputationScore(profileId uint32) (score float64, updated time.Time, err error) {
+	err = c.pool.QueryRow(c.ctx, getReputationScoreQuery, profileId).Scan(&score, &updated)
+	return
+}
+
+// AddReputationReport records a report against a profile and adds its weight to the decayed score.
+// A reporter's reports against the same profile are only counted once within the window, in which
+// case ok is false. A half-life of zero or less disables decay, as in DecayReputationScore.
+func (c *Connection) AddReputationReport(profileId uint32, reporterId uint32, record string, groupName string, weight float64, halfLife time.Duration, window time.Duration) (score float64, ok bool, err error) {
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = tx.Rollback(c.ctx)
+	}()
+
+	// The lock key is the profile ID's bits, profile IDs above the integer range wrap
+	if _, err = tx.Exec(c.ctx, lockReputationReportsQuery, int32(profileId)); err != nil {
+		return 0, false, err
+	}
+
+	var recent int
+	if err = tx.QueryRow(c.ctx, countRecentReputationReports, profileId, reporterId, window.Seconds()).Scan(&recent); err != nil {
+		return 0, false, err
+	}
+	if recent != 0 {
+		return 0, false, nil
+	}
+
+	var reportId int
+	if err = tx.QueryRow(c.ctx, insertReputationReportQuery, profileId, reporterId, record, groupName, weight).Scan(&reportId); err != nil {
+		return 0, false, err
+	}
+
+	if err = tx.QueryRow(c.ctx, addReputationScoreQuery, profileId, weight, halfLife.Seconds(), time.Now().UTC()).Scan(&score); err != nil {
+		return 0, false, err
+	}
+
+	if _, err = tx.Exec(c.ctx, updateReputationReportScoreQuery, reportId, score); err != nil {
+		return 0, false, err
+	}
+
+	return score, true, tx.Commit(c.ctx)
+}
+
+func (c *Connection) GetReputationHistory(profileId uint32, limit int) ([]ReputationReport, error) {
+	rows, err := c.pool.Query(c.ctx, getReputationHistoryQuery, profileId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []ReputationReport{}
+	for rows.Next() {
+		report := ReputationReport{}
+		err = rows.Scan(&report.ReporterID, &report.Record, &report.GroupName, &report.Weight, &report.Score, &report.Time)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, report)
+	}
+
+	return history, rows.Err()
+}
@@ -0,0 +1,80 @@
+package database
+
+import "time"
+
+const (
+	insertRoomHistoryQuery = `
+		INSERT INTO room_history (group_name, dwc_group_id, game_name, match_type, mkw_region, profile_ids, timeline, races, create_time, end_time, tournament_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9, $10, NULLIF($11, 0))`
+	getProfileRoomHistoryQuery = `
+		SELECT id, group_name, dwc_group_id, game_name, COALESCE(match_type, ''), COALESCE(mkw_region, ''), profile_ids, timeline, COALESCE(races, '[]'), create_time, end_time, COALESCE(tournament_id, 0)
+		FROM room_history
+		WHERE profile_ids @> ARRAY[$1::bigint]
+		ORDER BY end_time DESC
+		LIMIT $2`
+)
+
+type RoomTimelineEntry struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	// The player the event is about, or the new host
+	ProfileID uint32 `json:"pid,omitempty"`
+	// The other player in a NATNEG attempt, or the previous host
+	PeerID uint32 `json:"peer_pid,omitempty"`
+}
+
+type RoomRace struct {
+	RaceNumber    int       `json:"race_number"`
+	CourseID      int       `json:"course_id"`
+	EngineClassID int       `json:"engine_class_id"`
+	StartTime     time.Time `json:"start_time"`
+}
+
+type RoomHistory struct {
+	ID         int                 `json:"id"`
+	GroupName  string              `json:"group_name"`
+	GroupID    uint32              `json:"dwc_group_id"`
+	GameName   string              `json:"game_name"`
+	MatchType  string              `json:"match_type,omitempty"`
+	MKWRegion  string              `json:"mario_kart_wii_region,omitempty"`
+	ProfileIDs []int64             `json:"profile_ids"`
+	Timeline   []RoomTimelineEntry `json:"timeline"`
+	Races      []RoomRace          `json:"races"`
+	CreateTime time.Time           `json:"create_time"`
+	EndTime    time.Time           `json:"end_time"`
+
+	TournamentID int `json:"tournament_id,omitempty"`
+}
+
+func (c *Connection) InsertRoomHistory(room RoomHistory) error {
+	races := room.Races
+	if races == nil {
+		races = []RoomRace{}
+	}
+
+	_, err := c.pool.Exec(c.ctx, insertRoomHistoryQuery, room.GroupName, room.GroupID, room.GameName, room.MatchType, room.MKWRegion, room.ProfileIDs, room.Timeline, races, room.CreateTime, room.EndTime, room.TournamentID)
+	return err
+}
+
+// GetProfileRoomHistory returns the most recent finished rooms the profile was in, newest first.
+// The query uses array containment rather than ANY so it can use the GIN index on profile_ids.
+func (c *Connection) GetProfileRoomHistory(profileId uint32, limit int) ([]RoomHistory, error) {
+	rows, err := c.pool.Query(c.ctx, getProfileRoomHistoryQuery, int64(profileId), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []RoomHistory{}
+	for rows.Next() {
+		var room RoomHistory
+		err = rows.Scan(&room.ID, &room.GroupName, &room.GroupID, &room.GameName, &room.MatchType, &room.MKWRegion, &room.ProfileIDs, &room.Timeline, &room.Races, &room.CreateTime, &room.EndTime, &room.TournamentID)
+		if err != nil {
+			return nil, err
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
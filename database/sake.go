@@ -3,11 +3,13 @@ package database
 import (
 	"encoding/json"
 	"errors"
+	"strconv"
 	"wwfc/common"
 	"wwfc/filter"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -37,6 +39,8 @@ type SakeRecord struct {
 	TableId  string
 	RecordId int32
 	Fields   map[string]SakeField
+	// Position of the record in the sort order of a sorted query, starting at 1
+	Row int
 }
 
 const (
@@ -74,15 +78,74 @@ const (
 		SELECT COUNT(*) 
 		FROM sake_records 
 		WHERE owner_id = $1`
+
+	getSakeRecordOwnerQuery = `
+		SELECT owner_id 
+		FROM sake_records 
+		WHERE game_id = $1 
+		  AND table_id = $2 
+		  AND record_id = $3`
+
+	countSakeRecordsQuery = `
+		SELECT COUNT(*) 
+		FROM sake_records 
+		WHERE game_id = $1 
+		  AND table_id = $2 
+		  AND (cardinality($3::integer[]) = 0 OR owner_id = ANY($3::integer[]))`
+
+	lockSakeRecordOwnerQuery = `
+		SELECT owner_id 
+		FROM sake_records 
+		WHERE game_id = $1 
+		  AND table_id = $2 
+		  AND record_id = $3 
+		FOR UPDATE`
+
+	insertSakeRecordRatingQuery = `
+		INSERT INTO sake_record_ratings (game_id, table_id, record_id, profile_id, rating) 
+		VALUES ($1, $2, $3, $4, $5) 
+		ON CONFLICT DO NOTHING`
+
+	sumSakeRecordRatingsQuery = `
+		SELECT COUNT(*), COALESCE(SUM(rating), 0) 
+		FROM sake_record_ratings 
+		WHERE game_id = $1 
+		  AND table_id = $2 
+		  AND record_id = $3`
+
+	updateSakeRecordRatingFieldsQuery = `
+		UPDATE sake_records 
+		SET fields = fields || $4 
+		WHERE game_id = $1 
+		  AND table_id = $2 
+		  AND record_id = $3`
+
+	getMySakeRecordRatingsQuery = `
+		SELECT record_id, rating 
+		FROM sake_record_ratings 
+		WHERE game_id = $1 
+		  AND table_id = $2 
+		  AND profile_id = $3 
+		  AND record_id = ANY($4::integer[])`
+
+	insertSakeRecordReportQuery = `
+		INSERT INTO sake_record_reports (game_id, table_id, record_id, profile_id, reason_code, reason) 
+		SELECT game_id, table_id, record_id, $4::bigint, $5::integer, NULLIF($6::text, '') 
+		FROM sake_records 
+		WHERE game_id = $1 
+		  AND table_id = $2 
+		  AND record_id = $3 
+		ON CONFLICT DO NOTHING`
 )
 
 var (
 	ErrSakeNotOwned           = errors.New("record is not owned by the specified owner ID")
 	ErrSakeFieldLimitExceeded = errors.New("record has too many fields")
+	ErrSakeAlreadyRated       = errors.New("record has already been rated by the specified profile ID")
+	ErrSakeAlreadyReported    = errors.New("record has already been reported by the specified profile ID")
+	ErrSakeOwnRecord          = errors.New("record is owned by the specified profile ID")
 )
 
-var _ = common.MaybeUnused(deleteSakeRecordQuery)
-
 func parseSakeFieldsFromJson(fieldsJson []byte) (map[string]SakeField, error) {
 	var fields map[string]SakeField
 	err := json.Unmarshal(fieldsJson, &fields)
@@ -93,7 +156,9 @@ func parseSakeFieldsFromJson(fieldsJson []byte) (map[string]SakeField, error) {
 	return fields, nil
 }
 
-func (c *Connection) GetSakeRecords(gameId int, ownerIds []int32, tableId string, recordIds []int32, fields []string, filterExpr string) ([]SakeRecord, error) {
+// GetSakeRecords returns the records matching the filter. If options is nil, the records are
+// returned in no particular order.
+func (c *Connection) GetSakeRecords(gameId int, ownerIds []int32, tableId string, recordIds []int32, fields []string, filterExpr string, options *SakeRecordQueryOptions) ([]SakeRecord, error) {
 	if fields == nil {
 		fields = []string{}
 	}
@@ -105,31 +170,147 @@ func (c *Connection) GetSakeRecords(gameId int, ownerIds []int32, tableId string
 		recordIds = []int32{}
 	}
 
-	query := getSakeRecordsQuery
-	if filterExpr != "" {
-		tree, err := filter.Parse(filterExpr)
+	query, err := c.appendSakeFilter(getSakeRecordsQuery, filterExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []any{gameId, tableId, ownerIds, recordIds}
+	if options == nil {
+		rows, err := c.pool.Query(c.ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		return scanSakeRecords(rows, gameId, tableId, false)
+	}
+
+	orderBy, args := createSqlOrderBy(options.Sort, args)
+
+	if options.TargetFilter != "" {
+		targetFilterQuery, err := c.createSakeFilter(options.TargetFilter)
 		if err != nil {
 			return nil, err
 		}
 
-		var filterQuery string
-		err = c.pool.AcquireFunc(c.ctx, func(conn *pgxpool.Conn) error {
-			filterQuery, err = createSqlFilter(conn.Conn().PgConn(), tree)
-			return err
-		})
+		args = append(args, options.Surrounding)
+		surrounding := "$" + strconv.Itoa(len(args)) + "::bigint"
+		query = `
+			WITH ranked AS (
+				SELECT owner_id, record_id, fields, ROW_NUMBER() OVER (ORDER BY ` + orderBy + `) AS row_number
+				FROM (` + query + `) AS matching
+			), target AS (
+				SELECT row_number
+				FROM ranked
+				WHERE ` + targetFilterQuery + `
+				ORDER BY row_number
+				LIMIT 1
+			)
+			SELECT ranked.owner_id, ranked.record_id, ranked.fields, ranked.row_number
+			FROM ranked, target
+			WHERE ranked.row_number BETWEEN target.row_number - ` + surrounding + ` AND target.row_number + ` + surrounding + `
+			ORDER BY ranked.row_number`
+
+		rows, err := c.pool.Query(c.ctx, query, args...)
 		if err != nil {
 			return nil, err
 		}
 
-		// This filter has been entirely rewritten by our filter code,
-		// based on the expression supplied by the user. This should be safe!!!
-		query += " AND (" + filterQuery + ")"
+		return scanSakeRecords(rows, gameId, tableId, true)
+	}
+
+	query += " ORDER BY " + orderBy
+	if options.Max > 0 {
+		args = append(args, options.Max)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	if options.Offset > 0 {
+		args = append(args, options.Offset)
+		query += " OFFSET $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := c.pool.Query(c.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := scanSakeRecords(rows, gameId, tableId, false)
+	for i := range records {
+		records[i].Row = options.Offset + i + 1
+	}
+	return records, err
+}
+
+// GetRandomSakeRecords returns up to max records matching the filter, in random order
+func (c *Connection) GetRandomSakeRecords(gameId int, ownerIds []int32, tableId string, filterExpr string, max int) ([]SakeRecord, error) {
+	if ownerIds == nil {
+		ownerIds = []int32{}
+	}
+
+	query, err := c.appendSakeFilter(getSakeRecordsQuery, filterExpr)
+	if err != nil {
+		return nil, err
 	}
 
-	rows, err := c.pool.Query(c.ctx, query, gameId, tableId, ownerIds, recordIds)
+	rows, err := c.pool.Query(c.ctx, query+" ORDER BY random() LIMIT $5", gameId, tableId, ownerIds, []int32{}, max)
 	if err != nil {
 		return nil, err
 	}
+
+	return scanSakeRecords(rows, gameId, tableId, false)
+}
+
+func (c *Connection) CountSakeRecords(gameId int, ownerIds []int32, tableId string, filterExpr string) (int, error) {
+	if ownerIds == nil {
+		ownerIds = []int32{}
+	}
+
+	query, err := c.appendSakeFilter(countSakeRecordsQuery, filterExpr)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = c.pool.QueryRow(c.ctx, query, gameId, tableId, ownerIds).Scan(&count)
+	return count, err
+}
+
+// appendSakeFilter converts a GameSpy filter expression to SQL and appends it to the query's WHERE clause
+func (c *Connection) appendSakeFilter(query string, filterExpr string) (string, error) {
+	if filterExpr == "" {
+		return query, nil
+	}
+
+	filterQuery, err := c.createSakeFilter(filterExpr)
+	if err != nil {
+		return "", err
+	}
+
+	return query + " AND (" + filterQuery + ")", nil
+}
+
+// createSakeFilter converts a GameSpy filter expression to an SQL condition on a record
+func (c *Connection) createSakeFilter(filterExpr string) (string, error) {
+	tree, err := filter.Parse(filterExpr)
+	if err != nil {
+		return "", err
+	}
+
+	var filterQuery string
+	err = c.pool.AcquireFunc(c.ctx, func(conn *pgxpool.Conn) error {
+		filterQuery, err = createSqlFilter(conn.Conn().PgConn(), tree)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// This filter has been entirely rewritten by our filter code,
+	// based on the expression supplied by the user. This should be safe!!!
+	return filterQuery, nil
+}
+
+func scanSakeRecords(rows pgx.Rows, gameId int, tableId string, withRow bool) ([]SakeRecord, error) {
 	defer rows.Close()
 
 	var records []SakeRecord
@@ -139,7 +320,15 @@ func (c *Connection) GetSakeRecords(gameId int, ownerIds []int32, tableId string
 			TableId: tableId,
 		}
 		var fieldsJson []byte
-		if err := rows.Scan(&record.OwnerId, &record.RecordId, &fieldsJson); err != nil {
+		var err error
+		if withRow {
+			var row int64
+			err = rows.Scan(&record.OwnerId, &record.RecordId, &fieldsJson, &row)
+			record.Row = int(row)
+		} else {
+			err = rows.Scan(&record.OwnerId, &record.RecordId, &fieldsJson)
+		}
+		if err != nil {
 			return nil, err
 		}
 		fields, err := parseSakeFieldsFromJson(fieldsJson)
@@ -151,7 +340,7 @@ func (c *Connection) GetSakeRecords(gameId int, ownerIds []int32, tableId string
 		records = append(records, record)
 	}
 
-	return records, nil
+	return records, rows.Err()
 }
 
 func (c *Connection) UpdateSakeRecord(record SakeRecord, ownerId int32) error {
@@ -206,3 +395,126 @@ func (c *Connection) IsMaxSakeRecordsReached(profileId uint32, maxRecords int) (
 	}
 	return count >= maxRecords, nil
 }
+
+// DeleteSakeRecord deletes a record owned by the owner ID. Returns pgx.ErrNoRows if the record
+// doesn't exist, or ErrSakeNotOwned if it belongs to someone else.
+func (c *Connection) DeleteSakeRecord(gameId int, tableId string, recordId int32, ownerId int32) error {
+	result, err := c.pool.Exec(c.ctx, deleteSakeRecordQuery, gameId, tableId, recordId, ownerId)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() != 0 {
+		return nil
+	}
+
+	var existingOwnerId int32
+	err = c.pool.QueryRow(c.ctx, getSakeRecordOwnerQuery, gameId, tableId, recordId).Scan(&existingOwnerId)
+	if err != nil {
+		return err
+	}
+	return ErrSakeNotOwned
+}
+
+// RateSakeRecord adds the profile's rating to a record and updates the record's rating fields.
+// Returns the new number of ratings and the average rating.
+func (c *Connection) RateSakeRecord(gameId int, tableId string, recordId int32, profileId uint32, rating byte) (int32, float32, error) {
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(c.ctx)
+
+	var ownerId int32
+	err = tx.QueryRow(c.ctx, lockSakeRecordOwnerQuery, gameId, tableId, recordId).Scan(&ownerId)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ownerId == int32(profileId) {
+		return 0, 0, ErrSakeOwnRecord
+	}
+
+	result, err := tx.Exec(c.ctx, insertSakeRecordRatingQuery, gameId, tableId, recordId, profileId, rating)
+	if err != nil {
+		return 0, 0, err
+	}
+	if result.RowsAffected() == 0 {
+		return 0, 0, ErrSakeAlreadyRated
+	}
+
+	var numRatings int32
+	var sumRatings int64
+	err = tx.QueryRow(c.ctx, sumSakeRecordRatingsQuery, gameId, tableId, recordId).Scan(&numRatings, &sumRatings)
+	if err != nil {
+		return 0, 0, err
+	}
+	averageRating := float32(sumRatings) / float32(numRatings)
+
+	fieldsJson, err := json.Marshal(map[string]SakeField{
+		"num_ratings": {
+			Type:  SakeFieldTypeInt,
+			Value: strconv.FormatInt(int64(numRatings), 10),
+		},
+		"sum_ratings": {
+			Type:  SakeFieldTypeInt,
+			Value: strconv.FormatInt(sumRatings, 10),
+		},
+		"average_rating": {
+			Type:  SakeFieldTypeFloat,
+			Value: strconv.FormatFloat(float64(averageRating), 'f', -1, 32),
+		},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	_, err = tx.Exec(c.ctx, updateSakeRecordRatingFieldsQuery, gameId, tableId, recordId, fieldsJson)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+			return 0, 0, ErrSakeFieldLimitExceeded
+		}
+		return 0, 0, err
+	}
+
+	return numRatings, averageRating, tx.Commit(c.ctx)
+}
+
+// GetMySakeRecordRatings returns the ratings the profile has given to any of the records, by record ID
+func (c *Connection) GetMySakeRecordRatings(gameId int, tableId string, profileId uint32, recordIds []int32) (map[int32]byte, error) {
+	rows, err := c.pool.Query(c.ctx, getMySakeRecordRatingsQuery, gameId, tableId, profileId, recordIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := map[int32]byte{}
+	for rows.Next() {
+		var recordId int32
+		var rating int16
+		if err := rows.Scan(&recordId, &rating); err != nil {
+			return nil, err
+		}
+		ratings[recordId] = byte(rating)
+	}
+
+	return ratings, rows.Err()
+}
+
+// ReportSakeRecord files the profile's report against a record. Returns pgx.ErrNoRows if the record
+// doesn't exist, or ErrSakeAlreadyReported if the profile has reported it before.
+func (c *Connection) ReportSakeRecord(gameId int, tableId string, recordId int32, profileId uint32, reasonCode int32, reason string) error {
+	result, err := c.pool.Exec(c.ctx, insertSakeRecordReportQuery, gameId, tableId, recordId, profileId, reasonCode, reason)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() != 0 {
+		return nil
+	}
+
+	var ownerId int32
+	err = c.pool.QueryRow(c.ctx, getSakeRecordOwnerQuery, gameId, tableId, recordId).Scan(&ownerId)
+	if err != nil {
+		return err
+	}
+	return ErrSakeAlreadyReported
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+// SakeRecordDetails is a record along with the columns moderators need to see
+type SakeRecordDetails struct {
+	SakeRecord
+	CreateTime *time.Time
+	UpdateTime *time.Time
+}
+
+// SakeRecordSearch filters records for moderators. Zero values match everything.
+type SakeRecordSearch struct {
+	GameID  int
+	TableID string
+	OwnerID int32
+	// Only match records that have this field
+	FieldName string
+	// Case insensitive substring of the field value, or of any field value if FieldName is empty
+	FieldValue string
+}
+
+const (
+	searchSakeRecordsQuery = `
+		SELECT game_id, table_id, owner_id, record_id, fields, create_time, update_time
+		FROM sake_records
+		WHERE ($1::integer = 0 OR game_id = $1)
+		  AND ($2::text = '' OR table_id = $2)
+		  AND ($3::integer = 0 OR owner_id = $3)
+		  AND (($4::text = '' AND $5::text = '') OR EXISTS (
+			SELECT 1
+			FROM jsonb_each(fields) AS field
+			WHERE ($4::text = '' OR field.key = $4)
+			  AND ($5::text = '' OR field.value->>'value' ILIKE $5 ESCAPE '\')
+		  ))
+		ORDER BY update_time DESC, record_id
+		LIMIT $6 OFFSET $7`
+
+	getSakeRecordDetailsQuery = `
+		SELECT game_id, table_id, owner_id, record_id, fields, create_time, update_time
+		FROM sake_records
+		WHERE game_id = $1
+		  AND table_id = $2
+		  AND record_id = $3`
+
+	forceDeleteSakeRecordQuery = `
+		DELETE FROM sake_records
+		WHERE game_id = $1
+		  AND table_id = $2
+		  AND record_id = $3
+		RETURNING game_id, table_id, owner_id, record_id, fields, create_time, update_time`
+
+	overwriteSakeRecordFieldsQuery = `
+		UPDATE sake_records
+		SET fields = fields || $4, update_time = CURRENT_TIMESTAMP
+		WHERE game_id = $1
+		  AND table_id = $2
+		  AND record_id = $3
+		RETURNING owner_id`
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchSakeRecords returns the records matching the search, most recently updated first
+func (c *Connection) SearchSakeRecords(search SakeRecordSearch, limit int, offset int) ([]SakeRecordDetails, error) {
+	fieldValue := ""
+	if search.FieldValue != "" {
+		fieldValue = "%" + likeEscaper.Replace(search.FieldValue) + "%"
+	}
+
+	rows, err := c.pool.Query(c.ctx, searchSakeRecordsQuery, search.GameID, search.TableID, search.OwnerID, search.FieldName, fieldValue, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []SakeRecordDetails{}
+	for rows.Next() {
+		record, err := scanSakeRecordDetails(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// GetSakeRecordDetails returns a single record regardless of its owner. Returns pgx.ErrNoRows if
+// the record doesn't exist.
+func (c *Connection) GetSakeRecordDetails(gameId int, tableId string, recordId int32) (SakeRecordDetails, error) {
+	return scanSakeRecordDetails(c.pool.QueryRow(c.ctx, getSakeRecordDetailsQuery, gameId, tableId, recordId))
+}
+
+// ForceDeleteSakeRecord deletes a record regardless of its owner and returns what it contained.
+// Returns pgx.ErrNoRows if the record doesn't exist.
+func (c *Connection) ForceDeleteSakeRecord(gameId int, tableId string, recordId int32) (SakeRecordDetails, error) {
+	return scanSakeRecordDetails(c.pool.QueryRow(c.ctx, forceDeleteSakeRecordQuery, gameId, tableId, recordId))
+}
+
+// OverwriteSakeRecordFields replaces the given fields of a record regardless of its owner.
+// Returns pgx.ErrNoRows if the record doesn't exist.
+func (c *Connection) OverwriteSakeRecordFields(gameId int, tableId string, recordId int32, fields map[string]SakeField) error {
+	fieldsJson, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	var ownerId int32
+	err = c.pool.QueryRow(c.ctx, overwriteSakeRecordFieldsQuery, gameId, tableId, recordId, fieldsJson).Scan(&ownerId)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+			return ErrSakeFieldLimitExceeded
+		}
+		return err
+	}
+
+	return nil
+}
+
+func scanSakeRecordDetails(row interface{ Scan(dest ...any) error }) (SakeRecordDetails, error) {
+	var record SakeRecordDetails
+	var fieldsJson []byte
+	err := row.Scan(&record.GameId, &record.TableId, &record.OwnerId, &record.RecordId, &fieldsJson, &record.CreateTime, &record.UpdateTime)
+	if err != nil {
+		return SakeRecordDetails{}, err
+	}
+
+	record.Fields, err = parseSakeFieldsFromJson(fieldsJson)
+	if err != nil {
+		return SakeRecordDetails{}, err
+	}
+
+	return record, nil
+}
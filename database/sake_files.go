@@ -0,0 +1,97 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	insertSakeFileQuery = `
+		INSERT INTO sake_files (game_id, owner_id, hash, size) 
+		VALUES ($1, $2, $3, $4) 
+		ON CONFLICT (game_id, owner_id, hash) DO UPDATE 
+		SET upload_time = CURRENT_TIMESTAMP 
+		RETURNING file_id`
+
+	// Uploading a file the profile already has doesn't count as a new upload
+	countRecentSakeFilesQuery = `
+		SELECT COUNT(*) 
+		FROM sake_files 
+		WHERE owner_id = $1 
+		  AND upload_time > CURRENT_TIMESTAMP - INTERVAL '1 day' 
+		  AND NOT (game_id = $2 AND hash = $3)`
+
+	// Serialises uploads from the same profile so the upload limit can't be raced
+	lockSakeFileOwnerQuery = `SELECT pg_advisory_xact_lock(hashtext('sake_files'), $1::integer)`
+
+	getSakeFileQuery = `
+		SELECT file_id, game_id, owner_id, hash, size, upload_time 
+		FROM sake_files 
+		WHERE game_id = $1 
+		  AND file_id = $2`
+)
+
+var ErrSakeFileLimitReached = errors.New("profile has reached the daily file upload limit")
+
+type SakeFile struct {
+	FileID     int32     `json:"file_id"`
+	GameID     int       `json:"game_id"`
+	OwnerID    uint32    `json:"owner_id"`
+	Hash       string    `json:"hash"`
+	Size       int       `json:"size"`
+	UploadTime time.Time `json:"upload_time"`
+}
+
+// IsSakeFileLimitReached checks the daily upload limit before a file is stored. InsertSakeFile
+// checks it again when the file is recorded.
+func (c *Connection) IsSakeFileLimitReached(gameId int, ownerId uint32, hash string, maxPerDay int) (bool, error) {
+	if maxPerDay <= 0 {
+		return false, nil
+	}
+
+	var count int
+	err := c.pool.QueryRow(c.ctx, countRecentSakeFilesQuery, ownerId, gameId, hash).Scan(&count)
+	return count >= maxPerDay, err
+}
+
+// InsertSakeFile records an uploaded file. Uploading the same file again returns the existing file ID.
+// Returns ErrSakeFileLimitReached if the profile has uploaded maxPerDay other files in the last day,
+// maxPerDay of 0 disables the limit.
+func (c *Connection) InsertSakeFile(gameId int, ownerId uint32, hash string, size int, maxPerDay int) (int32, error) {
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback(c.ctx)
+	}()
+
+	if maxPerDay > 0 {
+		// The lock key is the profile ID's bits, profile IDs above the integer range wrap
+		if _, err := tx.Exec(c.ctx, lockSakeFileOwnerQuery, int32(ownerId)); err != nil {
+			return 0, err
+		}
+
+		var count int
+		if err := tx.QueryRow(c.ctx, countRecentSakeFilesQuery, ownerId, gameId, hash).Scan(&count); err != nil {
+			return 0, err
+		}
+		if count >= maxPerDay {
+			return 0, ErrSakeFileLimitReached
+		}
+	}
+
+	var fileId int32
+	if err := tx.QueryRow(c.ctx, insertSakeFileQuery, gameId, ownerId, hash, size).Scan(&fileId); err != nil {
+		return 0, err
+	}
+
+	return fileId, tx.Commit(c.ctx)
+}
+
+// GetSakeFile returns the game's file with the ID, or pgx.ErrNoRows if it doesn't exist
+func (c *Connection) GetSakeFile(gameId int, fileId int32) (SakeFile, error) {
+	var file SakeFile
+	err := c.pool.QueryRow(c.ctx, getSakeFileQuery, gameId, fileId).Scan(&file.FileID, &file.GameID, &file.OwnerID, &file.Hash, &file.Size, &file.UploadTime)
+	return file, err
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+type SakeSortField struct {
+	// Field name, or "ownerid" or "recordid" for the record's own columns
+	Name       string
+	Descending bool
+}
+
+type SakeRecordQueryOptions struct {
+	Sort   []SakeSortField
+	Offset int
+	// If zero, every matching record is returned
+	Max int
+	// If set, the records surrounding the first record matching this filter in the sort
+	// order are returned instead, and Offset and Max are ignored
+	TargetFilter string
+	Surrounding  int
+}
+
+const (
+	// Values that Postgres can cast to numeric. Floats are accepted in any form strconv.ParseFloat
+	// takes, so hex floats, "Inf", underscores and exponents past what numeric can hold must not
+	// reach the cast.
+	sakeNumericValuePattern = `^[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]{1,4})?$`
+	// Numeric field types share a sort key so records of any of them compare by value, values
+	// that can't be compared sort with the records missing the field. Booleans sort false before true.
+	sakeNumericSortKey = `CASE WHEN fields->{field}->>'type' IN ('0', '1', '2', '3', '9') AND fields->{field}->>'value' ~ '` + sakeNumericValuePattern + `' ` +
+		`THEN (fields->{field}->>'value')::numeric ` +
+		`WHEN fields->{field}->>'type' = '6' THEN (CASE WHEN fields->{field}->>'value' IN ('true', '1') THEN 1 ELSE 0 END) END`
+	// Strings and dates, the date format sorts correctly as text
+	sakeTextSortKey = `CASE WHEN fields->{field}->>'type' IN ('4', '5', '7') THEN fields->{field}->>'value' END`
+)
+
+// createSqlOrderBy builds the ORDER BY expression for the sort fields, adding the field
+// names to the query arguments. Records missing a sort field, or with binary data in it,
+// always come last.
+func createSqlOrderBy(sort []SakeSortField, args []any) (string, []any) {
+	var terms []string
+	for _, field := range sort {
+		direction := " ASC NULLS LAST"
+		if field.Descending {
+			direction = " DESC NULLS LAST"
+		}
+
+		switch field.Name {
+		case "ownerid":
+			terms = append(terms, "owner_id"+direction)
+			continue
+		case "recordid":
+			terms = append(terms, "record_id"+direction)
+			continue
+		}
+
+		args = append(args, field.Name)
+		name := "$" + strconv.Itoa(len(args)) + "::text"
+		terms = append(terms, "("+strings.ReplaceAll(sakeNumericSortKey, "{field}", name)+")"+direction)
+		terms = append(terms, "("+strings.ReplaceAll(sakeTextSortKey, "{field}", name)+")"+direction)
+	}
+
+	// Keep the order stable between pages
+	terms = append(terms, "record_id ASC")
+	return strings.Join(terms, ", "), args
+}
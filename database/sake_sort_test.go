@@ -0,0 +1,93 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSakeNumericValuePattern(t *testing.T) {
+	pattern := regexp.MustCompile(sakeNumericValuePattern)
+
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"0", true},
+		{"-12", true},
+		{"+7", true},
+		{"1.5", true},
+		{"5.", true},
+		{".5", true},
+		{"1e5", true},
+		{"-2.5E-10", true},
+		// Accepted by strconv.ParseFloat but not by Postgres
+		{"0x1p-2", false},
+		{"Inf", false},
+		{"-inf", false},
+		{"NaN", false},
+		{"1_0", false},
+		{"1e-99999", false},
+		{"", false},
+		{".", false},
+		{"1.5 ", false},
+	}
+
+	for _, test := range tests {
+		if result := pattern.MatchString(test.value); result != test.expected {
+			t.Errorf("%q: got %v, expected %v", test.value, result, test.expected)
+		}
+	}
+}
+
+func TestCreateSqlOrderBy(t *testing.T) {
+	tests := []struct {
+		name     string
+		sort     []SakeSortField
+		args     []any
+		expected []string
+		newArgs  []any
+	}{
+		{"no sort", nil, nil, []string{"record_id ASC"}, nil},
+		{"record columns", []SakeSortField{{Name: "ownerid", Descending: true}, {Name: "recordid"}}, nil,
+			[]string{"owner_id DESC NULLS LAST", "record_id ASC NULLS LAST", "record_id ASC"}, nil},
+		{"field after filter arguments", []SakeSortField{{Name: "time"}}, []any{1687, "course"},
+			[]string{
+				"(" + strings.ReplaceAll(sakeNumericSortKey, "{field}", "$3::text") + ") ASC NULLS LAST",
+				"(" + strings.ReplaceAll(sakeTextSortKey, "{field}", "$3::text") + ") ASC NULLS LAST",
+				"record_id ASC",
+			}, []any{1687, "course", "time"}},
+		{"descending field", []SakeSortField{{Name: "score", Descending: true}}, nil,
+			[]string{
+				"(" + strings.ReplaceAll(sakeNumericSortKey, "{field}", "$1::text") + ") DESC NULLS LAST",
+				"(" + strings.ReplaceAll(sakeTextSortKey, "{field}", "$1::text") + ") DESC NULLS LAST",
+				"record_id ASC",
+			}, []any{"score"}},
+	}
+
+	for _, test := range tests {
+		orderBy, args := createSqlOrderBy(test.sort, test.args)
+		if expected := strings.Join(test.expected, ", "); orderBy != expected {
+			t.Errorf("%s: got %q, expected %q", test.name, orderBy, expected)
+		}
+
+		if len(args) != len(test.newArgs) {
+			t.Errorf("%s: got arguments %v, expected %v", test.name, args, test.newArgs)
+			continue
+		}
+		for i := range args {
+			if args[i] != test.newArgs[i] {
+				t.Errorf("%s: got arguments %v, expected %v", test.name, args, test.newArgs)
+				break
+			}
+		}
+	}
+}
+
+func TestCreateSqlOrderByFieldNotInlined(t *testing.T) {
+	// Field names are always passed as arguments
+	orderBy, _ := createSqlOrderBy([]SakeSortField{{Name: "x'; DROP TABLE sake_records; --"}}, nil)
+	if strings.Contains(orderBy, "DROP") {
+		t.Errorf("field name was written into the query: %s", orderBy)
+	}
+}
@@ -14,7 +14,10 @@ func (c *Connection) UpdateTables() {
 		ADD IF NOT EXISTS ban_moderator character varying,
 		ADD IF NOT EXISTS ban_tos boolean,
 		ADD IF NOT EXISTS open_host boolean DEFAULT false,
-		ADD IF NOT EXISTS allow_default_keys boolean DEFAULT false;
+		ADD IF NOT EXISTS allow_default_keys boolean DEFAULT false,
+		ADD IF NOT EXISTS join_policy character varying DEFAULT 'open_host'::character varying,
+		ADD IF NOT EXISTS hide_presence boolean DEFAULT false,
+		ADD IF NOT EXISTS hide_search boolean DEFAULT false;
 	`)
 
 	_, _ = c.pool.Exec(c.ctx, `
@@ -36,4 +39,23 @@ func (c *Connection) UpdateTables() {
 		ADD IF NOT EXISTS upload_time timestamp without time zone;
 	
 	`)
+
+	_, _ = c.pool.Exec(c.ctx, `
+
+	ALTER TABLE ONLY public.sake_records
+		DROP CONSTRAINT IF EXISTS sake_records_fields_check,
+		ADD CONSTRAINT sake_records_fields_check CHECK (CASE WHEN jsonb_typeof(fields) = 'object'
+			THEN jsonb_array_length(jsonb_path_query_array(fields - ARRAY['num_ratings', 'sum_ratings', 'average_rating'], '$.keyvalue().key')) <= 64
+			ELSE false END);
+
+	`)
+
+	_, _ = c.pool.Exec(c.ctx, `
+
+	ALTER TABLE ONLY public.reputation_reports
+		DROP CONSTRAINT IF EXISTS one_report_per_room_constraint;
+
+	CREATE INDEX IF NOT EXISTS reputation_reports_reporter_index ON public.reputation_reports (profile_id, reporter_id, report_time);
+
+	`)
 }
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
+)
+
+// How long before the start time the host can open the tournament room
+const TournamentLobbyTime = 30 * time.Minute
+
+var (
+	ErrTournamentCodeInUse = errors.New("tournament code is already in use")
+	ErrTournamentOverlap   = errors.New("host already has a tournament at that time")
+)
+
+const (
+	// Rooms are bound to the host's open tournament, so a host can't have two at once
+	lockTournamentsQuery             = `LOCK TABLE tournaments IN SHARE ROW EXCLUSIVE MODE`
+	countOverlappingTournamentsQuery = `
+		SELECT COUNT(*)
+		FROM tournaments
+		WHERE host_profile_id = $1
+		  AND start_time - $4::interval < $3
+		  AND end_time > $2 - $4::interval`
+	insertTournamentQuery = `
+		INSERT INTO tournaments (code, name, host_profile_id, profile_ids, course_ids, engine_class_ids, start_time, end_time, moderator)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id`
+	deleteTournamentQuery = `DELETE FROM tournaments WHERE id = $1`
+	getTournamentQuery    = `
+		SELECT id, code, name, host_profile_id, profile_ids, course_ids, engine_class_ids, start_time, end_time, COALESCE(moderator, '')
+		FROM tournaments
+		WHERE id = $1`
+	getUnfinishedTournamentsQuery = `
+		SELECT id, code, name, host_profile_id, profile_ids, course_ids, engine_class_ids, start_time, end_time, COALESCE(moderator, '')
+		FROM tournaments
+		WHERE end_time > $1
+		ORDER BY start_time ASC`
+	getTournamentRoomsQuery = `
+		SELECT id, group_name, dwc_group_id, game_name, COALESCE(match_type, ''), COALESCE(mkw_region, ''), profile_ids, timeline, COALESCE(races, '[]'), create_time, end_time
+		FROM room_history
+		WHERE tournament_id = $1
+		ORDER BY create_time ASC`
+)
+
+type Tournament struct {
+	ID            int      `json:"id"`
+	Code          string   `json:"code"`
+	Name          string   `json:"name"`
+	HostProfileID uint32   `json:"host_pid"`
+	ProfileIDs    []uint32 `json:"profile_ids"`
+	// Allowed courses and engine classes, any are allowed if empty
+	CourseIDs      []int     `json:"course_ids"`
+	EngineClassIDs []int     `json:"engine_class_ids"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	Moderator      string    `json:"moderator,omitempty"`
+}
+
+// CreateTournament stores the tournament and sets its ID. Returns ErrTournamentCodeInUse if another
+// tournament has the code, or ErrTournamentOverlap if the host has another tournament open at the same time.
+func (c *Connection) CreateTournament(tournament *Tournament) error {
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(c.ctx)
+	}()
+
+	if _, err = tx.Exec(c.ctx, lockTournamentsQuery); err != nil {
+		return err
+	}
+
+	var overlapping int
+	err = tx.QueryRow(c.ctx, countOverlappingTournamentsQuery, tournament.HostProfileID, tournament.StartTime, tournament.EndTime, TournamentLobbyTime).Scan(&overlapping)
+	if err != nil {
+		return err
+	}
+	if overlapping != 0 {
+		return ErrTournamentOverlap
+	}
+
+	err = tx.QueryRow(c.ctx, insertTournamentQuery, tournament.Code, tournament.Name, tournament.HostProfileID, tournament.ProfileIDs, tournament.CourseIDs, tournament.EngineClassIDs, tournament.StartTime, tournament.EndTime, tournament.Moderator).Scan(&tournament.ID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrTournamentCodeInUse
+		}
+		return err
+	}
+
+	return tx.Commit(c.ctx)
+}
+
+// DeleteTournament removes the tournament, returning false if it doesn't exist
+func (c *Connection) DeleteTournament(id int) (bool, error) {
+	result, err := c.pool.Exec(c.ctx, deleteTournamentQuery, id)
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() != 0, nil
+}
+
+// GetTournament returns the tournament with the ID, or pgx.ErrNoRows if it doesn't exist
+func (c *Connection) GetTournament(id int) (Tournament, error) {
+	return scanTournament(c.pool.QueryRow(c.ctx, getTournamentQuery, id))
+}
+
+// GetUnfinishedTournaments returns the tournaments that are running or haven't started yet
+func (c *Connection) GetUnfinishedTournaments() ([]Tournament, error) {
+	rows, err := c.pool.Query(c.ctx, getUnfinishedTournamentsQuery, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tournaments := []Tournament{}
+	for rows.Next() {
+		tournament, err := scanTournament(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tournaments = append(tournaments, tournament)
+	}
+
+	return tournaments, rows.Err()
+}
+
+// GetTournamentRooms returns the archived rooms that were played for the tournament
+func (c *Connection) GetTournamentRooms(id int) ([]RoomHistory, error) {
+	rows, err := c.pool.Query(c.ctx, getTournamentRoomsQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []RoomHistory{}
+	for rows.Next() {
+		var room RoomHistory
+		err = rows.Scan(&room.ID, &room.GroupName, &room.GroupID, &room.GameName, &room.MatchType, &room.MKWRegion, &room.ProfileIDs, &room.Timeline, &room.Races, &room.CreateTime, &room.EndTime)
+		if err != nil {
+			return nil, err
+		}
+
+		room.TournamentID = id
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+func scanTournament(row pgx.Row) (Tournament, error) {
+	var tournament Tournament
+	err := row.Scan(&tournament.ID, &tournament.Code, &tournament.Name, &tournament.HostProfileID, &tournament.ProfileIDs, &tournament.CourseIDs, &tournament.EngineClassIDs, &tournament.StartTime, &tournament.EndTime, &tournament.Moderator)
+	return tournament, err
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"math/rand"
 	"time"
+
+	"github.com/jackc/pgx/v4"
 )
 
 const (
@@ -12,15 +14,33 @@ const (
 	UpdateUserTable         = `UPDATE users SET firstname = CASE WHEN $3 THEN $2 ELSE firstname END, lastname = CASE WHEN $5 THEN $4 ELSE lastname END, open_host = CASE WHEN $7 THEN $6 ELSE open_host END WHERE profile_id = $1`
 	UpdateUserProfileID     = `UPDATE users SET profile_id = $3 WHERE user_id = $1 AND gsbrcd = $2`
 	UpdateUserNGDeviceID    = `UPDATE users SET ng_device_id = $2 WHERE profile_id = $1`
-	GetUser                 = `SELECT user_id, gsbrcd, email, unique_nick, firstname, lastname, open_host, last_ip_address, last_ingamesn FROM users WHERE profile_id = $1`
+	GetUser                 = `SELECT user_id, gsbrcd, email, unique_nick, firstname, lastname, open_host, last_ip_address, last_ingamesn, join_policy, hide_presence, hide_search FROM users WHERE profile_id = $1`
 	ClearProfileQuery       = `DELETE FROM users WHERE profile_id = $1 RETURNING user_id, gsbrcd, email, unique_nick, firstname, lastname, open_host, last_ip_address, last_ingamesn`
 	DoesUserExist           = `SELECT EXISTS(SELECT 1 FROM users WHERE user_id = $1 AND gsbrcd = $2)`
 	IsProfileIDInUse        = `SELECT EXISTS(SELECT 1 FROM users WHERE profile_id = $1)`
 	DeleteUserSession       = `DELETE FROM sessions WHERE profile_id = $1`
-	GetUserProfileID        = `SELECT profile_id, ng_device_id, email, unique_nick, firstname, lastname, open_host, last_ip_address, allow_default_keys FROM users WHERE user_id = $1 AND gsbrcd = $2`
+	GetUserProfileID        = `SELECT profile_id, ng_device_id, email, unique_nick, firstname, lastname, open_host, last_ip_address, allow_default_keys, join_policy, hide_presence, hide_search FROM users WHERE user_id = $1 AND gsbrcd = $2`
 	UpdateUserLastIPAddress = `UPDATE users SET last_ip_address = $2, last_ingamesn = $3 WHERE profile_id = $1`
 	UpdateUserBan           = `UPDATE users SET has_ban = true, ban_issued = $2, ban_expires = $3, ban_reason = $4, ban_reason_hidden = $5, ban_moderator = $6, ban_tos = $7 WHERE profile_id = $1`
 	DisableUserBan          = `UPDATE users SET has_ban = false WHERE profile_id = $1`
+	UpdateUserPrivacy       = `UPDATE users SET join_policy = $2, hide_presence = $3, hide_search = $4 WHERE profile_id = $1`
+	GetUserNGDeviceID       = `SELECT ng_device_id FROM users WHERE profile_id = $1`
+	UpdateUserAdmin         = `UPDATE users SET firstname = CASE WHEN $3 THEN $2 ELSE firstname END, lastname = CASE WHEN $5 THEN $4 ELSE lastname END, last_ingamesn = CASE WHEN $7 THEN $6 ELSE last_ingamesn END, open_host = CASE WHEN $9 THEN $8 ELSE open_host END, ng_device_id = CASE WHEN $11 THEN $10 ELSE ng_device_id END WHERE profile_id = $1`
+	UpdateUserPair          = `UPDATE users SET user_id = $2, gsbrcd = $3 WHERE profile_id = $1`
+	GetProfileIDByPair      = `SELECT profile_id, has_ban FROM users WHERE user_id = $1 AND gsbrcd = $2`
+	UpdateProfileIDByID     = `UPDATE users SET profile_id = $2 WHERE profile_id = $1`
+	ArchiveMergedProfile    = `
+		WITH deleted AS (DELETE FROM users WHERE profile_id = $1 RETURNING *)
+		INSERT INTO merged_profiles (profile_id, merged_into, moderator, user_data)
+		SELECT profile_id, $2, $3, to_jsonb(deleted) FROM deleted
+		RETURNING id, user_data->>'email', user_data->>'unique_nick', COALESCE(user_data->>'firstname', ''), COALESCE(user_data->>'lastname', ''), COALESCE(user_data->>'last_ingamesn', '')`
+)
+
+// Join policies control who may join a player through their friend roster
+const (
+	JoinPolicyNobody   = "nobody"    // Nobody may join
+	JoinPolicyFriends  = "friends"   // Only mutual friends may join
+	JoinPolicyOpenHost = "open_host" // Mutual friends, or anyone if open host is enabled
 )
 
 type User struct {
@@ -36,6 +56,9 @@ type User struct {
 	RestrictedDeviceId uint32
 	BanReason          string
 	OpenHost           bool
+	JoinPolicy         string
+	HidePresence       bool
+	HideSearch         bool
 	LastInGameSn       string
 	LastIPAddress      string
 	Created            bool
@@ -44,6 +67,11 @@ type User struct {
 var (
 	ErrProfileIDInUse         = errors.New("profile ID is already in use")
 	ErrReservedProfileIDRange = errors.New("profile ID is in reserved range")
+	ErrInvalidJoinPolicy      = errors.New("invalid join policy")
+	ErrProfileNotFound        = errors.New("profile not found")
+	ErrTransferSameProfile    = errors.New("profile is already bound to this user ID and gsbrcd")
+	ErrTransferConflict       = errors.New("profile bound to the user ID and gsbrcd has changed")
+	ErrTransferBannedProfile  = errors.New("profile bound to the user ID and gsbrcd is banned")
 )
 
 func (c *Connection) CreateUser(user *User) error {
@@ -124,7 +152,7 @@ func (c *Connection) UpdateProfile(user *User, data map[string]string) {
 func (c *Connection) GetProfile(profileId uint32) (User, bool) {
 	user := User{}
 	row := c.pool.QueryRow(c.ctx, GetUser, profileId)
-	err := row.Scan(&user.UserId, &user.GsbrCode, &user.Email, &user.UniqueNick, &user.FirstName, &user.LastName, &user.OpenHost, &user.LastIPAddress, &user.LastInGameSn)
+	err := row.Scan(&user.UserId, &user.GsbrCode, &user.Email, &user.UniqueNick, &user.FirstName, &user.LastName, &user.OpenHost, &user.LastIPAddress, &user.LastInGameSn, &user.JoinPolicy, &user.HidePresence, &user.HideSearch)
 	if err != nil {
 		return User{}, false
 	}
@@ -133,6 +161,195 @@ func (c *Connection) GetProfile(profileId uint32) (User, bool) {
 	return user, true
 }
 
+func IsValidJoinPolicy(joinPolicy string) bool {
+	return joinPolicy == JoinPolicyNobody || joinPolicy == JoinPolicyFriends || joinPolicy == JoinPolicyOpenHost
+}
+
+func (c *Connection) UpdatePrivacySettings(user *User, joinPolicy string, hidePresence bool, hideSearch bool) error {
+	if !IsValidJoinPolicy(joinPolicy) {
+		return ErrInvalidJoinPolicy
+	}
+
+	_, err := c.pool.Exec(c.ctx, UpdateUserPrivacy, user.ProfileId, joinPolicy, hidePresence, hideSearch)
+	if err == nil {
+		user.JoinPolicy = joinPolicy
+		user.HidePresence = hidePresence
+		user.HideSearch = hideSearch
+	}
+
+	return err
+}
+
+// ProfileEdit holds the profile fields a moderator can change. Nil fields are left unchanged.
+type ProfileEdit struct {
+	FirstName    *string
+	LastName     *string
+	LastInGameSn *string
+	OpenHost     *bool
+	NgDeviceId   *[]uint32
+}
+
+func (c *Connection) GetProfileDeviceIDs(profileId uint32) ([]uint32, error) {
+	var deviceIds []uint32
+	err := c.pool.QueryRow(c.ctx, GetUserNGDeviceID, profileId).Scan(&deviceIds)
+	if err == pgx.ErrNoRows {
+		return nil, ErrProfileNotFound
+	}
+
+	return deviceIds, err
+}
+
+func (c *Connection) EditProfile(profileId uint32, edit ProfileEdit) error {
+	var firstName, lastName, lastInGameSn string
+	var openHost bool
+	var deviceIds []uint32
+
+	if edit.FirstName != nil {
+		firstName = *edit.FirstName
+	}
+	if edit.LastName != nil {
+		lastName = *edit.LastName
+	}
+	if edit.LastInGameSn != nil {
+		lastInGameSn = *edit.LastInGameSn
+	}
+	if edit.OpenHost != nil {
+		openHost = *edit.OpenHost
+	}
+	if edit.NgDeviceId != nil {
+		deviceIds = *edit.NgDeviceId
+		if deviceIds == nil {
+			deviceIds = []uint32{}
+		}
+	}
+
+	tag, err := c.pool.Exec(c.ctx, UpdateUserAdmin, profileId, firstName, edit.FirstName != nil, lastName, edit.LastName != nil, lastInGameSn, edit.LastInGameSn != nil, openHost, edit.OpenHost != nil, deviceIds, edit.NgDeviceId != nil)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrProfileNotFound
+	}
+
+	return nil
+}
+
+// ResetDeviceIDs clears the device binding so the next console to log in is bound to the profile
+func (c *Connection) ResetDeviceIDs(profileId uint32) error {
+	tag, err := c.pool.Exec(c.ctx, UpdateUserNGDeviceID, profileId, []uint32{})
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrProfileNotFound
+	}
+
+	return nil
+}
+
+type ProfileTransfer struct {
+	ProfileID uint32
+	UserID    uint64
+	GsbrCode  string
+	// Profile ID to assign once transferred, or 0 to keep the current one
+	NewProfileID uint32
+	// Profile currently bound to the new pair as seen by the caller, or 0 if there is none
+	MergedProfileID uint32
+	Moderator       string
+}
+
+// MergedProfile is the archived copy of a profile removed by a merge
+type MergedProfile struct {
+	ArchiveID    int
+	ProfileID    uint32
+	Email        string
+	UniqueNick   string
+	FirstName    string
+	LastName     string
+	LastInGameSn string
+}
+
+// GetProfileIDByPair returns the profile bound to a user ID and gsbrcd pair, and whether it is banned
+func (c *Connection) GetProfileIDByPair(userId uint64, gsbrcd string) (uint32, bool, error) {
+	var profileId uint32
+	var hasBan *bool
+	err := c.pool.QueryRow(c.ctx, GetProfileIDByPair, userId, gsbrcd).Scan(&profileId, &hasBan)
+	return profileId, hasBan != nil && *hasBan, err
+}
+
+// TransferProfile binds a profile to a new user ID and gsbrcd pair, and optionally assigns it a new
+// profile ID. If another profile is already bound to the pair, it is merged into the transferred
+// profile: its user row is archived in merged_profiles and removed, while its other data is kept
+// under its old profile ID. Banned profiles are never merged. The caller must pass the profile it
+// expects to be merged, so that it can be kicked before the transfer; ErrTransferConflict is
+// returned if a different one is found. Returns the archived profile if one was merged.
+func (c *Connection) TransferProfile(transfer ProfileTransfer) (*MergedProfile, error) {
+	user, ok := c.GetProfile(transfer.ProfileID)
+	if !ok {
+		return nil, ErrProfileNotFound
+	}
+
+	if user.UserId == transfer.UserID && user.GsbrCode == transfer.GsbrCode {
+		return nil, ErrTransferSameProfile
+	}
+
+	if transfer.NewProfileID >= 1000000000 {
+		return nil, ErrReservedProfileIDRange
+	}
+
+	tx, err := c.pool.Begin(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(c.ctx)
+
+	var mergedId uint32
+	var mergedBan *bool
+	err = tx.QueryRow(c.ctx, GetProfileIDByPair, transfer.UserID, transfer.GsbrCode).Scan(&mergedId, &mergedBan)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+
+	if mergedId != transfer.MergedProfileID {
+		return nil, ErrTransferConflict
+	}
+
+	var merged *MergedProfile
+	if mergedId != 0 {
+		if mergedBan != nil && *mergedBan {
+			return nil, ErrTransferBannedProfile
+		}
+
+		merged = &MergedProfile{ProfileID: mergedId}
+		err = tx.QueryRow(c.ctx, ArchiveMergedProfile, mergedId, transfer.ProfileID, transfer.Moderator).Scan(&merged.ArchiveID, &merged.Email, &merged.UniqueNick, &merged.FirstName, &merged.LastName, &merged.LastInGameSn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = tx.Exec(c.ctx, UpdateUserPair, transfer.ProfileID, transfer.UserID, transfer.GsbrCode); err != nil {
+		return nil, err
+	}
+
+	if transfer.NewProfileID != 0 && transfer.NewProfileID != transfer.ProfileID {
+		var exists bool
+		if err = tx.QueryRow(c.ctx, IsProfileIDInUse, transfer.NewProfileID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrProfileIDInUse
+		}
+
+		if _, err = tx.Exec(c.ctx, UpdateProfileIDByID, transfer.ProfileID, transfer.NewProfileID); err != nil {
+			return nil, err
+		}
+	}
+
+	return merged, tx.Commit(c.ctx)
+}
+
 func (c *Connection) ClearProfile(profileId uint32) (User, bool) {
 	user := User{}
 	row := c.pool.QueryRow(c.ctx, ClearProfileQuery, profileId)
@@ -2,6 +2,7 @@ package gamestats
 
 import (
 	"strconv"
+	"strings"
 	"wwfc/common"
 	"wwfc/logging"
 
@@ -51,7 +52,19 @@ func (g *GameStatsSession) getpd(command common.GameSpyCommand) {
 		return
 	}
 
-	logging.Info(g.ModuleName, "Get public data: PID:", aurora.Cyan(profileId), "Index:", aurora.Cyan(dindex), "Type:", aurora.Cyan(ptype))
+	if !isValidPersistType(ptype) {
+		logging.Error(g.ModuleName, "Invalid ptype:", aurora.Cyan(ptype))
+		g.Write(errMsg)
+		return
+	}
+
+	if isPrivatePersistType(ptype) && uint32(profileId) != g.User.ProfileId {
+		logging.Error(g.ModuleName, "Attempt to get private data of profile", aurora.Cyan(profileId))
+		g.Write(errMsg)
+		return
+	}
+
+	logging.Info(g.ModuleName, "Get persistent data: PID:", aurora.Cyan(profileId), "Index:", aurora.Cyan(dindex), "Type:", aurora.Cyan(ptype))
 
 	modifiedTime, data, err := db.GetGameStatsPublicData(uint32(profileId), dindex, ptype)
 	if err != nil {
@@ -66,6 +79,11 @@ func (g *GameStatsSession) getpd(command common.GameSpyCommand) {
 		return
 	}
 
+	// Only return the requested keys of key value data
+	if keys := strings.Trim(command.OtherValues["keys"], persistKeySeparator); keys != "" {
+		data = parseKeyValueData(data).String(strings.Split(keys, persistKeySeparator)...)
+	}
+
 	g.Write(common.GameSpyCommand{
 		Command:      "getpdr",
 		CommandValue: "1",
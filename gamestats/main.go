@@ -36,8 +36,9 @@ type GameStatsSession struct {
 var (
 	db database.Connection
 
-	serverName string
-	webSalt    string
+	serverName   string
+	storageQuota int
+	maxSnapshots int
 
 	sessionsByConnIndex = make(map[uint64]*GameStatsSession)
 	mutex               = deadlock.RWMutex{}
@@ -48,7 +49,10 @@ func StartServer(reload bool) {
 	config := common.GetConfig()
 
 	serverName = config.ServerName
-	webSalt = common.RandomString(32)
+	loadWebConfig(config.GameStats)
+	loadRankingConfig(config.GameStats)
+	storageQuota = config.GameStats.StorageQuota
+	maxSnapshots = config.GameStats.MaxSnapshots
 
 	common.ReadGameList()
 
@@ -79,6 +83,13 @@ func StartServer(reload bool) {
 		}
 
 		logging.Notice("GSTATS", "Loaded", aurora.Cyan(len(sessionsByConnIndex)), "sessions")
+
+		// Tokens only live for a few minutes, so the server can run without them
+		if err := loadWebTokens(); err != nil {
+			logging.Error("GSTATS", "Failed to load web tokens:", err)
+		} else {
+			logging.Notice("GSTATS", "Loaded", aurora.Cyan(GetWebStats().OutstandingTokens), "web tokens")
+		}
 	}
 }
 
@@ -95,6 +106,12 @@ func Shutdown() {
 	common.ShouldNotError(encoder.Encode(sessionsByConnIndex))
 
 	logging.Notice("GSTATS", "Saved", aurora.Cyan(len(sessionsByConnIndex)), "sessions")
+
+	if err := saveWebTokens(); err != nil {
+		logging.Error("GSTATS", "Failed to save web tokens:", err)
+	} else {
+		logging.Notice("GSTATS", "Saved", aurora.Cyan(GetWebStats().OutstandingTokens), "web tokens")
+	}
 }
 
 func NewConnection(index uint64, address string) {
@@ -228,6 +245,8 @@ func HandlePacket(index uint64, data []byte) {
 
 	commands = session.handleCommand("getpd", commands, session.getpd)
 	commands = session.handleCommand("setpd", commands, session.setpd)
+	commands = session.handleCommand("newgame", commands, session.newgame)
+	commands = session.handleCommand("updgame", commands, session.updgame)
 	common.MaybeUnused(session.ignoreCommand)
 
 	for _, command := range commands {
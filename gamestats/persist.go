@@ -0,0 +1,75 @@
+package gamestats
+
+import (
+	"strings"
+)
+
+// persisttype_t
+const (
+	persistTypePrivateReadOnly  = "0"
+	persistTypePrivateReadWrite = "1"
+	persistTypePublicReadOnly   = "2"
+	persistTypePublicReadWrite  = "3"
+)
+
+// Separates the key names in the keys value of getpd
+const persistKeySeparator = "\x01"
+
+func isValidPersistType(ptype string) bool {
+	switch ptype {
+	case persistTypePrivateReadOnly, persistTypePrivateReadWrite, persistTypePublicReadOnly, persistTypePublicReadWrite:
+		return true
+	}
+	return false
+}
+
+// Private data can only be read by its owner
+func isPrivatePersistType(ptype string) bool {
+	return ptype == persistTypePrivateReadOnly || ptype == persistTypePrivateReadWrite
+}
+
+type keyValueData struct {
+	keys   []string
+	values map[string]string
+}
+
+// parseKeyValueData parses data in the \key\value\key\value format
+func parseKeyValueData(data string) keyValueData {
+	kv := keyValueData{values: map[string]string{}}
+
+	parts := strings.Split(strings.TrimPrefix(data, `\`), `\`)
+	for i := 0; i+1 < len(parts); i += 2 {
+		kv.set(parts[i], parts[i+1])
+	}
+
+	return kv
+}
+
+func (kv *keyValueData) set(key string, value string) {
+	if _, exists := kv.values[key]; !exists {
+		kv.keys = append(kv.keys, key)
+	}
+	kv.values[key] = value
+}
+
+// merge sets the keys of other, keeping the keys it doesn't have
+func (kv *keyValueData) merge(other keyValueData) {
+	for _, key := range other.keys {
+		kv.set(key, other.values[key])
+	}
+}
+
+// String returns the keys in the \key\value format. If a selection is given, only those keys
+// are included in that order, with an empty value for missing keys.
+func (kv keyValueData) String(selection ...string) string {
+	keys := kv.keys
+	if len(selection) != 0 {
+		keys = selection
+	}
+
+	var builder strings.Builder
+	for _, key := range keys {
+		builder.WriteString(`\` + key + `\` + kv.values[key])
+	}
+	return builder.String()
+}
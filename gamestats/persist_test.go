@@ -0,0 +1,77 @@
+package gamestats
+
+import (
+	"testing"
+)
+
+func TestParseKeyValueData(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+		keys     int
+	}{
+		{"empty", "", "", 0},
+		{"single key", `\a\1`, `\a\1`, 1},
+		{"several keys", `\a\1\b\2\c\3`, `\a\1\b\2\c\3`, 3},
+		{"no leading separator", `a\1\b\2`, `\a\1\b\2`, 2},
+		{"empty value", `\a\\b\2`, `\a\\b\2`, 2},
+		{"missing last value", `\a\1\b`, `\a\1`, 1},
+		{"repeated key", `\a\1\b\2\a\3`, `\a\3\b\2`, 2},
+	}
+
+	for _, test := range tests {
+		kv := parseKeyValueData(test.data)
+		if result := kv.String(); result != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, result, test.expected)
+		}
+		if len(kv.keys) != test.keys || len(kv.values) != test.keys {
+			t.Errorf("%s: got %d keys and %d values, expected %d", test.name, len(kv.keys), len(kv.values), test.keys)
+		}
+	}
+}
+
+func TestKeyValueDataMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      string
+		new      string
+		expected string
+	}{
+		{"new keys are appended", `\a\1`, `\b\2`, `\a\1\b\2`},
+		{"existing keys keep their order", `\a\1\b\2\c\3`, `\c\4\a\5`, `\a\5\b\2\c\4`},
+		{"nothing sent", `\a\1\b\2`, "", `\a\1\b\2`},
+		{"nothing stored", "", `\a\1`, `\a\1`},
+		{"value cleared", `\a\1\b\2`, `\a\`, `\a\\b\2`},
+	}
+
+	for _, test := range tests {
+		kv := parseKeyValueData(test.old)
+		kv.merge(parseKeyValueData(test.new))
+		if result := kv.String(); result != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestKeyValueDataStringSelection(t *testing.T) {
+	kv := parseKeyValueData(`\a\1\b\2\c\3`)
+
+	tests := []struct {
+		name      string
+		selection []string
+		expected  string
+	}{
+		{"no selection", nil, `\a\1\b\2\c\3`},
+		{"one key", []string{"b"}, `\b\2`},
+		{"selection order", []string{"c", "a"}, `\c\3\a\1`},
+		{"missing key", []string{"a", "d"}, `\a\1\d\`},
+		{"repeated key", []string{"a", "a"}, `\a\1\a\1`},
+	}
+
+	for _, test := range tests {
+		if result := kv.String(test.selection...); result != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, result, test.expected)
+		}
+	}
+}
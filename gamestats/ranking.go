@@ -0,0 +1,285 @@
+package gamestats
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/gpcm"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Response types
+const (
+	rankingResponsePut = 0
+	rankingResponseGet = 1
+)
+
+// DWCRnkGetMode
+const (
+	rankingModeOrder    = 0 // The player's own rank
+	rankingModeTopList  = 1
+	rankingModeNear     = 2 // Scores above and below the player's
+	rankingModeFriends  = 3
+	rankingModeNearHigh = 4 // Scores above the player's
+	rankingModeNearLow  = 5 // Scores below the player's
+)
+
+const (
+	rankingMaxCategory = 1000
+	rankingMaxDataSize = 764
+	rankingMaxLimit    = 30
+	rankingMaxFriends  = 64
+	// DWCRnkRegion, every region the DWC ranking library knows about
+	rankingRegionAll = 0xFF
+
+	// Ordering of DWCRnkGetParam
+	rankingOrderDescending = 0
+	rankingOrderAscending  = 1
+)
+
+var (
+	// Games that put2 and get2 are enabled for
+	rankingGames = map[string]bool{}
+
+	errInvalidRankingData = errors.New("invalid ranking request data")
+)
+
+type put2Request struct {
+	Category uint32
+	Region   uint32
+	Score    int32
+	Data     []byte
+}
+
+type get2Request struct {
+	Category   uint32
+	Mode       uint32
+	RegionMask uint32
+	Order      uint32
+	Limit      int
+	// Minutes, 0 for all time
+	Since   uint32
+	Friends []uint32
+}
+
+// The put2 and get2 layouts below are reconstructed from the DWC ranking library's parameters
+// (DWCRnkGetMode, DWCRnkRegion, DWCRnkGetParam) and haven't been checked against traffic from a
+// retail game. Rankings are only enabled for the games listed in the config, see loadRankingConfig.
+
+// parsePut2Data reads put2.asp data, little endian:
+// 0x00 u32 category, 0x04 u32 region, 0x08 s32 score, 0x0C u32 data size, 0x10 data
+func parsePut2Data(moduleName string, data []byte) (put2Request, bool) {
+	if len(data) < 0x10 {
+		logging.Error(moduleName, "Invalid put2 data length:", aurora.Cyan(len(data)))
+		return put2Request{}, false
+	}
+
+	request := put2Request{
+		Category: binary.LittleEndian.Uint32(data[0x00:0x04]),
+		Region:   binary.LittleEndian.Uint32(data[0x04:0x08]) & rankingRegionAll,
+		Score:    int32(binary.LittleEndian.Uint32(data[0x08:0x0C])),
+		Data:     data[0x10:],
+	}
+	size := binary.LittleEndian.Uint32(data[0x0C:0x10])
+
+	if request.Category > rankingMaxCategory {
+		logging.Error(moduleName, "Invalid ranking category:", aurora.Cyan(request.Category))
+		return put2Request{}, false
+	}
+	if size > rankingMaxDataSize || int(size) != len(request.Data) {
+		logging.Error(moduleName, "Invalid ranking data size:", aurora.Cyan(size))
+		return put2Request{}, false
+	}
+
+	return request, true
+}
+
+func loadRankingConfig(config common.GameStatsConfig) {
+	rankingGames = map[string]bool{}
+	for _, game := range config.Games {
+		if game.Rankings {
+			rankingGames[game.GameName] = true
+		}
+	}
+}
+
+// makeEmptyGet2Response is sent to games that rankings aren't enabled for
+func makeEmptyGet2Response() []byte {
+	return makeGet2Response(nil, time.Time{})
+}
+
+func handlePut2(moduleName string, game *common.GameInfo, profileId uint32, query url.Values) []byte {
+	data, err := decodeRankingData(query)
+	if err != nil {
+		logging.Error(moduleName, "Invalid put2 data:", aurora.Cyan(query.Get("data")))
+		return nil
+	}
+
+	request, ok := parsePut2Data(moduleName, data)
+	if !ok {
+		return nil
+	}
+
+	err = db.PutGameStatsRanking(game.Name, request.Category, profileId, request.Region, request.Score, request.Data)
+	if err != nil {
+		logging.Error(moduleName, "Failed to store the ranking:", err)
+		return nil
+	}
+
+	logging.Info(moduleName, "Put ranking category", aurora.Cyan(request.Category), "score", aurora.Cyan(request.Score))
+
+	return binary.LittleEndian.AppendUint32([]byte{}, rankingResponsePut)
+}
+
+// parseGet2Data reads get2.asp data, little endian:
+// 0x00 u32 category, 0x04 u32 mode, 0x08 u32 region mask, 0x0C u32 order, 0x10 u32 limit,
+// 0x14 u32 since (minutes, 0 for all time), 0x18 u32 friend count, 0x1C u32 friend profile IDs
+func parseGet2Data(moduleName string, data []byte) (get2Request, bool) {
+	if len(data) < 0x1C {
+		logging.Error(moduleName, "Invalid get2 data length:", aurora.Cyan(len(data)))
+		return get2Request{}, false
+	}
+
+	request := get2Request{
+		Category:   binary.LittleEndian.Uint32(data[0x00:0x04]),
+		Mode:       binary.LittleEndian.Uint32(data[0x04:0x08]),
+		RegionMask: binary.LittleEndian.Uint32(data[0x08:0x0C]) & rankingRegionAll,
+		Order:      binary.LittleEndian.Uint32(data[0x0C:0x10]),
+		Limit:      int(min(binary.LittleEndian.Uint32(data[0x10:0x14]), rankingMaxLimit)),
+		Since:      binary.LittleEndian.Uint32(data[0x14:0x18]),
+	}
+	friendCount := binary.LittleEndian.Uint32(data[0x18:0x1C])
+
+	if request.Category > rankingMaxCategory || (request.Order != rankingOrderDescending && request.Order != rankingOrderAscending) {
+		logging.Error(moduleName, "Invalid ranking query: category", aurora.Cyan(request.Category), "order", aurora.Cyan(request.Order))
+		return get2Request{}, false
+	}
+	if friendCount > rankingMaxFriends || len(data) < 0x1C+int(friendCount)*4 {
+		logging.Error(moduleName, "Invalid ranking friend count:", aurora.Cyan(friendCount))
+		return get2Request{}, false
+	}
+
+	for i := 0; i < int(friendCount); i++ {
+		request.Friends = append(request.Friends, binary.LittleEndian.Uint32(data[0x1C+i*4:]))
+	}
+
+	return request, true
+}
+
+func handleGet2(moduleName string, game *common.GameInfo, profileId uint32, query url.Values) []byte {
+	data, err := decodeRankingData(query)
+	if err != nil {
+		logging.Error(moduleName, "Invalid get2 data:", aurora.Cyan(query.Get("data")))
+		return nil
+	}
+
+	request, ok := parseGet2Data(moduleName, data)
+	if !ok {
+		return nil
+	}
+
+	rankingQuery := database.GameStatsRankingQuery{
+		GameName:   game.Name,
+		Category:   request.Category,
+		RegionMask: request.RegionMask,
+		Ascending:  request.Order == rankingOrderAscending,
+		Since:      time.Duration(request.Since) * time.Minute,
+	}
+
+	var rankings []database.GameStatsRanking
+	switch request.Mode {
+	case rankingModeOrder:
+		rankings, err = db.GetGameStatsProfileRanking(rankingQuery, profileId)
+
+	case rankingModeTopList:
+		rankings, err = db.GetGameStatsTopRankings(rankingQuery, request.Limit)
+
+	case rankingModeNear:
+		rankings, err = db.GetGameStatsRankingsAround(rankingQuery, profileId, request.Limit/2, request.Limit/2)
+
+	case rankingModeNearHigh:
+		rankings, err = db.GetGameStatsRankingsAround(rankingQuery, profileId, request.Limit, 0)
+
+	case rankingModeNearLow:
+		rankings, err = db.GetGameStatsRankingsAround(rankingQuery, profileId, 0, request.Limit)
+
+	case rankingModeFriends:
+		friends := []uint32{profileId}
+		for _, friend := range request.Friends {
+			if !slices.Contains(friends, friend) {
+				friends = append(friends, friend)
+			}
+		}
+		rankings, err = db.GetGameStatsFriendRankings(rankingQuery, friends, rankingMaxFriends+1)
+
+	default:
+		logging.Error(moduleName, "Invalid ranking mode:", aurora.Cyan(request.Mode))
+		return nil
+	}
+
+	if err != nil {
+		logging.Error(moduleName, "Failed to get rankings:", err)
+		return nil
+	}
+
+	return makeGet2Response(rankings, time.Now())
+}
+
+func makeGet2Response(rankings []database.GameStatsRanking, now time.Time) []byte {
+	response := binary.LittleEndian.AppendUint32([]byte{}, rankingResponseGet)
+	response = binary.LittleEndian.AppendUint32(response, uint32(len(rankings)))
+	for _, ranking := range rankings {
+		response = binary.LittleEndian.AppendUint32(response, uint32(ranking.Rank))
+		response = binary.LittleEndian.AppendUint32(response, ranking.ProfileID)
+		response = binary.LittleEndian.AppendUint32(response, uint32(ranking.Score))
+		response = binary.LittleEndian.AppendUint32(response, ranking.Region)
+		// Minutes since the score was updated
+		response = binary.LittleEndian.AppendUint32(response, uint32(max(0, now.Sub(ranking.UpdateTime).Minutes())))
+		response = binary.LittleEndian.AppendUint32(response, uint32(len(ranking.Data)))
+		response = append(response, ranking.Data...)
+	}
+
+	return response
+}
+
+func decodeRankingData(query url.Values) ([]byte, error) {
+	encoded := query.Get("data")
+	if encoded == "" {
+		return nil, errInvalidRankingData
+	}
+
+	return base64.URLEncoding.DecodeString(encoded)
+}
+
+// isProfileAuthenticatedFrom returns true if the profile has an authenticated gamestats session or
+// is logged in to GPCM from the IP address. Ranking requests only carry the profile ID in the query.
+func isProfileAuthenticatedFrom(profileId uint32, ipAddress string) bool {
+	mutex.RLock()
+	for _, session := range sessionsByConnIndex {
+		if session.Authenticated && session.User.ProfileId == profileId && strings.Split(session.RemoteAddr, ":")[0] == ipAddress {
+			mutex.RUnlock()
+			return true
+		}
+	}
+	mutex.RUnlock()
+
+	return gpcm.IsLoggedInFrom(profileId, ipAddress)
+}
+
+func getRankingProfileID(query url.Values) (uint32, bool) {
+	profileId, err := strconv.ParseUint(query.Get("pid"), 10, 32)
+	if err != nil || profileId == 0 {
+		return 0, false
+	}
+
+	return uint32(profileId), true
+}
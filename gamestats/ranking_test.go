@@ -0,0 +1,145 @@
+package gamestats
+
+import (
+	"encoding/hex"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+)
+
+// These fixtures were written by hand from the layout in ranking.go, they are not captured from
+// a game. They document the layout the handlers expect until real traffic is available, which is
+// why rankings are only enabled for games listed in the config.
+const (
+	// Category 5, region 2, score 12345, 4 bytes of data "ABCD"
+	testPut2Data = "BQAAAAIAAAA5MAAABAAAAEFCQ0Q="
+	// Category 5, friends mode, all regions, descending, limit 10, all time, friends 1000 and 1001
+	testGet2Data = "BQAAAAMAAAD_AAAAAAAAAAoAAAAAAAAAAgAAAOgDAADpAwAA"
+	// Get response, one ranking: rank 1, profile 1000, score 12345, region 2, 5 minutes old, "ABCD"
+	testGet2Response = "010000000100000001000000e80300003930000002000000050000000400000041424344"
+)
+
+func TestParsePut2Data(t *testing.T) {
+	data, err := decodeRankingData(url.Values{"data": {testPut2Data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, ok := parsePut2Data("test", data)
+	if !ok {
+		t.Fatal("fixture was rejected")
+	}
+	if request.Category != 5 || request.Region != 2 || request.Score != 12345 || string(request.Data) != "ABCD" {
+		t.Errorf("got %+v", request)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short header", data[:0xC]},
+		{"size past the data", data[:len(data)-1]},
+		{"category out of range", append([]byte{0xE9, 0x03, 0, 0}, data[4:]...)},
+	}
+
+	for _, test := range tests {
+		if _, ok := parsePut2Data("test", test.data); ok {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
+
+func TestParseGet2Data(t *testing.T) {
+	data, err := decodeRankingData(url.Values{"data": {testGet2Data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, ok := parseGet2Data("test", data)
+	if !ok {
+		t.Fatal("fixture was rejected")
+	}
+	if request.Category != 5 || request.Mode != rankingModeFriends || request.RegionMask != rankingRegionAll || request.Order != rankingOrderDescending ||
+		request.Limit != 10 || request.Since != 0 || !slices.Equal(request.Friends, []uint32{1000, 1001}) {
+		t.Errorf("got %+v", request)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short header", data[:0x18]},
+		{"friends past the data", data[:len(data)-4]},
+		{"invalid order", append(append(slices.Clone(data[:0xC]), 2, 0, 0, 0), data[0x10:]...)},
+	}
+
+	for _, test := range tests {
+		if _, ok := parseGet2Data("test", test.data); ok {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
+
+func TestMakeGet2Response(t *testing.T) {
+	now := time.Now()
+	rankings := []database.GameStatsRanking{
+		{Rank: 1, ProfileID: 1000, Score: 12345, Region: 2, UpdateTime: now.Add(-5 * time.Minute), Data: []byte("ABCD")},
+	}
+
+	if response := hex.EncodeToString(makeGet2Response(rankings, now)); response != testGet2Response {
+		t.Errorf("got %s, expected %s", response, testGet2Response)
+	}
+}
+
+func TestMakeEmptyGet2Response(t *testing.T) {
+	// The response sent before rankings were implemented: RNK_GET with no rankings
+	if response := hex.EncodeToString(makeEmptyGet2Response()); response != "0100000000000000" {
+		t.Errorf("got %s, expected 0100000000000000", response)
+	}
+}
+
+func TestLoadRankingConfig(t *testing.T) {
+	defer func() {
+		rankingGames = map[string]bool{}
+	}()
+
+	loadRankingConfig(common.GameStatsConfig{Games: []common.GameStatsGameConfig{
+		{GameName: "enabled", Rankings: true},
+		{GameName: "disabled", HashPolicy: "lenient"},
+	}})
+
+	if !rankingGames["enabled"] || rankingGames["disabled"] || rankingGames["unlisted"] {
+		t.Errorf("got enabled games %v, expected only \"enabled\"", rankingGames)
+	}
+}
+
+func TestIsProfileAuthenticatedFrom(t *testing.T) {
+	sessionsByConnIndex = map[uint64]*GameStatsSession{
+		1: {RemoteAddr: "203.0.113.1:29920", Authenticated: true, User: database.User{ProfileId: 1000}},
+		2: {RemoteAddr: "203.0.113.2:29920", Authenticated: false, User: database.User{ProfileId: 1001}},
+	}
+	defer func() {
+		sessionsByConnIndex = map[uint64]*GameStatsSession{}
+	}()
+
+	tests := []struct {
+		name      string
+		profileId uint32
+		ipAddress string
+		expected  bool
+	}{
+		{"authenticated session", 1000, "203.0.113.1", true},
+		{"another address", 1000, "198.51.100.1", false},
+		{"unauthenticated session", 1001, "203.0.113.2", false},
+		{"no session", 1002, "203.0.113.1", false},
+	}
+
+	for _, test := range tests {
+		if result := isProfileAuthenticatedFrom(test.profileId, test.ipAddress); result != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, result, test.expected)
+		}
+	}
+}
@@ -3,11 +3,10 @@ package gamestats
 import (
 	"strconv"
 	"strings"
-	"time"
 	"wwfc/common"
+	"wwfc/database"
 	"wwfc/logging"
 
-	"github.com/jackc/pgx/v4"
 	"github.com/logrusorgru/aurora/v3"
 )
 
@@ -63,7 +62,13 @@ func (g *GameStatsSession) setpd(command common.GameSpyCommand) {
 		return
 	}
 
-	logging.Info(g.ModuleName, "Set public data: PID:", aurora.Cyan(g.User.ProfileId), "Index:", aurora.Cyan(dindex), "Type:", aurora.Cyan(ptype), "Data:", aurora.Cyan(newData))
+	if !isValidPersistType(ptype) {
+		logging.Error(g.ModuleName, "Invalid ptype:", aurora.Cyan(ptype))
+		g.Write(errMsg)
+		return
+	}
+
+	logging.Info(g.ModuleName, "Set persistent data: PID:", aurora.Cyan(g.User.ProfileId), "Index:", aurora.Cyan(dindex), "Type:", aurora.Cyan(ptype), "Data:", aurora.Cyan(newData))
 
 	// Trim extra null byte at the end
 	if len(newData) > 0 && newData[len(newData)-1] == 0 {
@@ -76,28 +81,25 @@ func (g *GameStatsSession) setpd(command common.GameSpyCommand) {
 		return
 	}
 
-	var modifiedTime time.Time
-	_, _, err := db.GetGameStatsPublicData(g.User.ProfileId, dindex, ptype)
-	if err != nil {
-		if err != pgx.ErrNoRows {
-			logging.Error(g.ModuleName, "GetGameStatsPublicData returned", err)
-			g.Write(errMsg)
-			return
+	// Key value data only updates the keys that are sent
+	var merge func(oldData string) string
+	if command.OtherValues["kv"] == "1" {
+		merge = func(oldData string) string {
+			merged := parseKeyValueData(oldData)
+			merged.merge(parseKeyValueData(newData))
+			return merged.String()
 		}
+	}
 
-		modifiedTime, err = db.CreateGameStatsPublicData(g.User.ProfileId, dindex, ptype, newData)
-		if err != nil {
-			logging.Error(g.ModuleName, "GetGameStatsPublicData returned", err)
-			g.Write(errMsg)
-			return
-		}
-	} else {
-		modifiedTime, err = db.UpdateGameStatsPublicData(g.User.ProfileId, dindex, ptype, newData)
-		if err != nil {
-			logging.Error(g.ModuleName, "UpdateGameStatsPublicData returned", err)
-			g.Write(errMsg)
-			return
-		}
+	modifiedTime, err := db.SetGameStatsPublicData(g.User.ProfileId, dindex, ptype, newData, merge, storageQuota)
+	if err == database.ErrGameStatsQuotaExceeded {
+		logging.Error(g.ModuleName, "Storage quota exceeded:", aurora.Cyan(storageQuota), "bytes")
+		g.Write(errMsg)
+		return
+	} else if err != nil {
+		logging.Error(g.ModuleName, "SetGameStatsPublicData returned", err)
+		g.Write(errMsg)
+		return
 	}
 
 	// TODO: Is mod supposed to be the last modified time or new modified time?
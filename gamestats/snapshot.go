@@ -0,0 +1,71 @@
+package gamestats
+
+import (
+	"strconv"
+	"strings"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+func (g *GameStatsSession) newgame(command common.GameSpyCommand) {
+	// Example (with formatting):
+	// \newgame\
+	//     \connid\0
+	//     \sesskey\123456789
+	// \final\
+
+	g.saveSnapshot(command, "", false)
+}
+
+func (g *GameStatsSession) updgame(command common.GameSpyCommand) {
+	// Example (with formatting):
+	// \updgame\
+	//     \sesskey\123456789
+	//     \connid\0
+	//     \done\1
+	//     \gamedata\<\key\value data with \x01 in place of each backslash>
+	// \final\
+
+	gameData, ok := command.OtherValues["gamedata"]
+	if !ok {
+		logging.Error(g.ModuleName, "Missing gamedata")
+		logging.Error(g.ModuleName, "Full command:", command)
+		return
+	}
+
+	gameData = strings.ReplaceAll(gameData, "\x01", `\`)
+	g.saveSnapshot(command, gameData, command.OtherValues["done"] == "1")
+}
+
+// saveSnapshot stores a game snapshot. Neither command has a reply.
+func (g *GameStatsSession) saveSnapshot(command common.GameSpyCommand, gameData string, done bool) {
+	sessionKey := command.OtherValues["sesskey"]
+	if sessionKey != strconv.FormatInt(int64(g.SessionKey), 10) {
+		logging.Error(g.ModuleName, "Invalid session key:", aurora.Cyan(sessionKey))
+		return
+	}
+
+	connectionId := command.OtherValues["connid"]
+	if connectionId == "" {
+		connectionId = "0"
+	}
+
+	if len(gameData) > storageQuota {
+		logging.Error(g.ModuleName, "Game snapshot too large:", aurora.Cyan(len(gameData)), "bytes")
+		return
+	}
+
+	err := db.SaveGameStatsSnapshot(g.User.ProfileId, g.GameName, g.SessionKey, connectionId, gameData, done, maxSnapshots)
+	if err == database.ErrGameStatsSnapshotLimit {
+		logging.Error(g.ModuleName, "Game snapshot limit reached:", aurora.Cyan(maxSnapshots), "unfinished snapshots")
+		return
+	} else if err != nil {
+		logging.Error(g.ModuleName, "SaveGameStatsSnapshot returned", err)
+		return
+	}
+
+	logging.Info(g.ModuleName, "Saved game snapshot:", aurora.Cyan(connectionId), "Done:", aurora.Cyan(done))
+}
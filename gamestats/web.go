@@ -2,9 +2,7 @@ package gamestats
 
 import (
 	"crypto/sha1"
-	"crypto/sha256"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/hex"
 	"net/http"
 	"net/url"
@@ -52,25 +50,59 @@ func HandleWebRequest(w http.ResponseWriter, r *http.Request) {
 	var response []byte
 
 	hash := query.Get("hash")
-	token := calculateToken(r.URL, r.Host)
+	tokenKey := getWebTokenKey(r.URL, r.Host)
 
 	if hash == "" {
 		// No hash, just return token
+		token, ok := issueWebToken(tokenKey, strings.Split(r.RemoteAddr, ":")[0])
+		if !ok {
+			logging.Warn(moduleName, "Refused a token, too many are outstanding")
+			replyHTTPError(w, http.StatusServiceUnavailable, "503 Service Unavailable")
+			return
+		}
 		response = []byte(token)
 	} else {
 		// Check hash supplied by client
-		hasher := sha1.New()
-		hasher.Write([]byte(game.GameStatsKey))
-		hasher.Write([]byte(token))
-		expectedHash := hex.EncodeToString(hasher.Sum(nil))
-
-		if hash != expectedHash {
+		result := useWebToken(game, tokenKey, hash)
+		switch result {
+		case webTokenHashMismatch:
 			logging.Warn(moduleName, "Invalid hash")
+		case webTokenUnknown:
+			logging.Warn(moduleName, "Expired, reused or unknown token")
+		}
+
+		if result != webTokenValid && getWebHashPolicy(game.Name) != webHashPolicyLenient {
+			webRejected.Add(1)
+			replyHTTPError(w, http.StatusForbidden, "403 Forbidden")
+			return
 		}
 
 		switch subPath {
-		case "/web/client/get2.asp":
-			response = handleGet2(game, query)
+		case "/web/client/put2.asp", "/web/client/get2.asp":
+			if !rankingGames[game.Name] {
+				if subPath == "/web/client/get2.asp" {
+					response = makeEmptyGet2Response()
+				} else {
+					logging.Warn(moduleName, "Rankings are not enabled for", aurora.Cyan(game.Name))
+				}
+				break
+			}
+
+			profileId, ok := getRankingProfileID(query)
+			if !ok {
+				logging.Error(moduleName, "Invalid profile ID:", aurora.Cyan(query.Get("pid")))
+				break
+			}
+
+			if subPath == "/web/client/put2.asp" {
+				if !isProfileAuthenticatedFrom(profileId, strings.Split(r.RemoteAddr, ":")[0]) {
+					logging.Error(moduleName, "Ranking put for profile", aurora.Cyan(profileId), "which is not logged in from this address")
+					break
+				}
+				response = handlePut2(moduleName, game, profileId, query)
+			} else {
+				response = handleGet2(moduleName, game, profileId, query)
+			}
 
 		default:
 			logging.Warn(moduleName, "Unhandled path:", aurora.Cyan(subPath))
@@ -101,27 +133,6 @@ func HandleWebRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func calculateToken(u *url.URL, host string) string {
-	newURL := *u
-	newURL.RawQuery = url.Values{
-		"pid": {u.Query().Get("pid")},
-	}.Encode()
-
-	hasher := sha256.New()
-	hasher.Write([]byte(host + newURL.String()))
-	hasher.Write([]byte(webSalt))
-	return base64.URLEncoding.EncodeToString(hasher.Sum(nil))[:32]
-}
-
-func handleGet2(game *common.GameInfo, query url.Values) []byte {
-	// TODO
-	common.MaybeUnused(game, query)
-
-	data := binary.LittleEndian.AppendUint32([]byte{}, 1) // RNK_GET
-	data = binary.LittleEndian.AppendUint32(data, 0)      // count
-	return data
-}
-
 func replyHTTPError(w http.ResponseWriter, errorCode int, errorString string) {
 	response := "<html>\n" +
 		"<head><title>" + errorString + "</title></head>\n" +
@@ -0,0 +1,288 @@
+package gamestats
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+	"wwfc/common"
+
+	"github.com/linkdata/deadlock"
+)
+
+// Exported for saving in the reload state
+type webToken struct {
+	Token   string
+	Expires time.Time
+	// Address the token was issued to
+	IP string
+}
+
+type webTokenResult int
+
+const (
+	webTokenValid webTokenResult = iota
+	// There are unused tokens for the URL, but the hash doesn't match any of them
+	webTokenHashMismatch
+	// No unused token for the URL, it expired, was already used or was never issued
+	webTokenUnknown
+)
+
+// Unused tokens kept for each URL, a client only needs the latest one
+const maxWebTokensPerURL = 4
+
+// Hash policies
+const (
+	// Requests with an invalid hash or token are rejected
+	webHashPolicyStrict = "strict"
+	// Requests with an invalid hash or token are logged and served anyway, for games with known quirks
+	webHashPolicyLenient = "lenient"
+)
+
+type WebStats struct {
+	TokensIssued      uint64            `json:"tokens_issued"`
+	TokensRefused     uint64            `json:"tokens_refused"`
+	OutstandingTokens int               `json:"outstanding_tokens"`
+	Verified          uint64            `json:"verified"`
+	HashMismatches    uint64            `json:"hash_mismatches"`
+	UnknownTokens     uint64            `json:"unknown_tokens"`
+	Rejected          uint64            `json:"rejected"`
+	FailuresByGame    map[string]uint64 `json:"failures_by_game"`
+}
+
+var (
+	// URL and profile ID -> unused tokens
+	webTokens      = map[string][]webToken{}
+	webTokensSwept time.Time
+	webTokensMutex = deadlock.Mutex{}
+	webTokenExpiry time.Duration
+	// Unused tokens in total and by IP address, kept in step with webTokens
+	webTokenCount     int
+	webTokenCountByIP = map[string]int{}
+	maxWebTokens      int
+	maxWebTokensPerIP int
+
+	defaultWebHashPolicy string
+	webHashPolicies      = map[string]string{}
+
+	webTokensIssued   atomic.Uint64
+	webTokensRefused  atomic.Uint64
+	webVerified       atomic.Uint64
+	webHashMismatches atomic.Uint64
+	webUnknownTokens  atomic.Uint64
+	webRejected       atomic.Uint64
+	webFailuresByGame = map[string]uint64{}
+)
+
+func loadWebConfig(config common.GameStatsConfig) {
+	webTokenExpiry = time.Duration(config.TokenExpirySeconds) * time.Second
+	maxWebTokens = config.MaxTokens
+	maxWebTokensPerIP = config.MaxTokensPerIP
+
+	defaultWebHashPolicy = config.HashPolicy
+	webHashPolicies = map[string]string{}
+	for _, game := range config.Games {
+		webHashPolicies[game.GameName] = game.HashPolicy
+	}
+}
+
+func getWebHashPolicy(gameName string) string {
+	if policy, ok := webHashPolicies[gameName]; ok {
+		return policy
+	}
+	return defaultWebHashPolicy
+}
+
+// getWebTokenKey identifies the URL a token is issued for, ignoring everything in the query but the profile ID
+func getWebTokenKey(u *url.URL, host string) string {
+	newURL := *u
+	newURL.RawQuery = url.Values{
+		"pid": {u.Query().Get("pid")},
+	}.Encode()
+
+	return host + newURL.String()
+}
+
+// issueWebToken creates a token that can be used once to sign a request to the URL before it expires.
+// Returns false if the total or the IP address's limit of unused tokens has been reached.
+func issueWebToken(key string, ip string) (string, bool) {
+	randomBytes := make([]byte, 24)
+	_, err := rand.Read(randomBytes)
+	common.ShouldNotError(err)
+	token := base64.URLEncoding.EncodeToString(randomBytes)[:32]
+
+	now := time.Now()
+
+	webTokensMutex.Lock()
+	defer webTokensMutex.Unlock()
+
+	if now.Sub(webTokensSwept) > time.Minute {
+		for sweepKey, tokens := range webTokens {
+			setWebTokens(sweepKey, removeExpiredWebTokens(tokens, now))
+		}
+		webTokensSwept = now
+	}
+
+	tokens := removeExpiredWebTokens(webTokens[key], now)
+	setWebTokens(key, tokens)
+
+	// A token replacing the oldest one for the URL doesn't add to the counts
+	if len(tokens) < maxWebTokensPerURL || tokens[0].IP != ip {
+		if (maxWebTokens > 0 && webTokenCount >= maxWebTokens) || (maxWebTokensPerIP > 0 && webTokenCountByIP[ip] >= maxWebTokensPerIP) {
+			webTokensRefused.Add(1)
+			return "", false
+		}
+	}
+
+	tokens = append(tokens, webToken{Token: token, Expires: now.Add(webTokenExpiry), IP: ip})
+	if len(tokens) > maxWebTokensPerURL {
+		tokens = tokens[len(tokens)-maxWebTokensPerURL:]
+	}
+	setWebTokens(key, tokens)
+
+	webTokensIssued.Add(1)
+	return token, true
+}
+
+// setWebTokens replaces the unused tokens for the URL and updates the counts. webTokensMutex must be held.
+func setWebTokens(key string, tokens []webToken) {
+	for _, token := range webTokens[key] {
+		webTokenCount--
+		if webTokenCountByIP[token.IP]--; webTokenCountByIP[token.IP] <= 0 {
+			delete(webTokenCountByIP, token.IP)
+		}
+	}
+
+	if len(tokens) == 0 {
+		delete(webTokens, key)
+		return
+	}
+
+	webTokens[key] = tokens
+	for _, token := range tokens {
+		webTokenCount++
+		webTokenCountByIP[token.IP]++
+	}
+}
+
+// useWebToken checks the hash of GameStatsKey + token against the unused tokens for the URL,
+// and uses up the token that matches
+func useWebToken(game *common.GameInfo, key string, hash string) webTokenResult {
+	webTokensMutex.Lock()
+	defer webTokensMutex.Unlock()
+
+	tokens := removeExpiredWebTokens(webTokens[key], time.Now())
+	result := webTokenUnknown
+	if len(tokens) != 0 {
+		result = webTokenHashMismatch
+	}
+
+	for i, token := range tokens {
+		hasher := sha1.New()
+		hasher.Write([]byte(game.GameStatsKey))
+		hasher.Write([]byte(token.Token))
+		if hex.EncodeToString(hasher.Sum(nil)) == hash {
+			tokens = slices.Delete(slices.Clone(tokens), i, i+1)
+			result = webTokenValid
+			break
+		}
+	}
+
+	setWebTokens(key, tokens)
+
+	switch result {
+	case webTokenValid:
+		webVerified.Add(1)
+	case webTokenHashMismatch:
+		webHashMismatches.Add(1)
+		webFailuresByGame[game.Name]++
+	case webTokenUnknown:
+		webUnknownTokens.Add(1)
+		webFailuresByGame[game.Name]++
+	}
+
+	return result
+}
+
+func removeExpiredWebTokens(tokens []webToken, now time.Time) []webToken {
+	var unexpired []webToken
+	for _, token := range tokens {
+		if now.Before(token.Expires) {
+			unexpired = append(unexpired, token)
+		}
+	}
+	return unexpired
+}
+
+// GetWebStats returns the web request verification counters
+func GetWebStats() WebStats {
+	webTokensMutex.Lock()
+	outstanding := webTokenCount
+	failuresByGame := maps.Clone(webFailuresByGame)
+	webTokensMutex.Unlock()
+
+	return WebStats{
+		TokensIssued:      webTokensIssued.Load(),
+		TokensRefused:     webTokensRefused.Load(),
+		OutstandingTokens: outstanding,
+		Verified:          webVerified.Load(),
+		HashMismatches:    webHashMismatches.Load(),
+		UnknownTokens:     webUnknownTokens.Load(),
+		Rejected:          webRejected.Load(),
+		FailuresByGame:    failuresByGame,
+	}
+}
+
+// Save unused tokens to a file, so clients that fetched a token before a reload can still use it
+func saveWebTokens() error {
+	file, err := os.OpenFile("state/gstats_web_tokens.gob", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		common.ShouldNotError(file.Close())
+	}()
+
+	webTokensMutex.Lock()
+	defer webTokensMutex.Unlock()
+
+	encoder := gob.NewEncoder(file)
+	return encoder.Encode(webTokens)
+}
+
+// Load unused tokens from a file, expired tokens are dropped
+func loadWebTokens() error {
+	file, err := os.Open("state/gstats_web_tokens.gob")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		common.ShouldNotError(file.Close())
+	}()
+
+	loaded := map[string][]webToken{}
+	decoder := gob.NewDecoder(file)
+	if err := decoder.Decode(&loaded); err != nil {
+		return err
+	}
+
+	webTokensMutex.Lock()
+	defer webTokensMutex.Unlock()
+
+	webTokens = map[string][]webToken{}
+	webTokenCount = 0
+	webTokenCountByIP = map[string]int{}
+
+	now := time.Now()
+	for key, tokens := range loaded {
+		setWebTokens(key, removeExpiredWebTokens(tokens, now))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package gamestats
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+	"wwfc/common"
+)
+
+func setupTestWebTokens(t *testing.T, maxTokens int, maxTokensPerIP int) {
+	loadWebConfig(common.GameStatsConfig{TokenExpirySeconds: 300, MaxTokens: maxTokens, MaxTokensPerIP: maxTokensPerIP})
+	webTokens = map[string][]webToken{}
+	webTokenCount = 0
+	webTokenCountByIP = map[string]int{}
+
+	t.Cleanup(func() {
+		loadWebConfig(common.GameStatsConfig{})
+		webTokens = map[string][]webToken{}
+		webTokenCount = 0
+		webTokenCountByIP = map[string]int{}
+	})
+}
+
+func makeTestWebTokenHash(game *common.GameInfo, token string) string {
+	hasher := sha1.New()
+	hasher.Write([]byte(game.GameStatsKey))
+	hasher.Write([]byte(token))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+func TestIssueWebTokenLimits(t *testing.T) {
+	setupTestWebTokens(t, 10, 3)
+
+	for i := 0; i < 3; i++ {
+		if _, ok := issueWebToken("url"+strconv.Itoa(i), "203.0.113.1"); !ok {
+			t.Fatalf("token %d was refused", i)
+		}
+	}
+
+	if _, ok := issueWebToken("url3", "203.0.113.1"); ok {
+		t.Error("token past the address limit was issued")
+	}
+
+	// Other addresses have their own limit, up to the total
+	for i := 0; i < 7; i++ {
+		if _, ok := issueWebToken("other"+strconv.Itoa(i), "198.51.100."+strconv.Itoa(i)); !ok {
+			t.Fatalf("token %d for another address was refused", i)
+		}
+	}
+	if _, ok := issueWebToken("url4", "192.0.2.1"); ok {
+		t.Error("token past the total limit was issued")
+	}
+
+	// Using a token frees its place
+	game := &common.GameInfo{Name: "test", GameStatsKey: "key"}
+	token := webTokens["url0"][0].Token
+	if result := useWebToken(game, "url0", makeTestWebTokenHash(game, token)); result != webTokenValid {
+		t.Fatalf("got result %d", result)
+	}
+	if _, ok := issueWebToken("url3", "203.0.113.1"); !ok {
+		t.Error("token was refused after one was used")
+	}
+
+	if webTokenCount != 10 || webTokenCountByIP["203.0.113.1"] != 3 {
+		t.Errorf("counted %d tokens, %d for the address", webTokenCount, webTokenCountByIP["203.0.113.1"])
+	}
+}
+
+func TestIssueWebTokenReplacesOldest(t *testing.T) {
+	setupTestWebTokens(t, 0, maxWebTokensPerURL)
+
+	// A client repeatedly fetching tokens for the same URL isn't locked out
+	for i := 0; i < maxWebTokensPerURL*2; i++ {
+		if _, ok := issueWebToken("url", "203.0.113.1"); !ok {
+			t.Fatalf("token %d was refused", i)
+		}
+	}
+
+	if webTokenCount != maxWebTokensPerURL || len(webTokens["url"]) != maxWebTokensPerURL {
+		t.Errorf("counted %d tokens, %d stored", webTokenCount, len(webTokens["url"]))
+	}
+}
+
+func TestWebTokensReload(t *testing.T) {
+	setupTestWebTokens(t, 0, 0)
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("state", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := issueWebToken("url", "203.0.113.1")
+	webTokens["expired"] = []webToken{{Token: "old", Expires: time.Now().Add(-time.Minute), IP: "198.51.100.1"}}
+	if err := saveWebTokens(); err != nil {
+		t.Fatal(err)
+	}
+
+	webTokens = map[string][]webToken{}
+	if err := loadWebTokens(); err != nil {
+		t.Fatal(err)
+	}
+
+	if webTokenCount != 1 || webTokenCountByIP["203.0.113.1"] != 1 || len(webTokens["expired"]) != 0 {
+		t.Errorf("loaded %d tokens: %+v", webTokenCount, webTokens)
+	}
+
+	game := &common.GameInfo{Name: "test", GameStatsKey: "key"}
+	if result := useWebToken(game, "url", makeTestWebTokenHash(game, token)); result != webTokenValid {
+		t.Errorf("token issued before the reload got result %d", result)
+	}
+}
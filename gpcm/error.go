@@ -3,6 +3,7 @@ package gpcm
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"unicode/utf16"
 	"wwfc/common"
 	"wwfc/logging"
@@ -28,6 +29,23 @@ const (
 type WWFCErrorMessage struct {
 	ErrorCode  int
 	MessageRMC map[byte]string
+	// Set for messages from the config, which use placeholders instead of format verbs
+	Placeholders bool
+}
+
+// format fills in the error code, NGID and reason. Built-in messages use the format verbs %[1]d,
+// %[2]d and %[3]s, messages from the config use {code}, {ngid} and {reason} so that the text is
+// never interpreted as a format string.
+func (m WWFCErrorMessage) format(text string, ngid uint32, reason string) string {
+	if m.Placeholders {
+		return strings.NewReplacer(
+			"{code}", strconv.Itoa(m.ErrorCode),
+			"{ngid}", strconv.FormatUint(uint64(ngid), 10),
+			"{reason}", reason,
+		).Replace(text)
+	}
+
+	return fmt.Sprintf(text, m.ErrorCode, ngid, reason)
 }
 
 type GPError struct {
@@ -375,6 +393,40 @@ var (
 		},
 	}
 
+	WWFCMsgPayloadOutdated = WWFCErrorMessage{
+		ErrorCode: 22010,
+		MessageRMC: map[byte]string{
+			LangEnglish: "" +
+				"Your WiiLink WFC payload is out\n" +
+				"of date. Please update to version\n" +
+				"%[3]s or later to continue.\n" +
+				"\n" +
+				"Error Code: %[1]d",
+		},
+	}
+
+	WWFCMsgPayloadBlocked = WWFCErrorMessage{
+		ErrorCode: 22011,
+		MessageRMC: map[byte]string{
+			LangEnglish: "" +
+				"This version of the WiiLink WFC\n" +
+				"payload is no longer supported.\n" +
+				"Please update to continue.\n" +
+				"\n" +
+				"Error Code: %[1]d",
+		},
+	}
+
+	WWFCMsgPayloadUpdateRecommended = WWFCErrorMessage{
+		ErrorCode: 22012,
+		MessageRMC: map[byte]string{
+			LangEnglish: "" +
+				"A new version of the WiiLink WFC\n" +
+				"payload is available. Please update\n" +
+				"to version %[3]s soon.",
+		},
+	}
+
 	WWFCMsgInvalidELO = WWFCErrorMessage{
 		ErrorCode: 22009,
 		MessageRMC: map[byte]string{
@@ -462,7 +514,7 @@ func (err GPError) GetMessageTranslate(gameName string, region byte, lang byte,
 	}
 
 	if errMsg != "" && wwfcMessage != nil {
-		errMsg = fmt.Sprintf(errMsg, wwfcMessage.ErrorCode, ngid, reason)
+		errMsg = wwfcMessage.format(errMsg, ngid, reason)
 		errMsgUTF16 := utf16.Encode([]rune(errMsg))
 		errMsgByteArray := common.UTF16ToByteArray(errMsgUTF16)
 		command.OtherValues["wl:errmsg"] = common.Base64DwcEncoding.EncodeToString(errMsgByteArray)
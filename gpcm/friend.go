@@ -105,6 +105,7 @@ func (g *GameSpySession) addFriend(command common.GameSpyCommand) {
 	// TODO: Add a limit
 	if !g.isFriendAdded(uint32(newProfileId)) {
 		g.FriendList = append(g.FriendList, uint32(newProfileId))
+		qr2.UpdateFriendList(g.User.ProfileId, g.FriendList)
 	}
 
 	// Check if destination has added the sender
@@ -173,6 +174,7 @@ func (g *GameSpySession) removeFriend(command common.GameSpyCommand) {
 	if g.isFriendAdded(delProfileID32) {
 		delProfileIDIndex := g.getFriendIndex(delProfileID32)
 		removeFromUint32Array(&g.FriendList, delProfileIDIndex)
+		qr2.UpdateFriendList(g.User.ProfileId, g.FriendList)
 	}
 
 	if !g.User.OpenHost {
@@ -301,7 +303,7 @@ func (g *GameSpySession) sendFriendStatus(profileId uint32) {
 		}
 
 		session.recordStatusSent(g.User.ProfileId)
-		sendMessageToSession("100", g.User.ProfileId, session, g.Status)
+		sendMessageToSession("100", g.User.ProfileId, session, g.visibleStatus())
 	}
 }
 
@@ -314,7 +316,7 @@ func (g *GameSpySession) exchangeFriendStatus(profileId uint32) {
 			}
 
 			session.recordStatusSent(g.User.ProfileId)
-			sendMessageToSession("100", g.User.ProfileId, session, g.Status)
+			sendMessageToSession("100", g.User.ProfileId, session, g.visibleStatus())
 		}
 
 		if g.isFriendAdded(profileId) && g.isFriendAuthorized(profileId) {
@@ -324,11 +326,21 @@ func (g *GameSpySession) exchangeFriendStatus(profileId uint32) {
 			}
 
 			g.recordStatusSent(profileId)
-			sendMessageToSessionBuffer("100", profileId, g, session.Status)
+			sendMessageToSessionBuffer("100", profileId, g, session.visibleStatus())
 		}
 	}
 }
 
+// visibleStatus returns the status shown to friends, which appears offline if
+// the player has hidden their presence
+func (g *GameSpySession) visibleStatus() string {
+	if g.User.HidePresence {
+		return logOutMessage
+	}
+
+	return g.Status
+}
+
 func (g *GameSpySession) recordStatusSent(sender uint32) {
 	for _, friend := range g.RecvStatusFromList {
 		if friend == sender {
@@ -25,18 +25,6 @@ const (
 	UnitCodeDSAndWii = 0xff
 )
 
-type MinimumPayloadVersion struct {
-	major byte
-	minor int
-}
-
-var MinimumPayloadVersions = []MinimumPayloadVersion{
-	{
-		major: 0,
-		minor: 1,
-	},
-}
-
 func generateResponse(gpcmChallenge, nasChallenge, authToken, clientChallenge string) string {
 	hasher := md5.New()
 	hasher.Write([]byte(nasChallenge))
@@ -371,7 +359,8 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 	g.ModuleName += "/" + common.CalcFriendCodeString(g.User.ProfileId, g.User.GsbrCode[:4])
 
 	// Notify QR2 of the login
-	qr2.Login(g.User.ProfileId, g.GameCode, g.InGameName, g.ConsoleFriendCode, g.User.GsbrCode[:4], g.RemoteAddr, g.NeedsExploit, g.DeviceAuthenticated, g.User.Restricted)
+	qr2.Login(g.User.ProfileId, g.GameCode, g.InGameName, g.ConsoleFriendCode, g.User.GsbrCode[:4], g.RemoteAddr, g.NeedsExploit, g.DeviceAuthenticated, g.User.Restricted, g.User.OpenHost, g.User.JoinPolicy)
+	g.loadReputation()
 
 	replyUserId := g.User.UserId
 	if g.UnitCode == UnitCodeDS {
@@ -390,9 +379,22 @@ func (g *GameSpySession) login(command common.GameSpyCommand) {
 	}
 
 	if g.GameName == "mariokartwii" {
-		if motd, err := GetMessageOfTheDay(); err != nil {
+		motd, err := GetMessageOfTheDay()
+		if err != nil {
 			logging.Info(g.ModuleName, err)
-		} else {
+		}
+
+		// Show the outdated payload warning in place of or in front of the MOTD
+		if g.PayloadWarning != "" {
+			if err != nil || motd == "" {
+				motd = g.PayloadWarning
+			} else {
+				motd = g.PayloadWarning + "\n\n" + motd
+			}
+			err = nil
+		}
+
+		if err == nil {
 			motdUTF16 := utf16.Encode([]rune(motd))
 			motdByteArray := common.UTF16ToByteArray(motdUTF16)
 			otherValues["wl:motd"] = common.Base64DwcEncoding.EncodeToString(motdByteArray)
@@ -440,31 +442,13 @@ func (g *GameSpySession) exLogin(command common.GameSpyCommand) {
 	qr2.SetDeviceAuthenticated(g.User.ProfileId)
 }
 
-func checkPayloadVersion(payloadVer string) bool {
-	verInt, err := strconv.ParseInt(payloadVer, 0, 32)
-	if err != nil {
-		return false
-	}
-
-	major := byte(verInt>>24) & 255
-	minor := int(verInt>>12) & 4095
-	// beta := verInt & 4095
-
-	for _, v := range MinimumPayloadVersions {
-		if v.major == major && minor >= v.minor {
-			return true
-		}
-	}
-	return false
-}
-
 func (g *GameSpySession) verifyExLoginInfo(command common.GameSpyCommand, authToken string) (defaultKey bool, deviceId uint32) {
 	payloadVer, payloadVerExists := command.OtherValues["wl:ver"]
 	signature, signatureExists := command.OtherValues["wl:sig"]
 	defaultKey = false
 	deviceId = 0
 
-	if !payloadVerExists || !checkPayloadVersion(payloadVer) {
+	if !payloadVerExists {
 		g.replyError(GPError{
 			ErrorCode:   ErrLogin.ErrorCode,
 			ErrorString: "The payload version is invalid.",
@@ -474,6 +458,35 @@ func (g *GameSpySession) verifyExLoginInfo(command common.GameSpyCommand, authTo
 		return
 	}
 
+	version, result, message, requiredVersion := checkPayloadVersion(g.GameName, payloadVer)
+	switch result {
+	case payloadVersionInvalid:
+		g.replyError(GPError{
+			ErrorCode:   ErrLogin.ErrorCode,
+			ErrorString: "The payload version is invalid.",
+			Fatal:       true,
+			WWFCMessage: message,
+		})
+		return
+
+	case payloadVersionOutdated, payloadVersionBlocked:
+		logging.Error(g.ModuleName, "Rejected payload version", aurora.Cyan(version), "required", aurora.Cyan(requiredVersion))
+		g.replyError(GPError{
+			ErrorCode:   ErrLogin.ErrorCode,
+			ErrorString: "The payload version is not allowed.",
+			Fatal:       true,
+			WWFCMessage: message,
+			Reason:      requiredVersion,
+		})
+		return
+
+	case payloadVersionWarning:
+		logging.Warn(g.ModuleName, "Outdated payload version", aurora.Cyan(version), "recommended", aurora.Cyan(requiredVersion))
+		g.PayloadWarning = g.formatPayloadWarning(message, requiredVersion)
+	}
+
+	g.PayloadVersion = version.String()
+
 	if !signatureExists {
 		g.replyError(GPError{
 			ErrorCode:   ErrLogin.ErrorCode,
@@ -597,3 +610,13 @@ func IsLoggedIn(profileID uint32) bool {
 	session, exists := sessions[profileID]
 	return exists && session.LoggedIn
 }
+
+// IsLoggedInFrom returns true if the profile is logged in from the IP address. Services that
+// only receive a profile ID from the client use it to tie the request to the player.
+func IsLoggedInFrom(profileID uint32, ipAddress string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	session, exists := sessions[profileID]
+	return exists && session.LoggedIn && strings.Split(session.RemoteAddr, ":")[0] == ipAddress
+}
@@ -0,0 +1,34 @@
+package gpcm
+
+import (
+	"testing"
+)
+
+func TestIsLoggedInFrom(t *testing.T) {
+	sessions = map[uint32]*GameSpySession{
+		1: {RemoteAddr: "203.0.113.1:29900", LoggedIn: true},
+		2: {RemoteAddr: "203.0.113.2:29900", LoggedIn: false},
+	}
+	defer func() {
+		sessions = map[uint32]*GameSpySession{}
+	}()
+
+	tests := []struct {
+		name      string
+		profileID uint32
+		ipAddress string
+		expected  bool
+	}{
+		{"same address", 1, "203.0.113.1", true},
+		{"another address", 1, "198.51.100.1", false},
+		{"address with port", 1, "203.0.113.1:29900", false},
+		{"not logged in yet", 2, "203.0.113.2", false},
+		{"no session", 3, "203.0.113.1", false},
+	}
+
+	for _, test := range tests {
+		if result := IsLoggedInFrom(test.profileID, test.ipAddress); result != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, result, test.expected)
+		}
+	}
+}
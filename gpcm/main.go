@@ -4,6 +4,7 @@ import (
 	"encoding/gob"
 	"os"
 	"strings"
+	"time"
 	"wwfc/common"
 	"wwfc/database"
 	"wwfc/logging"
@@ -50,7 +51,11 @@ type GameSpySession struct {
 	Reservation    common.MatchCommandData
 	ReservationPID uint32
 
-	NeedsExploit bool
+	NeedsExploit   bool
+	PayloadVersion string
+	PayloadWarning string
+
+	reportTimes map[string][]time.Time
 
 	ReadBuffer  []byte
 	WriteBuffer string
@@ -78,6 +83,13 @@ func StartServer(reload bool) {
 	db.UpdateTables()
 
 	allowDefaultDolphinKeys = config.AllowDefaultDolphinKeys
+	if err := loadPayloadVersionPolicies(config.PayloadVersionPolicies); err != nil {
+		panic(err)
+	}
+	if err := loadReportEscalationRules(config.ReportEscalations); err != nil {
+		panic(err)
+	}
+	loadReputationConfig(config.Reputation)
 
 	if reload {
 		err := loadState()
@@ -97,6 +109,7 @@ func StartServer(reload bool) {
 		"device_authenticated",
 		"reported_bad_packet",
 		"reported_stall",
+		"report_escalated",
 		"gpcm_returned_error",
 	})
 }
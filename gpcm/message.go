@@ -311,14 +311,20 @@ func (g *GameSpySession) mungeMatchReservation(toSession *GameSpySession, msgMat
 		msgMatchData.Reservation.LocalPort = 0
 	}
 
-	if !g.User.Restricted && !toSession.User.Restricted {
+	// Check with QR2 if the room is public or private, and if the destination allows the join
+	resvError := qr2.CheckGPReservationAllowed(g.QR2IP, g.User.ProfileId, uint32(toProfileId), msgMatchData.Reservation.MatchType)
+	if resvError == "ok" || (resvError == "" && !g.User.Restricted && !toSession.User.Restricted) {
 		return true
 	}
 
-	// Check with QR2 if the room is public or private
-	resvError := qr2.CheckGPReservationAllowed(g.QR2IP, g.User.ProfileId, uint32(toProfileId), msgMatchData.Reservation.MatchType)
-	if resvError == "ok" {
-		return true
+	if resvError == "join_denied" {
+		logging.Warn(g.ModuleName, "RESERVATION: Denied by the join policy of", aurora.Cyan(toProfileId))
+		return false
+	}
+
+	if resvError == "tournament_roster" {
+		logging.Warn(g.ModuleName, "RESERVATION: Not on the tournament roster of", aurora.Cyan(toProfileId))
+		return false
 	}
 
 	// Figure out which error to return
@@ -0,0 +1,269 @@
+package gpcm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type PayloadVersion struct {
+	Major byte
+	Minor int
+}
+
+type payloadVersionPolicy struct {
+	minimum        PayloadVersion
+	recommended    *PayloadVersion
+	blocked        []PayloadVersion
+	gracePeriodEnd time.Time
+
+	outdatedMessage WWFCErrorMessage
+	blockedMessage  WWFCErrorMessage
+	warningMessage  WWFCErrorMessage
+}
+
+type payloadVersionResult int
+
+const (
+	payloadVersionOK payloadVersionResult = iota
+	payloadVersionWarning
+	payloadVersionOutdated
+	payloadVersionBlocked
+	payloadVersionInvalid
+)
+
+// Policy key that applies to every game without its own policy
+const payloadVersionPolicyAllGames = "*"
+
+var (
+	defaultPayloadVersionPolicy = payloadVersionPolicy{
+		minimum:         PayloadVersion{Major: 0, Minor: 1},
+		outdatedMessage: WWFCMsgPayloadOutdated,
+		blockedMessage:  WWFCMsgPayloadBlocked,
+		warningMessage:  WWFCMsgPayloadUpdateRecommended,
+	}
+
+	payloadVersionPolicies = map[string]payloadVersionPolicy{}
+
+	errInvalidPayloadVersion = errors.New("invalid payload version")
+	errInvalidPayloadMessage = errors.New("unknown placeholder in message")
+)
+
+func (v PayloadVersion) String() string {
+	return strconv.Itoa(int(v.Major)) + "." + strconv.Itoa(v.Minor)
+}
+
+func (v PayloadVersion) Less(other PayloadVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+// parsePayloadVersion parses the wl:ver value sent by the client
+func parsePayloadVersion(payloadVer string) (PayloadVersion, bool) {
+	verInt, err := strconv.ParseInt(payloadVer, 0, 32)
+	if err != nil {
+		return PayloadVersion{}, false
+	}
+
+	major := byte(verInt>>24) & 255
+	minor := int(verInt>>12) & 4095
+	// beta := verInt & 4095
+
+	return PayloadVersion{Major: major, Minor: minor}, true
+}
+
+// parseConfigPayloadVersion parses a "major.minor" version from the config
+func parseConfigPayloadVersion(version string) (PayloadVersion, error) {
+	majorStr, minorStr, found := strings.Cut(strings.TrimSpace(version), ".")
+	if !found {
+		return PayloadVersion{}, errInvalidPayloadVersion
+	}
+
+	major, err := strconv.ParseUint(majorStr, 10, 8)
+	if err != nil {
+		return PayloadVersion{}, errInvalidPayloadVersion
+	}
+
+	minor, err := strconv.ParseUint(minorStr, 10, 12)
+	if err != nil {
+		return PayloadVersion{}, errInvalidPayloadVersion
+	}
+
+	return PayloadVersion{Major: byte(major), Minor: int(minor)}, nil
+}
+
+// Placeholders that can be used in payload version messages from the config
+var configMessagePlaceholderRegex = regexp.MustCompile(`\{[a-z]+\}`)
+
+func makeConfigMessage(base WWFCErrorMessage, message string) (WWFCErrorMessage, error) {
+	if message == "" {
+		return base, nil
+	}
+
+	// The reason of a payload version error is the required version
+	message = strings.ReplaceAll(strings.TrimSpace(message), "{version}", "{reason}")
+	for _, placeholder := range configMessagePlaceholderRegex.FindAllString(message, -1) {
+		if placeholder != "{code}" && placeholder != "{ngid}" && placeholder != "{reason}" {
+			return WWFCErrorMessage{}, errInvalidPayloadMessage
+		}
+	}
+
+	return WWFCErrorMessage{
+		ErrorCode: base.ErrorCode,
+		MessageRMC: map[byte]string{
+			LangEnglish: message,
+		},
+		Placeholders: true,
+	}, nil
+}
+
+// loadPayloadVersionPolicies replaces the active policies. An invalid policy is an error rather
+// than being skipped, as the game would otherwise silently fall back to a more permissive policy.
+func loadPayloadVersionPolicies(configs []common.PayloadVersionPolicyConfig) error {
+	policies := map[string]payloadVersionPolicy{}
+
+	for _, config := range configs {
+		gameName := config.GameName
+		if gameName == "" {
+			gameName = payloadVersionPolicyAllGames
+		}
+
+		policy, err := parsePayloadVersionPolicy(config)
+		if err != nil {
+			logging.Error("GPCM", "Invalid payload version policy for game", aurora.Cyan(gameName).String()+":", err)
+			return err
+		}
+
+		policies[gameName] = policy
+	}
+
+	payloadVersionPolicies = policies
+	return nil
+}
+
+func parsePayloadVersionPolicy(config common.PayloadVersionPolicyConfig) (payloadVersionPolicy, error) {
+	policy := payloadVersionPolicy{
+		minimum: defaultPayloadVersionPolicy.minimum,
+	}
+
+	var err error
+	if policy.outdatedMessage, err = makeConfigMessage(WWFCMsgPayloadOutdated, config.OutdatedMessage); err != nil {
+		return policy, fmt.Errorf("outdated message: %w", err)
+	}
+	if policy.blockedMessage, err = makeConfigMessage(WWFCMsgPayloadBlocked, config.BlockedMessage); err != nil {
+		return policy, fmt.Errorf("blocked message: %w", err)
+	}
+	if policy.warningMessage, err = makeConfigMessage(WWFCMsgPayloadUpdateRecommended, config.WarningMessage); err != nil {
+		return policy, fmt.Errorf("warning message: %w", err)
+	}
+
+	if config.Minimum != "" {
+		if policy.minimum, err = parseConfigPayloadVersion(config.Minimum); err != nil {
+			return policy, fmt.Errorf("minimum %q: %w", config.Minimum, err)
+		}
+	}
+
+	if config.Recommended != "" {
+		recommended, err := parseConfigPayloadVersion(config.Recommended)
+		if err != nil {
+			return policy, fmt.Errorf("recommended %q: %w", config.Recommended, err)
+		}
+		policy.recommended = &recommended
+	}
+
+	for _, blockedStr := range config.Blocked {
+		blocked, err := parseConfigPayloadVersion(blockedStr)
+		if err != nil {
+			return policy, fmt.Errorf("blocked %q: %w", blockedStr, err)
+		}
+		policy.blocked = append(policy.blocked, blocked)
+	}
+
+	if config.GracePeriodEnd != "" {
+		if policy.gracePeriodEnd, err = time.Parse(time.RFC3339, config.GracePeriodEnd); err != nil {
+			return policy, fmt.Errorf("grace period end %q: %w", config.GracePeriodEnd, err)
+		}
+	}
+
+	return policy, nil
+}
+
+func getPayloadVersionPolicy(gameName string) payloadVersionPolicy {
+	if policy, ok := payloadVersionPolicies[gameName]; ok {
+		return policy
+	}
+
+	if policy, ok := payloadVersionPolicies[payloadVersionPolicyAllGames]; ok {
+		return policy
+	}
+
+	return defaultPayloadVersionPolicy
+}
+
+// checkPayloadVersion checks a client's payload version against the game's policy.
+// The returned message is the error to kick with, or the warning to show.
+func checkPayloadVersion(gameName string, payloadVer string) (PayloadVersion, payloadVersionResult, WWFCErrorMessage, string) {
+	version, ok := parsePayloadVersion(payloadVer)
+	if !ok {
+		return version, payloadVersionInvalid, WWFCMsgPayloadInvalid, ""
+	}
+
+	policy := getPayloadVersionPolicy(gameName)
+
+	if slices.Contains(policy.blocked, version) {
+		return version, payloadVersionBlocked, policy.blockedMessage, policy.minimum.String()
+	}
+
+	if version.Less(policy.minimum) {
+		if time.Now().Before(policy.gracePeriodEnd) {
+			return version, payloadVersionWarning, policy.warningMessage, policy.minimum.String()
+		}
+		return version, payloadVersionOutdated, policy.outdatedMessage, policy.minimum.String()
+	}
+
+	if policy.recommended != nil && version.Less(*policy.recommended) {
+		return version, payloadVersionWarning, policy.warningMessage, policy.recommended.String()
+	}
+
+	return version, payloadVersionOK, WWFCErrorMessage{}, ""
+}
+
+// formatPayloadWarning formats a warning message to be shown to the player on login
+func (g *GameSpySession) formatPayloadWarning(message WWFCErrorMessage, requiredVersion string) string {
+	text := message.MessageRMC[g.Language]
+	if text == "" {
+		text = message.MessageRMC[LangEnglish]
+	}
+
+	return message.format(text, g.DeviceId, requiredVersion)
+}
+
+// GetPayloadVersionCounts returns the number of online players on each payload version, grouped by game
+func GetPayloadVersionCounts() map[string]map[string]int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	counts := map[string]map[string]int{}
+	for _, session := range sessions {
+		if !session.LoggedIn || session.PayloadVersion == "" {
+			continue
+		}
+
+		if counts[session.GameName] == nil {
+			counts[session.GameName] = map[string]int{}
+		}
+		counts[session.GameName][session.PayloadVersion]++
+	}
+
+	return counts
+}
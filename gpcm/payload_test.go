@@ -0,0 +1,58 @@
+package gpcm
+
+import (
+	"testing"
+	"wwfc/common"
+)
+
+func TestConfigMessageFormat(t *testing.T) {
+	tests := []struct {
+		config   string
+		expected string
+	}{
+		{"This version is no longer supported.", "This version is no longer supported."},
+		{"Update to {version}. Error Code: {code}", "Update to 1.2. Error Code: 22011"},
+		{"100% of %s players need {version}", "100% of %s players need 1.2"},
+		{"NG ID {ngid}", "NG ID 5"},
+	}
+
+	for _, test := range tests {
+		message, err := makeConfigMessage(WWFCMsgPayloadBlocked, test.config)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.config, err)
+			continue
+		}
+
+		if result := message.format(message.MessageRMC[LangEnglish], 5, "1.2"); result != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.config, result, test.expected)
+		}
+	}
+
+	if _, err := makeConfigMessage(WWFCMsgPayloadBlocked, "Unknown {placeholder}"); err == nil {
+		t.Error("expected an error for an unknown placeholder")
+	}
+}
+
+func TestBuiltInMessageFormat(t *testing.T) {
+	result := WWFCMsgPayloadBlocked.format(WWFCMsgPayloadBlocked.MessageRMC[LangEnglish], 5, "1.2")
+	expected := "This version of the WiiLink WFC\npayload is no longer supported.\nPlease update to continue.\n\nError Code: 22011"
+	if result != expected {
+		t.Errorf("got %q, expected %q", result, expected)
+	}
+}
+
+func TestLoadPayloadVersionPoliciesInvalid(t *testing.T) {
+	payloadVersionPolicies = map[string]payloadVersionPolicy{}
+
+	err := loadPayloadVersionPolicies([]common.PayloadVersionPolicyConfig{
+		{GameName: "mariokartwii", Minimum: "1.0"},
+		{GameName: "mariokartwii2", Minimum: "one"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for an invalid minimum version")
+	}
+
+	if len(payloadVersionPolicies) != 0 {
+		t.Error("policies were replaced despite the error")
+	}
+}
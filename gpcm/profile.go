@@ -5,6 +5,7 @@ import (
 	"wwfc/common"
 	"wwfc/database"
 	"wwfc/logging"
+	"wwfc/qr2"
 
 	"github.com/logrusorgru/aurora/v3"
 )
@@ -25,8 +26,10 @@ func (g *GameSpySession) getProfile(command common.GameSpyCommand) {
 
 	mutex.Lock()
 	if session, ok := sessions[uint32(profileId)]; ok && session.LoggedIn {
-		locstring = session.LocString
 		user = session.User
+		if !user.HidePresence || user.ProfileId == g.User.ProfileId {
+			locstring = session.LocString
+		}
 		mutex.Unlock()
 	} else {
 		mutex.Unlock()
@@ -92,6 +95,86 @@ func (g *GameSpySession) updateProfile(command common.GameSpyCommand) {
 	}
 
 	db.UpdateProfile(&g.User, command.OtherValues)
+	qr2.UpdatePrivacy(g.User.ProfileId, g.User.OpenHost, g.User.JoinPolicy)
+}
+
+// ApplyProfileEdit applies a moderator's profile edit to an online player
+func ApplyProfileEdit(profileId uint32, edit database.ProfileEdit) {
+	mutex.Lock()
+	session, ok := sessions[profileId]
+	if !ok || !session.LoggedIn {
+		mutex.Unlock()
+		return
+	}
+
+	if edit.FirstName != nil {
+		session.User.FirstName = *edit.FirstName
+	}
+	if edit.LastName != nil {
+		session.User.LastName = *edit.LastName
+	}
+	if edit.LastInGameSn != nil {
+		session.User.LastInGameSn = *edit.LastInGameSn
+	}
+	if edit.NgDeviceId != nil {
+		session.User.NgDeviceId = *edit.NgDeviceId
+	}
+
+	openHostChanged := edit.OpenHost != nil && *edit.OpenHost != session.User.OpenHost
+	mutex.Unlock()
+
+	if !openHostChanged {
+		return
+	}
+
+	if *edit.OpenHost {
+		session.openHostEnabled(true, true)
+	} else {
+		session.openHostDisabled()
+	}
+
+	mutex.Lock()
+	session.User.OpenHost = *edit.OpenHost
+	qr2.UpdatePrivacy(profileId, session.User.OpenHost, session.User.JoinPolicy)
+	mutex.Unlock()
+}
+
+// UpdatePrivacySettings applies new privacy settings to an online player
+func UpdatePrivacySettings(profileId uint32, joinPolicy string, hidePresence bool, hideSearch bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	session, ok := sessions[profileId]
+	if !ok || !session.LoggedIn {
+		return
+	}
+
+	presenceChanged := session.User.HidePresence != hidePresence
+
+	session.User.JoinPolicy = joinPolicy
+	session.User.HidePresence = hidePresence
+	session.User.HideSearch = hideSearch
+	qr2.UpdatePrivacy(profileId, session.User.OpenHost, joinPolicy)
+
+	if presenceChanged && session.StatusSet {
+		for _, storedPid := range session.AuthFriendList {
+			session.sendFriendStatus(storedPid)
+		}
+	}
+}
+
+// IsProfileSearchable returns false if the profile has opted out of appearing in search results
+func IsProfileSearchable(profileId uint32) bool {
+	mutex.Lock()
+	if session, ok := sessions[profileId]; ok && session.LoggedIn {
+		hideSearch := session.User.HideSearch
+		mutex.Unlock()
+		return !hideSearch
+	}
+	mutex.Unlock()
+
+	user, ok := db.GetProfile(profileId)
+	return ok && !user.HideSearch
 }
 
 func VerifyPlayerSearch(profileId uint32, sessionKey int32, gameName string) (string, bool) {
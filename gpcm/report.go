@@ -1,76 +1,376 @@
 package gpcm
 
 import (
+	"errors"
 	"strconv"
+	"time"
 	"wwfc/common"
 	"wwfc/logging"
 	"wwfc/qr2"
 
+	"github.com/linkdata/deadlock"
 	"github.com/logrusorgru/aurora/v3"
 )
 
-func (g *GameSpySession) handleWWFCReport(command common.GameSpyCommand) {
-	for key, value := range command.OtherValues {
-		logging.Info(g.ModuleName, "WiiLink Report:", aurora.Yellow(key))
+type ReportRecord struct {
+	Key   string
+	Value string
+	// Value returned by the handler's validator
+	Parsed any
+	// Profile the report is about, or 0 if it isn't about another player
+	TargetID uint32
+	// Room the reporter and the target are both in, set if TargetID is
+	GroupName string
+}
 
-		keyColored := aurora.BrightCyan(key).String()
+type ReportHandler struct {
+	// Restrict the handler to a single game, or empty for every game
+	GameName string
+	// Maximum number of records a profile can send within reportRateLimitWindow, or 0 for no limit
+	RateLimit int
+	Validate  func(value string) (parsed any, targetId uint32, err error)
+	Handle    func(g *GameSpySession, record ReportRecord)
+}
 
-		switch key {
-		default:
-			logging.Error(g.ModuleName, "Unknown record", aurora.Cyan(key).String()+":", aurora.Cyan(value))
+type reportEscalationRule struct {
+	threshold int
+	window    time.Duration
+	action    string
+}
 
-		case "wl:bad_packet":
-			profileId, err := strconv.ParseUint(value, 10, 32)
-			if err != nil {
-				logging.Error(g.ModuleName, "Error decoding", keyColored+":", err.Error())
-				continue
-			}
+const reportRateLimitWindow = time.Minute
+
+var (
+	// Record name -> game name -> handler
+	reportHandlers = map[string]map[string]ReportHandler{}
 
-			logging.Warn(g.ModuleName, "Report bad packet from", aurora.BrightCyan(strconv.FormatUint(profileId, 10)))
+	// Used if the config has no escalation rules
+	defaultReportEscalationRules = map[string]reportEscalationRule{
+		"wl:bad_packet": {threshold: 3, window: 10 * time.Minute, action: "flag"},
+	}
+	reportEscalationRules = defaultReportEscalationRules
+
+	// Record name -> target profile ID -> reporter profile ID -> last report time
+	reportEscalations      = map[string]map[uint32]map[uint32]time.Time{}
+	reportEscalationsSwept time.Time
+	reportMutex            = deadlock.Mutex{}
+
+	errReportInvalidLength = errors.New("invalid record length")
+	errReportEmpty         = errors.New("empty record")
+)
+
+func init() {
+	RegisterReportHandler("wl:bad_packet", ReportHandler{
+		RateLimit: 10,
+		Validate:  validateReportProfileID,
+		Handle: func(g *GameSpySession, record ReportRecord) {
+			logging.Warn(g.ModuleName, "Report bad packet from", aurora.BrightCyan(strconv.FormatUint(uint64(record.TargetID), 10)))
 			logging.Event("reported_bad_packet", map[string]any{
 				"profile_id": g.User.ProfileId,
-				"sender_id":  profileId,
+				"sender_id":  record.TargetID,
 			})
+			g.reportReputation(record, reputationBadPacketWeight)
+		},
+	})
 
-		case "wl:stall":
-			profileId, err := strconv.ParseUint(value, 10, 32)
-			if err != nil {
-				logging.Error(g.ModuleName, "Error decoding", keyColored+":", err.Error())
-				continue
-			}
-
-			logging.Warn(g.ModuleName, "Room stall caused by", aurora.BrightCyan(strconv.FormatUint(profileId, 10)))
+	RegisterReportHandler("wl:stall", ReportHandler{
+		RateLimit: 10,
+		Validate:  validateReportProfileID,
+		Handle: func(g *GameSpySession, record ReportRecord) {
+			logging.Warn(g.ModuleName, "Room stall caused by", aurora.BrightCyan(strconv.FormatUint(uint64(record.TargetID), 10)))
 			logging.Event("reported_stall", map[string]any{
 				"profile_id":  g.User.ProfileId,
-				"stalling_id": profileId,
+				"stalling_id": record.TargetID,
 			})
+			g.reportReputation(record, reputationStallWeight)
+		},
+	})
 
-		case "wl:mkw_user":
-			if g.GameName != "mariokartwii" {
-				logging.Warn(g.ModuleName, "Ignoring", keyColored+":", "from wrong game")
-				continue
-			}
-
+	RegisterReportHandler("wl:mkw_user", ReportHandler{
+		GameName:  "mariokartwii",
+		RateLimit: 20,
+		Validate: func(value string) (any, uint32, error) {
 			packet, err := common.Base64DwcEncoding.DecodeString(value)
 			if err != nil {
-				logging.Error(g.ModuleName, "Error decoding", keyColored+":", err.Error())
-				continue
+				return nil, 0, err
 			}
 
 			if len(packet) != 0xC0 {
-				logging.Error(g.ModuleName, "Invalid", keyColored, "record length:", len(packet))
-				continue
+				return nil, 0, errReportInvalidLength
+			}
+
+			return packet, 0, nil
+		},
+		Handle: func(g *GameSpySession, record ReportRecord) {
+			qr2.ProcessUSER(g.User.ProfileId, g.QR2IP, record.Parsed.([]byte))
+		},
+	})
+
+	for _, key := range []string{"wl:mkw_select_course", "wl:mkw_select_cc"} {
+		RegisterReportHandler(key, ReportHandler{
+			GameName:  "mariokartwii",
+			RateLimit: 20,
+			Validate: func(value string) (any, uint32, error) {
+				if value == "" {
+					return nil, 0, errReportEmpty
+				}
+				return value, 0, nil
+			},
+			Handle: func(g *GameSpySession, record ReportRecord) {
+				qr2.ProcessMKWSelectRecord(g.User.ProfileId, record.Key, record.Value)
+			},
+		})
+	}
+}
+
+// RegisterReportHandler adds a handler for a wl:report record. A handler scoped to a
+// game takes priority over a handler for every game.
+func RegisterReportHandler(key string, handler ReportHandler) {
+	if reportHandlers[key] == nil {
+		reportHandlers[key] = map[string]ReportHandler{}
+	}
+	reportHandlers[key][handler.GameName] = handler
+}
+
+func getReportHandler(key string, gameName string) (ReportHandler, bool) {
+	handlers, ok := reportHandlers[key]
+	if !ok {
+		return ReportHandler{}, false
+	}
+
+	if handler, ok := handlers[gameName]; ok {
+		return handler, true
+	}
+
+	handler, ok := handlers[""]
+	return handler, ok
+}
+
+func validateReportProfileID(value string) (any, uint32, error) {
+	profileId, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if profileId == 0 {
+		return nil, 0, errors.New("invalid profile ID")
+	}
+
+	return nil, uint32(profileId), nil
+}
+
+// loadReportEscalationRules replaces the escalation rules with the ones from the config, or the
+// defaults if the config has none. A rule with the action "none" turns escalation off for the record.
+func loadReportEscalationRules(configs []common.ReportEscalationConfig) error {
+	if len(configs) == 0 {
+		reportMutex.Lock()
+		reportEscalationRules = defaultReportEscalationRules
+		reportMutex.Unlock()
+		return nil
+	}
+
+	rules := map[string]reportEscalationRule{}
+	for _, config := range configs {
+		if config.Action == "none" {
+			continue
+		}
+
+		if config.Record == "" || config.Threshold <= 0 || config.WindowMinutes <= 0 || (config.Action != "kick" && config.Action != "flag") {
+			logging.Error("GPCM", "Invalid report escalation rule for", aurora.Cyan(config.Record))
+			return errors.New("invalid report escalation rule for " + config.Record)
+		}
+
+		rules[config.Record] = reportEscalationRule{
+			threshold: config.Threshold,
+			window:    time.Duration(config.WindowMinutes) * time.Minute,
+			action:    config.Action,
+		}
+	}
+
+	reportMutex.Lock()
+	reportEscalationRules = rules
+	reportMutex.Unlock()
+	return nil
+}
+
+// checkReportRateLimit returns false if the profile has sent too many records of this type recently
+func (g *GameSpySession) checkReportRateLimit(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if g.reportTimes == nil {
+		g.reportTimes = map[string][]time.Time{}
+	}
+
+	times := g.reportTimes[key][:0]
+	for _, t := range g.reportTimes[key] {
+		if now.Sub(t) < reportRateLimitWindow {
+			times = append(times, t)
+		}
+	}
+
+	if len(times) >= limit {
+		g.reportTimes[key] = times
+		return false
+	}
+
+	g.reportTimes[key] = append(times, now)
+	return true
+}
+
+// escalateReport tracks distinct reporters against the target and applies the
+// record's escalation rule once the threshold is reached
+func (g *GameSpySession) escalateReport(record ReportRecord) {
+	if err := db.RecordReport(record.TargetID, record.Key, g.User.ProfileId); err != nil {
+		logging.Error(g.ModuleName, "Failed to record report:", err)
+	}
+
+	rule, reporterCount, escalate := countReportEscalation(record.Key, record.TargetID, g.User.ProfileId, time.Now())
+	if !escalate {
+		return
+	}
+
+	logging.Warn(g.ModuleName, "Escalating", aurora.Cyan(record.Key), "reports against", aurora.BrightCyan(record.TargetID), "from", aurora.Cyan(reporterCount), "players, action:", aurora.Cyan(rule.action))
+
+	if err := db.RecordReportEscalation(record.TargetID, record.Key, rule.action); err != nil {
+		logging.Error(g.ModuleName, "Failed to record report escalation:", err)
+	}
+
+	logging.Event("report_escalated", map[string]any{
+		"profile_id": record.TargetID,
+		"record":     record.Key,
+		"reporters":  reporterCount,
+		"action":     rule.action,
+	})
+
+	if rule.action == "kick" {
+		KickPlayer(record.TargetID, "reported")
+	}
+}
+
+// countReportEscalation counts the report towards the record's escalation rule. Returns true
+// once enough distinct players have reported the target within the rule's window.
+func countReportEscalation(key string, targetId uint32, reporterId uint32, now time.Time) (reportEscalationRule, int, bool) {
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+
+	if now.Sub(reportEscalationsSwept) > time.Minute {
+		sweepReportEscalations(now)
+		reportEscalationsSwept = now
+	}
+
+	rule, ok := reportEscalationRules[key]
+	if !ok {
+		return rule, 0, false
+	}
+
+	if reportEscalations[key] == nil {
+		reportEscalations[key] = map[uint32]map[uint32]time.Time{}
+	}
+
+	reporters := reportEscalations[key][targetId]
+	if reporters == nil {
+		reporters = map[uint32]time.Time{}
+		reportEscalations[key][targetId] = reporters
+	}
+
+	reporters[reporterId] = now
+	for reporter, t := range reporters {
+		if now.Sub(t) > rule.window {
+			delete(reporters, reporter)
+		}
+	}
+
+	if len(reporters) < rule.threshold {
+		return rule, len(reporters), false
+	}
+
+	delete(reportEscalations[key], targetId)
+	return rule, len(reporters), true
+}
+
+// sweepReportEscalations removes targets that haven't been reported within the rule's window,
+// and records that no longer have a rule. reportMutex must be held.
+func sweepReportEscalations(now time.Time) {
+	for key, targets := range reportEscalations {
+		rule, ok := reportEscalationRules[key]
+		if !ok {
+			delete(reportEscalations, key)
+			continue
+		}
+
+		for targetId, reporters := range targets {
+			latest := time.Time{}
+			for _, t := range reporters {
+				if t.After(latest) {
+					latest = t
+				}
+			}
+
+			if now.Sub(latest) > rule.window {
+				delete(targets, targetId)
 			}
+		}
+	}
+}
+
+func (g *GameSpySession) handleWWFCReport(command common.GameSpyCommand) {
+	for key, value := range command.OtherValues {
+		logging.Info(g.ModuleName, "WiiLink Report:", aurora.Yellow(key))
 
-			qr2.ProcessUSER(g.User.ProfileId, g.QR2IP, packet)
+		keyColored := aurora.BrightCyan(key).String()
 
-		case "wl:mkw_select_course", "wl:mkw_select_cc":
-			if g.GameName != "mariokartwii" {
+		handler, ok := getReportHandler(key, g.GameName)
+		if !ok {
+			if _, exists := reportHandlers[key]; exists {
 				logging.Warn(g.ModuleName, "Ignoring", keyColored, "from wrong game")
 				continue
 			}
 
-			qr2.ProcessMKWSelectRecord(g.User.ProfileId, key, value)
+			logging.Error(g.ModuleName, "Unknown record", aurora.Cyan(key).String()+":", aurora.Cyan(value))
+			continue
+		}
+
+		if !g.checkReportRateLimit(key, handler.RateLimit) {
+			logging.Warn(g.ModuleName, "Rate limited", keyColored)
+			continue
+		}
+
+		parsed, targetId, err := handler.Validate(value)
+		if err != nil {
+			logging.Error(g.ModuleName, "Error decoding", keyColored+":", err.Error())
+			continue
+		}
+
+		if targetId == g.User.ProfileId {
+			logging.Warn(g.ModuleName, "Ignoring", keyColored, "about self")
+			continue
+		}
+
+		// Players can only report others in the same room
+		groupName := ""
+		if targetId != 0 {
+			groupName = qr2.GetGroupName(g.User.ProfileId)
+			if groupName == "" || qr2.GetGroupName(targetId) != groupName {
+				logging.Warn(g.ModuleName, "Ignoring", keyColored, "about", aurora.BrightCyan(targetId), "outside of the room")
+				continue
+			}
+		}
+
+		record := ReportRecord{
+			Key:       key,
+			Value:     value,
+			Parsed:    parsed,
+			TargetID:  targetId,
+			GroupName: groupName,
+		}
+
+		handler.Handle(g, record)
+
+		if targetId != 0 {
+			g.escalateReport(record)
 		}
 	}
 }
@@ -0,0 +1,179 @@
+package gpcm
+
+import (
+	"testing"
+	"time"
+	"wwfc/common"
+)
+
+func TestGetReportHandler(t *testing.T) {
+	saved := reportHandlers
+	reportHandlers = map[string]map[string]ReportHandler{}
+	defer func() {
+		reportHandlers = saved
+	}()
+
+	RegisterReportHandler("test:all", ReportHandler{RateLimit: 1})
+	RegisterReportHandler("test:scoped", ReportHandler{GameName: "mariokartwii", RateLimit: 2})
+	RegisterReportHandler("test:both", ReportHandler{RateLimit: 3})
+	RegisterReportHandler("test:both", ReportHandler{GameName: "mariokartwii", RateLimit: 4})
+
+	tests := []struct {
+		name      string
+		key       string
+		gameName  string
+		found     bool
+		rateLimit int
+	}{
+		{"every game", "test:all", "smashbrawlx", true, 1},
+		{"scoped game", "test:scoped", "mariokartwii", true, 2},
+		{"other game", "test:scoped", "smashbrawlx", false, 0},
+		{"scoped before every game", "test:both", "mariokartwii", true, 4},
+		{"fallback to every game", "test:both", "smashbrawlx", true, 3},
+		{"unknown record", "test:unknown", "mariokartwii", false, 0},
+	}
+
+	for _, test := range tests {
+		handler, found := getReportHandler(test.key, test.gameName)
+		if found != test.found || handler.RateLimit != test.rateLimit {
+			t.Errorf("%s: got %v with rate limit %d, expected %v with %d", test.name, found, handler.RateLimit, test.found, test.rateLimit)
+		}
+	}
+}
+
+func TestCheckReportRateLimit(t *testing.T) {
+	g := &GameSpySession{}
+
+	for i := 0; i < 3; i++ {
+		if !g.checkReportRateLimit("test", 3) {
+			t.Fatalf("record %d was rate limited", i+1)
+		}
+	}
+	if g.checkReportRateLimit("test", 3) {
+		t.Error("record past the limit was allowed")
+	}
+	if !g.checkReportRateLimit("other", 3) {
+		t.Error("another record was rate limited")
+	}
+	if !g.checkReportRateLimit("test", 0) {
+		t.Error("record without a limit was rate limited")
+	}
+
+	// Records older than the window no longer count
+	old := time.Now().Add(-reportRateLimitWindow - time.Second)
+	g.reportTimes["test"] = []time.Time{old, old, time.Now()}
+	if !g.checkReportRateLimit("test", 3) {
+		t.Error("record was rate limited by expired records")
+	}
+	if len(g.reportTimes["test"]) != 2 {
+		t.Errorf("kept %d records, expected 2", len(g.reportTimes["test"]))
+	}
+}
+
+func setupTestReportEscalation(t *testing.T) {
+	reportEscalationRules = map[string]reportEscalationRule{
+		"test": {threshold: 3, window: 10 * time.Minute, action: "flag"},
+	}
+	reportEscalations = map[string]map[uint32]map[uint32]time.Time{}
+	reportEscalationsSwept = time.Time{}
+
+	t.Cleanup(func() {
+		reportEscalationRules = defaultReportEscalationRules
+		reportEscalations = map[string]map[uint32]map[uint32]time.Time{}
+		reportEscalationsSwept = time.Time{}
+	})
+}
+
+func TestCountReportEscalation(t *testing.T) {
+	setupTestReportEscalation(t)
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		key      string
+		reporter uint32
+		time     time.Time
+		count    int
+		escalate bool
+	}{
+		{"first reporter", "test", 1, now, 1, false},
+		{"same reporter again", "test", 1, now.Add(time.Minute), 1, false},
+		{"second reporter", "test", 2, now.Add(2 * time.Minute), 2, false},
+		{"third reporter", "test", 3, now.Add(3 * time.Minute), 3, true},
+		{"count restarts", "test", 4, now.Add(4 * time.Minute), 1, false},
+		{"first report expired", "test", 5, now.Add(15 * time.Minute), 1, false},
+		{"record without a rule", "other", 6, now.Add(16 * time.Minute), 0, false},
+	}
+
+	for _, test := range tests {
+		_, count, escalate := countReportEscalation(test.key, 100, test.reporter, test.time)
+		if count != test.count || escalate != test.escalate {
+			t.Errorf("%s: got %d reporters (escalate %v), expected %d (escalate %v)", test.name, count, escalate, test.count, test.escalate)
+		}
+	}
+}
+
+func TestCountReportEscalationSweep(t *testing.T) {
+	setupTestReportEscalation(t)
+	now := time.Now()
+
+	for target := uint32(100); target < 110; target++ {
+		countReportEscalation("test", target, 1, now)
+	}
+	reportEscalations["removed"] = map[uint32]map[uint32]time.Time{100: {1: now}}
+
+	countReportEscalation("test", 200, 1, now.Add(5*time.Minute))
+	if len(reportEscalations["test"]) != 11 {
+		t.Errorf("kept %d targets within the window, expected 11", len(reportEscalations["test"]))
+	}
+
+	countReportEscalation("test", 200, 2, now.Add(12*time.Minute))
+	if len(reportEscalations["test"]) != 1 {
+		t.Errorf("kept %d targets after the window, expected 1", len(reportEscalations["test"]))
+	}
+	if _, exists := reportEscalations["removed"]; exists {
+		t.Error("targets of a record without a rule were kept")
+	}
+}
+
+func TestLoadReportEscalationRules(t *testing.T) {
+	defer func() {
+		reportEscalationRules = defaultReportEscalationRules
+	}()
+
+	tests := []struct {
+		name     string
+		configs  []common.ReportEscalationConfig
+		expected []string
+		valid    bool
+	}{
+		{"no rules", nil, []string{"wl:bad_packet"}, true},
+		{"replaces defaults", []common.ReportEscalationConfig{{Record: "wl:stall", Threshold: 2, WindowMinutes: 5, Action: "kick"}}, []string{"wl:stall"}, true},
+		{"turned off", []common.ReportEscalationConfig{{Record: "wl:bad_packet", Action: "none"}}, nil, true},
+		{"invalid action", []common.ReportEscalationConfig{{Record: "wl:stall", Threshold: 2, WindowMinutes: 5, Action: "ban"}}, nil, false},
+		{"invalid threshold", []common.ReportEscalationConfig{{Record: "wl:stall", WindowMinutes: 5, Action: "flag"}}, nil, false},
+	}
+
+	for _, test := range tests {
+		reportEscalationRules = defaultReportEscalationRules
+
+		err := loadReportEscalationRules(test.configs)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+
+		if len(reportEscalationRules) != len(test.expected) {
+			t.Errorf("%s: got %d rules, expected %v", test.name, len(reportEscalationRules), test.expected)
+			continue
+		}
+		for _, key := range test.expected {
+			if _, exists := reportEscalationRules[key]; !exists {
+				t.Errorf("%s: missing rule for %s", test.name, key)
+			}
+		}
+	}
+}
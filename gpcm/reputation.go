@@ -0,0 +1,87 @@
+package gpcm
+
+import (
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+	"wwfc/qr2"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+var (
+	reputationHalfLife        time.Duration
+	reputationStallWeight     float64
+	reputationBadPacketWeight float64
+	reputationReportWindow    time.Duration
+)
+
+func loadReputationConfig(config common.ReputationConfig) {
+	reputationHalfLife = time.Duration(config.HalfLifeHours * float64(time.Hour))
+	reputationStallWeight = config.StallWeight
+	reputationBadPacketWeight = config.BadPacketWeight
+	reputationReportWindow = time.Duration(config.ReportWindowHours * float64(time.Hour))
+}
+
+// getReputationScore returns the profile's current score with decay applied
+func getReputationScore(profileId uint32) (float64, time.Time, error) {
+	score, updated, err := db.GetReputationScore(profileId)
+	if err == pgx.ErrNoRows {
+		return 0, time.Now(), nil
+	} else if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return database.DecayReputationScore(score, updated, reputationHalfLife), updated, nil
+}
+
+// loadReputation passes the player's score to QR2 for matchmaking
+func (g *GameSpySession) loadReputation() {
+	score, updated, err := db.GetReputationScore(g.User.ProfileId)
+	if err != nil {
+		if err != pgx.ErrNoRows {
+			logging.Error(g.ModuleName, "Failed to get reputation score:", err)
+		}
+		return
+	}
+
+	qr2.SetReputationScore(g.User.ProfileId, score, updated)
+}
+
+// reportReputation adds a report against another player in the same room to their reputation
+// score. The weight is scaled down by the reporter's own score, so players who are often
+// reported themselves have less influence.
+func (g *GameSpySession) reportReputation(record ReportRecord, weight float64) {
+	if weight <= 0 {
+		return
+	}
+
+	if record.GroupName == "" {
+		logging.Warn(g.ModuleName, "Ignoring", aurora.Cyan(record.Key), "report outside of a room")
+		return
+	}
+
+	reporterScore, _, err := getReputationScore(g.User.ProfileId)
+	if err != nil {
+		logging.Error(g.ModuleName, "Failed to get reputation score:", err)
+		return
+	}
+
+	trust := 1 / (1 + reporterScore)
+
+	score, ok, err := db.AddReputationReport(record.TargetID, g.User.ProfileId, record.Key, record.GroupName, weight*trust, reputationHalfLife, reputationReportWindow)
+	if err != nil {
+		logging.Error(g.ModuleName, "Failed to add reputation report:", err)
+		return
+	}
+
+	if !ok {
+		logging.Info(g.ModuleName, "Ignoring repeated", aurora.Cyan(record.Key), "report against", aurora.BrightCyan(record.TargetID))
+		return
+	}
+
+	logging.Info(g.ModuleName, "Reputation score of", aurora.BrightCyan(record.TargetID), "is now", aurora.Cyan(score))
+	qr2.SetReputationScore(record.TargetID, score, time.Now())
+}
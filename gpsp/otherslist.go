@@ -85,6 +85,12 @@ func handleOthersList(command common.GameSpyCommand) string {
 
 	payload := `\otherslist\`
 	for _, strOtherId := range opidsSplit {
+		otherId, err := strconv.ParseUint(strOtherId, 10, 32)
+		if err != nil || !gpcm.IsProfileSearchable(uint32(otherId)) {
+			logging.Info(moduleName, "Omitting profile", aurora.Cyan(strOtherId), "from otherslist")
+			continue
+		}
+
 		payload += `\o\` + strOtherId
 		payload += `\uniquenick\` + uniqueNick
 	}
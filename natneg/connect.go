@@ -26,6 +26,10 @@ func (session *NATNEGSession) sendConnectRequests(moduleName string) {
 			}
 
 			logging.Notice(moduleName, "Exchange connect requests between", aurora.BrightCyan(id), "and", aurora.BrightCyan(destID))
+			if reason := shouldRelay(sender, destination); reason != "" {
+				session.setupRelay(moduleName, sender, destination, reason)
+			}
+
 			sender.ConnectingIndex = destID
 			sender.ConnectAck = false
 			destination.ConnectingIndex = id
@@ -62,8 +66,13 @@ func (session *NATNEGSession) sendConnectRequests(moduleName string) {
 
 func (client *NATNEGClient) sendConnectRequestPacket(conn net.PacketConn, destination *NATNEGClient, version byte) {
 	connectHeader := createPacketHeader(version, NNConnectRequest, destination.Cookie)
-	connectHeader = append(connectHeader, common.IPFormatBytes(client.ServerIP)...)
-	_, port := common.IPFormatToInt(client.ServerIP)
+	address := client.ServerIP
+	if relayAddress, ok := destination.relayEndpoints[client.Index]; ok {
+		address = relayAddress
+	}
+
+	connectHeader = append(connectHeader, common.IPFormatBytes(address)...)
+	_, port := common.IPFormatToInt(address)
 	connectHeader = binary.BigEndian.AppendUint16(connectHeader, port)
 	// Two bytes: "gotyourdata" and "finished"
 	connectHeader = append(connectHeader, 0x42, 0x00)
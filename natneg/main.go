@@ -76,6 +76,8 @@ type NATNEGClient struct {
 	LocalIP         string
 	ServerIP        string
 	GameName        string
+	// Peer client index -> relay address to connect to instead of the peer
+	relayEndpoints map[byte]string
 }
 
 var (
@@ -100,6 +102,16 @@ func StartServer(reload bool) {
 	natnegConn = conn
 	inShutdown.Store(false)
 
+	secondaryAddress := ""
+	if config.NATNEGSecondaryAddress != nil {
+		secondaryAddress = *config.NATNEGSecondaryAddress
+	}
+	if secondaryAddress == "" {
+		logging.Warn("NATNEG", "No secondary address is configured, symmetric and full cone NATs cannot be detected")
+	}
+	startErtListeners(*config.GameSpyAddress, secondaryAddress)
+	startRelay(config.Relay)
+
 	if reload {
 		// Load state
 		file, err := os.Open("state/natneg_sessions.gob")
@@ -155,6 +167,8 @@ func StartServer(reload bool) {
 func Shutdown() {
 	inShutdown.Store(true)
 	common.ShouldNotError(natnegConn.Close())
+	closeErtListeners()
+	closeRelay()
 	waitGroup.Wait()
 
 	// Save state
@@ -198,7 +212,7 @@ func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
 
 	var session *NATNEGSession
 
-	if command != NNNatifyRequest && command != NNAddressCheckRequest {
+	if command != NNNatifyRequest && command != NNAddressCheckRequest && command != NNErtTestReply {
 		mutex.Lock()
 		var exists bool
 		session, exists = sessions[cookie]
@@ -245,6 +259,7 @@ func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
 
 	case NNErtTestReply:
 		logging.Info(moduleName, "Command:", aurora.Yellow("NN_ERTACK"))
+		handleErtReply(addr, buffer[12:], moduleName)
 
 	case NNStateUpdate:
 		logging.Info(moduleName, "Command:", aurora.Yellow("NN_STATEUPDATE"))
@@ -267,12 +282,14 @@ func handleConnection(conn net.PacketConn, addr net.Addr, buffer []byte) {
 
 	case NNAddressCheckRequest:
 		logging.Info(moduleName, "Command:", aurora.Yellow("NN_ADDRESS_CHECK"))
+		handleAddressCheck(conn, addr, buffer[12:], moduleName, version, cookie)
 
 	case NNAddressCheckReply:
 		logging.Warn(moduleName, "Received server command:", aurora.Yellow("NN_ADDRESS_REPLY"))
 
 	case NNNatifyRequest:
 		logging.Info(moduleName, "Command:", aurora.Yellow("NN_NATIFY_REQUEST"))
+		handleNatifyRequest(addr, buffer[12:], moduleName, version, cookie)
 
 	case NNReportRequest:
 		// logging.Info(moduleName, "Command:", aurora.Yellow("NN_REPORT"))
@@ -344,8 +361,6 @@ func getPortTypeName(portType byte) string {
 	}
 }
 
-var _ = common.MaybeUnused(getPortTypeName)
-
 func (client *NATNEGClient) isMapped() bool {
 	if client.NegotiateIP == "" || client.ServerIP == "" {
 		return false
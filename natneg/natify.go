@@ -0,0 +1,379 @@
+package natneg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"wwfc/common"
+	"wwfc/logging"
+	"wwfc/qr2"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// typedef struct _InitPacket
+// {
+//     unsigned char porttype;
+//     unsigned char clientindex;
+//     unsigned char usegameport;
+//     unsigned int localip;
+//     unsigned short localport;
+// } InitPacket;
+
+const initPacketSize = 9
+
+// Time to wait for the client's address checks and ERT acknowledgements before classifying
+const natifyTestDuration = 5 * time.Second
+
+type natifyTest struct {
+	LocalIP string
+	// Port type -> public port seen by the server
+	MappedPorts map[byte]uint16
+	// Port type -> whether the client acknowledged the unsolicited ERT test
+	ErtAcked map[byte]bool
+}
+
+var (
+	// Public IP and private IP of the console -> running NAT detection test
+	natifyTests = map[string]*natifyTest{}
+	natifyMutex = sync.Mutex{}
+
+	// Port type -> connection the ERT test is sent from
+	ertConns = map[byte]net.PacketConn{}
+)
+
+// startErtListeners opens the extra sockets used to send unsolicited ERT tests.
+// The unsolicited port test uses a second port on the main address, and the
+// unsolicited IP tests use the secondary address, if one is configured.
+func startErtListeners(address string, secondaryAddress string) {
+	ertConns = map[byte]net.PacketConn{}
+
+	addresses := map[byte]string{
+		PortTypeNATNEG1: address + ":27902",
+	}
+	if secondaryAddress != "" {
+		addresses[PortTypeNATNEG2] = secondaryAddress + ":27901"
+		addresses[PortTypeNATNEG3] = secondaryAddress + ":27902"
+	}
+
+	for portType, ertAddress := range addresses {
+		conn, err := net.ListenPacket("udp", ertAddress)
+		if err != nil {
+			logging.Error("NATNEG", "Failed to listen for ERT tests on", aurora.BrightCyan(ertAddress), err)
+			continue
+		}
+
+		ertConns[portType] = conn
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			defer func() {
+				_ = conn.Close()
+			}()
+			logging.Notice("NATNEG", "Listening for ERT tests on", aurora.BrightCyan(ertAddress))
+
+			for {
+				if inShutdown.Load() {
+					return
+				}
+
+				buffer := make([]byte, 1024)
+				size, addr, err := conn.ReadFrom(buffer)
+				if err != nil {
+					if inShutdown.Load() {
+						return
+					}
+					continue
+				}
+
+				waitGroup.Add(1)
+
+				go handleConnection(conn, addr, buffer[:size])
+			}
+		}()
+	}
+}
+
+func closeErtListeners() {
+	for _, conn := range ertConns {
+		common.ShouldNotError(conn.Close())
+	}
+}
+
+// getInitPacketLocalIP returns the private IP the console reported in an init packet
+func getInitPacketLocalIP(buffer []byte) string {
+	return net.IP(buffer[3:7]).String()
+}
+
+// makeNatifyKey identifies the console a test belongs to. The private IP tells apart consoles
+// behind the same NAT; the port is left out because each test uses a different socket.
+func makeNatifyKey(publicIP string, localIP string) string {
+	return publicIP + "/" + localIP
+}
+
+// getNatifyTest returns the test for the console, starting a new one if needed
+func getNatifyTest(addr *net.UDPAddr, localIP string) *natifyTest {
+	publicIP := addr.IP.String()
+	key := makeNatifyKey(publicIP, localIP)
+
+	natifyMutex.Lock()
+	defer natifyMutex.Unlock()
+
+	if test, exists := natifyTests[key]; exists {
+		return test
+	}
+
+	test := &natifyTest{
+		MappedPorts: map[byte]uint16{},
+		ErtAcked:    map[byte]bool{},
+	}
+	natifyTests[key] = test
+
+	time.AfterFunc(natifyTestDuration, func() {
+		natifyMutex.Lock()
+		delete(natifyTests, key)
+		natType, mappingScheme := test.classify(publicIP)
+		natifyMutex.Unlock()
+
+		logging.Notice("NATNEG:"+publicIP, "NAT type of", aurora.Cyan(localIP).String()+":", aurora.Cyan(getNATTypeName(natType)), "mapping:", aurora.Cyan(getNATMappingName(mappingScheme)))
+		qr2.SetNATType(publicIP, localIP, getNATTypeName(natType), getNATMappingName(mappingScheme))
+	})
+
+	return test
+}
+
+func handleAddressCheck(conn net.PacketConn, addr net.Addr, buffer []byte, moduleName string, version byte, cookie uint32) {
+	if len(buffer) < initPacketSize {
+		logging.Error(moduleName, "Invalid packet size")
+		return
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || udpAddr.IP.To4() == nil {
+		logging.Error(moduleName, "Address check from non-IPv4 address")
+		return
+	}
+
+	portType := buffer[0]
+	localIPBytes := buffer[3:7]
+	localPort := binary.BigEndian.Uint16(buffer[7:9])
+
+	// Reply with the public address the request came from
+	reply := createPacketHeader(version, NNAddressCheckReply, cookie)
+	reply = append(reply, buffer[:3]...)
+	reply = append(reply, udpAddr.IP.To4()...)
+	reply = binary.BigEndian.AppendUint16(reply, uint16(udpAddr.Port))
+	if _, err := conn.WriteTo(reply, addr); err != nil {
+		logging.Error(moduleName, "Error writing address reply:", err)
+		return
+	}
+
+	test := getNatifyTest(udpAddr, getInitPacketLocalIP(buffer))
+
+	natifyMutex.Lock()
+	defer natifyMutex.Unlock()
+
+	test.LocalIP = fmt.Sprintf("%d.%d.%d.%d:%d", localIPBytes[0], localIPBytes[1], localIPBytes[2], localIPBytes[3], localPort)
+	test.MappedPorts[portType] = uint16(udpAddr.Port)
+}
+
+func handleNatifyRequest(addr net.Addr, buffer []byte, moduleName string, version byte, cookie uint32) {
+	if len(buffer) < initPacketSize {
+		logging.Error(moduleName, "Invalid packet size")
+		return
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	portType := buffer[0]
+	getNatifyTest(udpAddr, getInitPacketLocalIP(buffer))
+
+	ertConn, ok := ertConns[portType]
+	if !ok {
+		// Unable to run this test, the client will treat it as unreachable
+		logging.Info(moduleName, "No ERT listener for port type", aurora.Cyan(getPortTypeName(portType)))
+		return
+	}
+
+	packet := createPacketHeader(version, NNErtTestRequest, cookie)
+	packet = append(packet, buffer[:initPacketSize]...)
+	if _, err := ertConn.WriteTo(packet, addr); err != nil {
+		logging.Error(moduleName, "Error writing ERT test:", err)
+	}
+}
+
+func handleErtReply(addr net.Addr, buffer []byte, moduleName string) {
+	if len(buffer) < 1 {
+		logging.Error(moduleName, "Invalid packet size")
+		return
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	natifyMutex.Lock()
+	defer natifyMutex.Unlock()
+
+	test := findErtReplyTest(udpAddr.IP.String(), buffer)
+	if test == nil {
+		logging.Warn(moduleName, "ERT acknowledgement without a running test")
+		return
+	}
+
+	test.ErtAcked[buffer[0]] = true
+}
+
+// findErtReplyTest returns the test an ERT acknowledgement belongs to. The acknowledgement
+// echoes the init packet, but if it is cut short the test can still be found when it is the
+// only one running for the public IP. Expects natifyMutex to already be locked.
+func findErtReplyTest(publicIP string, buffer []byte) *natifyTest {
+	if len(buffer) >= initPacketSize {
+		return natifyTests[makeNatifyKey(publicIP, getInitPacketLocalIP(buffer))]
+	}
+
+	var found *natifyTest
+	prefix := makeNatifyKey(publicIP, "")
+	for key, test := range natifyTests {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = test
+	}
+
+	return found
+}
+
+// classify determines the NAT type and mapping scheme from the test results.
+// Expects natifyMutex to already be locked.
+func (test *natifyTest) classify(publicIP string) (byte, byte) {
+	mappingScheme := test.classifyMapping()
+
+	if len(test.MappedPorts) == 0 {
+		return NATTypeUnknown, mappingScheme
+	}
+
+	localHost, _, _ := net.SplitHostPort(test.LocalIP)
+	if localHost == publicIP {
+		if test.ErtAcked[PortTypeNATNEG3] {
+			return NATTypeNoNat, mappingScheme
+		}
+		return NATTypeFirewallOnly, mappingScheme
+	}
+
+	switch {
+	case mappingScheme == NATMappingIncremental || mappingScheme == NATMappingMixed:
+		return NATTypeSymmetric, mappingScheme
+
+	case test.ErtAcked[PortTypeNATNEG2] || test.ErtAcked[PortTypeNATNEG3]:
+		return NATTypeFullCone, mappingScheme
+
+	case test.ErtAcked[PortTypeNATNEG1]:
+		return NATTypeRestrictedCone, mappingScheme
+
+	default:
+		return NATTypePortRestrictedCone, mappingScheme
+	}
+}
+
+func (test *natifyTest) classifyMapping() byte {
+	var ports []uint16
+	for _, portType := range []byte{PortTypeNATNEG1, PortTypeNATNEG2, PortTypeNATNEG3} {
+		if port, ok := test.MappedPorts[portType]; ok {
+			ports = append(ports, port)
+		}
+	}
+
+	if len(ports) < 2 {
+		return NATMappingUnknown
+	}
+
+	_, localPortStr, _ := net.SplitHostPort(test.LocalIP)
+	samePrivatePublic := localPortStr != ""
+	consistent := true
+	incremental := true
+	for i, port := range ports {
+		if localPortStr != fmt.Sprint(port) {
+			samePrivatePublic = false
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		if port != ports[0] {
+			consistent = false
+		}
+
+		if diff := int(port) - int(ports[i-1]); diff <= 0 || diff > 10 {
+			incremental = false
+		}
+	}
+
+	switch {
+	case samePrivatePublic:
+		return NATMappingSamePrivatePublic
+	case consistent:
+		return NATMappingConsistent
+	case incremental:
+		return NATMappingIncremental
+	default:
+		return NATMappingMixed
+	}
+}
+
+func getNATTypeName(natType byte) string {
+	switch natType {
+	default:
+		return "unknown"
+
+	case NATTypeNoNat:
+		return "no_nat"
+
+	case NATTypeFirewallOnly:
+		return "firewall_only"
+
+	case NATTypeFullCone:
+		return "full_cone"
+
+	case NATTypeRestrictedCone:
+		return "restricted_cone"
+
+	case NATTypePortRestrictedCone:
+		return "port_restricted_cone"
+
+	case NATTypeSymmetric:
+		return "symmetric"
+	}
+}
+
+func getNATMappingName(mappingScheme byte) string {
+	switch mappingScheme {
+	default:
+		return "unknown"
+
+	case NATMappingSamePrivatePublic:
+		return "same_private_public"
+
+	case NATMappingConsistent:
+		return "consistent"
+
+	case NATMappingIncremental:
+		return "incremental"
+
+	case NATMappingMixed:
+		return "mixed"
+	}
+}
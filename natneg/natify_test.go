@@ -0,0 +1,75 @@
+package natneg
+
+import (
+	"testing"
+)
+
+func makeTestInitPacket(portType byte, localIP [4]byte) []byte {
+	return []byte{portType, 0, 0, localIP[0], localIP[1], localIP[2], localIP[3], 0xD2, 0x04}
+}
+
+func TestFindErtReplyTest(t *testing.T) {
+	natifyMutex.Lock()
+	defer natifyMutex.Unlock()
+
+	first := &natifyTest{MappedPorts: map[byte]uint16{}, ErtAcked: map[byte]bool{}}
+	second := &natifyTest{MappedPorts: map[byte]uint16{}, ErtAcked: map[byte]bool{}}
+	alone := &natifyTest{MappedPorts: map[byte]uint16{}, ErtAcked: map[byte]bool{}}
+
+	natifyTests = map[string]*natifyTest{
+		makeNatifyKey("203.0.113.1", "192.168.1.10"): first,
+		makeNatifyKey("203.0.113.1", "192.168.1.11"): second,
+		makeNatifyKey("203.0.113.2", "192.168.1.10"): alone,
+	}
+	defer func() {
+		natifyTests = map[string]*natifyTest{}
+	}()
+
+	tests := []struct {
+		name     string
+		publicIP string
+		buffer   []byte
+		expected *natifyTest
+	}{
+		{"first console", "203.0.113.1", makeTestInitPacket(PortTypeNATNEG1, [4]byte{192, 168, 1, 10}), first},
+		{"second console", "203.0.113.1", makeTestInitPacket(PortTypeNATNEG2, [4]byte{192, 168, 1, 11}), second},
+		{"unknown console", "203.0.113.1", makeTestInitPacket(PortTypeNATNEG1, [4]byte{192, 168, 1, 12}), nil},
+		{"short reply with several tests", "203.0.113.1", []byte{PortTypeNATNEG1}, nil},
+		{"short reply with one test", "203.0.113.2", []byte{PortTypeNATNEG1}, alone},
+		{"short reply without a test", "203.0.113.3", []byte{PortTypeNATNEG1}, nil},
+	}
+
+	for _, test := range tests {
+		if found := findErtReplyTest(test.publicIP, test.buffer); found != test.expected {
+			t.Errorf("%s: found the wrong test", test.name)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		localIP  string
+		mapped   map[byte]uint16
+		acked    map[byte]bool
+		natType  byte
+		mappings byte
+	}{
+		{"no checks", "192.168.1.10:1234", map[byte]uint16{}, map[byte]bool{}, NATTypeUnknown, NATMappingUnknown},
+		{"no nat", "203.0.113.1:1234", map[byte]uint16{PortTypeNATNEG1: 1234, PortTypeNATNEG2: 1234}, map[byte]bool{PortTypeNATNEG3: true}, NATTypeNoNat, NATMappingSamePrivatePublic},
+		{"firewall only", "203.0.113.1:1234", map[byte]uint16{PortTypeNATNEG1: 1234}, map[byte]bool{}, NATTypeFirewallOnly, NATMappingUnknown},
+		{"full cone", "192.168.1.10:1234", map[byte]uint16{PortTypeNATNEG1: 5000, PortTypeNATNEG2: 5000}, map[byte]bool{PortTypeNATNEG2: true}, NATTypeFullCone, NATMappingConsistent},
+		{"restricted cone", "192.168.1.10:1234", map[byte]uint16{PortTypeNATNEG1: 5000, PortTypeNATNEG2: 5000}, map[byte]bool{PortTypeNATNEG1: true}, NATTypeRestrictedCone, NATMappingConsistent},
+		{"port restricted cone", "192.168.1.10:1234", map[byte]uint16{PortTypeNATNEG1: 5000, PortTypeNATNEG2: 5000}, map[byte]bool{}, NATTypePortRestrictedCone, NATMappingConsistent},
+		{"symmetric incremental", "192.168.1.10:1234", map[byte]uint16{PortTypeNATNEG1: 5000, PortTypeNATNEG2: 5001, PortTypeNATNEG3: 5002}, map[byte]bool{}, NATTypeSymmetric, NATMappingIncremental},
+		{"symmetric mixed", "192.168.1.10:1234", map[byte]uint16{PortTypeNATNEG1: 5000, PortTypeNATNEG2: 40000}, map[byte]bool{}, NATTypeSymmetric, NATMappingMixed},
+	}
+
+	for _, test := range tests {
+		natify := &natifyTest{LocalIP: test.localIP, MappedPorts: test.mapped, ErtAcked: test.acked}
+		natType, mapping := natify.classify("203.0.113.1")
+		if natType != test.natType || mapping != test.mappings {
+			t.Errorf("%s: got %s/%s, expected %s/%s", test.name, getNATTypeName(natType), getNATMappingName(mapping), getNATTypeName(test.natType), getNATMappingName(test.mappings))
+		}
+	}
+}
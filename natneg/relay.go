@@ -0,0 +1,428 @@
+package natneg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wwfc/common"
+	"wwfc/logging"
+	"wwfc/qr2"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// How long a failed negotiation between two addresses causes them to be relayed
+const relayFailedPairExpiry = 10 * time.Minute
+
+type relayPair struct {
+	key string
+	// Each client talks to its own socket, and receives the other client's packets from it
+	conns       [2]net.PacketConn
+	indexes     [2]byte
+	expectedIPs [2]string
+	peers       [2]*net.UDPAddr
+	mutex       sync.Mutex
+
+	// Token bucket for the bandwidth limit, shared by both directions
+	tokens     float64
+	lastRefill time.Time
+
+	lastActivity     atomic.Int64
+	bytesForwarded   atomic.Uint64
+	packetsForwarded atomic.Uint64
+	packetsDropped   atomic.Uint64
+	closed           atomic.Bool
+}
+
+type RelayStats struct {
+	Enabled          bool   `json:"enabled"`
+	ActivePairs      int    `json:"active_pairs"`
+	TotalPairs       uint64 `json:"total_pairs"`
+	BytesForwarded   uint64 `json:"bytes_forwarded"`
+	PacketsForwarded uint64 `json:"packets_forwarded"`
+	PacketsDropped   uint64 `json:"packets_dropped"`
+}
+
+var (
+	relayConfig common.RelayConfig
+	relayMutex  = sync.Mutex{}
+	// Session cookie and client indexes -> relayed pair
+	relayPairs = map[string]*relayPair{}
+	relayPorts = map[int]bool{}
+	// Pair of server addresses -> time the negotiation between them failed
+	relayFailedPairs = map[string]time.Time{}
+
+	relayTotalPairs       atomic.Uint64
+	relayBytesForwarded   atomic.Uint64
+	relayPacketsForwarded atomic.Uint64
+	relayPacketsDropped   atomic.Uint64
+
+	errRelayNoPorts = errors.New("no relay ports available")
+	errRelayIPLimit = errors.New("relay limit reached for the address")
+)
+
+func startRelay(config common.RelayConfig) {
+	relayConfig = config
+
+	if relayConfig.PublicAddress == "" {
+		relayConfig.PublicAddress = relayConfig.Address
+	}
+
+	if relayConfig.IdleTimeoutSeconds <= 0 {
+		relayConfig.IdleTimeoutSeconds = 60
+	}
+
+	if !relayConfig.Enable {
+		return
+	}
+
+	if net.ParseIP(relayConfig.PublicAddress).To4() == nil {
+		logging.Error("NATNEG", "Relay public address must be an IPv4 address, disabling the relay")
+		relayConfig.Enable = false
+		return
+	}
+
+	logging.Notice("NATNEG", "Relay enabled on", aurora.BrightCyan(relayConfig.Address), "ports", aurora.Cyan(relayConfig.PortMin), "to", aurora.Cyan(relayConfig.PortMax))
+}
+
+func closeRelay() {
+	relayMutex.Lock()
+	pairs := relayPairs
+	relayPairs = map[string]*relayPair{}
+	relayMutex.Unlock()
+
+	for _, pair := range pairs {
+		pair.close()
+	}
+}
+
+func makeRelayFailedPairKey(ip1 string, ip2 string) string {
+	if ip1 > ip2 {
+		ip1, ip2 = ip2, ip1
+	}
+	return ip1 + "/" + ip2
+}
+
+// markRelayNeeded records a failed negotiation so the next attempt between the two addresses is relayed
+func markRelayNeeded(ip1 string, ip2 string) {
+	if !relayConfig.Enable || ip1 == "" || ip2 == "" {
+		return
+	}
+
+	relayMutex.Lock()
+	defer relayMutex.Unlock()
+
+	for key, failed := range relayFailedPairs {
+		if time.Since(failed) > relayFailedPairExpiry {
+			delete(relayFailedPairs, key)
+		}
+	}
+
+	key := makeRelayFailedPairKey(ip1, ip2)
+	if _, exists := relayFailedPairs[key]; !exists {
+		for _, ip := range []string{ip1, ip2} {
+			if host, _, _ := net.SplitHostPort(ip); isRelayIPLimited(countRelayFailedPairs(host)) {
+				logging.Warn("NATNEG", "Too many failed negotiations for", aurora.BrightCyan(host), "not relaying them")
+				return
+			}
+		}
+	}
+
+	relayFailedPairs[key] = time.Now()
+}
+
+func isRelayIPLimited(count int) bool {
+	return relayConfig.MaxPairsPerIP > 0 && count >= relayConfig.MaxPairsPerIP
+}
+
+// countRelayFailedPairs returns the number of failed negotiations recorded for the host.
+// Expects relayMutex to be locked.
+func countRelayFailedPairs(host string) int {
+	count := 0
+	for key := range relayFailedPairs {
+		ip1, ip2, _ := strings.Cut(key, "/")
+		host1, _, _ := net.SplitHostPort(ip1)
+		host2, _, _ := net.SplitHostPort(ip2)
+		if host1 == host || host2 == host {
+			count++
+		}
+	}
+	return count
+}
+
+// countRelayPairs returns the number of open relayed pairs for the host. Expects relayMutex to be locked.
+func countRelayPairs(host string) int {
+	count := 0
+	for _, pair := range relayPairs {
+		if !pair.closed.Load() && (pair.expectedIPs[0] == host || pair.expectedIPs[1] == host) {
+			count++
+		}
+	}
+	return count
+}
+
+// shouldRelay returns the reason to relay between the two clients, or an empty string to connect them directly
+func shouldRelay(sender *NATNEGClient, destination *NATNEGClient) string {
+	if !relayConfig.Enable {
+		return ""
+	}
+
+	relayMutex.Lock()
+	failed, ok := relayFailedPairs[makeRelayFailedPairKey(sender.ServerIP, destination.ServerIP)]
+	relayMutex.Unlock()
+
+	if ok && time.Since(failed) <= relayFailedPairExpiry {
+		return "failed"
+	}
+
+	if relayConfig.RelaySymmetricNAT {
+		for _, client := range []*NATNEGClient{sender, destination} {
+			host, _, err := net.SplitHostPort(client.ServerIP)
+			if err != nil {
+				continue
+			}
+
+			localHost, _, err := net.SplitHostPort(client.LocalIP)
+			if err == nil && qr2.GetNATType(host, localHost) == "symmetric" {
+				return "symmetric"
+			}
+		}
+	}
+
+	return ""
+}
+
+// setupRelay allocates a relayed pair for the two clients and records the endpoint each
+// should connect to instead of the other client's address. Expects the session mutex to be locked.
+func (session *NATNEGSession) setupRelay(moduleName string, sender *NATNEGClient, destination *NATNEGClient, reason string) {
+	key := fmt.Sprintf("%08x/%d/%d", session.Cookie, min(sender.Index, destination.Index), max(sender.Index, destination.Index))
+
+	senderHost, _, _ := net.SplitHostPort(sender.ServerIP)
+	destinationHost, _, _ := net.SplitHostPort(destination.ServerIP)
+
+	pair, err := allocateRelayPair(key, [2]byte{sender.Index, destination.Index}, [2]string{senderHost, destinationHost})
+	if err != nil {
+		logging.Error(moduleName, "Failed to allocate relay:", err)
+		return
+	}
+
+	// The same pair may already be allocated with the sides the other way around
+	senderSide := 0
+	if pair.indexes[1] == sender.Index {
+		senderSide = 1
+	}
+
+	if sender.relayEndpoints == nil {
+		sender.relayEndpoints = map[byte]string{}
+	}
+	if destination.relayEndpoints == nil {
+		destination.relayEndpoints = map[byte]string{}
+	}
+
+	sender.relayEndpoints[destination.Index] = pair.endpoint(senderSide)
+	destination.relayEndpoints[sender.Index] = pair.endpoint(1 - senderSide)
+
+	logging.Notice(moduleName, "Relaying between", aurora.BrightCyan(sender.Index), "and", aurora.BrightCyan(destination.Index), "reason:", aurora.Cyan(reason))
+	logging.Event("natneg_relay_started", map[string]any{
+		"cookie":            session.Cookie,
+		"game_name":         sender.GameName,
+		"sender_ip":         sender.ServerIP,
+		"destination_ip":    destination.ServerIP,
+		"sender_relay":      pair.endpoint(senderSide),
+		"destination_relay": pair.endpoint(1 - senderSide),
+		"reason":            reason,
+	})
+}
+
+func allocateRelayPair(key string, indexes [2]byte, expectedIPs [2]string) (*relayPair, error) {
+	relayMutex.Lock()
+	defer relayMutex.Unlock()
+
+	if pair, ok := relayPairs[key]; ok && !pair.closed.Load() {
+		return pair, nil
+	}
+
+	for _, host := range expectedIPs {
+		if isRelayIPLimited(countRelayPairs(host)) {
+			return nil, errRelayIPLimit
+		}
+	}
+
+	pair := &relayPair{
+		key:         key,
+		indexes:     indexes,
+		expectedIPs: expectedIPs,
+		tokens:      float64(relayConfig.BandwidthLimitKB * 1024),
+		lastRefill:  time.Now(),
+	}
+	pair.lastActivity.Store(time.Now().Unix())
+
+	for side := 0; side < 2; side++ {
+		conn, err := listenRelayPort()
+		if err != nil {
+			if side == 1 {
+				releaseRelayPort(pair.conns[0])
+			}
+			return nil, err
+		}
+		pair.conns[side] = conn
+	}
+
+	relayPairs[key] = pair
+	relayTotalPairs.Add(1)
+
+	for side := 0; side < 2; side++ {
+		go pair.forward(side)
+	}
+
+	return pair, nil
+}
+
+// listenRelayPort opens a socket on a free port in the relay range. Expects relayMutex to be locked.
+func listenRelayPort() (net.PacketConn, error) {
+	for port := relayConfig.PortMin; port <= relayConfig.PortMax; port++ {
+		if relayPorts[port] {
+			continue
+		}
+
+		conn, err := net.ListenPacket("udp", net.JoinHostPort(relayConfig.Address, strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+
+		relayPorts[port] = true
+		return conn, nil
+	}
+
+	return nil, errRelayNoPorts
+}
+
+// releaseRelayPort closes a relay socket and frees its port. Expects relayMutex to be locked.
+func releaseRelayPort(conn net.PacketConn) {
+	if conn == nil {
+		return
+	}
+
+	delete(relayPorts, conn.LocalAddr().(*net.UDPAddr).Port)
+	_ = conn.Close()
+}
+
+func (pair *relayPair) endpoint(side int) string {
+	port := pair.conns[side].LocalAddr().(*net.UDPAddr).Port
+	return net.JoinHostPort(relayConfig.PublicAddress, strconv.Itoa(port))
+}
+
+// allowPacket applies the pair's bandwidth limit
+func (pair *relayPair) allowPacket(size int) bool {
+	if relayConfig.BandwidthLimitKB <= 0 {
+		return true
+	}
+
+	pair.mutex.Lock()
+	defer pair.mutex.Unlock()
+
+	limit := float64(relayConfig.BandwidthLimitKB * 1024)
+	now := time.Now()
+	pair.tokens = min(limit, pair.tokens+now.Sub(pair.lastRefill).Seconds()*limit)
+	pair.lastRefill = now
+
+	if pair.tokens < float64(size) {
+		return false
+	}
+
+	pair.tokens -= float64(size)
+	return true
+}
+
+func (pair *relayPair) forward(side int) {
+	conn := pair.conns[side]
+	other := pair.conns[1-side]
+	idleTimeout := time.Duration(relayConfig.IdleTimeoutSeconds) * time.Second
+	buffer := make([]byte, 2048)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		size, addr, err := conn.ReadFrom(buffer)
+		if pair.closed.Load() {
+			return
+		}
+
+		if err != nil {
+			if time.Since(time.Unix(pair.lastActivity.Load(), 0)) >= idleTimeout {
+				logging.Info("NATNEG:Relay", "Closing idle relay", aurora.Cyan(pair.key))
+				pair.close()
+				return
+			}
+			continue
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || udpAddr.IP.String() != pair.expectedIPs[side] {
+			continue
+		}
+
+		pair.mutex.Lock()
+		pair.peers[side] = udpAddr
+		destination := pair.peers[1-side]
+		pair.mutex.Unlock()
+
+		pair.lastActivity.Store(time.Now().Unix())
+
+		if destination == nil {
+			// The other client hasn't sent anything yet
+			continue
+		}
+
+		if !pair.allowPacket(size) {
+			pair.packetsDropped.Add(1)
+			relayPacketsDropped.Add(1)
+			continue
+		}
+
+		if _, err := other.WriteTo(buffer[:size], destination); err != nil {
+			continue
+		}
+
+		pair.bytesForwarded.Add(uint64(size))
+		pair.packetsForwarded.Add(1)
+		relayBytesForwarded.Add(uint64(size))
+		relayPacketsForwarded.Add(1)
+	}
+}
+
+func (pair *relayPair) close() {
+	if pair.closed.Swap(true) {
+		return
+	}
+
+	relayMutex.Lock()
+	if relayPairs[pair.key] == pair {
+		delete(relayPairs, pair.key)
+	}
+	releaseRelayPort(pair.conns[0])
+	releaseRelayPort(pair.conns[1])
+	relayMutex.Unlock()
+
+	logging.Info("NATNEG:Relay", "Closed relay", aurora.Cyan(pair.key), "forwarded", aurora.Cyan(pair.bytesForwarded.Load()), "bytes, dropped", aurora.Cyan(pair.packetsDropped.Load()), "packets")
+}
+
+// GetRelayStats returns the relay's metrics
+func GetRelayStats() RelayStats {
+	relayMutex.Lock()
+	activePairs := len(relayPairs)
+	relayMutex.Unlock()
+
+	return RelayStats{
+		Enabled:          relayConfig.Enable,
+		ActivePairs:      activePairs,
+		TotalPairs:       relayTotalPairs.Load(),
+		BytesForwarded:   relayBytesForwarded.Load(),
+		PacketsForwarded: relayPacketsForwarded.Load(),
+		PacketsDropped:   relayPacketsDropped.Load(),
+	}
+}
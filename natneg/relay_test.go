@@ -0,0 +1,82 @@
+package natneg
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"wwfc/common"
+)
+
+func setupTestRelay(t *testing.T, maxPairsPerIP int) {
+	relayConfig = common.RelayConfig{Enable: true, MaxPairsPerIP: maxPairsPerIP}
+	relayPairs = map[string]*relayPair{}
+	relayFailedPairs = map[string]time.Time{}
+
+	t.Cleanup(func() {
+		relayConfig = common.RelayConfig{}
+		relayPairs = map[string]*relayPair{}
+		relayFailedPairs = map[string]time.Time{}
+	})
+}
+
+func TestMarkRelayNeededLimit(t *testing.T) {
+	setupTestRelay(t, 3)
+
+	for i := 0; i < 5; i++ {
+		markRelayNeeded("203.0.113.1:1234", fmt.Sprintf("198.51.100.%d:1234", i+1))
+	}
+
+	if count := countRelayFailedPairs("203.0.113.1"); count != 3 {
+		t.Errorf("recorded %d failed negotiations, expected 3", count)
+	}
+
+	// Refreshing a pair that is already recorded is still allowed
+	key := makeRelayFailedPairKey("203.0.113.1:1234", "198.51.100.1:1234")
+	relayFailedPairs[key] = time.Now().Add(-time.Minute)
+	markRelayNeeded("198.51.100.1:1234", "203.0.113.1:1234")
+	if time.Since(relayFailedPairs[key]) >= time.Minute {
+		t.Error("recorded pair was not refreshed")
+	}
+
+	// Other addresses are not affected
+	markRelayNeeded("192.0.2.1:1234", "198.51.100.9:1234")
+	if count := countRelayFailedPairs("192.0.2.1"); count != 1 {
+		t.Errorf("recorded %d failed negotiations for another address, expected 1", count)
+	}
+}
+
+func TestMarkRelayNeededNoLimit(t *testing.T) {
+	setupTestRelay(t, 0)
+
+	for i := 0; i < 20; i++ {
+		markRelayNeeded("203.0.113.1:1234", fmt.Sprintf("198.51.100.%d:1234", i+1))
+	}
+
+	if count := countRelayFailedPairs("203.0.113.1"); count != 20 {
+		t.Errorf("recorded %d failed negotiations, expected 20", count)
+	}
+}
+
+func TestAllocateRelayPairLimit(t *testing.T) {
+	setupTestRelay(t, 2)
+
+	relayPairs["a"] = &relayPair{key: "a", expectedIPs: [2]string{"203.0.113.1", "198.51.100.1"}}
+	relayPairs["b"] = &relayPair{key: "b", expectedIPs: [2]string{"198.51.100.2", "203.0.113.1"}}
+	closed := &relayPair{key: "c", expectedIPs: [2]string{"192.0.2.1", "198.51.100.3"}}
+	closed.closed.Store(true)
+	relayPairs["c"] = closed
+	relayPairs["d"] = &relayPair{key: "d", expectedIPs: [2]string{"192.0.2.1", "198.51.100.4"}}
+
+	if _, err := allocateRelayPair("e", [2]byte{0, 1}, [2]string{"198.51.100.5", "203.0.113.1"}); err != errRelayIPLimit {
+		t.Errorf("allocated a pair past the limit, error: %v", err)
+	}
+
+	// An existing pair is returned even at the limit
+	if pair, err := allocateRelayPair("a", [2]byte{0, 1}, [2]string{"203.0.113.1", "198.51.100.1"}); err != nil || pair != relayPairs["a"] {
+		t.Errorf("existing pair was not returned, error: %v", err)
+	}
+
+	if count := countRelayPairs("192.0.2.1"); count != 1 {
+		t.Errorf("counted %d open pairs, expected 1", count)
+	}
+}
@@ -40,6 +40,9 @@ func (session *NATNEGSession) handleReport(conn net.PacketConn, addr net.Addr, b
 			if otherResult != 1 {
 				result = otherResult
 			}
+			if result != 1 {
+				markRelayNeeded(client.ServerIP, connecting.ServerIP)
+			}
 			qr2.ProcessNATNEGReport(result, client.ServerIP, connecting.ServerIP)
 		}
 	}
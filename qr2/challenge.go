@@ -1,6 +1,7 @@
 package qr2
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net"
 	"strconv"
@@ -12,6 +13,150 @@ import (
 	"github.com/logrusorgru/aurora/v3"
 )
 
+const (
+	// Number of recent failed challenge responses from an address (IP and port) before it is blocked
+	challengeFailureLimit = 5
+	// Number of recent failed challenge responses from all ports of an IP before the IP is blocked.
+	// Many consoles can share an IP behind a household router or carrier-grade NAT, so this is much
+	// higher than the per address limit to keep one bad client from locking out the others.
+	challengeIPFailureLimit = 50
+	// Time for one failure to be forgiven
+	challengeFailureDecay = 2 * time.Minute
+	// Number of tracked addresses at which fully forgiven entries are cleaned up
+	challengeFailureMaxEntries = 4096
+)
+
+type challengeFailure struct {
+	count int
+	// Time from which the next failure is forgiven
+	updated time.Time
+}
+
+// Failed challenge responses by address and by IP. Guarded by the global mutex.
+var (
+	challengeFailures   = map[string]*challengeFailure{}
+	challengeIPFailures = map[string]*challengeFailure{}
+)
+
+// computeChallengeResponse computes the response a game client sends for a challenge,
+// by encrypting it with the game's secret key and encoding it with GameSpy's base64 variant
+func computeChallengeResponse(secretKey string, challenge string) string {
+	key := []byte(secretKey)
+	data := []byte(challenge)
+
+	state := [256]byte{}
+	for i := range state {
+		state[i] = byte(i)
+	}
+
+	var x, y byte
+	for i := 0; i < 256; i++ {
+		y = key[x] + state[i] + y
+		x = byte((int(x) + 1) % len(key))
+		state[i], state[y] = state[y], state[i]
+	}
+
+	x, y = 0, 0
+	for i := range data {
+		x = x + data[i] + 1
+		y = state[x] + y
+		state[x], state[y] = state[y], state[x]
+		data[i] ^= state[state[x]+state[y]]
+	}
+
+	// GameSpy's encoding pads the last group with zero bytes instead of using '='
+	for len(data)%3 != 0 {
+		data = append(data, 0)
+	}
+
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// verifyChallenge checks a challenge response against the session's game. Expects the global mutex to already be locked.
+func verifyChallenge(moduleName string, session *Session, response string) bool {
+	gameName := session.Data["gamename"]
+	gameInfo := common.GetGameInfoByName(gameName)
+	if gameInfo == nil {
+		logging.Error(moduleName, "Challenge response for unknown game", aurora.Cyan(gameName))
+		return false
+	}
+
+	if gameInfo.SecretKey == "" {
+		// Nothing to verify against
+		logging.Warn(moduleName, "No secret key for game", aurora.Cyan(gameName), "- accepting challenge response")
+		return true
+	}
+
+	return response == computeChallengeResponse(gameInfo.SecretKey, session.Challenge)
+}
+
+// decay removes the failures that have been forgiven by now
+func (f *challengeFailure) decay(now time.Time) {
+	forgiven := int(now.Sub(f.updated) / challengeFailureDecay)
+	if forgiven <= 0 {
+		return
+	}
+
+	f.count = max(0, f.count-forgiven)
+	f.updated = f.updated.Add(time.Duration(forgiven) * challengeFailureDecay)
+}
+
+func addFailure(failures map[string]*challengeFailure, key string, now time.Time) int {
+	if len(failures) >= challengeFailureMaxEntries {
+		for otherKey, failure := range failures {
+			if failure.decay(now); failure.count == 0 {
+				delete(failures, otherKey)
+			}
+		}
+	}
+
+	failure := failures[key]
+	if failure == nil {
+		failure = &challengeFailure{}
+		failures[key] = failure
+	}
+
+	failure.decay(now)
+	if failure.count == 0 {
+		failure.updated = now
+	}
+	failure.count++
+	return failure.count
+}
+
+func isBlocked(failures map[string]*challengeFailure, key string, limit int, now time.Time) bool {
+	failure := failures[key]
+	if failure == nil {
+		return false
+	}
+
+	if failure.decay(now); failure.count == 0 {
+		delete(failures, key)
+		return false
+	}
+
+	return failure.count >= limit
+}
+
+// addChallengeFailure records a failed challenge response. Returns the number of recent failures
+// from the address, and whether the address or its IP is now blocked. Expects the global mutex to
+// already be locked.
+func addChallengeFailure(addr net.UDPAddr) (int, bool) {
+	now := time.Now()
+	count := addFailure(challengeFailures, addr.String(), now)
+	ipCount := addFailure(challengeIPFailures, addr.IP.String(), now)
+
+	return count, count >= challengeFailureLimit || ipCount >= challengeIPFailureLimit
+}
+
+// isChallengeBlocked returns true if the address or its IP has failed too many challenges
+// recently. Expects the global mutex to already be locked.
+func isChallengeBlocked(addr net.UDPAddr) bool {
+	now := time.Now()
+	return isBlocked(challengeFailures, addr.String(), challengeFailureLimit, now) ||
+		isBlocked(challengeIPFailures, addr.IP.String(), challengeIPFailureLimit, now)
+}
+
 func sendChallenge(conn net.PacketConn, addr net.UDPAddr, session Session, lookupAddr uint64) {
 	challenge := session.Challenge
 	if challenge == "" {
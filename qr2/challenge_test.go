@@ -0,0 +1,76 @@
+package qr2
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Fixed vectors to catch changes to the response computation. They were computed with a separate
+// implementation of the same algorithm, not taken from captured traffic.
+func TestComputeChallengeResponse(t *testing.T) {
+	tests := []struct {
+		secretKey string
+		challenge string
+		expected  string
+	}{
+		// Mario Kart Wii, challenge format from sendChallenge: random, "00", IP and port in hex
+		{"9r3Rmy", "Lq3aBC00C0A8000A1234", "GpmoLnfqsLpeCWXz8EIvRPGTkAcA"},
+		{"mbx0pd", "abcdef007F0000010BB8", "ib6JePqpOZmgc9SlLnzNmqWDhLwA"},
+		// The last group is padded with zero bytes rather than '='
+		{"gamespy", "x", "2AAA"},
+	}
+
+	for _, test := range tests {
+		if result := computeChallengeResponse(test.secretKey, test.challenge); result != test.expected {
+			t.Errorf("%s/%s: got %q, expected %q", test.secretKey, test.challenge, result, test.expected)
+		}
+	}
+}
+
+func TestChallengeFailures(t *testing.T) {
+	challengeFailures = map[string]*challengeFailure{}
+	challengeIPFailures = map[string]*challengeFailure{}
+
+	bad := net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 50000}
+	neighbour := net.UDPAddr{IP: net.IPv4(203, 0, 113, 1), Port: 50001}
+
+	for i := 1; i < challengeFailureLimit; i++ {
+		if _, blocked := addChallengeFailure(bad); blocked {
+			t.Fatalf("blocked after %d failures", i)
+		}
+	}
+	if _, blocked := addChallengeFailure(bad); !blocked {
+		t.Fatal("not blocked after reaching the limit")
+	}
+
+	if !isChallengeBlocked(bad) {
+		t.Error("address is not blocked")
+	}
+	if isChallengeBlocked(neighbour) {
+		t.Error("another console behind the same IP is blocked")
+	}
+
+	// Failures are forgiven over time
+	challengeFailures[bad.String()].updated = time.Now().Add(-challengeFailureDecay)
+	if isChallengeBlocked(bad) {
+		t.Error("address is still blocked after a failure was forgiven")
+	}
+}
+
+func TestChallengeIPFailures(t *testing.T) {
+	challengeFailures = map[string]*challengeFailure{}
+	challengeIPFailures = map[string]*challengeFailure{}
+
+	// A client cycling through ports still has the whole IP blocked eventually
+	for port := 0; port < challengeIPFailureLimit; port++ {
+		addChallengeFailure(net.UDPAddr{IP: net.IPv4(203, 0, 113, 2), Port: 40000 + port})
+	}
+
+	if !isChallengeBlocked(net.UDPAddr{IP: net.IPv4(203, 0, 113, 2), Port: 60000}) {
+		t.Error("IP is not blocked after reaching the IP limit")
+	}
+	if isChallengeBlocked(net.UDPAddr{IP: net.IPv4(203, 0, 113, 3), Port: 60000}) {
+		t.Error("unrelated IP is blocked")
+	}
+}
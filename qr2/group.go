@@ -6,10 +6,12 @@ import (
 	"encoding/gob"
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 	"wwfc/common"
+	"wwfc/database"
 	"wwfc/logging"
 
 	"github.com/logrusorgru/aurora/v3"
@@ -29,6 +31,12 @@ type Group struct {
 	MKWRaceNumber    int
 	MKWCourseID      int
 	MKWEngineClassID int
+
+	TournamentID int
+
+	// Kept for the room history once the group is deleted
+	Timeline []database.RoomTimelineEntry
+	MKWRaces []database.RoomRace
 }
 
 var groups = map[string]*Group{}
@@ -53,14 +61,21 @@ func processResvOK(moduleName string, matchVersion int, reservation common.Match
 			players:       map[*Session]bool{sender: true},
 		}
 
-		for {
+		// A tournament room is named after the tournament's code if it's free
+		if tournament := getHostedTournament(getSessionProfileID(sender)); tournament != nil {
+			group.TournamentID = tournament.ID
+			if groups[tournament.Code] == nil {
+				group.GroupName = tournament.Code
+			}
+		}
+
+		for group.GroupName == "" {
 			groupName := common.RandomString(6)
 			if groups[groupName] != nil {
 				continue
 			}
 
 			group.GroupName = groupName
-			break
 		}
 
 		if group.GameName == "mariokartwii" {
@@ -95,9 +110,15 @@ func processResvOK(moduleName string, matchVersion int, reservation common.Match
 		if group.GameName == "mariokartwii" {
 			eventData["mario_kart_wii_region"] = group.MKWRegion
 		}
+		if group.TournamentID != 0 {
+			eventData["tournament_id"] = group.TournamentID
+		}
 		logging.Event("group_created", eventData)
+		group.addTimelineEntry("created", getSessionProfileID(sender), 0)
 	}
 
+	defer group.notifyWatchers()
+
 	// Keep group ID updated
 	group.GroupID = resvOK.GroupID
 
@@ -120,6 +141,7 @@ func processResvOK(moduleName string, matchVersion int, reservation common.Match
 			"profile_id":   destination.Data["dwc_pid"],
 		},
 	)
+	group.addTimelineEntry("joined", getSessionProfileID(destination), 0)
 
 	group.LastJoinIndex++
 	destination.Data["+joinindex"] = strconv.Itoa(group.LastJoinIndex)
@@ -271,31 +293,99 @@ func checkReservationAllowed(moduleName string, sender, destination *Session, jo
 		return ""
 	}
 
+	if joinType == 2 || joinType == 3 {
+		if resvError := checkJoinPolicy(sender, destination); resvError != "ok" {
+			return resvError
+		}
+	}
+
+	if resvError := checkTournamentRoster(sender, destination); resvError != "ok" {
+		return resvError
+	}
+
+	public := isPublicReservation(destination, joinType)
+
+	if public && (hasPoorReputation(sender.login) || hasPoorReputation(destination.login)) {
+		return "reputation"
+	}
+
+	if public && !checkRatingSpread(sender, destination) {
+		return "rating_spread"
+	}
+
 	if !sender.login.Restricted && !destination.login.Restricted {
 		return "ok"
 	}
 
-	if joinType != 2 && joinType != 3 {
+	if public {
 		return "restricted_join"
 	}
 
+	return "ok"
+}
+
+func isPublicReservation(destination *Session, joinType byte) bool {
+	if joinType != 2 && joinType != 3 {
+		return true
+	}
+
 	// TODO: Once OpenHost is implemented, disallow joining public rooms
 
 	if destination.groupPointer == nil {
 		// Destination is not in a group, check their dwc_mtype instead
-		if destination.Data["dwc_mtype"] != "2" && destination.Data["dwc_mtype"] != "3" {
-			return "restricted_join"
-		}
+		return destination.Data["dwc_mtype"] != "2" && destination.Data["dwc_mtype"] != "3"
+	}
+
+	return destination.groupPointer.MatchType != "private"
+}
+
+func hasPoorReputation(login *LoginInfo) bool {
+	if reputationThreshold <= 0 {
+		return false
+	}
 
-		// This is fine
+	return database.DecayReputationScore(login.ReputationScore, login.ReputationUpdated, reputationHalfLife) >= reputationThreshold
+}
+
+func isMutualFriend(a, b *LoginInfo) bool {
+	return slices.Contains(a.FriendList, b.ProfileID) && slices.Contains(b.FriendList, a.ProfileID)
+}
+
+// checkJoinPolicy checks the destination's privacy settings for a friend join.
+// Joining through a mutual friend already in the destination's room is treated
+// the same as joining the friend directly.
+func checkJoinPolicy(sender, destination *Session) string {
+	if sender.login == nil || destination.login == nil {
+		return ""
+	}
+
+	if destination.groupPointer != nil && destination.groupPointer == sender.groupPointer {
+		// Already in the same room
 		return "ok"
 	}
 
-	if destination.groupPointer.MatchType != "private" {
-		return "restricted_join"
+	policy := destination.login.JoinPolicy
+	if policy == database.JoinPolicyNobody {
+		return "join_denied"
 	}
 
-	return "ok"
+	if policy != database.JoinPolicyFriends && destination.login.OpenHost {
+		return "ok"
+	}
+
+	if isMutualFriend(sender.login, destination.login) {
+		return "ok"
+	}
+
+	if destination.groupPointer != nil {
+		for player := range destination.groupPointer.players {
+			if player.login != nil && player.login.JoinPolicy != database.JoinPolicyNobody && isMutualFriend(sender.login, player.login) {
+				return "ok"
+			}
+		}
+	}
+
+	return "join_denied"
 }
 
 func CheckGPReservationAllowed(senderIP uint64, senderPid uint32, destPid uint32, joinType byte) string {
@@ -367,6 +457,7 @@ func ProcessNATNEGReport(result byte, ip1 string, ip2 string) {
 
 	session1.Data["+conn_"+session2.Data["+joinindex"]] = resultString
 	session2.Data["+conn_"+session1.Data["+joinindex"]] = resultString
+	session1.groupPointer.notifyWatchers()
 
 	if result != 1 {
 		// Increment +conn_fail
@@ -376,6 +467,7 @@ func ProcessNATNEGReport(result byte, ip1 string, ip2 string) {
 		connFail2++
 		session1.Data["+conn_fail"] = strconv.Itoa(connFail1)
 		session2.Data["+conn_fail"] = strconv.Itoa(connFail2)
+		session1.groupPointer.addTimelineEntry("natneg_failed", getSessionProfileID(session1), getSessionProfileID(session2))
 		logging.Event(
 			"natneg_failed",
 			map[string]any{
@@ -396,6 +488,7 @@ func ProcessNATNEGReport(result byte, ip1 string, ip2 string) {
 			},
 		)
 	} else {
+		session1.groupPointer.addTimelineEntry("natneg_succeeded", getSessionProfileID(session1), getSessionProfileID(session2))
 		logging.Event(
 			"natneg_succeeded",
 			map[string]any{
@@ -485,6 +578,10 @@ func ProcessUSER(senderPid uint32, senderIP uint64, packet []byte) {
 		session.Data["+mii"+strconv.Itoa(i)] = miiData[i]
 		session.Data["+mii_name"+strconv.Itoa(i)] = name
 	}
+
+	if session.groupPointer != nil {
+		session.groupPointer.notifyWatchers()
+	}
 }
 
 // findNewServer attempts to find the new server/host in the group when the current server goes down.
@@ -520,8 +617,13 @@ func (g *Group) findNewServer() {
 		eventData["old_host_id"] = g.server.Data["dwc_pid"]
 	}
 
+	if server != g.server {
+		g.addTimelineEntry("host_changed", getSessionProfileID(server), getSessionProfileID(g.server))
+	}
+
 	g.server = server
 	g.updateMatchType()
+	g.notifyWatchers()
 
 	logging.Event("group_host_changed", eventData)
 }
@@ -573,11 +675,17 @@ func ProcessMKWSelectRecord(profileId uint32, key string, value string) {
 		logging.Info(moduleName, "Selected course", aurora.BrightCyan(strconv.FormatUint(courseId, 10)))
 
 		mutex.Lock()
-		defer mutex.Unlock()
-
 		group.MKWRaceNumber++
 		group.MKWCourseID = int(courseId)
 		group.MKWEngineClassID = -1
+		group.addMKWRace()
+		allowed := group.checkTournamentRules(moduleName, profileId)
+		group.notifyWatchers()
+		mutex.Unlock()
+
+		if !allowed {
+			gpErrorCallback(profileId, "tournament_rules")
+		}
 		return
 
 	case "wl:mkw_select_cc":
@@ -590,9 +698,15 @@ func ProcessMKWSelectRecord(profileId uint32, key string, value string) {
 		logging.Info(moduleName, "Selected CC", aurora.BrightCyan(strconv.FormatUint(ccId, 10)))
 
 		mutex.Lock()
-		defer mutex.Unlock()
-
 		group.MKWEngineClassID = int(ccId)
+		group.setMKWRaceEngineClass()
+		allowed := group.checkTournamentRules(moduleName, profileId)
+		group.notifyWatchers()
+		mutex.Unlock()
+
+		if !allowed {
+			gpErrorCallback(profileId, "tournament_rules")
+		}
 		return
 	}
 
@@ -19,6 +19,8 @@ type PlayerInfo struct {
 	ConnMap    string `json:"conn_map"`
 	ConnFail   string `json:"conn_fail"`
 	Suspend    string `json:"suspend"`
+	NATType    string `json:"nat_type,omitempty"`
+	NATMapping string `json:"nat_mapping,omitempty"`
 
 	// Mario Kart Wii-specific fields
 	FriendCode string    `json:"fc,omitempty"`
@@ -161,6 +163,8 @@ func GetGroups(gameNames []string, groupNames []string, sorted bool) []GroupInfo
 				Count:      rawPlayer["+localplayers"],
 				ProfileID:  rawPlayer["dwc_pid"],
 				InGameName: rawPlayer["+ingamesn"],
+				NATType:    rawPlayer["+nattype"],
+				NATMapping: rawPlayer["+natmapping"],
 			}
 
 			pid, err := strconv.ParseUint(rawPlayer["dwc_pid"], 10, 32)
@@ -0,0 +1,87 @@
+package qr2
+
+import (
+	"testing"
+	"wwfc/database"
+)
+
+func newTestSession(profileId uint32, joinPolicy string, openHost bool, friends ...uint32) *Session {
+	session := &Session{
+		Data: map[string]string{},
+		login: &LoginInfo{
+			ProfileID:  profileId,
+			JoinPolicy: joinPolicy,
+			OpenHost:   openHost,
+			FriendList: friends,
+		},
+	}
+	session.login.session = session
+	return session
+}
+
+func TestCheckJoinPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		openHost    bool
+		mutual      bool
+		expectation string
+	}{
+		{"nobody, friend", database.JoinPolicyNobody, true, true, "join_denied"},
+		{"friends, friend", database.JoinPolicyFriends, false, true, "ok"},
+		{"friends, stranger", database.JoinPolicyFriends, false, false, "join_denied"},
+		{"friends ignores open host", database.JoinPolicyFriends, true, false, "join_denied"},
+		{"open host, stranger", database.JoinPolicyOpenHost, true, false, "ok"},
+		{"open host disabled, stranger", database.JoinPolicyOpenHost, false, false, "join_denied"},
+		{"open host disabled, friend", database.JoinPolicyOpenHost, false, true, "ok"},
+	}
+
+	for _, test := range tests {
+		sender := newTestSession(1, database.JoinPolicyOpenHost, false)
+		destination := newTestSession(2, test.policy, test.openHost)
+		if test.mutual {
+			sender.login.FriendList = []uint32{2}
+			destination.login.FriendList = []uint32{1}
+		}
+
+		if result := checkJoinPolicy(sender, destination); result != test.expectation {
+			t.Errorf("%s: got %q, expected %q", test.name, result, test.expectation)
+		}
+	}
+}
+
+func TestCheckJoinPolicyThroughFriend(t *testing.T) {
+	sender := newTestSession(1, database.JoinPolicyOpenHost, false, 3)
+	destination := newTestSession(2, database.JoinPolicyFriends, false)
+	friend := newTestSession(3, database.JoinPolicyFriends, false, 1)
+
+	group := &Group{players: map[*Session]bool{destination: true, friend: true}}
+	destination.groupPointer = group
+	friend.groupPointer = group
+
+	if result := checkJoinPolicy(sender, destination); result != "ok" {
+		t.Errorf("join through mutual friend: got %q, expected %q", result, "ok")
+	}
+
+	friend.login.JoinPolicy = database.JoinPolicyNobody
+	if result := checkJoinPolicy(sender, destination); result != "join_denied" {
+		t.Errorf("join through friend with nobody policy: got %q, expected %q", result, "join_denied")
+	}
+}
+
+func TestCheckReservationAllowedJoinType(t *testing.T) {
+	sender := newTestSession(1, database.JoinPolicyOpenHost, false)
+	destination := newTestSession(2, database.JoinPolicyNobody, false)
+
+	// The join policy only applies to friend joins
+	for _, joinType := range []byte{0, 1} {
+		if result := checkReservationAllowed("test", sender, destination, joinType); result != "ok" {
+			t.Errorf("join type %d: got %q, expected %q", joinType, result, "ok")
+		}
+	}
+	for _, joinType := range []byte{2, 3} {
+		if result := checkReservationAllowed("test", sender, destination, joinType); result != "join_denied" {
+			t.Errorf("join type %d: got %q, expected %q", joinType, result, "join_denied")
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package qr2
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+// Limit on live subscriptions to a single room
+const maxGroupWatchers = 100
+
+type GroupChange struct {
+	Event     string      `json:"event"`
+	JoinIndex string      `json:"join_index,omitempty"`
+	Player    *PlayerInfo `json:"player,omitempty"`
+	Host      string      `json:"host,omitempty"`
+	Suspend   *bool       `json:"suspend,omitempty"`
+	MatchType string      `json:"type,omitempty"`
+	Race      *RaceInfo   `json:"race,omitempty"`
+}
+
+var (
+	// Group name -> channels signalled when the group changes
+	groupWatchers = map[string]map[chan struct{}]bool{}
+
+	ErrGroupNotFound   = errors.New("group not found")
+	ErrTooManyWatchers = errors.New("too many watchers for group")
+)
+
+// WatchGroup returns a channel that is signalled whenever the group changes, and closed once
+// the group is deleted. Signals are coalesced, so the receiver should compare the group's
+// current state with the last state it saw. The returned function stops watching.
+func WatchGroup(groupName string) (<-chan struct{}, func(), error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if groups[groupName] == nil {
+		return nil, nil, ErrGroupNotFound
+	}
+
+	watchers := groupWatchers[groupName]
+	if watchers == nil {
+		watchers = map[chan struct{}]bool{}
+		groupWatchers[groupName] = watchers
+	} else if len(watchers) >= maxGroupWatchers {
+		return nil, nil, ErrTooManyWatchers
+	}
+
+	ch := make(chan struct{}, 1)
+	watchers[ch] = true
+
+	return ch, func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if watchers := groupWatchers[groupName]; watchers[ch] {
+			delete(watchers, ch)
+			if len(watchers) == 0 {
+				delete(groupWatchers, groupName)
+			}
+		}
+	}, nil
+}
+
+// notifyWatchers signals everyone watching the group. Expects the mutex to be locked.
+func (g *Group) notifyWatchers() {
+	for ch := range groupWatchers[g.GroupName] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// Already signalled
+		}
+	}
+}
+
+// closeGroupWatchers ends all watches on a deleted group. Expects the mutex to be locked.
+func closeGroupWatchers(groupName string) {
+	for ch := range groupWatchers[groupName] {
+		close(ch)
+	}
+	delete(groupWatchers, groupName)
+}
+
+// closeAllGroupWatchers ends every watch on shutdown. Expects the mutex to be locked.
+func closeAllGroupWatchers() {
+	for groupName := range groupWatchers {
+		closeGroupWatchers(groupName)
+	}
+}
+
+// DiffGroups lists the changes between two states of the same group
+func DiffGroups(before GroupInfo, after GroupInfo) []GroupChange {
+	var changes []GroupChange
+
+	for _, joinIndex := range slices.Sorted(maps.Keys(before.Players)) {
+		if _, exists := after.Players[joinIndex]; !exists {
+			player := before.Players[joinIndex]
+			changes = append(changes, GroupChange{Event: "player_left", JoinIndex: joinIndex, Player: &player})
+		}
+	}
+
+	for _, joinIndex := range after.SortedJoinIndex {
+		player, exists := after.Players[joinIndex]
+		if !exists {
+			continue
+		}
+
+		oldPlayer, existed := before.Players[joinIndex]
+		switch {
+		case !existed:
+			changes = append(changes, GroupChange{Event: "player_joined", JoinIndex: joinIndex, Player: &player})
+
+		case oldPlayer.ConnMap != player.ConnMap || oldPlayer.ConnFail != player.ConnFail:
+			changes = append(changes, GroupChange{Event: "conn_map", JoinIndex: joinIndex, Player: &player})
+
+		case !playerInfoEqual(oldPlayer, player):
+			changes = append(changes, GroupChange{Event: "player_updated", JoinIndex: joinIndex, Player: &player})
+		}
+	}
+
+	if before.ServerIndex != after.ServerIndex {
+		changes = append(changes, GroupChange{Event: "host_changed", Host: after.ServerIndex})
+	}
+
+	if before.Suspend != after.Suspend {
+		suspend := after.Suspend
+		changes = append(changes, GroupChange{Event: "suspend", Suspend: &suspend})
+	}
+
+	if before.MatchType != after.MatchType {
+		changes = append(changes, GroupChange{Event: "match_type", MatchType: after.MatchType})
+	}
+
+	if after.RaceInfo != nil && (before.RaceInfo == nil || *before.RaceInfo != *after.RaceInfo) {
+		race := *after.RaceInfo
+		changes = append(changes, GroupChange{Event: "race", Race: &race})
+	}
+
+	return changes
+}
+
+func playerInfoEqual(a PlayerInfo, b PlayerInfo) bool {
+	return a.Count == b.Count && a.ProfileID == b.ProfileID && a.InGameName == b.InGameName &&
+		a.Suspend == b.Suspend && a.NATType == b.NATType && a.NATMapping == b.NATMapping &&
+		a.FriendCode == b.FriendCode && a.VersusELO == b.VersusELO && a.BattleELO == b.BattleELO &&
+		slices.Equal(a.Mii, b.Mii)
+}
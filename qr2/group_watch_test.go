@@ -0,0 +1,142 @@
+package qr2
+
+import (
+	"maps"
+	"testing"
+)
+
+func newTestGroupInfo(host string, players ...string) GroupInfo {
+	info := GroupInfo{
+		GroupName:   "test",
+		MatchType:   "anybody",
+		ServerIndex: host,
+		Players:     map[string]PlayerInfo{},
+	}
+
+	for _, joinIndex := range players {
+		info.Players[joinIndex] = PlayerInfo{Count: "1", ProfileID: "100" + joinIndex, InGameName: "Player " + joinIndex, ConnMap: "1"}
+		info.SortedJoinIndex = append(info.SortedJoinIndex, joinIndex)
+	}
+
+	return info
+}
+
+func TestDiffGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   GroupInfo
+		after    func(GroupInfo) GroupInfo
+		expected []string
+	}{
+		{"unchanged", newTestGroupInfo("0", "0", "1"), func(g GroupInfo) GroupInfo { return g }, nil},
+		{"player joined", newTestGroupInfo("0", "0"), func(GroupInfo) GroupInfo { return newTestGroupInfo("0", "0", "1") }, []string{"player_joined"}},
+		{"player left", newTestGroupInfo("0", "0", "1"), func(GroupInfo) GroupInfo { return newTestGroupInfo("0", "0") }, []string{"player_left"}},
+		{"player replaced", newTestGroupInfo("0", "0", "1"), func(GroupInfo) GroupInfo { return newTestGroupInfo("0", "0", "2") }, []string{"player_left", "player_joined"}},
+		{"conn map changed", newTestGroupInfo("0", "0", "1"), func(g GroupInfo) GroupInfo {
+			g.Players = maps.Clone(g.Players)
+			player := g.Players["1"]
+			player.ConnMap = "2"
+			g.Players["1"] = player
+			return g
+		}, []string{"conn_map"}},
+		{"player updated", newTestGroupInfo("0", "0", "1"), func(g GroupInfo) GroupInfo {
+			g.Players = maps.Clone(g.Players)
+			player := g.Players["0"]
+			player.VersusELO = "5000"
+			g.Players["0"] = player
+			return g
+		}, []string{"player_updated"}},
+		{"host changed", newTestGroupInfo("0", "0", "1"), func(g GroupInfo) GroupInfo {
+			g.ServerIndex = "1"
+			return g
+		}, []string{"host_changed"}},
+		{"host left", newTestGroupInfo("0", "0", "1"), func(GroupInfo) GroupInfo { return newTestGroupInfo("1", "1") }, []string{"player_left", "host_changed"}},
+		{"suspended", newTestGroupInfo("0", "0"), func(g GroupInfo) GroupInfo {
+			g.Suspend = true
+			return g
+		}, []string{"suspend"}},
+		{"match type changed", newTestGroupInfo("0", "0"), func(g GroupInfo) GroupInfo {
+			g.MatchType = "private"
+			return g
+		}, []string{"match_type"}},
+		{"race started", newTestGroupInfo("0", "0"), func(g GroupInfo) GroupInfo {
+			g.RaceInfo = &RaceInfo{RaceNumber: 1, CourseID: 8, EngineClassID: 2}
+			return g
+		}, []string{"race"}},
+	}
+
+	for _, test := range tests {
+		changes := DiffGroups(test.before, test.after(test.before))
+
+		var events []string
+		for _, change := range changes {
+			events = append(events, change.Event)
+		}
+
+		if len(events) != len(test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, events, test.expected)
+			continue
+		}
+
+		for i := range events {
+			if events[i] != test.expected[i] {
+				t.Errorf("%s: got %v, expected %v", test.name, events, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestDiffGroupsRace(t *testing.T) {
+	before := newTestGroupInfo("0", "0")
+	before.RaceInfo = &RaceInfo{RaceNumber: 1, CourseID: 8, EngineClassID: 2}
+
+	same := before
+	same.RaceInfo = &RaceInfo{RaceNumber: 1, CourseID: 8, EngineClassID: 2}
+	if changes := DiffGroups(before, same); len(changes) != 0 {
+		t.Errorf("unchanged race reported %d changes", len(changes))
+	}
+
+	next := before
+	next.RaceInfo = &RaceInfo{RaceNumber: 2, CourseID: 3, EngineClassID: 2}
+	changes := DiffGroups(before, next)
+	if len(changes) != 1 || changes[0].Race == nil || changes[0].Race.RaceNumber != 2 {
+		t.Errorf("next race was not reported: %+v", changes)
+	}
+}
+
+func TestWatchGroup(t *testing.T) {
+	groups = map[string]*Group{"test": {GroupName: "test"}}
+	groupWatchers = map[string]map[chan struct{}]bool{}
+	defer func() {
+		groups = map[string]*Group{}
+		groupWatchers = map[string]map[chan struct{}]bool{}
+	}()
+
+	if _, _, err := WatchGroup("missing"); err != ErrGroupNotFound {
+		t.Errorf("watching a missing group returned %v", err)
+	}
+
+	updates, unwatch, err := WatchGroup("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signals are coalesced
+	groups["test"].notifyWatchers()
+	groups["test"].notifyWatchers()
+	if len(updates) != 1 {
+		t.Errorf("got %d pending signals, expected 1", len(updates))
+	}
+
+	unwatch()
+	if len(groupWatchers) != 0 {
+		t.Error("watcher was not removed")
+	}
+
+	updates, _, _ = WatchGroup("test")
+	closeGroupWatchers("test")
+	if _, open := <-updates; open {
+		t.Error("watch was not closed with the group")
+	}
+}
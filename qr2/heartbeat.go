@@ -12,6 +12,14 @@ import (
 )
 
 func heartbeat(moduleName string, conn net.PacketConn, addr net.UDPAddr, buffer []byte) {
+	mutex.Lock()
+	blocked := isChallengeBlocked(addr)
+	mutex.Unlock()
+	if blocked {
+		logging.Warn(moduleName, "Ignoring heartbeat from address blocked for failed challenges")
+		return
+	}
+
 	sessionId := binary.BigEndian.Uint32(buffer[1:5])
 	values := strings.Split(string(buffer[5:]), "\u0000")
 
@@ -96,6 +104,8 @@ func heartbeat(moduleName string, conn net.PacketConn, addr net.UDPAddr, buffer
 		return
 	}
 
+	trackRatings(moduleName, lookupAddr)
+
 	if len(unknowns) > 0 {
 		// Try to login using the first unknown as a profile ID
 		// This makes it possible to execute the exploit on the client sooner
@@ -4,6 +4,7 @@ import (
 	"encoding/gob"
 	"os"
 	"strconv"
+	"time"
 	"wwfc/common"
 )
 
@@ -17,12 +18,17 @@ type LoginInfo struct {
 	NeedsExploit        bool
 	DeviceAuthenticated bool
 	Restricted          bool
+	OpenHost            bool
+	JoinPolicy          string
+	FriendList          []uint32
+	ReputationScore     float64
+	ReputationUpdated   time.Time
 	session             *Session
 }
 
 var logins = map[uint32]*LoginInfo{}
 
-func Login(profileID uint32, gameCode string, inGameName string, consoleFriendCode uint64, fcGame string, publicIP string, needsExploit bool, deviceAuthenticated bool, restricted bool) {
+func Login(profileID uint32, gameCode string, inGameName string, consoleFriendCode uint64, fcGame string, publicIP string, needsExploit bool, deviceAuthenticated bool, restricted bool, openHost bool, joinPolicy string) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -36,6 +42,9 @@ func Login(profileID uint32, gameCode string, inGameName string, consoleFriendCo
 		NeedsExploit:        needsExploit,
 		DeviceAuthenticated: deviceAuthenticated,
 		Restricted:          restricted,
+		OpenHost:            openHost,
+		JoinPolicy:          joinPolicy,
+		FriendList:          []uint32{},
 		session:             nil,
 	}
 }
@@ -52,6 +61,47 @@ func SetDeviceAuthenticated(profileID uint32) {
 	}
 }
 
+func UpdatePrivacy(profileID uint32, openHost bool, joinPolicy string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if login, exists := logins[profileID]; exists {
+		login.OpenHost = openHost
+		login.JoinPolicy = joinPolicy
+	}
+}
+
+func UpdateFriendList(profileID uint32, friendList []uint32) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if login, exists := logins[profileID]; exists {
+		login.FriendList = append([]uint32{}, friendList...)
+	}
+}
+
+func SetReputationScore(profileID uint32, score float64, updated time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if login, exists := logins[profileID]; exists {
+		login.ReputationScore = score
+		login.ReputationUpdated = updated
+	}
+}
+
+// GetGroupName returns the name of the room the profile is in, or an empty string if they aren't in one
+func GetGroupName(profileID uint32) string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if login, exists := logins[profileID]; exists && login.session != nil && login.session.groupPointer != nil {
+		return login.session.groupPointer.GroupName
+	}
+
+	return ""
+}
+
 func Logout(profileID uint32) {
 	mutex.Lock()
 	defer mutex.Unlock()
@@ -64,6 +114,7 @@ func Logout(profileID uint32) {
 	}
 
 	delete(logins, profileID)
+	forgetTrackedRatings(profileID)
 }
 
 // Save logins to a file. Expects the mutex to be locked.
@@ -3,6 +3,7 @@ package qr2
 import (
 	"encoding/binary"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -34,6 +35,11 @@ var (
 	masterConn net.PacketConn
 	inShutdown atomic.Bool
 	waitGroup  = sync.WaitGroup{}
+
+	db database.Connection
+
+	reputationThreshold float64
+	reputationHalfLife  time.Duration
 )
 
 func StartServer(reload bool) {
@@ -46,9 +52,15 @@ func StartServer(reload bool) {
 		panic(err)
 	}
 
-	// Connect to database for event logging
+	reputationThreshold = config.Reputation.Threshold
+	reputationHalfLife = time.Duration(config.Reputation.HalfLifeHours * float64(time.Hour))
+	matchmakingConfig = config.Matchmaking
+	ratingsConfig = config.Ratings
+
+	// Room history, tournaments and the rating ledger are kept in the database
+	db = database.Start(config)
+
 	if config.EventReporting.LogToDatabase {
-		db := database.Start(config)
 		db.RegisterEvents(config, []string{
 			"group_created",
 			"group_deleted",
@@ -57,9 +69,15 @@ func StartServer(reload bool) {
 			"group_host_changed",
 			"natneg_succeeded",
 			"natneg_failed",
+			"natneg_relay_started",
+			"mkw_rating_flagged",
+			"tournament_rule_violation",
 		})
 	}
 
+	loadTournaments()
+	startRatingTracker()
+
 	masterConn = conn
 	inShutdown.Store(false)
 
@@ -119,10 +137,14 @@ func Shutdown() {
 	inShutdown.Store(true)
 	common.ShouldNotError(masterConn.Close())
 	waitGroup.Wait()
+	stopRatingTracker()
+	db.Close()
 
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	closeAllGroupWatchers()
+
 	err := saveSessions()
 	if err != nil {
 		logging.Error("QR2", "Failed to save sessions:", err)
@@ -175,17 +197,34 @@ func handleConnection(conn net.PacketConn, addr net.UDPAddr, buffer []byte) {
 	case ChallengeRequest:
 		logging.Info(moduleName, "Command:", aurora.Yellow("CHALLENGE"))
 
+		response, _, _ := strings.Cut(string(buffer[5:]), "\x00")
+
 		mutex.Lock()
-		if session.Challenge != "" {
-			// TODO: Verify the challenge
-			session.Authenticated = true
+		if session.Challenge == "" {
 			mutex.Unlock()
+			return
+		}
+
+		if !verifyChallenge(moduleName, session, response) {
+			failures, blocked := addChallengeFailure(addr)
+			logging.Error(moduleName, "Invalid challenge response, failures:", aurora.Cyan(failures))
 
-			_, _ = conn.WriteTo(createResponseHeader(ClientRegisteredReply, session.SessionID), &addr)
-		} else {
+			if blocked {
+				logging.Warn(moduleName, "Blocking address after too many failed challenges")
+				removeSession(makeLookupAddr(addr.String()))
+			}
 			mutex.Unlock()
+			return
 		}
 
+		if !session.Authenticated {
+			session.Authenticated = true
+			session.notifyServerUpdate(ServerAdded)
+		}
+		mutex.Unlock()
+
+		_, _ = conn.WriteTo(createResponseHeader(ClientRegisteredReply, session.SessionID), &addr)
+
 	case EchoRequest:
 		logging.Info(moduleName, "Command:", aurora.Yellow("ECHO"))
 
@@ -0,0 +1,289 @@
+package qr2
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"wwfc/common"
+)
+
+type MatchmakingStats struct {
+	Enabled             bool    `json:"enabled"`
+	PublicRooms         int     `json:"public_rooms"`
+	AveragePlayers      float64 `json:"average_players"`
+	AverageRatingSpread float64 `json:"average_rating_spread"`
+	LargestRatingSpread int     `json:"largest_rating_spread"`
+	SingleRegionRooms   int     `json:"single_region_rooms"`
+	JoinsDenied         uint64  `json:"joins_denied"`
+	ServersFiltered     uint64  `json:"servers_filtered"`
+	ListsReordered      uint64  `json:"lists_reordered"`
+}
+
+type roomRatings struct {
+	min     int
+	max     int
+	average int
+	regions map[string]bool
+}
+
+type matchmakingCandidate struct {
+	server     map[string]string
+	inBand     bool
+	sameRegion bool
+	distance   int
+}
+
+var (
+	matchmakingConfig common.MatchmakingConfig
+
+	matchmakingJoinsDenied     atomic.Uint64
+	matchmakingServersFiltered atomic.Uint64
+	matchmakingListsReordered  atomic.Uint64
+)
+
+// getLatencyRegion groups consoles by the region of their game, as players from the same
+// region are usually closer to each other than the worldwide rooms would suggest
+func getLatencyRegion(gameCode string) string {
+	if len(gameCode) != 4 {
+		return ""
+	}
+
+	switch gameCode[3] {
+	case 'E':
+		return "americas"
+	case 'P':
+		return "europe"
+	case 'J', 'K', 'W':
+		return "asia"
+	}
+
+	return ""
+}
+
+func getRatingKey(rk string) (string, bool) {
+	public, isBattle := isPublicMatchRegion(rk)
+	if !public {
+		return "", false
+	}
+
+	if isBattle {
+		return "eb", true
+	}
+	return "ev", true
+}
+
+// getSessionRating returns the player's rating for the key, or 0 if unknown
+func getSessionRating(session *Session, key string) int {
+	rating, err := strconv.Atoi(session.Data[key])
+	if err != nil || rating < 1 || rating > 9999 {
+		return 0
+	}
+	return rating
+}
+
+// getRoomRatings collects the ratings of everyone in the session's room, or of the session
+// alone if it isn't in a room. Expects the global mutex to already be locked.
+func (session *Session) getRoomRatings(key string) roomRatings {
+	if session.groupPointer != nil {
+		return getPlayerRatings(session.groupPointer.players, key)
+	}
+	return getPlayerRatings(map[*Session]bool{session: true}, key)
+}
+
+func getPlayerRatings(players map[*Session]bool, key string) roomRatings {
+	room := roomRatings{regions: map[string]bool{}}
+	total, count := 0, 0
+	for player := range players {
+		if player.login != nil {
+			room.regions[getLatencyRegion(player.login.GameCode)] = true
+		}
+
+		rating := getSessionRating(player, key)
+		if rating == 0 {
+			continue
+		}
+
+		if count == 0 || rating < room.min {
+			room.min = rating
+		}
+		room.max = max(room.max, rating)
+		total += rating
+		count++
+	}
+
+	if count != 0 {
+		room.average = total / count
+	}
+	return room
+}
+
+// exceedsRatingSpread checks whether a player with the rating joining the room would
+// push the room past the configured rating spread
+func (room roomRatings) exceedsRatingSpread(rating int) bool {
+	if matchmakingConfig.MaxRatingSpread <= 0 || rating == 0 || room.average == 0 {
+		return false
+	}
+
+	return max(room.max, rating)-min(room.min, rating) > matchmakingConfig.MaxRatingSpread
+}
+
+// checkRatingSpread denies a public Mario Kart Wii reservation that would spread the
+// ratings in the destination's room too far. Expects the global mutex to already be locked.
+func checkRatingSpread(sender, destination *Session) bool {
+	if !matchmakingConfig.Enable || destination.Data["gamename"] != "mariokartwii" {
+		return true
+	}
+
+	key, ok := getRatingKey(destination.Data["rk"])
+	if !ok {
+		return true
+	}
+
+	if destination.getRoomRatings(key).exceedsRatingSpread(getSessionRating(sender, key)) {
+		matchmakingJoinsDenied.Add(1)
+		return false
+	}
+
+	return true
+}
+
+// ApplyMatchmakingPolicy filters and reorders a Mario Kart Wii server list for the
+// searching player. Rooms the player can't join without exceeding the rating spread,
+// or outside the hard rating band, are removed. The rest are sorted with rooms in the
+// soft rating band first, then rooms from the player's region, then by rating distance.
+//
+// The profile ID the client put in its filter is only used to find its QR2 session, which
+// has to be from the same public IP as the server list request. Otherwise the list is left
+// as is, so a client can't search with someone else's rating.
+func ApplyMatchmakingPolicy(profileID uint32, callerPublicIP string, servers []map[string]string) []map[string]string {
+	if !matchmakingConfig.Enable || len(servers) == 0 {
+		return servers
+	}
+
+	mutex.Lock()
+	login := logins[profileID]
+	if login == nil || login.session == nil || login.session.Data["publicip"] != callerPublicIP {
+		mutex.Unlock()
+		return servers
+	}
+
+	key, ok := getRatingKey(login.session.Data["rk"])
+	rating := getSessionRating(login.session, key)
+	if !ok || rating == 0 {
+		mutex.Unlock()
+		return servers
+	}
+
+	region := getLatencyRegion(login.GameCode)
+	rooms := map[*Group]roomRatings{}
+	candidates := make([]matchmakingCandidate, 0, len(servers))
+	filtered := 0
+	for _, server := range servers {
+		searchID, err := strconv.ParseUint(server["+searchid"], 10, 64)
+		session := sessionBySearchID[searchID]
+		if err != nil || session == nil {
+			candidates = append(candidates, matchmakingCandidate{server: server, distance: 10000})
+			continue
+		}
+
+		room, cached := rooms[session.groupPointer]
+		if !cached || session.groupPointer == nil {
+			room = session.getRoomRatings(key)
+			if session.groupPointer != nil {
+				rooms[session.groupPointer] = room
+			}
+		}
+
+		distance := rating - room.average
+		if room.average == 0 {
+			distance = 0
+		} else if distance < 0 {
+			distance = -distance
+		}
+
+		if room.exceedsRatingSpread(rating) || (matchmakingConfig.HardRatingBand > 0 && distance > matchmakingConfig.HardRatingBand) {
+			filtered++
+			continue
+		}
+
+		candidates = append(candidates, matchmakingCandidate{
+			server:     server,
+			inBand:     matchmakingConfig.SoftRatingBand <= 0 || distance <= matchmakingConfig.SoftRatingBand,
+			sameRegion: region != "" && room.regions[region],
+			distance:   distance,
+		})
+	}
+	mutex.Unlock()
+
+	slices.SortStableFunc(candidates, func(a, b matchmakingCandidate) int {
+		if a.inBand != b.inBand {
+			if a.inBand {
+				return -1
+			}
+			return 1
+		}
+
+		if matchmakingConfig.PreferSameRegion && a.sameRegion != b.sameRegion {
+			if a.sameRegion {
+				return -1
+			}
+			return 1
+		}
+
+		return cmp.Compare(a.distance, b.distance)
+	})
+
+	matchmakingServersFiltered.Add(uint64(filtered))
+	matchmakingListsReordered.Add(1)
+
+	result := make([]map[string]string, len(candidates))
+	for i, candidate := range candidates {
+		result[i] = candidate.server
+	}
+	return result
+}
+
+// GetMatchmakingStats returns the matchmaking policy's metrics, along with the current
+// quality of the public Mario Kart Wii rooms
+func GetMatchmakingStats() MatchmakingStats {
+	stats := MatchmakingStats{
+		Enabled:         matchmakingConfig.Enable,
+		JoinsDenied:     matchmakingJoinsDenied.Load(),
+		ServersFiltered: matchmakingServersFiltered.Load(),
+		ListsReordered:  matchmakingListsReordered.Load(),
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	totalPlayers, totalSpread := 0, 0
+	for _, group := range groups {
+		if group.GameName != "mariokartwii" {
+			continue
+		}
+
+		key, ok := getRatingKey(group.MKWRegion)
+		if !ok {
+			continue
+		}
+
+		room := getPlayerRatings(group.players, key)
+		spread := room.max - room.min
+
+		stats.PublicRooms++
+		totalPlayers += len(group.players)
+		totalSpread += spread
+		stats.LargestRatingSpread = max(stats.LargestRatingSpread, spread)
+		if len(room.regions) == 1 {
+			stats.SingleRegionRooms++
+		}
+	}
+
+	if stats.PublicRooms != 0 {
+		stats.AveragePlayers = float64(totalPlayers) / float64(stats.PublicRooms)
+		stats.AverageRatingSpread = float64(totalSpread) / float64(stats.PublicRooms)
+	}
+
+	return stats
+}
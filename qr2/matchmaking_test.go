@@ -0,0 +1,109 @@
+package qr2
+
+import (
+	"strconv"
+	"testing"
+	"wwfc/common"
+)
+
+// setupTestMatchmaking registers a searching player and a room for each rating, hosted by a
+// session with search ID 100 + index
+func setupTestMatchmaking(t *testing.T, searcherRating string, roomRatings ...string) []map[string]string {
+	matchmakingConfig = common.MatchmakingConfig{
+		Enable:          true,
+		SoftRatingBand:  1000,
+		HardRatingBand:  3000,
+		MaxRatingSpread: 5000,
+	}
+	logins = map[uint32]*LoginInfo{}
+	sessionBySearchID = map[uint64]*Session{}
+
+	t.Cleanup(func() {
+		matchmakingConfig = common.MatchmakingConfig{}
+		logins = map[uint32]*LoginInfo{}
+		sessionBySearchID = map[uint64]*Session{}
+	})
+
+	searcher := newTestSession(1, "", false)
+	searcher.Data = map[string]string{"publicip": "1000", "rk": "vs", "ev": searcherRating}
+	logins[1] = searcher.login
+
+	var servers []map[string]string
+	for i, rating := range roomRatings {
+		searchID := strconv.Itoa(100 + i)
+		host := newTestSession(uint32(100+i), "", false)
+		host.Data = map[string]string{"+searchid": searchID, "rk": "vs", "ev": rating}
+		host.groupPointer = &Group{players: map[*Session]bool{host: true}}
+		sessionBySearchID[uint64(100+i)] = host
+		servers = append(servers, map[string]string{"+searchid": searchID})
+	}
+
+	return servers
+}
+
+func getSearchIDs(servers []map[string]string) []string {
+	var searchIDs []string
+	for _, server := range servers {
+		searchIDs = append(searchIDs, server["+searchid"])
+	}
+	return searchIDs
+}
+
+func TestApplyMatchmakingPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		profileID      uint32
+		callerPublicIP string
+		expected       []string
+	}{
+		// Room 100 is outside the hard band, 101 is in the soft band and 102 is outside it
+		{"own session", 1, "1000", []string{"101", "102"}},
+		{"another IP", 1, "2000", []string{"100", "101", "102"}},
+		{"unknown profile", 2, "1000", []string{"100", "101", "102"}},
+	}
+
+	for _, test := range tests {
+		servers := setupTestMatchmaking(t, "5000", "9000", "5500", "7000")
+
+		result := getSearchIDs(ApplyMatchmakingPolicy(test.profileID, test.callerPublicIP, servers))
+		if len(result) != len(test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, result, test.expected)
+			continue
+		}
+
+		for i := range result {
+			if result[i] != test.expected[i] {
+				t.Errorf("%s: got %v, expected %v", test.name, result, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckRatingSpread(t *testing.T) {
+	matchmakingConfig = common.MatchmakingConfig{Enable: true, MaxRatingSpread: 1000}
+	defer func() {
+		matchmakingConfig = common.MatchmakingConfig{}
+	}()
+
+	tests := []struct {
+		name     string
+		rating   string
+		expected bool
+	}{
+		{"within spread", "5500", true},
+		{"past spread", "7000", false},
+		{"unrated", "", true},
+	}
+
+	for _, test := range tests {
+		sender := newTestSession(1, "", false)
+		sender.Data["ev"] = test.rating
+		destination := newTestSession(2, "", false)
+		destination.Data = map[string]string{"gamename": "mariokartwii", "rk": "vs", "ev": "5000"}
+
+		if result := checkRatingSpread(sender, destination); result != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, result, test.expected)
+		}
+	}
+}
@@ -335,6 +335,12 @@ func processClientMessage(moduleName string, sender, receiver *Session, message
 					gpErrorCallback(profileId, resvError)
 					mutex.Lock()
 				}
+			} else if resvError == "reputation" {
+				logging.Warn(moduleName, "RESERVATION: Player with poor reputation attempted to join a public match")
+			} else if resvError == "rating_spread" {
+				logging.Info(moduleName, "RESERVATION: Join denied by the room rating spread limit")
+			} else if resvError == "tournament_roster" {
+				logging.Warn(moduleName, "RESERVATION: Player not on the roster attempted to join a tournament room")
 			}
 			return
 		}
@@ -0,0 +1,244 @@
+package qr2
+
+import (
+	"sync"
+	"time"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/logrusorgru/aurora/v3"
+)
+
+type trackedRating struct {
+	rating     int
+	groupName  string
+	raceNumber int
+}
+
+type ratingObservation struct {
+	moduleName string
+	profileId  uint32
+	key        string
+	rating     int
+	groupName  string
+	raceNumber int
+}
+
+// Rating changes waiting to be recorded, so heartbeats don't wait on the database
+const ratingQueueSize = 4096
+
+var (
+	ratingsConfig common.RatingsConfig
+	ratingMutex   = sync.Mutex{}
+	// Profile ID -> rating key -> last rating recorded in the ledger
+	trackedRatings = map[uint32]map[string]trackedRating{}
+
+	ratingQueue      chan ratingObservation
+	ratingWorkerDone chan struct{}
+)
+
+// startRatingTracker starts recording the ratings queued by heartbeats
+func startRatingTracker() {
+	if !ratingsConfig.Enable {
+		return
+	}
+
+	ratingQueue = make(chan ratingObservation, ratingQueueSize)
+	ratingWorkerDone = make(chan struct{})
+
+	go func() {
+		defer close(ratingWorkerDone)
+
+		for observation := range ratingQueue {
+			trackRating(observation)
+		}
+	}()
+}
+
+// stopRatingTracker records the ratings still queued. Expects no more heartbeats to be handled.
+func stopRatingTracker() {
+	if ratingQueue == nil {
+		return
+	}
+
+	close(ratingQueue)
+	<-ratingWorkerDone
+	ratingQueue = nil
+}
+
+func getRatingType(key string) string {
+	if key == "eb" {
+		return "br"
+	}
+	return "vr"
+}
+
+// trackRatings records any change to a Mario Kart Wii player's VR or BR in the rating ledger
+func trackRatings(moduleName string, lookupAddr uint64) {
+	if !ratingsConfig.Enable {
+		return
+	}
+
+	mutex.Lock()
+	session := sessions[lookupAddr]
+	if session == nil || session.login == nil || session.Data["gamename"] != "mariokartwii" {
+		mutex.Unlock()
+		return
+	}
+
+	profileId := session.login.ProfileID
+	ratings := map[string]int{
+		"ev": getSessionRating(session, "ev"),
+		"eb": getSessionRating(session, "eb"),
+	}
+
+	groupName, raceNumber := "", 0
+	if session.groupPointer != nil {
+		groupName = session.groupPointer.GroupName
+		raceNumber = session.groupPointer.MKWRaceNumber
+	}
+	mutex.Unlock()
+
+	for key, rating := range ratings {
+		if rating == 0 || isRatingTracked(profileId, key, rating) {
+			continue
+		}
+
+		select {
+		case ratingQueue <- ratingObservation{moduleName, profileId, key, rating, groupName, raceNumber}:
+		default:
+			logging.Warn(moduleName, "Rating queue is full, dropping rating change")
+		}
+	}
+}
+
+func isRatingTracked(profileId uint32, key string, rating int) bool {
+	ratingMutex.Lock()
+	defer ratingMutex.Unlock()
+
+	last, tracked := trackedRatings[profileId][key]
+	return tracked && last.rating == rating
+}
+
+// setTrackedRating remembers the rating once it has been recorded in the ledger
+func setTrackedRating(observation ratingObservation) {
+	ratingMutex.Lock()
+	defer ratingMutex.Unlock()
+
+	profileRatings := trackedRatings[observation.profileId]
+	if profileRatings == nil {
+		profileRatings = map[string]trackedRating{}
+		trackedRatings[observation.profileId] = profileRatings
+	}
+
+	profileRatings[observation.key] = trackedRating{
+		rating:     observation.rating,
+		groupName:  observation.groupName,
+		raceNumber: observation.raceNumber,
+	}
+}
+
+// trackRating compares a rating against the last one recorded and records the change. The rating
+// is only remembered once the ledger is updated, so a failed write is retried on the next heartbeat.
+func trackRating(observation ratingObservation) {
+	moduleName, profileId, key := observation.moduleName, observation.profileId, observation.key
+	rating, groupName, raceNumber := observation.rating, observation.groupName, observation.raceNumber
+
+	ratingMutex.Lock()
+	last, tracked := trackedRatings[profileId][key]
+	ratingMutex.Unlock()
+
+	if tracked && last.rating == rating {
+		// Queued more than once before it was recorded
+		return
+	}
+
+	change := database.MarioKartWiiRatingChange{
+		ProfileID:  profileId,
+		RatingType: getRatingType(key),
+		OldRating:  last.rating,
+		NewRating:  rating,
+		GroupName:  groupName,
+		RaceNumber: raceNumber,
+		Time:       time.Now().UTC(),
+	}
+
+	if !tracked {
+		// First heartbeat since logging in, compare against the last rating recorded
+		stored, err := db.GetMarioKartWiiRating(profileId, change.RatingType)
+		if err == pgx.ErrNoRows {
+			if err := db.InitMarioKartWiiRating(profileId, change.RatingType, rating); err != nil {
+				logging.Error(moduleName, "Failed to record initial rating:", err)
+				return
+			}
+			setTrackedRating(observation)
+			return
+		} else if err != nil {
+			logging.Error(moduleName, "Failed to get recorded rating:", err)
+			return
+		}
+
+		if stored == rating {
+			setTrackedRating(observation)
+			return
+		}
+
+		// The rating can't change while the player is offline
+		change.OldRating = stored
+		change.GroupName = ""
+		change.RaceNumber = 0
+		if abs(rating-stored) > ratingsConfig.MaxChangePerRace {
+			change.Flagged = true
+			change.Reason = "offline_change"
+		}
+	} else {
+		races := 1
+		if groupName != "" && groupName == last.groupName && raceNumber != 0 {
+			races = raceNumber - last.raceNumber
+		}
+
+		if races <= 0 {
+			change.Flagged = true
+			change.Reason = "no_race"
+		} else if abs(rating-last.rating) > ratingsConfig.MaxChangePerRace*races {
+			change.Flagged = true
+			change.Reason = "implausible_change"
+		}
+	}
+
+	if err := db.RecordMarioKartWiiRatingChange(change); err != nil {
+		logging.Error(moduleName, "Failed to record rating change:", err)
+		return
+	}
+	setTrackedRating(observation)
+
+	if change.Flagged {
+		logging.Warn(moduleName, "Flagged", aurora.Cyan(change.RatingType), "change from", aurora.Cyan(change.OldRating), "to", aurora.Cyan(change.NewRating), "reason:", aurora.Cyan(change.Reason))
+		logging.Event("mkw_rating_flagged", map[string]any{
+			"profile_id":  profileId,
+			"rating_type": change.RatingType,
+			"old_rating":  change.OldRating,
+			"new_rating":  change.NewRating,
+			"group_name":  change.GroupName,
+			"race_number": change.RaceNumber,
+			"reason":      change.Reason,
+		})
+	}
+}
+
+// forgetTrackedRatings drops the ratings seen for a profile, so the next login is checked against the ledger
+func forgetTrackedRatings(profileId uint32) {
+	ratingMutex.Lock()
+	defer ratingMutex.Unlock()
+
+	delete(trackedRatings, profileId)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,53 @@
+package qr2
+
+import (
+	"testing"
+	"wwfc/common"
+)
+
+func TestTrackRatingsQueue(t *testing.T) {
+	ratingsConfig = common.RatingsConfig{Enable: true, MaxChangePerRace: 100}
+	ratingQueue = make(chan ratingObservation, 2)
+	trackedRatings = map[uint32]map[string]trackedRating{}
+	sessions = map[uint64]*Session{}
+	defer func() {
+		ratingsConfig = common.RatingsConfig{}
+		ratingQueue = nil
+		trackedRatings = map[uint32]map[string]trackedRating{}
+		sessions = map[uint64]*Session{}
+	}()
+
+	session := newTestSession(1, "", false)
+	session.Data = map[string]string{"gamename": "mariokartwii", "ev": "5000", "eb": "0"}
+	sessions[1] = session
+
+	trackRatings("test", 1)
+	if len(ratingQueue) != 1 {
+		t.Fatalf("queued %d ratings, expected 1", len(ratingQueue))
+	}
+
+	// The heartbeat path doesn't remember the rating until it is recorded
+	if isRatingTracked(1, "ev", 5000) {
+		t.Error("rating was remembered before it was recorded")
+	}
+
+	observation := <-ratingQueue
+	if observation.profileId != 1 || observation.key != "ev" || observation.rating != 5000 {
+		t.Errorf("unexpected observation %+v", observation)
+	}
+
+	setTrackedRating(observation)
+	trackRatings("test", 1)
+	if len(ratingQueue) != 0 {
+		t.Errorf("queued a rating that was already recorded")
+	}
+
+	// A full queue drops changes instead of blocking the heartbeat
+	session.Data["ev"] = "5100"
+	for i := 0; i < 3; i++ {
+		trackRatings("test", 1)
+	}
+	if len(ratingQueue) != 2 {
+		t.Errorf("queued %d ratings, expected the queue to be full at 2", len(ratingQueue))
+	}
+}
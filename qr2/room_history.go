@@ -0,0 +1,101 @@
+package qr2
+
+import (
+	"slices"
+	"strconv"
+	"time"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Limits on what is kept for a single room, in case a room stays open for days
+const (
+	maxRoomTimelineEntries = 1000
+	maxRoomRaces           = 500
+)
+
+func getSessionProfileID(session *Session) uint32 {
+	if session == nil {
+		return 0
+	}
+
+	profileId, _ := strconv.ParseUint(session.Data["dwc_pid"], 10, 32)
+	return uint32(profileId)
+}
+
+// addTimelineEntry records an event in the room's history. Expects the mutex to be locked.
+func (g *Group) addTimelineEntry(event string, profileId uint32, peerId uint32) {
+	if len(g.Timeline) >= maxRoomTimelineEntries {
+		return
+	}
+
+	g.Timeline = append(g.Timeline, database.RoomTimelineEntry{
+		Time:      time.Now().UTC(),
+		Event:     event,
+		ProfileID: profileId,
+		PeerID:    peerId,
+	})
+}
+
+// addMKWRace records the start of a race in the room's history. Expects the mutex to be locked.
+func (g *Group) addMKWRace() {
+	if len(g.MKWRaces) >= maxRoomRaces {
+		return
+	}
+
+	g.MKWRaces = append(g.MKWRaces, database.RoomRace{
+		RaceNumber:    g.MKWRaceNumber,
+		CourseID:      g.MKWCourseID,
+		EngineClassID: g.MKWEngineClassID,
+		StartTime:     time.Now().UTC(),
+	})
+}
+
+// setMKWRaceEngineClass fills in the engine class of the current race, which is selected
+// after the course. Expects the mutex to be locked.
+func (g *Group) setMKWRaceEngineClass() {
+	if len(g.MKWRaces) == 0 {
+		return
+	}
+
+	race := &g.MKWRaces[len(g.MKWRaces)-1]
+	if race.RaceNumber == g.MKWRaceNumber {
+		race.EngineClassID = g.MKWEngineClassID
+	}
+}
+
+// archive saves the finished room to the database. Expects the mutex to be locked.
+func (g *Group) archive() {
+	room := database.RoomHistory{
+		GroupName:  g.GroupName,
+		GroupID:    g.GroupID,
+		GameName:   g.GameName,
+		MatchType:  g.MatchType,
+		MKWRegion:  g.MKWRegion,
+		ProfileIDs: []int64{},
+		Timeline:   slices.Clone(g.Timeline),
+		Races:      slices.Clone(g.MKWRaces),
+		CreateTime: g.CreateTime,
+		EndTime:    time.Now().UTC(),
+
+		TournamentID: g.TournamentID,
+	}
+
+	for _, entry := range room.Timeline {
+		if entry.ProfileID != 0 && !slices.Contains(room.ProfileIDs, int64(entry.ProfileID)) {
+			room.ProfileIDs = append(room.ProfileIDs, int64(entry.ProfileID))
+		}
+	}
+
+	if room.Timeline == nil {
+		room.Timeline = []database.RoomTimelineEntry{}
+	}
+
+	go func() {
+		if err := db.InsertRoomHistory(room); err != nil {
+			logging.Error("QR2", "Failed to archive group", aurora.Cyan(room.GroupName).String()+":", err)
+		}
+	}()
+}
@@ -0,0 +1,55 @@
+package qr2
+
+import (
+	"errors"
+	"maps"
+	"wwfc/logging"
+)
+
+type ServerUpdateType int
+
+const (
+	ServerAdded ServerUpdateType = iota
+	ServerUpdated
+	ServerDeleted
+)
+
+type ServerUpdate struct {
+	Type   ServerUpdateType
+	Server map[string]string
+}
+
+var (
+	serverUpdates chan ServerUpdate
+
+	ErrServerUpdatesSubscribed = errors.New("server updates already have a subscriber")
+)
+
+// SubscribeServerUpdates returns a channel that receives a copy of each server's data
+// when it is added, updated or removed. There can only be one subscriber, as each update
+// is only received once.
+func SubscribeServerUpdates() (<-chan ServerUpdate, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if serverUpdates != nil {
+		return nil, ErrServerUpdatesSubscribed
+	}
+
+	serverUpdates = make(chan ServerUpdate, 1024)
+	return serverUpdates, nil
+}
+
+// notifyServerUpdate sends the session to the update subscriber, if the session is listed
+// in the server browser. Expects the global mutex to already be locked.
+func (session *Session) notifyServerUpdate(updateType ServerUpdateType) {
+	if serverUpdates == nil || !session.Authenticated {
+		return
+	}
+
+	select {
+	case serverUpdates <- ServerUpdate{Type: updateType, Server: maps.Clone(session.Data)}:
+	default:
+		logging.Warn("QR2", "Server update queue is full, dropping update")
+	}
+}
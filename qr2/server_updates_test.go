@@ -0,0 +1,21 @@
+package qr2
+
+import (
+	"testing"
+)
+
+func TestSubscribeServerUpdatesOnce(t *testing.T) {
+	serverUpdates = nil
+	defer func() {
+		serverUpdates = nil
+	}()
+
+	updates, err := SubscribeServerUpdates()
+	if err != nil || updates == nil {
+		t.Fatalf("first subscription failed: %v", err)
+	}
+
+	if _, err := SubscribeServerUpdates(); err != ErrServerUpdatesSubscribed {
+		t.Errorf("second subscription returned %v, expected ErrServerUpdatesSubscribed", err)
+	}
+}
@@ -2,6 +2,7 @@ package qr2
 
 import (
 	"encoding/gob"
+	"maps"
 	"math/rand"
 	"net"
 	"os"
@@ -42,10 +43,22 @@ type Session struct {
 	GroupName       string
 }
 
+type natTypeInfo struct {
+	natType       string
+	mappingScheme string
+	detected      time.Time
+}
+
+// How long a detected NAT type is applied to new sessions from the same console
+const natTypeExpiry = 24 * time.Hour
+
 var (
 	sessions          = map[uint64]*Session{}
 	sessionBySearchID = map[uint64]*Session{}
 	mutex             = deadlock.Mutex{}
+
+	// Public IP and private IP of the console -> NAT type detected by NATNEG
+	natTypes = map[string]natTypeInfo{}
 )
 
 // Remove a session. Expects the global mutex to already be locked.
@@ -55,6 +68,7 @@ func removeSession(addr uint64) {
 		return
 	}
 
+	session.notifyServerUpdate(ServerDeleted)
 	session.messageAckWaker.Assert()
 
 	if session.groupPointer != nil {
@@ -79,10 +93,13 @@ func (session *Session) removeFromGroup() {
 	}
 
 	delete(session.groupPointer.players, session)
+	session.groupPointer.addTimelineEntry("left", getSessionProfileID(session), 0)
 
 	if len(session.groupPointer.players) == 0 {
 		logging.Notice("QR2", "Deleting group", aurora.Cyan(session.groupPointer.GroupName))
 		delete(groups, session.groupPointer.GroupName)
+		session.groupPointer.archive()
+		closeGroupWatchers(session.groupPointer.GroupName)
 		logging.Event(
 			"group_deleted",
 			map[string]any{
@@ -114,6 +131,7 @@ func (session *Session) removeFromGroup() {
 		},
 	)
 
+	session.groupPointer.notifyWatchers()
 	session.groupPointer = nil
 	session.GroupName = ""
 
@@ -171,6 +189,11 @@ func setSessionData(moduleName string, addr net.Addr, sessionId uint32, payload
 			}
 		}
 
+		if info, ok := natTypes[makeNATTypeKey(session.Addr.IP.String(), session.Data["localip0"])]; ok && time.Since(info.detected) < natTypeExpiry {
+			session.Data["+nattype"] = info.natType
+			session.Data["+natmapping"] = info.mappingScheme
+		}
+
 		sessions[lookupAddr] = session
 		return *session, true
 	}
@@ -185,6 +208,10 @@ func setSessionData(moduleName string, addr net.Addr, sessionId uint32, payload
 	session.Data = payload
 	session.LastKeepAlive = time.Now().UTC().Unix()
 	session.SessionID = sessionId
+	session.notifyServerUpdate(ServerUpdated)
+	if session.groupPointer != nil {
+		session.groupPointer.notifyWatchers()
+	}
 	return *session, true
 }
 
@@ -352,3 +379,66 @@ func loadSessions() error {
 
 	return nil
 }
+
+// Consoles behind the same NAT share a public IP, so the private IP tells them apart
+func makeNATTypeKey(publicIP string, localIP string) string {
+	return publicIP + "/" + localIP
+}
+
+// SetNATType records the NAT type and mapping scheme detected by NATNEG on every session of the console
+func SetNATType(publicIP string, localIP string, natType string, mappingScheme string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for key, info := range natTypes {
+		if time.Since(info.detected) >= natTypeExpiry {
+			delete(natTypes, key)
+		}
+	}
+	natTypes[makeNATTypeKey(publicIP, localIP)] = natTypeInfo{natType: natType, mappingScheme: mappingScheme, detected: time.Now()}
+
+	for _, session := range sessions {
+		if session.Addr.IP.String() == publicIP && session.Data["localip0"] == localIP {
+			session.Data["+nattype"] = natType
+			session.Data["+natmapping"] = mappingScheme
+		}
+	}
+}
+
+// GetNATType returns the NAT type detected for the console, or an empty string if it is unknown
+func GetNATType(publicIP string, localIP string) string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if info, ok := natTypes[makeNATTypeKey(publicIP, localIP)]; ok && time.Since(info.detected) < natTypeExpiry {
+		return info.natType
+	}
+
+	return ""
+}
+
+// FindPlayerServers returns a copy of each listed server in the game whose player's
+// in-game name contains the search name
+func FindPlayerServers(gameName string, name string) []map[string]string {
+	var servers []map[string]string
+	name = strings.ToLower(name)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, session := range sessions {
+		if !session.Authenticated || session.login == nil || session.Data["gamename"] != gameName {
+			continue
+		}
+
+		if !strings.Contains(strings.ToLower(session.login.InGameName), name) {
+			continue
+		}
+
+		server := maps.Clone(session.Data)
+		server["+ingamesn"] = session.login.InGameName
+		servers = append(servers, server)
+	}
+
+	return servers
+}
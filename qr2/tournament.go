@@ -0,0 +1,147 @@
+package qr2
+
+import (
+	"slices"
+	"strconv"
+	"time"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// Tournament ID -> tournament that hasn't finished yet
+var tournaments = map[int]*database.Tournament{}
+
+func loadTournaments() {
+	unfinished, err := db.GetUnfinishedTournaments()
+	if err != nil {
+		logging.Error("QR2", "Failed to load tournaments:", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	tournaments = map[int]*database.Tournament{}
+	for _, tournament := range unfinished {
+		tournaments[tournament.ID] = &tournament
+	}
+
+	if len(tournaments) != 0 {
+		logging.Notice("QR2", "Loaded", aurora.Cyan(len(tournaments)), "tournaments")
+	}
+}
+
+// AddTournament starts enforcing a newly created tournament
+func AddTournament(tournament database.Tournament) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// Finished tournaments are only kept for their rooms that are still open
+	for id, existing := range tournaments {
+		if time.Since(existing.EndTime) > 24*time.Hour {
+			delete(tournaments, id)
+		}
+	}
+
+	tournaments[tournament.ID] = &tournament
+}
+
+// RemoveTournament stops enforcing a deleted tournament. A room already bound to it is left open without restrictions.
+func RemoveTournament(id int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	delete(tournaments, id)
+}
+
+func isTournamentOpen(tournament *database.Tournament) bool {
+	now := time.Now()
+	return now.After(tournament.StartTime.Add(-database.TournamentLobbyTime)) && now.Before(tournament.EndTime)
+}
+
+// getHostedTournament returns the open tournament the profile is the host of. Expects the mutex to be locked.
+func getHostedTournament(profileId uint32) *database.Tournament {
+	if profileId == 0 {
+		return nil
+	}
+
+	for _, tournament := range tournaments {
+		if tournament.HostProfileID == profileId && isTournamentOpen(tournament) {
+			return tournament
+		}
+	}
+
+	return nil
+}
+
+// getReservationTournament returns the tournament of the room the destination is in, or is about
+// to create as the tournament's host. A room the host opened before the tournament did is bound
+// to it here, so its roster still applies. Expects the mutex to be locked.
+func getReservationTournament(destination *Session) *database.Tournament {
+	if group := destination.groupPointer; group != nil {
+		if group.TournamentID == 0 {
+			tournament := getHostedTournament(getSessionProfileID(group.server))
+			if tournament != nil {
+				group.TournamentID = tournament.ID
+			}
+			return tournament
+		}
+
+		tournament := tournaments[group.TournamentID]
+		if tournament == nil || time.Now().After(tournament.EndTime) {
+			return nil
+		}
+		return tournament
+	}
+
+	return getHostedTournament(getSessionProfileID(destination))
+}
+
+// checkTournamentRoster only allows players on the roster to join a tournament room. Expects the mutex to be locked.
+func checkTournamentRoster(sender, destination *Session) string {
+	tournament := getReservationTournament(destination)
+	if tournament == nil {
+		return "ok"
+	}
+
+	profileId := getSessionProfileID(sender)
+	if profileId == tournament.HostProfileID || slices.Contains(tournament.ProfileIDs, profileId) {
+		return "ok"
+	}
+
+	return "tournament_roster"
+}
+
+// checkTournamentRules records a course or engine class selection that breaks the tournament's
+// rule set in the room's history. Returns false if the player who made the selection should be
+// kicked. Expects the mutex to be locked.
+func (g *Group) checkTournamentRules(moduleName string, profileId uint32) bool {
+	tournament := tournaments[g.TournamentID]
+	if tournament == nil {
+		return true
+	}
+
+	var rule string
+	var value int
+	if len(tournament.CourseIDs) != 0 && !slices.Contains(tournament.CourseIDs, g.MKWCourseID) {
+		rule, value = "course", g.MKWCourseID
+	} else if g.MKWEngineClassID != -1 && len(tournament.EngineClassIDs) != 0 && !slices.Contains(tournament.EngineClassIDs, g.MKWEngineClassID) {
+		rule, value = "engine_class", g.MKWEngineClassID
+	} else {
+		return true
+	}
+
+	logging.Warn(moduleName, "Tournament", aurora.Cyan(tournament.Code), "rule broken:", aurora.Cyan(rule), aurora.Cyan(value))
+	g.addTimelineEntry("tournament_rule_violation", profileId, 0)
+	logging.Event("tournament_rule_violation", map[string]any{
+		"tournament_id": tournament.ID,
+		"group_name":    g.GroupName,
+		"profile_id":    strconv.FormatUint(uint64(profileId), 10),
+		"race_number":   g.MKWRaceNumber,
+		"rule":          rule,
+		"value":         value,
+	})
+	return false
+}
@@ -0,0 +1,122 @@
+package qr2
+
+import (
+	"strconv"
+	"testing"
+	"time"
+	"wwfc/database"
+)
+
+func setupTestTournament(t *testing.T) *database.Tournament {
+	tournament := &database.Tournament{
+		ID:             1,
+		Code:           "TEST",
+		HostProfileID:  1,
+		ProfileIDs:     []uint32{1, 2},
+		CourseIDs:      []int{8, 9},
+		EngineClassIDs: []int{2},
+		StartTime:      time.Now().Add(-time.Hour),
+		EndTime:        time.Now().Add(time.Hour),
+	}
+	tournaments = map[int]*database.Tournament{tournament.ID: tournament}
+
+	t.Cleanup(func() {
+		tournaments = map[int]*database.Tournament{}
+	})
+	return tournament
+}
+
+func newTestTournamentSession(profileId uint32) *Session {
+	session := newTestSession(profileId, database.JoinPolicyOpenHost, true)
+	session.Data["dwc_pid"] = strconv.FormatUint(uint64(profileId), 10)
+	return session
+}
+
+func TestCheckTournamentRoster(t *testing.T) {
+	setupTestTournament(t)
+
+	tests := []struct {
+		name        string
+		sender      uint32
+		inRoom      bool
+		expectation string
+	}{
+		{"listed player, host creating room", 2, false, "ok"},
+		{"unlisted player, host creating room", 3, false, "tournament_roster"},
+		{"listed player, room open", 2, true, "ok"},
+		{"unlisted player, room open", 3, true, "tournament_roster"},
+	}
+
+	for _, test := range tests {
+		host := newTestTournamentSession(1)
+		if test.inRoom {
+			host.groupPointer = &Group{server: host, players: map[*Session]bool{host: true}, TournamentID: 1}
+		}
+
+		if result := checkTournamentRoster(newTestTournamentSession(test.sender), host); result != test.expectation {
+			t.Errorf("%s: got %q, expected %q", test.name, result, test.expectation)
+		}
+	}
+}
+
+func TestCheckTournamentRosterRoomOpenedEarly(t *testing.T) {
+	setupTestTournament(t)
+
+	// The host's room was opened before the tournament was, so it isn't bound to it yet
+	host := newTestTournamentSession(1)
+	member := newTestTournamentSession(2)
+	group := &Group{server: host, players: map[*Session]bool{host: true, member: true}}
+	host.groupPointer = group
+	member.groupPointer = group
+
+	if result := checkTournamentRoster(newTestTournamentSession(3), member); result != "tournament_roster" {
+		t.Errorf("unlisted player joining through a member got %q", result)
+	}
+
+	if group.TournamentID != 1 {
+		t.Error("room was not bound to the host's tournament")
+	}
+}
+
+func TestCheckTournamentRosterOtherHost(t *testing.T) {
+	setupTestTournament(t)
+
+	host := newTestTournamentSession(5)
+	host.groupPointer = &Group{server: host, players: map[*Session]bool{host: true}}
+
+	if result := checkTournamentRoster(newTestTournamentSession(3), host); result != "ok" {
+		t.Errorf("room without a tournament got %q", result)
+	}
+	if host.groupPointer.TournamentID != 0 {
+		t.Error("room was bound to someone else's tournament")
+	}
+}
+
+func TestCheckTournamentRules(t *testing.T) {
+	setupTestTournament(t)
+
+	tests := []struct {
+		name        string
+		tournament  int
+		course      int
+		engineClass int
+		allowed     bool
+	}{
+		{"allowed course before engine class", 1, 8, -1, true},
+		{"allowed course and engine class", 1, 9, 2, true},
+		{"disallowed course", 1, 3, -1, false},
+		{"disallowed engine class", 1, 8, 1, false},
+		{"no tournament", 0, 3, 1, true},
+	}
+
+	for _, test := range tests {
+		group := &Group{GroupName: "TEST", TournamentID: test.tournament, MKWCourseID: test.course, MKWEngineClassID: test.engineClass}
+		if allowed := group.checkTournamentRules("test", 2); allowed != test.allowed {
+			t.Errorf("%s: got %v, expected %v", test.name, allowed, test.allowed)
+		}
+
+		if violations := len(group.Timeline); (violations != 0) == test.allowed {
+			t.Errorf("%s: recorded %d violations", test.name, violations)
+		}
+	}
+}
@@ -7,7 +7,8 @@ import (
 )
 
 var (
-	db database.Connection
+	db                database.Connection
+	ghostReviewConfig common.GhostReviewConfig
 )
 
 func StartServer(reload bool) {
@@ -16,6 +17,8 @@ func StartServer(reload bool) {
 
 	common.ReadGameList()
 
+	ghostReviewConfig = config.GhostReview
+
 	// Start SQL
 	db = database.Start(config)
 }
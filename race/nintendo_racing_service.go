@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"strings"
 	"wwfc/common"
+	"wwfc/database"
+	"wwfc/gpcm"
 	"wwfc/logging"
 
 	"github.com/logrusorgru/aurora/v3"
@@ -26,6 +28,13 @@ type rankingsRequestData struct {
 	GameId   int                                    `xml:"gameid"`
 	RegionId common.MarioKartWiiLeaderboardRegionId `xml:"regionid"`
 	CourseId common.MarioKartWiiCourseId            `xml:"courseid"`
+	// SubmitScores, GetTenAboveRankings and GetFriendRankings
+	ProfileId int `xml:"profileid"`
+	// SubmitScores
+	Time     int    `xml:"time"`
+	UserData string `xml:"userdata"`
+	// GetFriendRankings
+	FriendIds []int `xml:"friendids>int"`
 }
 
 type rankingsResponseRankingDataResponse struct {
@@ -66,6 +75,12 @@ const (
 	raceServiceResultInvalidParameters = 105
 )
 
+const (
+	// Rankings above the player's own returned by GetTenAboveRankings
+	rankingsAboveCount = 10
+	maxFriendRankings  = 64
+)
+
 const (
 	xmlNamespaceXSI = "http://www.w3.org/2001/XMLSchema-instance"
 	xmlNamespaceXSD = "http://www.w3.org/2001/XMLSchema"
@@ -107,19 +122,28 @@ func handleNintendoRacingServiceRequest(w http.ResponseWriter, r *http.Request)
 	case "GetTopTenRankings":
 		handleGetTopTenRankingsRequest(moduleName, w, requestBody)
 
-	// TODO SubmitScores
+	case "GetTenAboveRankings":
+		handleGetTenAboveRankingsRequest(moduleName, w, requestBody)
+
+	case "GetFriendRankings":
+		handleGetFriendRankingsRequest(moduleName, w, requestBody)
+
+	case "SubmitScores":
+		handleSubmitScoresRequest(moduleName, w, requestBody, strings.Split(r.RemoteAddr, ":")[0])
+
 	default:
 		logging.Info(moduleName, "Unhandled SOAPAction:", aurora.Cyan(soapAction))
 	}
 }
 
-func handleGetTopTenRankingsRequest(moduleName string, responseWriter http.ResponseWriter, requestBody []byte) {
+// parseRankingsRequest reads a request and checks the game, region and course, which every action has
+func parseRankingsRequest(moduleName string, responseWriter http.ResponseWriter, requestBody []byte) (rankingsRequestData, bool) {
 	requestXML := rankingsRequestEnvelope{}
 	err := xml.Unmarshal(requestBody, &requestXML)
 	if err != nil {
 		logging.Error(moduleName, "Got malformed XML")
 		writeErrorResponse(raceServiceResultParseError, responseWriter)
-		return
+		return rankingsRequestData{}, false
 	}
 
 	requestData := requestXML.Body.Data
@@ -128,7 +152,7 @@ func handleGetTopTenRankingsRequest(moduleName string, responseWriter http.Respo
 	if gameId != marioKartWiiGameID {
 		logging.Error(moduleName, "Wrong GameSpy game ID:", aurora.Cyan(gameId))
 		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
-		return
+		return rankingsRequestData{}, false
 	}
 
 	regionId := requestData.RegionId
@@ -137,48 +161,216 @@ func handleGetTopTenRankingsRequest(moduleName string, responseWriter http.Respo
 	if !regionId.IsValid() {
 		logging.Error(moduleName, "Invalid region ID:", aurora.Cyan(regionId))
 		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
-		return
+		return rankingsRequestData{}, false
 	}
 	if courseId < common.MarioCircuit || courseId > 32767 {
 		logging.Error(moduleName, "Invalid course ID:", aurora.Cyan(courseId))
 		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+		return rankingsRequestData{}, false
+	}
+
+	return requestData, true
+}
+
+func handleGetTopTenRankingsRequest(moduleName string, responseWriter http.ResponseWriter, requestBody []byte) {
+	requestData, ok := parseRankingsRequest(moduleName, responseWriter, requestBody)
+	if !ok {
 		return
 	}
 
-	topTenRankings, err := db.GetMarioKartWiiTopTenRankings(regionId, courseId)
+	topTenRankings, err := db.GetMarioKartWiiTopTenRankings(requestData.RegionId, requestData.CourseId)
 	if err != nil {
 		logging.Error(moduleName, "Failed to get the Top 10 rankings:", err)
 		writeErrorResponse(raceServiceResultDatabaseError, responseWriter)
 		return
 	}
 
-	numberOfRankings := len(topTenRankings)
-	data := make([]rankingsResponseData, 0, numberOfRankings)
+	data := make([]rankingsResponseRankingData, 0, len(topTenRankings))
 	for i, topTenRanking := range topTenRankings {
+		data = append(data, rankingsResponseRankingData{
+			OwnerID:  topTenRanking.PID,
+			Rank:     i + 1,
+			Time:     topTenRanking.Score,
+			UserData: topTenRanking.PlayerInfo,
+		})
+	}
+
+	writeRankingsResponse(responseWriter, data)
+}
+
+func handleGetTenAboveRankingsRequest(moduleName string, responseWriter http.ResponseWriter, requestBody []byte) {
+	requestData, ok := parseRankingsRequest(moduleName, responseWriter, requestBody)
+	if !ok {
+		return
+	}
+
+	if requestData.ProfileId <= 0 {
+		logging.Error(moduleName, "Invalid profile ID:", aurora.Cyan(requestData.ProfileId))
+		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+		return
+	}
+
+	rankings, err := db.GetMarioKartWiiRankingsAbove(requestData.RegionId, requestData.CourseId, requestData.ProfileId, rankingsAboveCount)
+	if err != nil {
+		logging.Error(moduleName, "Failed to get the rankings above", aurora.Cyan(requestData.ProfileId).String()+":", err)
+		writeErrorResponse(raceServiceResultDatabaseError, responseWriter)
+		return
+	}
+
+	writeRankingsResponse(responseWriter, rankingsToResponseData(rankings))
+}
+
+func handleGetFriendRankingsRequest(moduleName string, responseWriter http.ResponseWriter, requestBody []byte) {
+	requestData, ok := parseRankingsRequest(moduleName, responseWriter, requestBody)
+	if !ok {
+		return
+	}
+
+	if len(requestData.FriendIds) > maxFriendRankings {
+		logging.Error(moduleName, "Too many friend IDs:", aurora.Cyan(len(requestData.FriendIds)))
+		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+		return
+	}
+
+	// The player's own time is ranked among their friends
+	profileIds := requestData.FriendIds
+	if requestData.ProfileId > 0 {
+		profileIds = append(profileIds, requestData.ProfileId)
+	}
+
+	rankings, err := db.GetMarioKartWiiFriendRankings(requestData.RegionId, requestData.CourseId, profileIds)
+	if err != nil {
+		logging.Error(moduleName, "Failed to get the friend rankings:", err)
+		writeErrorResponse(raceServiceResultDatabaseError, responseWriter)
+		return
+	}
+
+	writeRankingsResponse(responseWriter, rankingsToResponseData(rankings))
+}
+
+func handleSubmitScoresRequest(moduleName string, responseWriter http.ResponseWriter, requestBody []byte, ipAddress string) {
+	requestData, ok := parseRankingsRequest(moduleName, responseWriter, requestBody)
+	if !ok {
+		return
+	}
+
+	if requestData.RegionId == common.Worldwide {
+		logging.Error(moduleName, "Invalid region ID:", aurora.Cyan(requestData.RegionId))
+		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+		return
+	}
+
+	score := requestData.Time
+	if score <= 0 || score >= 360000 /* 6 minutes */ {
+		logging.Error(moduleName, "Invalid score:", aurora.Cyan(score))
+		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+		return
+	}
+
+	// The request has no login ticket, so only accept scores for players who are logged in from
+	// the same address
+	pid := requestData.ProfileId
+	if pid <= 0 || !gpcm.IsLoggedInFrom(uint32(pid), ipAddress) {
+		logging.Error(moduleName, "Score submitted for a profile that is not logged in from this address:", aurora.Cyan(pid))
+		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+		return
+	}
+
+	playerInfo, err := base64.StdEncoding.DecodeString(requestData.UserData)
+	if err != nil {
+		logging.Error(moduleName, "Invalid user data:", aurora.Cyan(requestData.UserData))
+		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+		return
+	}
+	playerInfo, ok = common.FixMarioKartWiiPlayerInfo(playerInfo)
+	if !ok {
+		logging.Error(moduleName, "Invalid user data:", aurora.Cyan(requestData.UserData))
+		writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+		return
+	}
+
+	if ghostReviewConfig.Enable {
+		reason, impossible := common.CheckMarioKartWiiTime(ghostReviewConfig, requestData.CourseId, score)
+		if impossible {
+			logging.Error(moduleName, "Impossible time", aurora.Cyan(score), "on course", aurora.Cyan(requestData.CourseId))
+			writeErrorResponse(raceServiceResultInvalidParameters, responseWriter)
+			return
+		}
+
+		if reason != "" {
+			holdScoreForReview(moduleName, requestData, score, base64.StdEncoding.EncodeToString(playerInfo), reason, responseWriter)
+			return
+		}
+	}
+
+	improved, err := db.InsertMarioKartWiiScore(requestData.RegionId, requestData.CourseId, score, pid, base64.StdEncoding.EncodeToString(playerInfo))
+	if err != nil {
+		logging.Error(moduleName, "Failed to insert the score:", err)
+		writeErrorResponse(raceServiceResultDatabaseError, responseWriter)
+		return
+	}
+
+	if improved {
+		logging.Info(moduleName, "Profile", aurora.Cyan(pid), "set a time of", aurora.Cyan(score), "on course", aurora.Cyan(requestData.CourseId))
+	}
+
+	writeErrorResponse(raceServiceResultSuccess, responseWriter)
+}
+
+// holdScoreForReview stores a submitted time in the ghost review queue instead of the leaderboards
+func holdScoreForReview(moduleName string, requestData rankingsRequestData, score int, playerInfo string, reason string, responseWriter http.ResponseWriter) {
+	id, err := db.InsertMarioKartWiiGhostReview(requestData.RegionId, requestData.CourseId, score, requestData.ProfileId, playerInfo, nil, []string{reason})
+	if err != nil {
+		logging.Error(moduleName, "Failed to insert the score review:", err)
+		writeErrorResponse(raceServiceResultDatabaseError, responseWriter)
+		return
+	}
+
+	logging.Warn(moduleName, "Held score for review as", aurora.Cyan(id).String()+":", aurora.BrightCyan(reason))
+	logging.Event("mkw_ghost_held_for_review", map[string]any{
+		"review_id":  id,
+		"profile_id": strconv.FormatUint(uint64(requestData.ProfileId), 10),
+		"course_id":  requestData.CourseId,
+		"score":      score,
+		"reasons":    []string{reason},
+	})
+
+	writeErrorResponse(raceServiceResultSuccess, responseWriter)
+}
+
+func rankingsToResponseData(rankings []database.MarioKartWiiRanking) []rankingsResponseRankingData {
+	data := make([]rankingsResponseRankingData, 0, len(rankings))
+	for _, ranking := range rankings {
+		data = append(data, rankingsResponseRankingData{
+			OwnerID:  ranking.ProfileID,
+			Rank:     ranking.Rank,
+			Time:     ranking.Score,
+			UserData: ranking.PlayerInfo,
+		})
+	}
+
+	return data
+}
+
+func writeRankingsResponse(responseWriter http.ResponseWriter, rankings []rankingsResponseRankingData) {
+	data := make([]rankingsResponseData, 0, len(rankings))
+	for _, rankingData := range rankings {
 		// Filter player info just in case
-		playerInfo, err := base64.StdEncoding.DecodeString(topTenRanking.PlayerInfo)
+		playerInfo, err := base64.StdEncoding.DecodeString(rankingData.UserData)
 		if err != nil {
 			panic(err)
 		}
 		miiData := common.RawMiiFromBytes(playerInfo).ClearMiiInfo().Data
 		playerInfo = append(miiData[:], playerInfo[len(miiData):]...)
+		rankingData.UserData = base64.StdEncoding.EncodeToString(playerInfo)
 
-		rankingData := rankingsResponseRankingData{
-			OwnerID:  topTenRanking.PID,
-			Rank:     i + 1,
-			Time:     topTenRanking.Score,
-			UserData: base64.StdEncoding.EncodeToString(playerInfo),
-		}
-
-		responseData := rankingsResponseData{
+		data = append(data, rankingsResponseData{
 			RankingData: rankingData,
-		}
-
-		data = append(data, responseData)
+		})
 	}
 
 	dataArray := rankingsResponseDataArray{
-		NumRecords: numberOfRankings,
+		NumRecords: len(data),
 		Data:       data,
 	}
 
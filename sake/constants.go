@@ -7,6 +7,7 @@ type SakeFileResult int
 // https://documentation.help/GameSpy-SDK/SAKEFileResult.html
 const (
 	SakeFileResultHeader = "Sake-File-Result"
+	SakeFileIdHeader     = "Sake-File-Id"
 
 	SakeFileResultSuccess          = 0
 	SakeFileResultMissingParameter = 3
@@ -1,11 +1,22 @@
 package sake
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"wwfc/common"
+	"wwfc/database"
+	"wwfc/gpcm"
 	"wwfc/logging"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/logrusorgru/aurora/v3"
 )
 
@@ -16,12 +27,67 @@ const (
 
 type FileRequest int
 
-var fileDownloadHandlers = map[int]func(string, http.ResponseWriter, *http.Request){
-	common.GetGameIDOrPanic("mariokartwii"): handleMarioKartWiiFileDownloadRequest,
+// Games with their own file handling, by game name. Other games use the generic file store.
+var fileDownloadHandlers = map[string]func(string, http.ResponseWriter, *http.Request){
+	"mariokartwii": handleMarioKartWiiFileDownloadRequest,
 }
 
-var fileUploadHandlers = map[int]func(string, http.ResponseWriter, *http.Request){
-	common.GetGameIDOrPanic("mariokartwii"): handleMarioKartWiiFileUploadRequest,
+var fileUploadHandlers = map[string]func(string, http.ResponseWriter, *http.Request){
+	"mariokartwii": handleMarioKartWiiFileUploadRequest,
+}
+
+// getFileHandler returns the game's own file handler, or the generic one
+func getFileHandler(handlers map[string]func(string, http.ResponseWriter, *http.Request), gameId int, generic func(string, http.ResponseWriter, *http.Request)) func(string, http.ResponseWriter, *http.Request) {
+	if gameInfo := common.GetGameInfoByID(gameId); gameInfo != nil {
+		if handler, exists := handlers[gameInfo.Name]; exists {
+			return handler
+		}
+	}
+
+	return generic
+}
+
+type fileLimit struct {
+	maxSize int
+	magic   [][]byte
+}
+
+var (
+	fileStore          FileStore
+	defaultFileLimit   fileLimit
+	fileLimitsByGame   = map[string]fileLimit{}
+	requireLoginTicket bool
+	maxUploadsPerDay   int
+	errFileValidation  = errors.New("file failed validation")
+)
+
+// loadFileConfig stops the server if the file storage can't be set up, rather than refusing
+// every upload
+func loadFileConfig(config common.SakeFilesConfig) {
+	var err error
+	fileStore, err = newFileStore(config)
+	if err != nil {
+		logging.Error("SAKE", "Failed to set up file storage:", err)
+		panic(err)
+	}
+
+	requireLoginTicket = config.RequireLoginTicket
+	maxUploadsPerDay = config.MaxUploadsPerDay
+
+	defaultFileLimit = fileLimit{maxSize: config.DefaultMaxSize}
+	fileLimitsByGame = map[string]fileLimit{}
+	for _, game := range config.Games {
+		limit := fileLimit{maxSize: game.MaxSize}
+		for _, magicString := range game.Magic {
+			magic, err := hex.DecodeString(magicString)
+			if err != nil || len(magic) == 0 {
+				logging.Error("SAKE", "Invalid file magic for", aurora.Cyan(game.GameName).String()+":", aurora.Cyan(magicString))
+				continue
+			}
+			limit.magic = append(limit.magic, magic)
+		}
+		fileLimitsByGame[game.GameName] = limit
+	}
 }
 
 func handleFileDownloadRequest(w http.ResponseWriter, r *http.Request) {
@@ -34,13 +100,7 @@ func handleFileDownloadRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	handler, handlerExists := fileDownloadHandlers[gameId]
-	if !handlerExists {
-		logging.Warn(moduleName, "Unhandled file download request for GameSpy game ID:", aurora.Cyan(gameId))
-		return
-	}
-
-	handler(moduleName, w, r)
+	getFileHandler(fileDownloadHandlers, gameId, handleGenericFileDownloadRequest)(moduleName, w, r)
 }
 
 func handleFileUploadRequest(w http.ResponseWriter, r *http.Request) {
@@ -53,11 +113,231 @@ func handleFileUploadRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	handler, handlerExists := fileUploadHandlers[gameId]
-	if !handlerExists {
-		logging.Warn(moduleName, "Unhandled file upload request for GameSpy game ID:", aurora.Cyan(gameId))
+	getFileHandler(fileUploadHandlers, gameId, handleGenericFileUploadRequest)(moduleName, w, r)
+}
+
+// getFileRequestProfileID returns the profile ID from the login ticket sent with a file request,
+// either in the Login-Ticket header or the loginticket query parameter. Unless a ticket is
+// required, a request without one is accepted for the pid query parameter if that player is
+// logged in from the same IP address.
+func getFileRequestProfileID(moduleName string, request *http.Request) (uint32, bool) {
+	ticket := request.Header.Get("Login-Ticket")
+	if ticket == "" {
+		ticket = request.URL.Query().Get("loginticket")
+	}
+
+	if ticket != "" {
+		loginTicket := common.GPCMLoginTicket{}
+		if err := loginTicket.Unmarshal(ticket); err != nil {
+			logging.Error(moduleName, "Invalid login ticket:", err)
+			return 0, false
+		}
+
+		return loginTicket.ProfileID, true
+	}
+
+	if requireLoginTicket {
+		logging.Error(moduleName, "No login ticket in file request")
+		return 0, false
+	}
+
+	pidString := request.URL.Query().Get("pid")
+	pid, err := strconv.ParseUint(pidString, 10, 32)
+	if err != nil || pid == 0 {
+		logging.Error(moduleName, "No login ticket or valid profile ID in file request:", aurora.Cyan(pidString))
+		return 0, false
+	}
+
+	if !gpcm.IsLoggedInFrom(uint32(pid), strings.Split(request.RemoteAddr, ":")[0]) {
+		logging.Error(moduleName, "Profile", aurora.Cyan(pid), "is not logged in from this address")
+		return 0, false
+	}
+
+	return uint32(pid), true
+}
+
+func handleGenericFileUploadRequest(moduleName string, responseWriter http.ResponseWriter, request *http.Request) {
+	// Already checked to be a number
+	gameId, _ := strconv.Atoi(request.URL.Query().Get("gameid"))
+	gameInfo := common.GetGameInfoByID(gameId)
+	if gameInfo == nil {
+		logging.Error(moduleName, "Unknown GameSpy game ID:", aurora.Cyan(request.URL.Query().Get("gameid")))
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultMissingParameter))
+		return
+	}
+
+	profileId, ok := getFileRequestProfileID(moduleName, request)
+	if !ok {
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultMissingParameter))
 		return
 	}
 
-	handler(moduleName, w, r)
+	limit, exists := fileLimitsByGame[gameInfo.Name]
+	if !exists {
+		limit = defaultFileLimit
+	}
+	if limit.maxSize <= 0 || fileStore == nil {
+		logging.Warn(moduleName, "File uploads are not enabled for", aurora.BrightCyan(gameInfo.Name))
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	}
+
+	data, result := readUploadedFile(moduleName, responseWriter, request, limit)
+	if result != SakeFileResultSuccess {
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(result))
+		return
+	}
+
+	hashBytes := sha256.Sum256(data)
+	hash := hex.EncodeToString(hashBytes[:])
+
+	// Check the limit before storing the file, so files over it aren't left in storage
+	if reached, err := db.IsSakeFileLimitReached(gameInfo.GameID, profileId, hash, maxUploadsPerDay); err != nil {
+		logging.Error(moduleName, "Failed to check the upload limit:", err)
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	} else if reached {
+		logging.Error(moduleName, "Profile", aurora.Cyan(profileId), "has reached the daily upload limit")
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	}
+
+	if err := fileStore.Put(hash, data); err != nil {
+		logging.Error(moduleName, "Failed to store the file:", err)
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	}
+
+	fileId, err := db.InsertSakeFile(gameInfo.GameID, profileId, hash, len(data), maxUploadsPerDay)
+	if err == database.ErrSakeFileLimitReached {
+		logging.Error(moduleName, "Profile", aurora.Cyan(profileId), "has reached the daily upload limit")
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	} else if err != nil {
+		logging.Error(moduleName, "Failed to insert the file into the database:", err)
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	}
+
+	logging.Info(moduleName, "Stored file", aurora.Cyan(fileId), "of", aurora.Cyan(len(data)), "bytes for profile", aurora.Cyan(profileId), "in", aurora.BrightCyan(gameInfo.Name))
+
+	responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultSuccess))
+	responseWriter.Header().Set(SakeFileIdHeader, strconv.FormatInt(int64(fileId), 10))
+}
+
+func handleGenericFileDownloadRequest(moduleName string, responseWriter http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	gameId, _ := strconv.Atoi(query.Get("gameid"))
+	fileId, err := strconv.ParseInt(query.Get("fileid"), 10, 32)
+	if err != nil || fileId <= 0 {
+		logging.Error(moduleName, "Invalid file ID:", aurora.Cyan(query.Get("fileid")))
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultMissingParameter))
+		return
+	}
+
+	if fileStore == nil {
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	}
+
+	file, err := db.GetSakeFile(gameId, int32(fileId))
+	if err == pgx.ErrNoRows {
+		logging.Error(moduleName, "File", aurora.Cyan(fileId), "does not exist for game ID", aurora.Cyan(gameId))
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultFileNotFound))
+		return
+	} else if err != nil {
+		logging.Error(moduleName, "Failed to get the file from the database:", err)
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	}
+
+	data, err := fileStore.Get(file.Hash)
+	if err != nil {
+		logging.Error(moduleName, "Failed to read file", aurora.Cyan(fileId), "from storage:", err)
+		if errors.Is(err, os.ErrNotExist) {
+			responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultFileNotFound))
+		} else {
+			responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		}
+		return
+	}
+
+	responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultSuccess))
+	responseWriter.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if _, err := responseWriter.Write(data); err != nil {
+		logging.Error(moduleName, "Failed to write response:", err)
+	}
+}
+
+// readUploadedFile reads the single file from a multipart upload and checks it against the game's limits
+func readUploadedFile(moduleName string, responseWriter http.ResponseWriter, request *http.Request, limit fileLimit) ([]byte, int) {
+	fixMultipartBoundary(request)
+
+	// Allow some room for the multipart headers
+	request.Body = http.MaxBytesReader(responseWriter, request.Body, int64(limit.maxSize)+4096)
+	if err := request.ParseMultipartForm(int64(limit.maxSize)); err != nil {
+		logging.Error(moduleName, "Failed to parse the multipart form:", err)
+		return nil, SakeFileResultFileTooLarge
+	}
+
+	var files []string
+	for name := range request.MultipartForm.File {
+		files = append(files, name)
+	}
+	if len(files) != 1 {
+		logging.Error(moduleName, "Expected one file in upload, got", aurora.Cyan(len(files)))
+		return nil, SakeFileResultFileNotFound
+	}
+
+	file, fileHeader, err := request.FormFile(files[0])
+	if err != nil {
+		logging.Error(moduleName, "Failed to find the uploaded file:", err)
+		return nil, SakeFileResultFileNotFound
+	}
+	defer func() {
+		common.ShouldNotError(file.Close())
+	}()
+
+	if fileHeader.Size <= 0 || fileHeader.Size > int64(limit.maxSize) {
+		logging.Error(moduleName, "The size of the uploaded file is invalid:", aurora.Cyan(fileHeader.Size))
+		return nil, SakeFileResultFileTooLarge
+	}
+
+	data := make([]byte, fileHeader.Size)
+	if _, err := io.ReadFull(file, data); err != nil {
+		logging.Error(moduleName, "Failed to read contents of the uploaded file:", err)
+		return nil, SakeFileResultServerError
+	}
+
+	if err := limit.validate(data); err != nil {
+		logging.Error(moduleName, "Rejected uploaded file:", err)
+		return nil, SakeFileResultFileNotFound
+	}
+
+	return data, SakeFileResultSuccess
+}
+
+func (l fileLimit) validate(data []byte) error {
+	if len(l.magic) == 0 {
+		return nil
+	}
+
+	for _, magic := range l.magic {
+		if bytes.HasPrefix(data, magic) {
+			return nil
+		}
+	}
+	return errFileValidation
+}
+
+// fixMultipartBoundary quotes the GameSpy multipart boundary, which does not conform to RFC 2045
+func fixMultipartBoundary(request *http.Request) {
+	contentType := request.Header.Get("Content-Type")
+	boundary := getMultipartBoundary(contentType)
+	if boundary == GameSpyMultipartBoundary {
+		quotedBoundary := fmt.Sprintf("%q", boundary)
+		contentType := strings.Replace(contentType, boundary, quotedBoundary, 1)
+		request.Header.Set("Content-Type", contentType)
+	}
 }
@@ -0,0 +1,199 @@
+package sake
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"wwfc/common"
+)
+
+// Files are stored by the hex encoded SHA-256 hash of their contents, so identical uploads are only stored once
+type FileStore interface {
+	Put(hash string, data []byte) error
+	// Returns os.ErrNotExist if there is no file with the hash
+	Get(hash string) ([]byte, error)
+}
+
+func newFileStore(config common.SakeFilesConfig) (FileStore, error) {
+	switch config.Storage {
+	case "", "disk":
+		return &diskFileStore{path: config.Path}, nil
+
+	case "s3":
+		endpoint, err := url.Parse(config.S3.Endpoint)
+		if err != nil || endpoint.Host == "" {
+			return nil, errors.New("invalid S3 endpoint: " + config.S3.Endpoint)
+		}
+		if config.S3.Bucket == "" || config.S3.Region == "" {
+			return nil, errors.New("S3 bucket and region are required")
+		}
+
+		return &s3FileStore{
+			endpoint:  endpoint,
+			region:    config.S3.Region,
+			bucket:    config.S3.Bucket,
+			accessKey: config.S3.AccessKey,
+			secretKey: config.S3.SecretKey,
+			client:    &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+
+	return nil, errors.New("unknown SAKE file storage: " + config.Storage)
+}
+
+type diskFileStore struct {
+	path string
+}
+
+func (s *diskFileStore) filePath(hash string) string {
+	// Split into subdirectories to keep directory sizes down
+	return filepath.Join(s.path, hash[:2], hash)
+}
+
+func (s *diskFileStore) Put(hash string, data []byte) error {
+	path := s.filePath(hash)
+	if _, err := os.Stat(path); err == nil {
+		// Already stored
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a partial file is never served
+	temp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+func (s *diskFileStore) Get(hash string) ([]byte, error) {
+	return os.ReadFile(s.filePath(hash))
+}
+
+type s3FileStore struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func (s *s3FileStore) Put(hash string, data []byte) error {
+	response, err := s.do(http.MethodPut, hash, data)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("S3 PUT returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (s *s3FileStore) Get(hash string) ([]byte, error) {
+	response, err := s.do(http.MethodGet, hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	} else if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("S3 GET returned status %d", response.StatusCode)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// do sends a path-style request signed with AWS Signature Version 4
+func (s *s3FileStore) do(method string, key string, body []byte) (*http.Response, error) {
+	path := strings.TrimSuffix(s.endpoint.Path, "/") + "/" + s.bucket + "/" + key
+	request, err := http.NewRequest(method, s.endpoint.Scheme+"://"+s.endpoint.Host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := sha256.Sum256(body)
+	signS3Request(request, hex.EncodeToString(payloadHash[:]), time.Now(), s.region, s.accessKey, s.secretKey)
+	return s.client.Do(request)
+}
+
+// signS3Request adds the AWS Signature Version 4 Authorization header to a request without query
+// parameters. The host and every header already set on the request are signed.
+func signS3Request(request *http.Request, payloadHash string, now time.Time, region string, accessKey string, secretKey string) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + region + "/s3/aws4_request"
+
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	request.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := slices.Sorted(maps.Keys(headers))
+
+	var canonicalHeaders []string
+	for _, name := range names {
+		canonicalHeaders = append(canonicalHeaders, name+":"+headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		"",
+		strings.Join(canonicalHeaders, "\n"),
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signature := hex.EncodeToString(hmacSHA256(deriveSigningKey(secretKey, now.Format("20060102"), region, "s3"), stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func deriveSigningKey(secretKey string, date string, region string, service string) []byte {
+	signingKey := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	return signingKey
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,33 @@
+package sake
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetFileRequestProfileIDWithoutTicket(t *testing.T) {
+	defer func() {
+		requireLoginTicket = false
+	}()
+
+	tests := []struct {
+		name    string
+		require bool
+		url     string
+	}{
+		{"ticket required", true, "/SakeFileServer/upload.aspx?gameid=1&pid=1000"},
+		{"no profile ID", false, "/SakeFileServer/upload.aspx?gameid=1"},
+		{"invalid profile ID", false, "/SakeFileServer/upload.aspx?gameid=1&pid=-5"},
+		// Nobody is logged in
+		{"profile not logged in", false, "/SakeFileServer/upload.aspx?gameid=1&pid=1000"},
+		{"invalid ticket", false, "/SakeFileServer/upload.aspx?gameid=1&pid=1000&loginticket=invalid"},
+	}
+
+	for _, test := range tests {
+		requireLoginTicket = test.require
+		request := httptest.NewRequest("POST", test.url, nil)
+		if profileId, ok := getFileRequestProfileID("test", request); ok {
+			t.Errorf("%s: accepted as profile %d", test.name, profileId)
+		}
+	}
+}
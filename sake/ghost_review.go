@@ -0,0 +1,57 @@
+package sake
+
+import (
+	"strconv"
+	"strings"
+	"wwfc/common"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+var ghostReviewConfig common.GhostReviewConfig
+
+// reviewMarioKartWiiGhost checks the ghost's time and inputs. Returns the reasons the ghost should be
+// held for moderator review, and false if it should be rejected outright.
+func reviewMarioKartWiiGhost(moduleName string, ghostData common.RKGhostData, courseId common.MarioKartWiiCourseId, score int) ([]string, bool) {
+	if !ghostReviewConfig.Enable {
+		return nil, true
+	}
+
+	timeReason, impossible := common.CheckMarioKartWiiTime(ghostReviewConfig, courseId, score)
+	if impossible {
+		logging.Error(moduleName, "Impossible time", aurora.Cyan(score), "on course", aurora.Cyan(courseId))
+		return nil, false
+	}
+
+	reasons, err := ghostData.AnalyzeInputs()
+	if err != nil {
+		logging.Error(moduleName, "Failed to read the ghost's inputs:", err)
+		return nil, false
+	}
+
+	if timeReason != "" {
+		reasons = append([]string{timeReason}, reasons...)
+	}
+	return reasons, true
+}
+
+// holdMarioKartWiiGhostForReview stores the upload in the review queue instead of the leaderboards
+func holdMarioKartWiiGhostForReview(moduleName string, regionId common.MarioKartWiiLeaderboardRegionId, courseId common.MarioKartWiiCourseId,
+	score int, pid int, playerInfo string, ghost []byte, reasons []string) bool {
+	id, err := db.InsertMarioKartWiiGhostReview(regionId, courseId, score, pid, playerInfo, ghost, reasons)
+	if err != nil {
+		logging.Error(moduleName, "Failed to insert the ghost review:", err)
+		return false
+	}
+
+	logging.Warn(moduleName, "Held ghost for review as", aurora.Cyan(id).String()+":", aurora.BrightCyan(strings.Join(reasons, ", ")))
+	logging.Event("mkw_ghost_held_for_review", map[string]any{
+		"review_id":  id,
+		"profile_id": strconv.FormatUint(uint64(pid), 10),
+		"course_id":  courseId,
+		"score":      score,
+		"reasons":    reasons,
+	})
+	return true
+}
@@ -16,8 +16,17 @@ func StartServer(reload bool) {
 
 	common.ReadGameList()
 
+	tablesPath = config.SakeTablesPath
+	loadTableDefinitionsOnStart()
+	loadFileConfig(config.SakeFiles)
+	ghostReviewConfig = config.GhostReview
+
 	// Start SQL
 	db = database.Start(config)
+	db.RegisterEvents(config, []string{
+		"sake_record_reported",
+		"mkw_ghost_held_for_review",
+	})
 }
 
 func Shutdown() {
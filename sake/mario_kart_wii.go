@@ -1,10 +1,8 @@
 package sake
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"net/http"
 	"regexp"
@@ -14,20 +12,11 @@ import (
 	"wwfc/database"
 	"wwfc/logging"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/logrusorgru/aurora/v3"
 )
 
-type playerInfo struct {
-	MiiData      common.RawMii // 0x00
-	ControllerId byte          // 0x4C
-	Unknown      byte          // 0x4D
-	StateCode    byte          // 0x4E
-	CountryCode  byte          // 0x4F
-}
-
 const (
-	playerInfoSize = 0x50
-
 	rkgdFileName = "ghost.bin"
 )
 
@@ -196,7 +185,11 @@ func handleMarioKartWiiFileDownloadRequest(moduleName string, responseWriter htt
 	}
 
 	file, err := db.GetMarioKartWiiFile(fileId)
-	if err != nil {
+	if err == pgx.ErrNoRows {
+		logging.Error(moduleName, "File", aurora.Cyan(fileId), "does not exist or has no ghost")
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultFileNotFound))
+		return
+	} else if err != nil {
 		logging.Error(moduleName, "Failed to get the file from the database:", err)
 		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
 		return
@@ -259,17 +252,22 @@ func handleMarioKartWiiGhostDownloadRequest(responseWriter http.ResponseWriter,
 	}
 
 	ghost, err := db.GetMarioKartWiiGhostFile(courseId, time, pid)
-	if err != nil {
+	if err == pgx.ErrNoRows {
+		logging.Error(moduleName, "No ghost to download for course", aurora.Cyan(courseId), "and time", aurora.Cyan(time))
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultFileNotFound))
+		return
+	} else if err != nil {
 		logging.Error(moduleName, "Failed to get a ghost file from the database:", err)
 		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
 		return
 	}
 
-	ghostData := common.RKGhostData(ghost)
-	ghostData.SetMiiData(ghostData.GetMiiData().ClearMiiInfo())
-	ghostData.RecalculateCRC()
-
-	responseBody := append(downloadedGhostFileHeader(), []byte(ghostData)...)
+	responseBody, ok := makeDownloadedGhostFile(ghost)
+	if !ok {
+		logging.Error(moduleName, "Stored ghost file has an invalid size:", aurora.Cyan(len(ghost)))
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+		return
+	}
 
 	responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultSuccess))
 	responseWriter.Header().Set("Content-Length", strconv.Itoa(len(responseBody)))
@@ -278,6 +276,19 @@ func handleMarioKartWiiGhostDownloadRequest(responseWriter http.ResponseWriter,
 	}
 }
 
+// makeDownloadedGhostFile clears the player's Mii info from a stored ghost and adds the download header
+func makeDownloadedGhostFile(ghost []byte) ([]byte, bool) {
+	if len(ghost) < common.RKGDFileMinSize || len(ghost) > common.RKGDFileMaxSize {
+		return nil, false
+	}
+
+	ghostData := common.RKGhostData(ghost)
+	ghostData.SetMiiData(ghostData.GetMiiData().ClearMiiInfo())
+	ghostData.RecalculateCRC()
+
+	return append(downloadedGhostFileHeader(), []byte(ghostData)...), true
+}
+
 func handleMarioKartWiiFileUploadRequest(moduleName string, responseWriter http.ResponseWriter, request *http.Request) {
 	if strings.HasSuffix(request.URL.Path, "ghostupload.aspx") {
 		handleMarioKartWiiGhostUploadRequest(moduleName, responseWriter, request)
@@ -335,6 +346,17 @@ func handleMarioKartWiiGhostUploadRequest(moduleName string, responseWriter http
 		return
 	}
 
+	ticketProfileId, ok := getFileRequestProfileID(moduleName, request)
+	if !ok {
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultMissingParameter))
+		return
+	}
+	if uint32(pid) != ticketProfileId {
+		logging.Error(moduleName, "Profile ID", aurora.Cyan(pid), "does not match the login ticket's", aurora.Cyan(ticketProfileId))
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultMissingParameter))
+		return
+	}
+
 	fixedPlayerInfo, ok := isPlayerInfoValid(playerInfo)
 	if !ok {
 		logging.Error(moduleName, "Invalid player info:", aurora.Cyan(playerInfo))
@@ -343,15 +365,7 @@ func handleMarioKartWiiGhostUploadRequest(moduleName string, responseWriter http
 	}
 	playerInfo = fixedPlayerInfo
 
-	// The multipart boundary utilized by GameSpy does not conform to RFC 2045. To ensure compliance,
-	// we need to surround it with double quotation marks.
-	contentType := request.Header.Get("Content-Type")
-	boundary := getMultipartBoundary(contentType)
-	if boundary == GameSpyMultipartBoundary {
-		quotedBoundary := fmt.Sprintf("%q", boundary)
-		contentType := strings.Replace(contentType, boundary, quotedBoundary, 1)
-		request.Header.Set("Content-Type", contentType)
-	}
+	fixMultipartBoundary(request)
 
 	err = request.ParseMultipartForm(common.RKGDFileMaxSize)
 	if err != nil {
@@ -394,10 +408,28 @@ func handleMarioKartWiiGhostUploadRequest(moduleName string, responseWriter http
 	ghostData.SetMiiData(ghostData.GetMiiData().ClearMiiInfo())
 	ghostData.RecalculateCRC()
 
+	reasons, ok := reviewMarioKartWiiGhost(moduleName, ghostData, courseId, score)
+	if !ok {
+		logging.Error(moduleName, "Rejected the ghost file")
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultFileTooLarge))
+		return
+	}
+
 	if isContest {
 		ghostData = nil
 	}
 
+	if len(reasons) != 0 {
+		if !holdMarioKartWiiGhostForReview(moduleName, regionId, courseId, score, pid, playerInfo, []byte(ghostData), reasons) {
+			responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultServerError))
+			return
+		}
+
+		// The game doesn't need to know the ghost isn't on the leaderboards yet
+		responseWriter.Header().Set(SakeFileResultHeader, strconv.Itoa(SakeFileResultSuccess))
+		return
+	}
+
 	err = db.InsertMarioKartWiiGhostFile(regionId, courseId, score, pid, playerInfo, []byte(ghostData))
 	if err != nil {
 		logging.Error(moduleName, "Failed to insert the ghost file into the database:", err)
@@ -423,37 +455,12 @@ func isPlayerInfoValid(playerInfoString string) (string, bool) {
 		return "", false
 	}
 
-	if len(playerInfoByteArray) != playerInfoSize {
-		return "", false
-	}
-
-	var playerInfo playerInfo
-	reader := bytes.NewReader(playerInfoByteArray)
-	err = binary.Read(reader, binary.BigEndian, &playerInfo)
-	if err != nil {
-		return "", false
-	}
-
-	if playerInfo.MiiData.CalculateMiiCRC() != 0x0000 {
-		return "", false
-	}
-
-	controllerId := common.MarioKartWiiControllerId(playerInfo.ControllerId)
-
-	if !controllerId.IsValid() {
-		return "", false
-	}
-
-	playerInfo.MiiData.ClearMiiInfo()
-
-	fixedPlayerInfoByteArray := new(bytes.Buffer)
-	err = binary.Write(fixedPlayerInfoByteArray, binary.BigEndian, playerInfo)
-	if err != nil {
+	fixedPlayerInfoByteArray, ok := common.FixMarioKartWiiPlayerInfo(playerInfoByteArray)
+	if !ok {
 		return "", false
 	}
 
-	fixedPlayerInfoString := base64.StdEncoding.EncodeToString(fixedPlayerInfoByteArray.Bytes())
-	return fixedPlayerInfoString, true
+	return base64.StdEncoding.EncodeToString(fixedPlayerInfoByteArray), true
 }
 
 func filterMarioKartWiiFriendInfo(value string, isOwner bool) (string, Result) {
@@ -0,0 +1,44 @@
+package sake
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"wwfc/common"
+)
+
+func TestMakeDownloadedGhostFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		ghost []byte
+		ok    bool
+	}{
+		// A time submitted without a ghost has a NULL ghost column
+		{"score without a ghost", nil, false},
+		{"truncated ghost", make([]byte, common.RKGDFileMinSize-1), false},
+		{"oversized ghost", make([]byte, common.RKGDFileMaxSize+1), false},
+		{"smallest ghost", make([]byte, common.RKGDFileMinSize), true},
+	}
+
+	for _, test := range tests {
+		file, ok := makeDownloadedGhostFile(test.ghost)
+		if ok != test.ok {
+			t.Errorf("%s: got %v, expected %v", test.name, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		header := downloadedGhostFileHeader()
+		if len(file) != len(header)+len(test.ghost) {
+			t.Errorf("%s: got %d bytes, expected %d", test.name, len(file), len(header)+len(test.ghost))
+			continue
+		}
+
+		ghost := file[len(header):]
+		if crc := binary.BigEndian.Uint32(ghost[len(ghost)-4:]); crc != crc32.ChecksumIEEE(ghost[:len(ghost)-4]) {
+			t.Errorf("%s: CRC was not recalculated", test.name)
+		}
+	}
+}
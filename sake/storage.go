@@ -4,8 +4,10 @@ import (
 	"encoding/xml"
 	"io"
 	"net/http"
-	"sort"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"wwfc/common"
 	"wwfc/database"
 	"wwfc/logging"
@@ -45,6 +47,11 @@ const (
 	ResultServiceDisabled     = Result("ServiceDisabled")     // 4xx53
 )
 
+const (
+	FaultCodeClient = "s:Client"
+	FaultCodeServer = "s:Server"
+)
+
 type Result string
 
 type StorageRequestEnvelope struct {
@@ -66,6 +73,7 @@ type StorageRequestCommon struct {
 	SecretKey    string        `xml:"secretKey"`
 	TableID      string        `xml:"tableid"`
 	RecordID     int32         `xml:"recordid"`
+	RecordIDs    ArrayOfInt    `xml:"recordids"`
 	Fields       ArrayOfString `xml:"fields"`
 	Filter       string        `xml:"filter"`
 	Sort         string        `xml:"sort"`
@@ -76,6 +84,8 @@ type StorageRequestCommon struct {
 	OwnerIDs     ArrayOfInt    `xml:"ownerids"`
 	CacheFlag    bool          `xml:"cacheFlag"`
 	Rating       byte          `xml:"rating"`
+	ReasonCode   int32         `xml:"reasonCode"`
+	Reason       string        `xml:"reason"`
 
 	Values ArrayOfRecordField `xml:"values"`
 }
@@ -96,10 +106,22 @@ type StorageResponseEnvelope struct {
 }
 
 type StorageResponseBody struct {
-	CreateRecordResponse     *CreateRecordResponse     `xml:"http://gamespy.net/sake CreateRecordResponse"`
-	UpdateRecordResponse     *UpdateRecordResponse     `xml:"http://gamespy.net/sake UpdateRecordResponse"`
-	GetMyRecordsResponse     *GetMyRecordsResponse     `xml:"http://gamespy.net/sake GetMyRecordsResponse"`
-	SearchForRecordsResponse *SearchForRecordsResponse `xml:"http://gamespy.net/sake SearchForRecordsResponse"`
+	CreateRecordResponse       *CreateRecordResponse       `xml:"http://gamespy.net/sake CreateRecordResponse"`
+	UpdateRecordResponse       *UpdateRecordResponse       `xml:"http://gamespy.net/sake UpdateRecordResponse"`
+	DeleteRecordResponse       *DeleteRecordResponse       `xml:"http://gamespy.net/sake DeleteRecordResponse"`
+	GetMyRecordsResponse       *GetMyRecordsResponse       `xml:"http://gamespy.net/sake GetMyRecordsResponse"`
+	SearchForRecordsResponse   *SearchForRecordsResponse   `xml:"http://gamespy.net/sake SearchForRecordsResponse"`
+	GetSpecificRecordsResponse *GetSpecificRecordsResponse `xml:"http://gamespy.net/sake GetSpecificRecordsResponse"`
+	GetRandomRecordsResponse   *GetRandomRecordsResponse   `xml:"http://gamespy.net/sake GetRandomRecordsResponse"`
+	GetRecordCountResponse     *GetRecordCountResponse     `xml:"http://gamespy.net/sake GetRecordCountResponse"`
+	RateRecordResponse         *RateRecordResponse         `xml:"http://gamespy.net/sake RateRecordResponse"`
+	ReportRecordResponse       *ReportRecordResponse       `xml:"http://gamespy.net/sake ReportRecordResponse"`
+	Fault                      *Fault                      `xml:"s:Fault"`
+}
+
+type Fault struct {
+	FaultCode   string `xml:"faultcode"`
+	FaultString string `xml:"faultstring"`
 }
 
 type CreateRecordResponse struct {
@@ -111,6 +133,10 @@ type UpdateRecordResponse struct {
 	UpdateRecordResult Result
 }
 
+type DeleteRecordResponse struct {
+	DeleteRecordResult Result
+}
+
 type GetMyRecordsResponse struct {
 	GetMyRecordsResult Result
 	Values             ArrayOfArrayOfRecordValue `xml:"values"`
@@ -121,6 +147,31 @@ type SearchForRecordsResponse struct {
 	Values                 ArrayOfArrayOfRecordValue `xml:"values"`
 }
 
+type GetSpecificRecordsResponse struct {
+	GetSpecificRecordsResult Result
+	Values                   ArrayOfArrayOfRecordValue `xml:"values"`
+}
+
+type GetRandomRecordsResponse struct {
+	GetRandomRecordsResult Result
+	Values                 ArrayOfArrayOfRecordValue `xml:"values"`
+}
+
+type GetRecordCountResponse struct {
+	GetRecordCountResult Result
+	Count                int32 `xml:"count"`
+}
+
+type RateRecordResponse struct {
+	RateRecordResult Result
+	NumRatings       int32   `xml:"numRatings"`
+	AverageRating    float32 `xml:"averageRating"`
+}
+
+type ReportRecordResponse struct {
+	ReportRecordResult Result
+}
+
 type RecordField struct {
 	Name  string      `xml:"name"`
 	Value RecordValue `xml:"value"`
@@ -166,11 +217,19 @@ var (
 
 	tagToSakeType = common.ReverseMap(sakeTypeToTag).(map[string]database.SakeFieldType)
 
+	sortFieldNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
 	storageRequestHandlers = map[string]func(moduleName string, profileId uint32, gameInfo common.GameInfo, request StorageRequestCommon) StorageResponseBody{
-		SakeNamespace + "/CreateRecord":     createRecord,
-		SakeNamespace + "/UpdateRecord":     updateRecord,
-		SakeNamespace + "/GetMyRecords":     getMyRecords,
-		SakeNamespace + "/SearchForRecords": searchForRecords,
+		SakeNamespace + "/CreateRecord":       createRecord,
+		SakeNamespace + "/UpdateRecord":       updateRecord,
+		SakeNamespace + "/DeleteRecord":       deleteRecord,
+		SakeNamespace + "/GetMyRecords":       getMyRecords,
+		SakeNamespace + "/SearchForRecords":   searchForRecords,
+		SakeNamespace + "/GetSpecificRecords": getSpecificRecords,
+		SakeNamespace + "/GetRandomRecords":   getRandomRecords,
+		SakeNamespace + "/GetRecordCount":     getRecordCount,
+		SakeNamespace + "/RateRecord":         rateRecord,
+		SakeNamespace + "/ReportRecord":       reportRecord,
 	}
 )
 
@@ -201,18 +260,20 @@ func handleStorageRequest(w http.ResponseWriter, r *http.Request) {
 	response := StorageResponseEnvelope{
 		NamespaceSoap: SOAPEnvNamespace,
 	}
+	status := http.StatusOK
 
 	xmlName := soap.Body.Data.XMLName.Space + "/" + soap.Body.Data.XMLName.Local
 	if headerAction == xmlName || headerAction == `"`+xmlName+`"` {
 		logging.Info(moduleName, "SOAPAction:", aurora.Yellow(soap.Body.Data.XMLName.Local))
 
-		handler, ok := storageRequestHandlers[xmlName]
-		if !ok {
-			panic("unknown SOAPAction: " + aurora.Cyan(xmlName).String())
-		}
-
-		profileId, gameInfo, result := getRequestIdentity(moduleName, soap.Body.Data)
-		if result != ResultSuccess {
+		if handler, ok := storageRequestHandlers[xmlName]; !ok {
+			logging.Error(moduleName, "Unknown SOAPAction:", aurora.Cyan(xmlName))
+			response.Body.Fault = &Fault{
+				FaultCode:   FaultCodeClient,
+				FaultString: "Unknown SOAPAction: " + xmlName,
+			}
+			status = http.StatusInternalServerError
+		} else if profileId, gameInfo, result := getRequestIdentity(moduleName, soap.Body.Data); result != ResultSuccess {
 			logging.Error(moduleName, "Failed to get request identity:", aurora.Cyan(result))
 			response.Body.setResultTag(xmlName, result)
 		} else {
@@ -220,6 +281,11 @@ func handleStorageRequest(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		logging.Error(moduleName, "Invalid SOAPAction or XML request:", aurora.Cyan(headerAction))
+		response.Body.Fault = &Fault{
+			FaultCode:   FaultCodeClient,
+			FaultString: "SOAPAction does not match the request body",
+		}
+		status = http.StatusInternalServerError
 	}
 
 	out, err := xml.Marshal(response)
@@ -233,6 +299,7 @@ func handleStorageRequest(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/xml")
 	w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+	w.WriteHeader(status)
 	if _, err := w.Write(payload); err != nil {
 		logging.Error(moduleName, "Failed to write response:", err)
 	}
@@ -348,7 +415,7 @@ func getMyRecords(moduleName string, profileId uint32, gameInfo common.GameInfo,
 		}}
 	}
 
-	records, err := db.GetSakeRecords(gameInfo.GameID, []int32{int32(profileId)}, request.TableID, nil, request.Fields.String, request.Filter)
+	records, err := db.GetSakeRecords(gameInfo.GameID, []int32{int32(profileId)}, request.TableID, nil, request.Fields.String, request.Filter, nil)
 	if err != nil {
 		logging.Error(moduleName, "Failed to get sake records from the database:", err)
 		if err == pgx.ErrNoRows {
@@ -422,6 +489,11 @@ func updateRecord(moduleName string, profileId uint32, gameInfo common.GameInfo,
 				UpdateRecordResult: ResultRecordNotFound,
 			}}
 		}
+		if err == database.ErrSakeFieldLimitExceeded {
+			return StorageResponseBody{UpdateRecordResponse: &UpdateRecordResponse{
+				UpdateRecordResult: ResultFieldTypeInvalid,
+			}}
+		}
 		return StorageResponseBody{UpdateRecordResponse: &UpdateRecordResponse{
 			UpdateRecordResult: ResultDatabaseUnavailable,
 		}}
@@ -465,8 +537,27 @@ func searchForRecords(moduleName string, profileId uint32, gameInfo common.GameI
 			ownerIds = []int32{int32(profileId)}
 		}
 
+		sortFields, ok := parseSortString(request.Sort)
+		if !ok {
+			logging.Error(moduleName, "Invalid sort string:", aurora.Cyan(request.Sort))
+			return StorageResponseBody{SearchForRecordsResponse: &SearchForRecordsResponse{
+				SearchForRecordsResult: ResultSortInvalid,
+			}}
+		}
+
+		options := database.SakeRecordQueryOptions{
+			Sort:         sortFields,
+			Offset:       max(int(request.Offset), 0),
+			Max:          int(request.Max),
+			TargetFilter: request.TargetFilter,
+			Surrounding:  min(max(int(request.Surrounding), 0), MaxSakeRecordsPerRequest/2),
+		}
+		if options.Max <= 0 || options.Max > MaxSakeRecordsPerRequest {
+			options.Max = MaxSakeRecordsPerRequest
+		}
+
 		var err error
-		records, err = db.GetSakeRecords(gameInfo.GameID, ownerIds, request.TableID, nil, request.Fields.String, request.Filter)
+		records, err = db.GetSakeRecords(gameInfo.GameID, ownerIds, request.TableID, nil, request.Fields.String, request.Filter, &options)
 		if err != nil {
 			logging.Error(moduleName, "Failed to get sake records from the database:", err)
 			return StorageResponseBody{SearchForRecordsResponse: &SearchForRecordsResponse{
@@ -475,44 +566,326 @@ func searchForRecords(moduleName string, profileId uint32, gameInfo common.GameI
 		}
 	}
 
-	// Sort the records now. TODO: This can be done more effectively in the database query.
-	sort.Slice(records, func(l, r int) bool {
-		lVal, lExists := records[l].Fields[request.Sort]
-		rVal, rExists := records[r].Fields[request.Sort]
-		if !lExists || !rExists {
-			// Prioritises the one that exists or goes left if both false
-			return rExists
-		}
+	responseValues, result := fillResponseValues(moduleName, profileId, table, records, request)
+	response := SearchForRecordsResponse{
+		SearchForRecordsResult: result,
+		Values:                 responseValues,
+	}
+	logging.Info(moduleName, "Found", aurora.Cyan(len(records)), "records in table", aurora.Cyan(request.TableID), "for profile", aurora.Cyan(profileId), "with filter", aurora.Cyan(request.Filter))
 
-		if lVal.Type != database.SakeFieldTypeInt || rVal.Type != database.SakeFieldTypeInt {
-			panic(aurora.Cyan(lVal.Type).String() + " used as sort value")
-		}
+	return StorageResponseBody{SearchForRecordsResponse: &response}
+}
 
-		lValInt, err := strconv.ParseInt(lVal.Value, 10, 64)
-		if err != nil {
-			panic(err)
+func deleteRecord(moduleName string, profileId uint32, gameInfo common.GameInfo, request StorageRequestCommon) StorageResponseBody {
+	if request.TableID == "" {
+		logging.Error(moduleName, "No table ID provided")
+		return StorageResponseBody{DeleteRecordResponse: &DeleteRecordResponse{
+			DeleteRecordResult: ResultTableNotFound,
+		}}
+	}
+
+	table := GetTable(gameInfo.Name, request.TableID)
+	if table != nil && table.Reserved {
+		// Reserved for special handler
+		logging.Error(moduleName, "Attempt to delete record in reserved table", aurora.Cyan(request.TableID), "in game", aurora.BrightCyan(gameInfo.Name))
+		return StorageResponseBody{DeleteRecordResponse: &DeleteRecordResponse{
+			DeleteRecordResult: ResultNoPermission,
+		}}
+	}
+
+	if !table.AllowsOwnerDelete() {
+		logging.Error(moduleName, "Attempt to delete record in table that doesn't allow owner delete", aurora.Cyan(request.TableID), "in game", aurora.BrightCyan(gameInfo.Name))
+		return StorageResponseBody{DeleteRecordResponse: &DeleteRecordResponse{
+			DeleteRecordResult: ResultNoPermission,
+		}}
+	}
+
+	err := db.DeleteSakeRecord(gameInfo.GameID, request.TableID, request.RecordID, int32(profileId))
+	if err != nil {
+		logging.Error(moduleName, "Failed to delete sake record from the database:", err)
+		if err == database.ErrSakeNotOwned {
+			return StorageResponseBody{DeleteRecordResponse: &DeleteRecordResponse{
+				DeleteRecordResult: ResultNotOwned,
+			}}
 		}
-		rValInt, err := strconv.ParseInt(rVal.Value, 10, 64)
-		if err != nil {
-			panic(err)
+		if err == pgx.ErrNoRows {
+			return StorageResponseBody{DeleteRecordResponse: &DeleteRecordResponse{
+				DeleteRecordResult: ResultRecordNotFound,
+			}}
 		}
+		return StorageResponseBody{DeleteRecordResponse: &DeleteRecordResponse{
+			DeleteRecordResult: ResultDatabaseUnavailable,
+		}}
+	}
 
-		return lValInt < rValInt
-	})
+	logging.Info(moduleName, "Deleted record", aurora.Cyan(request.RecordID), "in table", aurora.Cyan(request.TableID), "for profile", aurora.Cyan(profileId))
+
+	return StorageResponseBody{DeleteRecordResponse: &DeleteRecordResponse{
+		DeleteRecordResult: ResultSuccess,
+	}}
+}
+
+func getSpecificRecords(moduleName string, profileId uint32, gameInfo common.GameInfo, request StorageRequestCommon) StorageResponseBody {
+	if len(request.Fields.String) == 0 {
+		// GameSpy client doesn't consider zero fields valid
+		return StorageResponseBody{GetSpecificRecordsResponse: &GetSpecificRecordsResponse{
+			GetSpecificRecordsResult: "BadNumFields",
+		}}
+	}
+	if request.TableID == "" {
+		logging.Error(moduleName, "No table ID provided")
+		return StorageResponseBody{GetSpecificRecordsResponse: &GetSpecificRecordsResponse{
+			GetSpecificRecordsResult: ResultTableNotFound,
+		}}
+	}
+	if len(request.RecordIDs.Int) == 0 || len(request.RecordIDs.Int) > MaxSakeRecordsPerRequest {
+		logging.Error(moduleName, "Invalid number of record IDs:", aurora.Cyan(len(request.RecordIDs.Int)))
+		return StorageResponseBody{GetSpecificRecordsResponse: &GetSpecificRecordsResponse{
+			GetSpecificRecordsResult: ResultRecordNotFound,
+		}}
+	}
+
+	table := GetTable(gameInfo.Name, request.TableID)
+	if table != nil && table.Reserved {
+		logging.Error(moduleName, "Attempt to get specific records from reserved table", aurora.Cyan(request.TableID))
+		return StorageResponseBody{GetSpecificRecordsResponse: &GetSpecificRecordsResponse{
+			GetSpecificRecordsResult: ResultTableNotFound,
+		}}
+	}
 
-	// Enforce the maximum number of records after sorting
-	if request.Max > 0 && len(records) > int(request.Max) {
-		records = records[:request.Max]
+	var ownerIds []int32
+	if !table.AllowsPublicRead() {
+		ownerIds = []int32{int32(profileId)}
+	}
+
+	records, err := db.GetSakeRecords(gameInfo.GameID, ownerIds, request.TableID, request.RecordIDs.Int, request.Fields.String, "", nil)
+	if err != nil {
+		logging.Error(moduleName, "Failed to get sake records from the database:", err)
+		return StorageResponseBody{GetSpecificRecordsResponse: &GetSpecificRecordsResponse{
+			GetSpecificRecordsResult: ResultDatabaseUnavailable,
+		}}
 	}
 
 	responseValues, result := fillResponseValues(moduleName, profileId, table, records, request)
-	response := SearchForRecordsResponse{
-		SearchForRecordsResult: result,
+	response := GetSpecificRecordsResponse{
+		GetSpecificRecordsResult: result,
+		Values:                   responseValues,
+	}
+	logging.Info(moduleName, "Returning", aurora.Cyan(len(records)), "of", aurora.Cyan(len(request.RecordIDs.Int)), "requested records from table", aurora.Cyan(request.TableID))
+
+	return StorageResponseBody{GetSpecificRecordsResponse: &response}
+}
+
+func getRandomRecords(moduleName string, profileId uint32, gameInfo common.GameInfo, request StorageRequestCommon) StorageResponseBody {
+	if len(request.Fields.String) == 0 {
+		// GameSpy client doesn't consider zero fields valid
+		return StorageResponseBody{GetRandomRecordsResponse: &GetRandomRecordsResponse{
+			GetRandomRecordsResult: "BadNumFields",
+		}}
+	}
+	if request.TableID == "" {
+		logging.Error(moduleName, "No table ID provided")
+		return StorageResponseBody{GetRandomRecordsResponse: &GetRandomRecordsResponse{
+			GetRandomRecordsResult: ResultTableNotFound,
+		}}
+	}
+
+	table := GetTable(gameInfo.Name, request.TableID)
+	if table != nil && table.Reserved {
+		logging.Error(moduleName, "Attempt to get random records from reserved table", aurora.Cyan(request.TableID))
+		return StorageResponseBody{GetRandomRecordsResponse: &GetRandomRecordsResponse{
+			GetRandomRecordsResult: ResultTableNotFound,
+		}}
+	}
+
+	var ownerIds []int32
+	if !table.AllowsPublicRead() {
+		ownerIds = []int32{int32(profileId)}
+	}
+
+	max := int(request.Max)
+	if max <= 0 || max > MaxSakeRecordsPerRequest {
+		max = MaxSakeRecordsPerRequest
+	}
+
+	records, err := db.GetRandomSakeRecords(gameInfo.GameID, ownerIds, request.TableID, request.Filter, max)
+	if err != nil {
+		logging.Error(moduleName, "Failed to get random sake records from the database:", err)
+		return StorageResponseBody{GetRandomRecordsResponse: &GetRandomRecordsResponse{
+			GetRandomRecordsResult: ResultDatabaseUnavailable,
+		}}
+	}
+
+	responseValues, result := fillResponseValues(moduleName, profileId, table, records, request)
+	response := GetRandomRecordsResponse{
+		GetRandomRecordsResult: result,
 		Values:                 responseValues,
 	}
-	logging.Info(moduleName, "Found", aurora.Cyan(len(records)), "records in table", aurora.Cyan(request.TableID), "for profile", aurora.Cyan(profileId), "with filter", aurora.Cyan(request.Filter))
+	logging.Info(moduleName, "Returning", aurora.Cyan(len(records)), "random records from table", aurora.Cyan(request.TableID), "with filter", aurora.Cyan(request.Filter))
 
-	return StorageResponseBody{SearchForRecordsResponse: &response}
+	return StorageResponseBody{GetRandomRecordsResponse: &response}
+}
+
+func getRecordCount(moduleName string, profileId uint32, gameInfo common.GameInfo, request StorageRequestCommon) StorageResponseBody {
+	if request.TableID == "" {
+		logging.Error(moduleName, "No table ID provided")
+		return StorageResponseBody{GetRecordCountResponse: &GetRecordCountResponse{
+			GetRecordCountResult: ResultTableNotFound,
+		}}
+	}
+
+	table := GetTable(gameInfo.Name, request.TableID)
+	if table != nil && table.Reserved {
+		logging.Error(moduleName, "Attempt to get record count from reserved table", aurora.Cyan(request.TableID))
+		return StorageResponseBody{GetRecordCountResponse: &GetRecordCountResponse{
+			GetRecordCountResult: ResultTableNotFound,
+		}}
+	}
+
+	var ownerIds []int32
+	if !table.AllowsPublicRead() {
+		ownerIds = []int32{int32(profileId)}
+	}
+
+	count, err := db.CountSakeRecords(gameInfo.GameID, ownerIds, request.TableID, request.Filter)
+	if err != nil {
+		logging.Error(moduleName, "Failed to count sake records in the database:", err)
+		return StorageResponseBody{GetRecordCountResponse: &GetRecordCountResponse{
+			GetRecordCountResult: ResultDatabaseUnavailable,
+		}}
+	}
+
+	logging.Info(moduleName, "Counted", aurora.Cyan(count), "records in table", aurora.Cyan(request.TableID), "with filter", aurora.Cyan(request.Filter))
+
+	return StorageResponseBody{GetRecordCountResponse: &GetRecordCountResponse{
+		GetRecordCountResult: ResultSuccess,
+		Count:                int32(count),
+	}}
+}
+
+func rateRecord(moduleName string, profileId uint32, gameInfo common.GameInfo, request StorageRequestCommon) StorageResponseBody {
+	if request.TableID == "" {
+		logging.Error(moduleName, "No table ID provided")
+		return StorageResponseBody{RateRecordResponse: &RateRecordResponse{
+			RateRecordResult: ResultTableNotFound,
+		}}
+	}
+
+	table := GetTable(gameInfo.Name, request.TableID)
+	if !table.IsRateable() {
+		logging.Error(moduleName, "Attempt to rate record in table that isn't rateable", aurora.Cyan(request.TableID), "in game", aurora.BrightCyan(gameInfo.Name))
+		return StorageResponseBody{RateRecordResponse: &RateRecordResponse{
+			RateRecordResult: ResultNotRateable,
+		}}
+	}
+
+	numRatings, averageRating, err := db.RateSakeRecord(gameInfo.GameID, request.TableID, request.RecordID, profileId, request.Rating)
+	if err != nil {
+		logging.Error(moduleName, "Failed to rate sake record in the database:", err)
+		return StorageResponseBody{RateRecordResponse: &RateRecordResponse{
+			RateRecordResult: getRateRecordResult(err),
+		}}
+	}
+
+	logging.Info(moduleName, "Rated record", aurora.Cyan(request.RecordID), "in table", aurora.Cyan(request.TableID), "with", aurora.Cyan(request.Rating), "for profile", aurora.Cyan(profileId))
+
+	return StorageResponseBody{RateRecordResponse: &RateRecordResponse{
+		RateRecordResult: ResultSuccess,
+		NumRatings:       numRatings,
+		AverageRating:    averageRating,
+	}}
+}
+
+// getRateRecordResult maps an error from rating a record to the result sent to the client
+func getRateRecordResult(err error) Result {
+	switch err {
+	case database.ErrSakeAlreadyRated:
+		return ResultAlreadyRated
+	case database.ErrSakeOwnRecord:
+		return ResultNoPermission
+	case database.ErrSakeFieldLimitExceeded:
+		return ResultNotRateable
+	case pgx.ErrNoRows:
+		return ResultRecordNotFound
+	default:
+		return ResultDatabaseUnavailable
+	}
+}
+
+func reportRecord(moduleName string, profileId uint32, gameInfo common.GameInfo, request StorageRequestCommon) StorageResponseBody {
+	if request.TableID == "" {
+		logging.Error(moduleName, "No table ID provided")
+		return StorageResponseBody{ReportRecordResponse: &ReportRecordResponse{
+			ReportRecordResult: ResultTableNotFound,
+		}}
+	}
+
+	if len(request.Reason) > MaxSakeFieldValueLength {
+		request.Reason = request.Reason[:MaxSakeFieldValueLength]
+	}
+
+	err := db.ReportSakeRecord(gameInfo.GameID, request.TableID, request.RecordID, profileId, request.ReasonCode, request.Reason)
+	if err != nil {
+		logging.Error(moduleName, "Failed to report sake record in the database:", err)
+		var result Result
+		switch err {
+		case database.ErrSakeAlreadyReported:
+			result = ResultAlreadyReported
+		case pgx.ErrNoRows:
+			result = ResultRecordNotFound
+		default:
+			result = ResultDatabaseUnavailable
+		}
+		return StorageResponseBody{ReportRecordResponse: &ReportRecordResponse{
+			ReportRecordResult: result,
+		}}
+	}
+
+	logging.Notice(moduleName, "Profile", aurora.Cyan(profileId), "reported record", aurora.Cyan(request.RecordID), "in table", aurora.Cyan(request.TableID), "with reason", aurora.Cyan(request.ReasonCode))
+	logging.Event("sake_record_reported", map[string]any{
+		"game_name":   gameInfo.Name,
+		"table_id":    request.TableID,
+		"record_id":   request.RecordID,
+		"profile_id":  strconv.FormatUint(uint64(profileId), 10),
+		"reason_code": request.ReasonCode,
+		"reason":      request.Reason,
+	})
+
+	return StorageResponseBody{ReportRecordResponse: &ReportRecordResponse{
+		ReportRecordResult: ResultSuccess,
+	}}
+}
+
+// parseSortString parses a comma separated list of field names, each optionally followed by "asc" or "desc"
+func parseSortString(sort string) ([]database.SakeSortField, bool) {
+	if strings.TrimSpace(sort) == "" {
+		return nil, true
+	}
+
+	var fields []database.SakeSortField
+	for _, term := range strings.Split(sort, ",") {
+		words := strings.Fields(term)
+		if len(words) == 0 || len(words) > 2 || !sortFieldNameRegex.MatchString(words[0]) {
+			return nil, false
+		}
+
+		field := database.SakeSortField{Name: words[0]}
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				field.Descending = true
+			default:
+				return nil, false
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	if len(fields) > MaxSakeFieldsPerRecord {
+		return nil, false
+	}
+	return fields, true
 }
 
 func getInputFields(moduleName string, request StorageRequestCommon, table *SakeTable, useDefault bool) (map[string]database.SakeField, Result) {
@@ -558,10 +931,37 @@ func getInputFields(moduleName string, request StorageRequestCommon, table *Sake
 }
 
 func fillResponseValues(moduleName string, profileId uint32, table *SakeTable, records []database.SakeRecord, request StorageRequestCommon) (ArrayOfArrayOfRecordValue, Result) {
+	var myRatings map[int32]byte
+	if table.IsRateable() && len(records) != 0 && slices.Contains(request.Fields.String, "my_rating") {
+		recordIds := make([]int32, len(records))
+		for i, record := range records {
+			recordIds[i] = record.RecordId
+		}
+
+		var err error
+		myRatings, err = db.GetMySakeRecordRatings(records[0].GameId, records[0].TableId, profileId, recordIds)
+		if err != nil {
+			logging.Error(moduleName, "Failed to get my ratings from the database:", err)
+			return ArrayOfArrayOfRecordValue{}, ResultDatabaseUnavailable
+		}
+	}
+
 	var response ArrayOfArrayOfRecordValue
 	for _, record := range records {
 		valueArray := ArrayOfRecordValue{}
 		for _, field := range request.Fields.String {
+			if field == "my_rating" && myRatings != nil {
+				// -1 if the profile hasn't rated the record
+				myRating := int64(-1)
+				if rating, ok := myRatings[record.RecordId]; ok {
+					myRating = int64(rating)
+				}
+				valueArray.RecordValues = append(valueArray.RecordValues, RecordValue{Value: CommonValue{
+					XMLName: xml.Name{Local: "intValue"},
+					Value:   strconv.FormatInt(myRating, 10),
+				}})
+				continue
+			}
 			if field == "ownerid" {
 				valueArray.RecordValues = append(valueArray.RecordValues, RecordValue{Value: CommonValue{
 					XMLName: xml.Name{Local: "intValue"},
@@ -569,6 +969,13 @@ func fillResponseValues(moduleName string, profileId uint32, table *SakeTable, r
 				}})
 				continue
 			}
+			if field == "row" {
+				valueArray.RecordValues = append(valueArray.RecordValues, RecordValue{Value: CommonValue{
+					XMLName: xml.Name{Local: "intValue"},
+					Value:   strconv.Itoa(record.Row),
+				}})
+				continue
+			}
 			if field == "recordid" {
 				valueArray.RecordValues = append(valueArray.RecordValues, RecordValue{Value: CommonValue{
 					XMLName: xml.Name{Local: "intValue"},
@@ -630,5 +1037,29 @@ func (body *StorageResponseBody) setResultTag(xmlName string, result Result) {
 		}
 	case SakeNamespace + "/SearchForRecords":
 		body.SearchForRecordsResponse = &SearchForRecordsResponse{}
+	case SakeNamespace + "/DeleteRecord":
+		body.DeleteRecordResponse = &DeleteRecordResponse{
+			DeleteRecordResult: result,
+		}
+	case SakeNamespace + "/GetSpecificRecords":
+		body.GetSpecificRecordsResponse = &GetSpecificRecordsResponse{
+			GetSpecificRecordsResult: result,
+		}
+	case SakeNamespace + "/GetRandomRecords":
+		body.GetRandomRecordsResponse = &GetRandomRecordsResponse{
+			GetRandomRecordsResult: result,
+		}
+	case SakeNamespace + "/GetRecordCount":
+		body.GetRecordCountResponse = &GetRecordCountResponse{
+			GetRecordCountResult: result,
+		}
+	case SakeNamespace + "/RateRecord":
+		body.RateRecordResponse = &RateRecordResponse{
+			RateRecordResult: result,
+		}
+	case SakeNamespace + "/ReportRecord":
+		body.ReportRecordResponse = &ReportRecordResponse{
+			ReportRecordResult: result,
+		}
 	}
 }
@@ -0,0 +1,64 @@
+package sake
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"wwfc/database"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestGetRateRecordResult(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected Result
+	}{
+		{database.ErrSakeAlreadyRated, ResultAlreadyRated},
+		{database.ErrSakeOwnRecord, ResultNoPermission},
+		{database.ErrSakeFieldLimitExceeded, ResultNotRateable},
+		{pgx.ErrNoRows, ResultRecordNotFound},
+		{errors.New("connection refused"), ResultDatabaseUnavailable},
+	}
+
+	for _, test := range tests {
+		if result := getRateRecordResult(test.err); result != test.expected {
+			t.Errorf("getRateRecordResult(%v) = %s, expected %s", test.err, result, test.expected)
+		}
+	}
+}
+
+func TestParseSortString(t *testing.T) {
+	tests := []struct {
+		sort     string
+		expected []database.SakeSortField
+		ok       bool
+	}{
+		{"", nil, true},
+		{"   ", nil, true},
+		{"time", []database.SakeSortField{{Name: "time"}}, true},
+		{"time desc", []database.SakeSortField{{Name: "time", Descending: true}}, true},
+		{"time ASC,ownerid DESC", []database.SakeSortField{{Name: "time"}, {Name: "ownerid", Descending: true}}, true},
+		{" score desc , recordid ", []database.SakeSortField{{Name: "score", Descending: true}, {Name: "recordid"}}, true},
+		{"time,", nil, false},
+		{"time up", nil, false},
+		{"time desc extra", nil, false},
+		{"fields->>'a'", nil, false},
+		{strings.Repeat("a,", MaxSakeFieldsPerRecord) + "a", nil, false},
+	}
+
+	for _, test := range tests {
+		fields, ok := parseSortString(test.sort)
+		if ok != test.ok || len(fields) != len(test.expected) {
+			t.Errorf("parseSortString(%q) = %v, %v, expected %v, %v", test.sort, fields, ok, test.expected, test.ok)
+			continue
+		}
+
+		for i := range fields {
+			if fields[i] != test.expected[i] {
+				t.Errorf("parseSortString(%q) = %v, expected %v", test.sort, fields, test.expected)
+				break
+			}
+		}
+	}
+}
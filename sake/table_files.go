@@ -0,0 +1,275 @@
+package sake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"wwfc/common"
+	"wwfc/database"
+	"wwfc/logging"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// TableFile is the format of a file in the SAKE tables directory, defining the tables of one game
+type TableFile struct {
+	Game   string               `json:"game"`
+	Tables map[string]TableSpec `json:"tables"`
+}
+
+type TableSpec struct {
+	// "yes" or "no"
+	Rateable string `json:"rateable,omitempty"`
+	// "profile" or "backend"
+	OwnerType string `json:"owner_type,omitempty"`
+	// "allowed" or "denied", the SakeTable defaults apply if empty
+	PublicPermCreate string               `json:"public_create,omitempty"`
+	PublicPermRead   string               `json:"public_read,omitempty"`
+	OwnerPermUpdate  string               `json:"owner_update,omitempty"`
+	OwnerPermDelete  string               `json:"owner_delete,omitempty"`
+	LimitPerOwner    int                  `json:"limit_per_owner,omitempty"`
+	Hardened         bool                 `json:"hardened,omitempty"`
+	Fields           map[string]FieldSpec `json:"fields"`
+
+	// Set when listing tables that are defined in the server code rather than a file
+	BuiltIn  bool `json:"built_in,omitempty"`
+	Reserved bool `json:"reserved,omitempty"`
+}
+
+type FieldSpec struct {
+	Type        string `json:"type"`
+	Default     string `json:"default,omitempty"`
+	LengthLimit int    `json:"length_limit,omitempty"`
+}
+
+var (
+	rateableNames = map[Rateable]string{
+		RateableUnknown: "",
+		RateableYes:     "yes",
+		RateableNo:      "no",
+	}
+	ownerTypeNames = map[OwnerType]string{
+		OwnerTypeProfile: "profile",
+		OwnerTypeBackend: "backend",
+	}
+	permissionNames = map[Permission]string{
+		PermissionDefault: "",
+		PermissionAllowed: "allowed",
+		PermissionDenied:  "denied",
+	}
+	fieldTypeNames = map[database.SakeFieldType]string{
+		database.SakeFieldTypeByte:          "byte",
+		database.SakeFieldTypeShort:         "short",
+		database.SakeFieldTypeInt:           "int",
+		database.SakeFieldTypeFloat:         "float",
+		database.SakeFieldTypeAsciiString:   "ascii_string",
+		database.SakeFieldTypeUnicodeString: "unicode_string",
+		database.SakeFieldTypeBoolean:       "boolean",
+		database.SakeFieldTypeDateAndTime:   "date_and_time",
+		database.SakeFieldTypeBinaryData:    "binary_data",
+		database.SakeFieldTypeInt64:         "int64",
+	}
+
+	rateableLookup   = common.ReverseMap(rateableNames).(map[string]Rateable)
+	ownerTypeLookup  = common.ReverseMap(ownerTypeNames).(map[string]OwnerType)
+	permissionLookup = common.ReverseMap(permissionNames).(map[string]Permission)
+	fieldTypeLookup  = common.ReverseMap(fieldTypeNames).(map[string]database.SakeFieldType)
+
+	tableNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+	// Fields that are either record columns or generated by the server
+	reservedFieldNames = []string{"ownerid", "recordid", "gameid", "tableid", "row", "average_rating", "my_rating", "num_ratings", "sum_ratings"}
+)
+
+// LoadTableDefinitions reads every table file in the SAKE tables directory and replaces the
+// active table definitions. The current definitions are kept if the directory is missing or
+// any file is invalid.
+func LoadTableDefinitions() (int, error) {
+	info, err := os.Stat(tablesPath)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return 0, fmt.Errorf("%s is not a directory", tablesPath)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(tablesPath, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := maps.Clone(builtInTables)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+
+		var file TableFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if common.GetGameInfoByName(file.Game) == nil {
+			return 0, fmt.Errorf("%s: unknown game %q", path, file.Game)
+		}
+
+		for tableId, spec := range file.Tables {
+			key := file.Game + "/" + tableId
+			if !tableNameRegex.MatchString(tableId) {
+				return 0, fmt.Errorf("%s: invalid table ID %q", path, tableId)
+			}
+			if _, exists := builtInTables[key]; exists {
+				return 0, fmt.Errorf("%s: table %s is built in and can't be redefined", path, key)
+			} else if _, exists := loaded[key]; exists {
+				return 0, fmt.Errorf("%s: table %s is defined more than once", path, key)
+			}
+
+			table, err := spec.toTable()
+			if err != nil {
+				return 0, fmt.Errorf("%s: table %s: %w", path, key, err)
+			}
+			loaded[key] = table
+		}
+	}
+
+	tablesMutex.Lock()
+	tables = loaded
+	tablesMutex.Unlock()
+
+	return len(loaded) - len(builtInTables), nil
+}
+
+// GetTableSpecs lists the active table definitions, keyed by game and table ID
+func GetTableSpecs() map[string]TableSpec {
+	tablesMutex.RLock()
+	defer tablesMutex.RUnlock()
+
+	specs := map[string]TableSpec{}
+	for key, table := range tables {
+		spec := TableSpec{
+			Rateable:         rateableNames[table.Rateable],
+			OwnerType:        ownerTypeNames[table.OwnerType],
+			PublicPermCreate: permissionNames[table.PublicPermCreate],
+			PublicPermRead:   permissionNames[table.PublicPermRead],
+			OwnerPermUpdate:  permissionNames[table.OwnerPermUpdate],
+			OwnerPermDelete:  permissionNames[table.OwnerPermDelete],
+			LimitPerOwner:    table.LimitPerOwner,
+			Hardened:         table.Hardened,
+			Fields:           map[string]FieldSpec{},
+			Reserved:         table.Reserved,
+		}
+		_, spec.BuiltIn = builtInTables[key]
+
+		for name, field := range table.Fields {
+			spec.Fields[name] = FieldSpec{
+				Type:        fieldTypeNames[field.Type],
+				Default:     field.Default,
+				LengthLimit: field.LengthLimit,
+			}
+		}
+		specs[key] = spec
+	}
+
+	return specs
+}
+
+// FieldTypeName returns the name used for a field type in table files
+func FieldTypeName(fieldType database.SakeFieldType) string {
+	return fieldTypeNames[fieldType]
+}
+
+// loadTableDefinitionsOnStart stops the server if the table definitions can't be loaded, as
+// tables without a definition would accept any record
+func loadTableDefinitionsOnStart() {
+	count, err := LoadTableDefinitions()
+	if err != nil {
+		logging.Error("SAKE", "Failed to load table definitions:", err)
+		panic(err)
+	}
+
+	logging.Notice("SAKE", "Loaded", aurora.Cyan(count), "table definitions from", aurora.Cyan(tablesPath))
+}
+
+func (spec TableSpec) toTable() (SakeTable, error) {
+	var table SakeTable
+	var ok bool
+	if table.Rateable, ok = rateableLookup[spec.Rateable]; !ok {
+		return SakeTable{}, fmt.Errorf("invalid rateable value %q", spec.Rateable)
+	}
+	if spec.OwnerType != "" {
+		if table.OwnerType, ok = ownerTypeLookup[spec.OwnerType]; !ok {
+			return SakeTable{}, fmt.Errorf("invalid owner type %q", spec.OwnerType)
+		}
+	}
+
+	permissions := []struct {
+		value string
+		dest  *Permission
+	}{
+		{spec.PublicPermCreate, &table.PublicPermCreate},
+		{spec.PublicPermRead, &table.PublicPermRead},
+		{spec.OwnerPermUpdate, &table.OwnerPermUpdate},
+		{spec.OwnerPermDelete, &table.OwnerPermDelete},
+	}
+	for _, permission := range permissions {
+		if *permission.dest, ok = permissionLookup[permission.value]; !ok {
+			return SakeTable{}, fmt.Errorf("invalid permission %q", permission.value)
+		}
+	}
+
+	if spec.LimitPerOwner < 0 || spec.LimitPerOwner > MaxSakeRecordsPerProfile {
+		return SakeTable{}, errors.New("invalid limit per owner")
+	}
+	table.LimitPerOwner = spec.LimitPerOwner
+	table.Hardened = spec.Hardened
+
+	if len(spec.Fields) > MaxSakeFieldsPerRecord {
+		return SakeTable{}, errors.New("too many fields")
+	}
+
+	table.Fields = map[string]SakeFieldDefinition{}
+	for name, fieldSpec := range spec.Fields {
+		if !tableNameRegex.MatchString(name) || slices.Contains(reservedFieldNames, name) {
+			return SakeTable{}, fmt.Errorf("invalid field name %q", name)
+		}
+
+		fieldType, ok := fieldTypeLookup[fieldSpec.Type]
+		if !ok {
+			return SakeTable{}, fmt.Errorf("field %s: invalid type %q", name, fieldSpec.Type)
+		}
+		if fieldSpec.LengthLimit < 0 || fieldSpec.LengthLimit > MaxSakeFieldValueLength {
+			return SakeTable{}, fmt.Errorf("field %s: invalid length limit", name)
+		}
+
+		table.Fields[name] = SakeFieldDefinition{
+			Type:        fieldType,
+			Default:     fieldSpec.Default,
+			LengthLimit: fieldSpec.LengthLimit,
+		}
+	}
+
+	// Check the defaults against the complete table
+	for name, field := range table.Fields {
+		switch field.Default {
+		case "", "{EMPTY}":
+			continue
+		case "{CURRENT_TIMESTAMP}":
+			if field.Type != database.SakeFieldTypeDateAndTime {
+				return SakeTable{}, fmt.Errorf("field %s: {CURRENT_TIMESTAMP} default on a non date field", name)
+			}
+			continue
+		}
+
+		if result := table.CheckValidField(name, database.SakeField{Type: field.Type, Value: field.Default}); result != ResultSuccess {
+			return SakeTable{}, fmt.Errorf("field %s: invalid default value: %s", name, result)
+		}
+	}
+
+	return table, nil
+}
@@ -0,0 +1,73 @@
+package sake
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupTestTableFiles(t *testing.T) {
+	// The game list is read from the repository root
+	t.Chdir("..")
+
+	t.Cleanup(func() {
+		tablesMutex.Lock()
+		tables = builtInTables
+		tablesMutex.Unlock()
+		tablesPath = ""
+	})
+}
+
+func TestLoadTableDefinitions(t *testing.T) {
+	setupTestTableFiles(t)
+
+	tablesPath = "sake_tables"
+	count, err := LoadTableDefinitions()
+	if err != nil {
+		t.Fatal("shipped table files failed to load:", err)
+	}
+	if count == 0 || GetTable("micchannelwii", "userinfo") == nil {
+		t.Fatalf("loaded %d tables, expected micchannelwii/userinfo", count)
+	}
+
+	// The loaded tables are kept when a reload fails
+	for _, path := range []string{"missing_sake_tables", "game_list.tsv"} {
+		tablesPath = path
+		if _, err := LoadTableDefinitions(); err == nil {
+			t.Errorf("%s: loaded without an error", path)
+		}
+		if GetTable("micchannelwii", "userinfo") == nil {
+			t.Errorf("%s: table definitions were dropped", path)
+		}
+	}
+}
+
+func TestLoadTableDefinitionsInvalidFile(t *testing.T) {
+	setupTestTableFiles(t)
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"bad json", `{"game": "micchannelwii",`},
+		{"unknown game", `{"game": "notagame", "tables": {}}`},
+		{"built in table", `{"game": "mariokartwii", "tables": {"GhostData": {"rateable": "no", "fields": {}}}}`},
+		{"reserved field", `{"game": "micchannelwii", "tables": {"test": {"rateable": "no", "fields": {"sum_ratings": {"type": "int"}}}}}`},
+		{"invalid type", `{"game": "micchannelwii", "tables": {"test": {"rateable": "no", "fields": {"a": {"type": "long"}}}}}`},
+	}
+
+	for _, test := range tests {
+		tablesPath = t.TempDir()
+		if err := os.WriteFile(filepath.Join(tablesPath, "test.json"), []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadTableDefinitions(); err == nil {
+			t.Errorf("%s: loaded without an error", test.name)
+		}
+	}
+
+	if GetTable("micchannelwii", "test") != nil {
+		t.Error("a table from an invalid file was loaded")
+	}
+}
@@ -2,7 +2,9 @@ package sake
 
 import (
 	"encoding/base64"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 	"wwfc/database"
 )
@@ -11,6 +13,8 @@ const (
 	MaxSakeRecordsPerProfile = 96
 	MaxSakeFieldsPerRecord   = 64
 	MaxSakeFieldValueLength  = 4096
+	// Limit on records returned by SearchForRecords and GetRandomRecords, and requested by GetSpecificRecords
+	MaxSakeRecordsPerRequest = 100
 )
 
 const DateAndTimeFormat = "2006-01-02T15:04:05.000"
@@ -81,27 +85,8 @@ type SakeTable struct {
 	Fields map[string]SakeFieldDefinition
 }
 
-var TableDefinitions = map[string]SakeTable{
-	"micchannelwii/userinfo": {
-		Rateable:         RateableYes,
-		OwnerType:        OwnerTypeProfile,
-		PublicPermCreate: PermissionAllowed,
-		PublicPermRead:   PermissionAllowed,
-		OwnerPermUpdate:  PermissionAllowed,
-		OwnerPermDelete:  PermissionAllowed,
-		Fields: map[string]SakeFieldDefinition{
-			"wiiid": {
-				Type: database.SakeFieldTypeInt64,
-			},
-			"username": {
-				Type: database.SakeFieldTypeBinaryData,
-			},
-			"friendkey": {
-				Type: database.SakeFieldTypeInt64,
-			},
-		},
-	},
-
+// Tables that need custom handlers or filters. Other tables are defined in files in the SAKE tables directory.
+var builtInTables = map[string]SakeTable{
 	"mariokartwii/FriendInfo": {
 		Rateable:         RateableNo,
 		OwnerType:        OwnerTypeProfile,
@@ -174,49 +159,19 @@ var TableDefinitions = map[string]SakeTable{
 			},
 		},
 	},
-
-	"guinnesswrds/RecordTable": {
-		Rateable:         RateableUnknown,
-		OwnerType:        OwnerTypeProfile,
-		PublicPermCreate: PermissionAllowed,
-		PublicPermRead:   PermissionAllowed,
-		OwnerPermUpdate:  PermissionAllowed,
-		OwnerPermDelete:  PermissionAllowed,
-		Fields: map[string]SakeFieldDefinition{
-			"Score": {
-				Type: database.SakeFieldTypeInt,
-			},
-			"GameID": {
-				Type: database.SakeFieldTypeByte,
-			},
-			"Region": {
-				Type: database.SakeFieldTypeByte,
-			},
-			"Country": {
-				Type: database.SakeFieldTypeByte,
-			},
-			"OwnerName": {
-				Type: database.SakeFieldTypeUnicodeString,
-			},
-			"AvatarName": {
-				Type: database.SakeFieldTypeUnicodeString,
-			},
-			"AvatarModel": {
-				Type: database.SakeFieldTypeByte,
-			},
-			"AvatarParts": {
-				Type: database.SakeFieldTypeBinaryData,
-			},
-			"DateTimeSet": {
-				Type:    database.SakeFieldTypeDateAndTime,
-				Default: "{CURRENT_TIMESTAMP}",
-			},
-		},
-	},
 }
 
+var (
+	// Built in tables and tables loaded from files, keyed by game and table ID
+	tables      = builtInTables
+	tablesMutex = sync.RWMutex{}
+	tablesPath  string
+)
+
 func GetTable(gameName string, tableId string) *SakeTable {
-	tableDef, exists := TableDefinitions[gameName+"/"+tableId]
+	tablesMutex.RLock()
+	tableDef, exists := tables[gameName+"/"+tableId]
+	tablesMutex.RUnlock()
 	if !exists {
 		return nil
 	}
@@ -254,6 +209,10 @@ func (t *SakeTable) AllowsOwnerDelete() bool {
 	return t.OwnerPermDelete == PermissionAllowed || t.OwnerPermDelete == PermissionDefault
 }
 
+func (t *SakeTable) IsRateable() bool {
+	return t != nil && t.Rateable == RateableYes
+}
+
 func (t *SakeTable) GetDefaultFields() map[string]database.SakeField {
 	if t == nil {
 		return nil
@@ -288,6 +247,82 @@ func (t *SakeTable) GetDefaultFields() map[string]database.SakeField {
 	return defaultFields
 }
 
+// GetBlankField returns the value a field is reset to when its content is removed: the default from
+// the table definition if there is one, otherwise an empty value of the field's type. The field
+// keeps its type, and fields the table doesn't define are rejected if the table is hardened.
+func (t *SakeTable) GetBlankField(fieldName string, field database.SakeField) (database.SakeField, Result) {
+	if t != nil {
+		if fieldDef, exists := t.Fields[fieldName]; exists {
+			field.Type = fieldDef.Type
+		} else if t.Hardened {
+			return database.SakeField{}, ResultFieldNotFound
+		}
+	}
+
+	if defaultField, exists := t.GetDefaultFields()[fieldName]; exists && defaultField.Type == field.Type {
+		field.Value = defaultField.Value
+	} else {
+		switch field.Type {
+		case database.SakeFieldTypeByte, database.SakeFieldTypeShort, database.SakeFieldTypeInt, database.SakeFieldTypeInt64, database.SakeFieldTypeFloat:
+			field.Value = "0"
+
+		case database.SakeFieldTypeBoolean:
+			field.Value = "false"
+
+		case database.SakeFieldTypeDateAndTime:
+			field.Value = time.Time{}.Format(DateAndTimeFormat)
+
+		default:
+			field.Value = ""
+		}
+	}
+
+	if result := t.CheckValidField(fieldName, field); result != ResultSuccess {
+		return database.SakeField{}, result
+	}
+
+	return field, ResultSuccess
+}
+
+// GetBlankFields blanks the named fields of a record, or every field the client can write to if
+// no names are given. Reserved fields can't be blanked. Returns the name of the first field that
+// can't be blanked on failure.
+func (t *SakeTable) GetBlankFields(fields map[string]database.SakeField, fieldNames []string) (map[string]database.SakeField, string, Result) {
+	if len(fieldNames) == 0 {
+		for fieldName := range fields {
+			if !slices.Contains(reservedFieldNames, fieldName) {
+				fieldNames = append(fieldNames, fieldName)
+			}
+		}
+	}
+
+	blankFields := map[string]database.SakeField{}
+	for _, fieldName := range fieldNames {
+		if slices.Contains(reservedFieldNames, fieldName) {
+			return nil, fieldName, ResultNoPermission
+		}
+
+		field, exists := fields[fieldName]
+		if !exists {
+			// The type of a field the record doesn't have can only come from the table definition
+			if t == nil {
+				return nil, fieldName, ResultFieldNotFound
+			}
+			if _, defined := t.Fields[fieldName]; !defined {
+				return nil, fieldName, ResultFieldNotFound
+			}
+		}
+
+		blankField, result := t.GetBlankField(fieldName, field)
+		if result != ResultSuccess {
+			return nil, fieldName, result
+		}
+		blankFields[fieldName] = blankField
+	}
+
+	return blankFields, "", ResultSuccess
+}
+
 func (t *SakeTable) CheckValidField(fieldName string, field database.SakeField) Result {
 	lengthLimit := MaxSakeFieldValueLength
 	var verifyFunc func(value string) bool
@@ -0,0 +1,95 @@
+package sake
+
+import (
+	"testing"
+	"time"
+	"wwfc/database"
+)
+
+func newTestSakeTable(hardened bool) *SakeTable {
+	return &SakeTable{
+		Rateable: RateableYes,
+		Hardened: hardened,
+		Fields: map[string]SakeFieldDefinition{
+			"name":  {Type: database.SakeFieldTypeUnicodeString},
+			"score": {Type: database.SakeFieldTypeInt, Default: "100"},
+			"flag":  {Type: database.SakeFieldTypeBoolean},
+			"date":  {Type: database.SakeFieldTypeDateAndTime},
+		},
+	}
+}
+
+func TestGetBlankField(t *testing.T) {
+	tests := []struct {
+		name      string
+		table     *SakeTable
+		fieldName string
+		field     database.SakeField
+		expected  database.SakeField
+		result    Result
+	}{
+		{"string", newTestSakeTable(true), "name", database.SakeField{Type: database.SakeFieldTypeUnicodeString, Value: "abc"}, database.SakeField{Type: database.SakeFieldTypeUnicodeString, Value: ""}, ResultSuccess},
+		{"default value", newTestSakeTable(true), "score", database.SakeField{Type: database.SakeFieldTypeInt, Value: "5"}, database.SakeField{Type: database.SakeFieldTypeInt, Value: "100"}, ResultSuccess},
+		{"boolean", newTestSakeTable(true), "flag", database.SakeField{Type: database.SakeFieldTypeBoolean, Value: "true"}, database.SakeField{Type: database.SakeFieldTypeBoolean, Value: "false"}, ResultSuccess},
+		{"date", newTestSakeTable(true), "date", database.SakeField{Type: database.SakeFieldTypeDateAndTime, Value: "2024-01-01T00:00:00"}, database.SakeField{Type: database.SakeFieldTypeDateAndTime, Value: time.Time{}.Format(DateAndTimeFormat)}, ResultSuccess},
+		{"type from definition", newTestSakeTable(true), "score", database.SakeField{Type: database.SakeFieldTypeAsciiString, Value: "abc"}, database.SakeField{Type: database.SakeFieldTypeInt, Value: "100"}, ResultSuccess},
+		{"undefined field in hardened table", newTestSakeTable(true), "other", database.SakeField{Type: database.SakeFieldTypeInt, Value: "5"}, database.SakeField{}, ResultFieldNotFound},
+		{"undefined field", newTestSakeTable(false), "other", database.SakeField{Type: database.SakeFieldTypeShort, Value: "5"}, database.SakeField{Type: database.SakeFieldTypeShort, Value: "0"}, ResultSuccess},
+		{"no table", nil, "other", database.SakeField{Type: database.SakeFieldTypeBinaryData, Value: "AAAA"}, database.SakeField{Type: database.SakeFieldTypeBinaryData, Value: ""}, ResultSuccess},
+		{"rating field", newTestSakeTable(false), "num_ratings", database.SakeField{Type: database.SakeFieldTypeInt, Value: "5"}, database.SakeField{}, ResultFieldNotFound},
+	}
+
+	for _, test := range tests {
+		field, result := test.table.GetBlankField(test.fieldName, test.field)
+		if result != test.result || field != test.expected {
+			t.Errorf("%s: got %+v (%s), expected %+v (%s)", test.name, field, result, test.expected, test.result)
+		}
+	}
+}
+
+func TestGetBlankFields(t *testing.T) {
+	record := map[string]database.SakeField{
+		"ownerid":        {Type: database.SakeFieldTypeInt, Value: "1000000000"},
+		"recordid":       {Type: database.SakeFieldTypeInt, Value: "1"},
+		"average_rating": {Type: database.SakeFieldTypeFloat, Value: "4.5"},
+		"num_ratings":    {Type: database.SakeFieldTypeInt, Value: "2"},
+		"name":           {Type: database.SakeFieldTypeUnicodeString, Value: "abc"},
+		"score":          {Type: database.SakeFieldTypeInt, Value: "5"},
+	}
+
+	tests := []struct {
+		name       string
+		table      *SakeTable
+		fieldNames []string
+		expected   []string
+		failed     string
+		result     Result
+	}{
+		{"all fields", newTestSakeTable(true), nil, []string{"name", "score"}, "", ResultSuccess},
+		{"selected field", newTestSakeTable(true), []string{"score"}, []string{"score"}, "", ResultSuccess},
+		{"defined field the record doesn't have", newTestSakeTable(true), []string{"flag"}, []string{"flag"}, "", ResultSuccess},
+		{"undefined field the record doesn't have", newTestSakeTable(false), []string{"other"}, nil, "other", ResultFieldNotFound},
+		{"missing field without a table", nil, []string{"other"}, nil, "other", ResultFieldNotFound},
+		{"record column", newTestSakeTable(false), []string{"name", "ownerid"}, nil, "ownerid", ResultNoPermission},
+		{"rating field", newTestSakeTable(false), []string{"sum_ratings"}, nil, "sum_ratings", ResultNoPermission},
+		{"rating field the record has", nil, []string{"num_ratings"}, nil, "num_ratings", ResultNoPermission},
+	}
+
+	for _, test := range tests {
+		blankFields, fieldName, result := test.table.GetBlankFields(record, test.fieldNames)
+		if result != test.result || fieldName != test.failed {
+			t.Errorf("%s: got %s for %q, expected %s for %q", test.name, result, fieldName, test.result, test.failed)
+			continue
+		}
+
+		if len(blankFields) != len(test.expected) {
+			t.Errorf("%s: blanked %d fields, expected %v", test.name, len(blankFields), test.expected)
+			continue
+		}
+		for _, expected := range test.expected {
+			if _, exists := blankFields[expected]; !exists {
+				t.Errorf("%s: field %q was not blanked", test.name, expected)
+			}
+		}
+	}
+}
@@ -47,7 +47,10 @@ ALTER TABLE ONLY public.users
     ADD IF NOT EXISTS ban_reason_hidden character varying,
     ADD IF NOT EXISTS ban_moderator character varying,
     ADD IF NOT EXISTS ban_tos boolean,
-	ADD IF NOT EXISTS open_host boolean DEFAULT false;
+	ADD IF NOT EXISTS open_host boolean DEFAULT false,
+    ADD IF NOT EXISTS join_policy character varying DEFAULT 'open_host'::character varying,
+    ADD IF NOT EXISTS hide_presence boolean DEFAULT false,
+    ADD IF NOT EXISTS hide_search boolean DEFAULT false;
 
 --
 -- Change ng_device_id from bigint to bigint[]
@@ -71,11 +74,15 @@ CREATE TABLE IF NOT EXISTS public.sake_records (
     table_id character varying NOT NULL,
     record_id integer NOT NULL DEFAULT (random() * 2147483647)::integer,
     owner_id integer NOT NULL,
-    fields jsonb NOT NULL CHECK (jsonb_typeof(fields) = 'object' AND jsonb_array_length(jsonb_path_query_array(fields, '$.keyvalue().key')) <= 64),
+    fields jsonb NOT NULL,
     create_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
     update_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
 
-    CONSTRAINT one_sake_record_constraint UNIQUE (game_id, table_id, record_id)
+    CONSTRAINT one_sake_record_constraint UNIQUE (game_id, table_id, record_id),
+    -- The rating fields are maintained by the server and don't count towards the limit
+    CONSTRAINT sake_records_fields_check CHECK (CASE WHEN jsonb_typeof(fields) = 'object'
+        THEN jsonb_array_length(jsonb_path_query_array(fields - ARRAY['num_ratings', 'sum_ratings', 'average_rating'], '$.keyvalue().key')) <= 64
+        ELSE false END)
 );
 
 --
@@ -160,6 +167,245 @@ CREATE TABLE IF NOT EXISTS public.events (
     event_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP
 );
 
+--
+-- Name: report_aggregates; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.report_aggregates (
+    profile_id bigint NOT NULL,
+    record character varying NOT NULL,
+    report_count integer DEFAULT 0 NOT NULL,
+    last_reporter_id bigint,
+    first_report timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+    last_report timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+    escalation_count integer DEFAULT 0 NOT NULL,
+    last_escalation timestamp without time zone,
+    last_action character varying,
+
+    CONSTRAINT one_report_aggregate_constraint UNIQUE (profile_id, record)
+);
+
+--
+-- Name: reputation; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.reputation (
+    profile_id bigint PRIMARY KEY,
+    score double precision DEFAULT 0 NOT NULL,
+    updated timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+--
+-- Name: reputation_reports; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.reputation_reports (
+    id serial PRIMARY KEY,
+    profile_id bigint NOT NULL,
+    reporter_id bigint NOT NULL,
+    record character varying NOT NULL,
+    -- Room the report was made in
+    group_name character varying DEFAULT ''::character varying NOT NULL,
+    weight double precision NOT NULL,
+    score double precision,
+    report_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS reputation_reports_reporter_index ON public.reputation_reports (profile_id, reporter_id, report_time);
+
+--
+-- Name: mario_kart_wii_ratings; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.mario_kart_wii_ratings (
+    profile_id bigint NOT NULL,
+    rating_type character varying NOT NULL CHECK (rating_type IN ('vr', 'br')),
+    rating integer NOT NULL CHECK (rating >= 1 AND rating <= 9999),
+    races integer DEFAULT 0 NOT NULL,
+    flagged integer DEFAULT 0 NOT NULL,
+    updated timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (profile_id, rating_type)
+);
+
+CREATE INDEX IF NOT EXISTS mario_kart_wii_ratings_leaderboard_index ON public.mario_kart_wii_ratings (rating_type, rating DESC);
+
+--
+-- Name: mario_kart_wii_rating_history; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.mario_kart_wii_rating_history (
+    id serial PRIMARY KEY,
+    profile_id bigint NOT NULL,
+    rating_type character varying NOT NULL,
+    old_rating integer NOT NULL,
+    new_rating integer NOT NULL,
+    group_name character varying,
+    race_number integer,
+    flagged boolean DEFAULT false NOT NULL,
+    reason character varying,
+    change_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS mario_kart_wii_rating_history_profile_index ON public.mario_kart_wii_rating_history (profile_id, rating_type, change_time DESC);
+
+--
+-- Name: room_history; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.room_history (
+    id serial PRIMARY KEY,
+    group_name character varying NOT NULL,
+    dwc_group_id bigint NOT NULL,
+    game_name character varying NOT NULL,
+    match_type character varying,
+    mkw_region character varying,
+    profile_ids bigint[] NOT NULL,
+    timeline jsonb NOT NULL,
+    races jsonb,
+    create_time timestamp without time zone NOT NULL,
+    end_time timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS room_history_profile_ids_index ON public.room_history USING gin (profile_ids);
+
+--
+-- Name: tournaments; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.tournaments (
+    id serial PRIMARY KEY,
+    code character varying NOT NULL,
+    name character varying NOT NULL,
+    host_profile_id bigint NOT NULL,
+    profile_ids bigint[] NOT NULL,
+    course_ids integer[] NOT NULL DEFAULT '{}',
+    engine_class_ids integer[] NOT NULL DEFAULT '{}',
+    start_time timestamp without time zone NOT NULL,
+    end_time timestamp without time zone NOT NULL,
+    moderator character varying,
+    created timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tournaments_code_index ON public.tournaments USING btree (code);
+
+ALTER TABLE ONLY public.room_history
+    ADD IF NOT EXISTS tournament_id integer;
+
+--
+-- Name: sake_record_ratings; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.sake_record_ratings (
+    game_id integer NOT NULL,
+    table_id character varying NOT NULL,
+    record_id integer NOT NULL,
+    profile_id bigint NOT NULL,
+    rating smallint NOT NULL CHECK (rating >= 0 AND rating <= 255),
+    rate_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (game_id, table_id, record_id, profile_id),
+    FOREIGN KEY (game_id, table_id, record_id) REFERENCES public.sake_records (game_id, table_id, record_id) ON DELETE CASCADE
+);
+
+--
+-- Name: sake_record_reports; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.sake_record_reports (
+    game_id integer NOT NULL,
+    table_id character varying NOT NULL,
+    record_id integer NOT NULL,
+    profile_id bigint NOT NULL,
+    reason_code integer NOT NULL DEFAULT 0,
+    reason character varying,
+    report_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (game_id, table_id, record_id, profile_id),
+    FOREIGN KEY (game_id, table_id, record_id) REFERENCES public.sake_records (game_id, table_id, record_id) ON DELETE CASCADE
+);
+
+--
+-- Name: sake_files; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.sake_files (
+    file_id serial PRIMARY KEY,
+    game_id integer NOT NULL,
+    owner_id bigint NOT NULL,
+    hash character(64) NOT NULL,
+    size integer NOT NULL,
+    upload_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT one_sake_file_constraint UNIQUE (game_id, owner_id, hash)
+);
+
+--
+-- Name: mario_kart_wii_ghost_reviews; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.mario_kart_wii_ghost_reviews (
+    id serial PRIMARY KEY,
+    regionid smallint NOT NULL,
+    courseid smallint NOT NULL,
+    score integer NOT NULL,
+    pid integer NOT NULL,
+    playerinfo varchar(108) NOT NULL,
+    ghost bytea,
+    reasons text[] NOT NULL,
+    upload_time timestamp without time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    status varchar NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    moderator varchar,
+    review_time timestamp without time zone
+);
+
+--
+-- Name: gamestats_rankings; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.gamestats_rankings (
+    game_name character varying NOT NULL,
+    category integer NOT NULL,
+    profile_id bigint NOT NULL,
+    region integer NOT NULL,
+    score integer NOT NULL,
+    data bytea NOT NULL,
+    update_time timestamp without time zone NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (game_name, category, profile_id)
+);
+
+--
+-- Name: gamestats_snapshots; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.gamestats_snapshots (
+    profile_id bigint NOT NULL,
+    game_name character varying NOT NULL,
+    session_key integer NOT NULL,
+    connection_id character varying NOT NULL,
+    gamedata character varying NOT NULL,
+    done boolean NOT NULL,
+    created_time timestamp without time zone NOT NULL,
+    updated_time timestamp without time zone NOT NULL,
+
+    PRIMARY KEY (profile_id, session_key, connection_id)
+);
+
+--
+-- Name: merged_profiles; Type: TABLE; Schema: public; Owner: wiilink
+--
+
+CREATE TABLE IF NOT EXISTS public.merged_profiles (
+    id serial PRIMARY KEY,
+    profile_id bigint NOT NULL,
+    merged_into bigint NOT NULL,
+    moderator character varying,
+    -- The removed users row
+    user_data jsonb NOT NULL,
+    merge_time timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+);
+
 --
 -- PostgreSQL database dump complete
 --
@@ -37,6 +37,8 @@ var (
 )
 
 func StartServer(reload bool) {
+	startPushUpdates()
+
 	if !reload {
 		return
 	}
@@ -74,6 +76,7 @@ func NewConnection(index uint64, address string) {
 func CloseConnection(index uint64) {
 	mutex.Lock()
 	delete(connBuffers, index)
+	delete(connStates, index)
 	mutex.Unlock()
 }
 
@@ -131,6 +134,7 @@ func HandlePacket(index uint64, data []byte, address string) {
 
 	case ServerInfoRequest:
 		logging.Info(moduleName, "Command:", aurora.Yellow("SERVER_INFO_REQUEST"))
+		handleServerInfoRequest(moduleName, index, (*buffer)[:packetSize])
 
 	case SendMessageRequest:
 		// logging.Info(moduleName, "Command:", aurora.Yellow("SEND_MESSAGE_REQUEST"))
@@ -144,6 +148,7 @@ func HandlePacket(index uint64, data []byte, address string) {
 
 	case PlayerSearchRequest:
 		logging.Info(moduleName, "Command:", aurora.Yellow("PLAYER_SEARCH_REQUEST"))
+		handlePlayerSearchRequest(moduleName, index, (*buffer)[:packetSize])
 
 	default:
 		logging.Error(moduleName, "Unknown command:", aurora.Cyan((*buffer)[2]))
@@ -0,0 +1,260 @@
+package serverbrowser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"wwfc/common"
+	"wwfc/gpcm"
+	"wwfc/logging"
+	"wwfc/qr2"
+
+	"github.com/logrusorgru/aurora/v3"
+)
+
+// State kept after a server list request, as any further messages on the connection
+// continue its encrypted stream. This is not saved on reload, so push clients have to
+// request a new list after a restart.
+type connectionState struct {
+	queryGame      string
+	filter         string
+	fieldList      []string
+	callerPublicIP string
+	// Profile the matchmaking policy is applied for, or 0 if it isn't
+	searcherPID uint32
+	push        bool
+
+	mutex sync.Mutex
+	// Nil if the game's server list isn't encrypted
+	encryptor *common.TypeXEncryptor
+	// Search ID -> address the client knows the server by
+	sentServers map[string][]byte
+}
+
+var (
+	connStates = map[uint64]*connectionState{}
+
+	startPushOnce sync.Once
+)
+
+func startPushUpdates() {
+	startPushOnce.Do(func() {
+		updates, err := qr2.SubscribeServerUpdates()
+		if err != nil {
+			logging.Error("SB", "Failed to subscribe to server updates:", err)
+			return
+		}
+
+		go pushServerUpdates(updates)
+	})
+}
+
+// sendAdHocMessage encrypts and sends a message to a client that has already received a server list
+func (state *connectionState) sendAdHocMessage(connIndex uint64, messageType byte, data []byte) error {
+	message := binary.BigEndian.AppendUint16(nil, uint16(len(data)+3))
+	message = append(message, messageType)
+	message = append(message, data...)
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if getConnectionState(connIndex) != state {
+		// A new server list request restarted the encrypted stream
+		return nil
+	}
+
+	return common.SendPacket(ServerName, connIndex, state.encrypt(message))
+}
+
+// encrypt encrypts the next data sent on the connection, if the game uses encryption
+func (state *connectionState) encrypt(data []byte) []byte {
+	if state.encryptor == nil {
+		return data
+	}
+
+	return state.encryptor.Encrypt(data)
+}
+
+func getConnectionState(connIndex uint64) *connectionState {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return connStates[connIndex]
+}
+
+func pushServerUpdates(updates <-chan qr2.ServerUpdate) {
+	for update := range updates {
+		mutex.RLock()
+		states := make(map[uint64]*connectionState, len(connStates))
+		for connIndex, state := range connStates {
+			if state.push && state.queryGame == update.Server["gamename"] {
+				states[connIndex] = state
+			}
+		}
+		mutex.RUnlock()
+
+		for connIndex, state := range states {
+			state.pushServerUpdate(connIndex, update)
+		}
+	}
+}
+
+func (state *connectionState) pushServerUpdate(connIndex uint64, update qr2.ServerUpdate) {
+	moduleName := "SB:Push"
+	searchID := update.Server["+searchid"]
+
+	state.mutex.Lock()
+	identity, wasSent := state.sentServers[searchID]
+	state.mutex.Unlock()
+
+	matches := false
+	if update.Type != qr2.ServerDeleted && state.filter != "" && state.filter != " " && state.filter != "0" {
+		matches = len(filterServers(moduleName, []map[string]string{update.Server}, state.queryGame, state.filter)) != 0
+	}
+
+	// Keep out rooms the matchmaking policy removed from the client's list
+	if matches && state.searcherPID != 0 {
+		matches = len(qr2.ApplyMatchmakingPolicy(state.searcherPID, state.callerPublicIP, []map[string]string{update.Server})) != 0
+	}
+
+	if !matches {
+		if !wasSent {
+			return
+		}
+
+		state.mutex.Lock()
+		delete(state.sentServers, searchID)
+		state.mutex.Unlock()
+
+		if err := state.sendAdHocMessage(connIndex, DeleteServerMessage, identity); err != nil {
+			logging.Error(moduleName, "Failed to send delete message:", err)
+		}
+		return
+	}
+
+	serverBuffer, identity, ok := encodeServer(moduleName, update.Server, state.fieldList, state.callerPublicIP, false)
+	if !ok {
+		return
+	}
+
+	state.mutex.Lock()
+	state.sentServers[searchID] = identity
+	state.mutex.Unlock()
+
+	if err := state.sendAdHocMessage(connIndex, PushServerMessage, serverBuffer); err != nil {
+		logging.Error(moduleName, "Failed to send push message:", err)
+	}
+}
+
+// findServerByIdentity returns the server the client knows by the public IP and port
+func (state *connectionState) findServerByIdentity(moduleName string, identity []byte) map[string]string {
+	for _, server := range qr2.GetSessionServers() {
+		if server["gamename"] != state.queryGame {
+			continue
+		}
+
+		_, serverIdentity, ok := encodeServer(moduleName, server, nil, state.callerPublicIP, false)
+		if ok && bytes.Equal(serverIdentity, identity) {
+			return server
+		}
+	}
+
+	return nil
+}
+
+// handleServerInfoRequest sends every public field of a single server
+//
+//	xx xx xx xx - Public IP
+//	xx xx       - Public port
+func handleServerInfoRequest(moduleName string, connIndex uint64, buffer []byte) {
+	identity, _, err := popBytes(buffer, 3, 6)
+	if err != nil {
+		logging.Error(moduleName, "Invalid server info request")
+		return
+	}
+
+	state := getConnectionState(connIndex)
+	if state == nil {
+		logging.Error(moduleName, "Server info request before server list request")
+		return
+	}
+
+	server := state.findServerByIdentity(moduleName, identity)
+	if server == nil {
+		logging.Warn(moduleName, "Server info request for unknown server")
+		return
+	}
+
+	serverBuffer, _, ok := encodeServer(moduleName, server, nil, state.callerPublicIP, true)
+	if !ok {
+		return
+	}
+
+	if err := state.sendAdHocMessage(connIndex, PushServerMessage, serverBuffer); err != nil {
+		logging.Error(moduleName, "Failed to send server info:", err)
+	}
+}
+
+// handlePlayerSearchRequest searches the client's game for players by name
+//
+//	xx xx xx xx - Search options
+//	xx xx xx xx - Maximum results
+//	...      00 - Name to search for
+//
+// Each result is sent as a separate message with the server's address followed by the player's name.
+func handlePlayerSearchRequest(moduleName string, connIndex uint64, buffer []byte) {
+	index := 3
+	_, index, err := popUint32(buffer, index)
+	if err != nil {
+		logging.Error(moduleName, "Invalid search options")
+		return
+	}
+
+	maxResults, index, err := popUint32(buffer, index)
+	if err != nil {
+		logging.Error(moduleName, "Invalid max results")
+		return
+	}
+
+	name, _, err := popString(buffer, index)
+	if err != nil || name == "" {
+		logging.Error(moduleName, "Invalid search name")
+		return
+	}
+
+	state := getConnectionState(connIndex)
+	if state == nil {
+		logging.Error(moduleName, "Player search request before server list request")
+		return
+	}
+
+	logging.Info(moduleName, "Player search:", aurora.Cyan(name))
+
+	maxResults = min(maxResults, 100)
+	results := 0
+	for _, server := range qr2.FindPlayerServers(state.queryGame, name) {
+		if results >= int(maxResults) {
+			break
+		}
+
+		profileId, err := strconv.ParseUint(server["dwc_pid"], 10, 32)
+		if err != nil || !gpcm.IsProfileSearchable(uint32(profileId)) {
+			continue
+		}
+
+		_, identity, ok := encodeServer(moduleName, server, nil, state.callerPublicIP, false)
+		if !ok {
+			continue
+		}
+
+		message := append(identity, []byte(server["+ingamesn"])...)
+		message = append(message, 0x00)
+		if err := state.sendAdHocMessage(connIndex, PlayerSearchMessage, message); err != nil {
+			logging.Error(moduleName, "Failed to send player search result:", err)
+			return
+		}
+
+		results++
+	}
+}
@@ -81,6 +81,28 @@ func popUint32(buffer []byte, index int) (uint32, int, error) {
 
 var regexSelfLookup = regexp.MustCompile(`^dwc_pid ?= ?(\d{1,10})$`)
 
+// DWC excludes the searching player from their own matchmaking searches
+var regexSearcherPID = regexp.MustCompile(`dwc_pid ?!= ?(\d{1,10})`)
+
+// getSearcherPID returns the profile ID a Mario Kart Wii client excludes from its search, which
+// is its own, or 0 if the matchmaking policy doesn't apply to the search
+func getSearcherPID(queryGame string, filter string) uint32 {
+	if queryGame != "mariokartwii" {
+		return 0
+	}
+
+	match := regexSearcherPID.FindStringSubmatch(filter)
+	if match == nil {
+		return 0
+	}
+
+	profileId, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(profileId)
+}
+
 func handleServerListRequest(moduleName string, connIndex uint64, address string, buffer []byte) {
 	index := 9
 	queryGame, index, err := popString(buffer, index)
@@ -127,6 +149,11 @@ func handleServerListRequest(moduleName string, connIndex uint64, address string
 		return
 	}
 
+	if gameInfo.Encryption != common.EncryptionNone && gameInfo.Encryption != common.EncryptionTypeX {
+		logging.Error(moduleName, "Server list encryption", aurora.Cyan(gameInfo.Encryption), "is not implemented yet, refusing the request")
+		return
+	}
+
 	var output []byte
 	for _, s := range strings.Split(strings.Split(address, ":")[0], ".") {
 		val, err := strconv.Atoi(s)
@@ -179,159 +206,236 @@ func handleServerListRequest(moduleName string, connIndex uint64, address string
 			servers = filterSelfLookup(moduleName, qr2.GetSessionServers(), queryGame, match[1], callerPublicIP)
 		} else {
 			servers = filterServers(moduleName, qr2.GetSessionServers(), queryGame, filter)
+
+			if searcherPID := getSearcherPID(queryGame, filter); searcherPID != 0 {
+				servers = qr2.ApplyMatchmakingPolicy(searcherPID, callerPublicIP, servers)
+			}
 		}
 	}
 
 	for _, server := range servers {
-		var flags byte
-		var flagsBuffer []byte
+		serverBuffer, _, ok := encodeServer(moduleName, server, fieldList, callerPublicIP, false)
+		if !ok {
+			continue
+		}
 
-		// Server will always have keys
-		flags |= HasKeysFlag
+		output = append(output, serverBuffer...)
+	}
 
-		var natneg string
-		var exists bool
-		if natneg, exists = server["natneg"]; exists && natneg != "0" {
-			flags |= ConnectNegotiateFlag
-		}
+	if options&NoServerListOption == 0 {
+		// Server with 0 flags and IP of 0xffffffff terminates the list
+		output = append(output, []byte{0x00, 0xff, 0xff, 0xff, 0xff}...)
+	}
 
-		var publicip string
-		if publicip, exists = server["publicip"]; !exists {
-			logging.Error(moduleName, "Server exists without public IP")
-			continue
-		}
+	// The rest of the connection continues the same encrypted stream
+	var encryptor *common.TypeXEncryptor
+	var header []byte
+	if gameInfo.Encryption == common.EncryptionTypeX {
+		encryptor, header = common.NewTypeXEncryptor([]byte(gameInfo.SecretKey), challenge)
+	}
 
-		if publicip == callerPublicIP || server["+gppublicip"] == callerPublicIP {
-			// Use the real public IP if it matches the caller's
-			ip, err := strconv.ParseInt(publicip, 10, 32)
-			if err != nil {
-				logging.Error(moduleName, "Server has invalid public IP value:", aurora.Cyan(publicip))
+	state := &connectionState{
+		queryGame:      queryGame,
+		filter:         filter,
+		fieldList:      fieldList,
+		callerPublicIP: callerPublicIP,
+		searcherPID:    getSearcherPID(queryGame, filter),
+		push:           options&PushUpdatesOption != 0,
+		encryptor:      encryptor,
+		sentServers:    map[string][]byte{},
+	}
+
+	if state.push {
+		for _, server := range servers {
+			if _, identity, ok := encodeServer(moduleName, server, fieldList, callerPublicIP, false); ok {
+				state.sentServers[server["+searchid"]] = identity
 			}
+		}
+	}
 
-			flagsBuffer = binary.BigEndian.AppendUint32(flagsBuffer, uint32(ip))
+	// Push updates and ad hoc replies wait for the list to be sent, so they continue the
+	// encrypted stream in order
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
 
-			var port string
-			port, exists = server["publicport"]
-			if !exists {
-				// Fall back to local port if public port doesn't exist
-				if port, exists = server["localport"]; !exists {
-					logging.Error(moduleName, "Server exists without port (publicip =", aurora.Cyan(publicip).String()+")")
-					continue
-				}
-			}
+	mutex.Lock()
+	connStates[connIndex] = state
+	mutex.Unlock()
 
-			portValue, err := strconv.ParseUint(port, 10, 16)
-			if err != nil {
-				logging.Error(moduleName, "Server has invalid port value:", aurora.Cyan(port))
-				continue
-			}
+	// Write the encrypted reply
+	if err := common.SendPacket(ServerName, connIndex, append(header, state.encrypt(output)...)); err != nil {
+		logging.Error(moduleName, "Failed to send packet:", err)
+	}
+}
 
-			if portValue < 1024 {
-				logging.Error(moduleName, "Server uses reserved port:", aurora.Cyan(portValue))
-				continue
-			}
+// encodeServer encodes a server's address and the requested fields as they appear in the server list.
+// The identity is the address the client knows the server by. If fullRules is set, every public field
+// is appended instead of the requested fields.
+func encodeServer(moduleName string, server map[string]string, fieldList []string, callerPublicIP string, fullRules bool) ([]byte, []byte, bool) {
+	var flags byte
+	var flagsBuffer []byte
 
-			flags |= NonstandardPortFlag
-			flagsBuffer = binary.BigEndian.AppendUint16(flagsBuffer, uint16(portValue))
+	if fullRules {
+		flags |= HasFullRulesFlag
+	} else {
+		// Server will always have keys
+		flags |= HasKeysFlag
+	}
 
-			// Use the first local IP if it exists
-			if localip0, exists := server["localip0"]; exists {
-				flags |= PrivateIPFlag
+	var natneg string
+	var exists bool
+	if natneg, exists = server["natneg"]; exists && natneg != "0" {
+		flags |= ConnectNegotiateFlag
+	}
 
-				// localip is written like "192.168.255.255" for example, so it needs to be parsed
-				ipSplit := strings.Split(localip0, ".")
-				if len(ipSplit) != 4 {
-					logging.Error(moduleName, "Server has invalid local IP:", aurora.Cyan(localip0))
-					continue
-				}
+	var publicip string
+	if publicip, exists = server["publicip"]; !exists {
+		logging.Error(moduleName, "Server exists without public IP")
+		return nil, nil, false
+	}
 
-				err = nil
-				for _, s := range ipSplit {
-					var val uint64
-					val, err = strconv.ParseUint(s, 10, 8)
-					if err != nil {
-						break
-					}
+	if publicip == callerPublicIP || server["+gppublicip"] == callerPublicIP {
+		// Use the real public IP if it matches the caller's
+		ip, err := strconv.ParseInt(publicip, 10, 32)
+		if err != nil {
+			logging.Error(moduleName, "Server has invalid public IP value:", aurora.Cyan(publicip))
+		}
 
-					flagsBuffer = append(flagsBuffer, byte(val))
-				}
+		flagsBuffer = binary.BigEndian.AppendUint32(flagsBuffer, uint32(ip))
 
-				if err != nil {
-					logging.Error(moduleName, "Server has invalid local IP value:", aurora.Cyan(localip0))
-					continue
-				}
+		var port string
+		port, exists = server["publicport"]
+		if !exists {
+			// Fall back to local port if public port doesn't exist
+			if port, exists = server["localport"]; !exists {
+				logging.Error(moduleName, "Server exists without port (publicip =", aurora.Cyan(publicip).String()+")")
+				return nil, nil, false
+			}
+		}
+
+		portValue, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			logging.Error(moduleName, "Server has invalid port value:", aurora.Cyan(port))
+			return nil, nil, false
+		}
+
+		if portValue < 1024 {
+			logging.Error(moduleName, "Server uses reserved port:", aurora.Cyan(portValue))
+			return nil, nil, false
+		}
+
+		flags |= NonstandardPortFlag
+		flagsBuffer = binary.BigEndian.AppendUint16(flagsBuffer, uint16(portValue))
+
+		// Use the first local IP if it exists
+		if localip0, exists := server["localip0"]; exists {
+			flags |= PrivateIPFlag
+
+			// localip is written like "192.168.255.255" for example, so it needs to be parsed
+			ipSplit := strings.Split(localip0, ".")
+			if len(ipSplit) != 4 {
+				logging.Error(moduleName, "Server has invalid local IP:", aurora.Cyan(localip0))
+				return nil, nil, false
 			}
 
-			if localport, exists := server["localport"]; exists {
-				portValue, err = strconv.ParseUint(localport, 10, 16)
+			err = nil
+			for _, s := range ipSplit {
+				var val uint64
+				val, err = strconv.ParseUint(s, 10, 8)
 				if err != nil {
-					logging.Error(moduleName, "Server has invalid local port value:", aurora.Cyan(localport))
-					continue
+					break
 				}
 
-				flags |= NonstandardPrivatePortFlag
-				flagsBuffer = binary.BigEndian.AppendUint16(flagsBuffer, uint16(portValue))
+				flagsBuffer = append(flagsBuffer, byte(val))
 			}
 
-			flags |= ICMPIPFlag
-			flagsBuffer = append(flagsBuffer, 0, 0, 0, 0)
-		} else {
-			// Regular server, hide the public IP until match reservation is made
-			var searchIDStr string
-			if searchIDStr, exists = server["+searchid"]; !exists {
-				logging.Error(moduleName, "Server exists without search ID")
-				continue
+			if err != nil {
+				logging.Error(moduleName, "Server has invalid local IP value:", aurora.Cyan(localip0))
+				return nil, nil, false
 			}
+		}
 
-			searchID, err := strconv.ParseInt(searchIDStr, 10, 64)
+		if localport, exists := server["localport"]; exists {
+			portValue, err = strconv.ParseUint(localport, 10, 16)
 			if err != nil {
-				logging.Error(moduleName, "Server has invalid search ID value:", aurora.Cyan(searchIDStr))
+				logging.Error(moduleName, "Server has invalid local port value:", aurora.Cyan(localport))
+				return nil, nil, false
 			}
 
-			// Append low value as public IP
-			flagsBuffer = binary.BigEndian.AppendUint32(flagsBuffer, uint32(searchID&0xffffffff))
-			// Append high value as public port
-			flags |= NonstandardPortFlag
-			flagsBuffer = binary.BigEndian.AppendUint16(flagsBuffer, uint16((searchID>>32)&0xffff))
+			flags |= NonstandardPrivatePortFlag
+			flagsBuffer = binary.BigEndian.AppendUint16(flagsBuffer, uint16(portValue))
+		}
 
-			flags |= PrivateIPFlag | NonstandardPrivatePortFlag
-			flagsBuffer = append(flagsBuffer, 0, 0, 0, 0, 0, 0)
+		flags |= ICMPIPFlag
+		flagsBuffer = append(flagsBuffer, 0, 0, 0, 0)
+	} else {
+		// Regular server, hide the public IP until match reservation is made
+		var searchIDStr string
+		if searchIDStr, exists = server["+searchid"]; !exists {
+			logging.Error(moduleName, "Server exists without search ID")
+			return nil, nil, false
+		}
 
-			flags |= ICMPIPFlag
-			flagsBuffer = append(flagsBuffer, 0, 0, 0, 0)
+		searchID, err := strconv.ParseInt(searchIDStr, 10, 64)
+		if err != nil {
+			logging.Error(moduleName, "Server has invalid search ID value:", aurora.Cyan(searchIDStr))
 		}
 
-		// Append the server buffer to the output
-		output = append(output, flags)
-		output = append(output, flagsBuffer...)
+		// Append low value as public IP
+		flagsBuffer = binary.BigEndian.AppendUint32(flagsBuffer, uint32(searchID&0xffffffff))
+		// Append high value as public port
+		flags |= NonstandardPortFlag
+		flagsBuffer = binary.BigEndian.AppendUint16(flagsBuffer, uint16((searchID>>32)&0xffff))
 
-		if (flags & HasKeysFlag) == 0 {
-			// Server does not have keys, so skip them
-			continue
-		}
+		flags |= PrivateIPFlag | NonstandardPrivatePortFlag
+		flagsBuffer = append(flagsBuffer, 0, 0, 0, 0, 0, 0)
+
+		flags |= ICMPIPFlag
+		flagsBuffer = append(flagsBuffer, 0, 0, 0, 0)
+	}
+
+	// The public IP and port, as the client knows them
+	identity := append([]byte{}, flagsBuffer[:6]...)
 
-		// Add the requested fields
-		for _, field := range fieldList {
-			output = append(output, 0xff)
+	output := append([]byte{flags}, flagsBuffer...)
 
-			if str, exists := server[field]; exists {
-				output = append(output, []byte(str)...)
+	if fullRules {
+		for field, value := range server {
+			if !isPublicField(field) {
+				continue
 			}
 
-			// Add null terminator
+			output = append(output, []byte(field)...)
+			output = append(output, 0x00)
+			output = append(output, []byte(value)...)
 			output = append(output, 0x00)
 		}
+
+		return output, identity, true
 	}
 
-	if options&NoServerListOption == 0 {
-		// Server with 0 flags and IP of 0xffffffff terminates the list
-		output = append(output, []byte{0x00, 0xff, 0xff, 0xff, 0xff}...)
+	// Add the requested fields
+	for _, field := range fieldList {
+		output = append(output, 0xff)
+
+		if str, exists := server[field]; exists {
+			output = append(output, []byte(str)...)
+		}
+
+		// Add null terminator
+		output = append(output, 0x00)
 	}
 
-	// Write the encrypted reply
-	if err := common.SendPacket(ServerName, connIndex, common.EncryptTypeX([]byte(gameInfo.SecretKey), challenge, output)); err != nil {
-		logging.Error(moduleName, "Failed to send packet:", err)
+	return output, identity, true
+}
+
+// isPublicField returns false for fields that must not be sent to other clients
+func isPublicField(field string) bool {
+	if field == "" || field[0] == '+' {
+		return false
 	}
+
+	return field != "publicip" && field != "publicport" && !strings.HasPrefix(field, "localip") && field != "localport"
 }
 
 func handleSendMessageRequest(moduleName string, connIndex uint64, address string, buffer []byte) {
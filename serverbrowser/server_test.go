@@ -0,0 +1,27 @@
+package serverbrowser
+
+import (
+	"testing"
+)
+
+func TestGetSearcherPID(t *testing.T) {
+	tests := []struct {
+		name      string
+		queryGame string
+		filter    string
+		expected  uint32
+	}{
+		{"matchmaking search", "mariokartwii", `dwc_mver = 90 and dwc_pid != 1000004498 and maxplayers = 12`, 1000004498},
+		{"no spaces", "mariokartwii", `dwc_pid!=1000004498`, 1000004498},
+		{"other game", "fstarzerods", `dwc_pid != 1000004498`, 0},
+		{"self lookup", "mariokartwii", `dwc_pid = 1000004498`, 0},
+		{"no profile ID", "mariokartwii", `maxplayers = 12`, 0},
+		{"out of range", "mariokartwii", `dwc_pid != 9999999999`, 0},
+	}
+
+	for _, test := range tests {
+		if result := getSearcherPID(test.queryGame, test.filter); result != test.expected {
+			t.Errorf("%s: got %d, expected %d", test.name, result, test.expected)
+		}
+	}
+}